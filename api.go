@@ -0,0 +1,575 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrorAPI es el cuerpo de toda respuesta de error de la API.
+type ErrorAPI struct {
+	Error DetalleErrorAPI `json:"error"`
+}
+
+// DetalleErrorAPI describe un error. Codigo es estable y sirve para que los
+// clientes decidan qué hacer; Mensaje es para personas.
+type DetalleErrorAPI struct {
+	Codigo  string        `json:"codigo"`
+	Mensaje string        `json:"mensaje"`
+	Items   []ItemCarrito `json:"items,omitempty"` // Resultado de cada libro de un carrito rechazado
+}
+
+// ListaAPI es la respuesta de los listados de la API.
+type ListaAPI[T any] struct {
+	Datos     []T    `json:"datos"`
+	Siguiente string `json:"siguiente,omitempty"` // Cursor de la página siguiente en los listados paginados
+}
+
+// TokenCreadoAPI es la respuesta al crear un token: el único momento en que
+// se ve el token en claro.
+type TokenCreadoAPI struct {
+	Token string `json:"token"`
+	TokenAPI
+	Persona *Persona `json:"persona"`
+}
+
+// Cuerpos de las peticiones de la API.
+type (
+	credencialesAPI struct {
+		Nombre      string `json:"nombre"`
+		Contrasena  string `json:"contrasena"`
+		Descripcion string `json:"descripcion,omitempty"`
+	}
+	personaAPI struct {
+		Nombre     string `json:"nombre"`
+		Cedula     string `json:"cedula"`
+		Ano        int    `json:"ano"`
+		Contrasena string `json:"contrasena"`
+		Rol        string `json:"rol,omitempty"` // Por defecto usuario
+	}
+	prestamoAPI struct {
+		LibroIDs  []string `json:"libroIDs"`
+		PersonaID string   `json:"personaID,omitempty"` // Sólo para bibliotecarios y administradores; por defecto quien pide
+	}
+	devolucionAPI struct {
+		PrestamoID string `json:"prestamoID"`
+	}
+	reservaAPI struct {
+		LibroID   string `json:"libroID"`
+		PersonaID string `json:"personaID,omitempty"` // Igual que en prestamoAPI
+	}
+)
+
+// erroresAPI asocia los errores del dominio con su estado HTTP y su código.
+// Los que no están aquí son errores internos.
+var erroresAPI = []struct {
+	err    error
+	estado int
+	codigo string
+}{
+	{ErrNoEncontrado, http.StatusNotFound, "no_encontrado"},
+	{ErrNoAutorizado, http.StatusForbidden, "no_autorizado"},
+	{ErrCarritoRechazado, http.StatusConflict, "carrito_rechazado"},
+	{ErrSinCopias, http.StatusConflict, "sin_copias"},
+	{ErrLimitePrestamos, http.StatusConflict, "limite_prestamos"},
+	{ErrTieneVencidos, http.StatusConflict, "prestamos_vencidos"},
+	{ErrMultasPendientes, http.StatusConflict, "multas_pendientes"},
+	{ErrSolicitudPendiente, http.StatusConflict, "solicitud_pendiente"},
+	{ErrLibroRepetido, http.StatusBadRequest, "libro_repetido"},
+	{ErrPrestamoCerrado, http.StatusConflict, "prestamo_cerrado"},
+	{ErrMaxRenovaciones, http.StatusConflict, "max_renovaciones"},
+	{ErrLibroReservado, http.StatusConflict, "libro_reservado"},
+	{ErrHayCopias, http.StatusConflict, "hay_copias"},
+	{ErrYaReservado, http.StatusConflict, "ya_reservado"},
+	{ErrReservaCerrada, http.StatusConflict, "reserva_cerrada"},
+	{ErrStockOcupado, http.StatusConflict, "stock_ocupado"},
+	{ErrConPrestamos, http.StatusConflict, "con_prestamos"},
+	{ErrCedulaRegistrada, http.StatusConflict, "cedula_registrada"},
+	{ErrContrasenaInvalida, http.StatusBadRequest, "contrasena_invalida"},
+	{ErrOrdenInvalido, http.StatusBadRequest, "datos_invalidos"},
+	{ErrCursorInvalido, http.StatusBadRequest, "datos_invalidos"},
+	{ErrLimiteInvalido, http.StatusBadRequest, "datos_invalidos"},
+}
+
+func responderJSON(w http.ResponseWriter, estado int, datos any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(estado)
+	if err := json.NewEncoder(w).Encode(datos); err != nil {
+		log.Printf("Error al codificar la respuesta de la API: %v", err)
+	}
+}
+
+func responderErrorAPI(w http.ResponseWriter, estado int, codigo, mensaje string) {
+	responderJSON(w, estado, ErrorAPI{DetalleErrorAPI{Codigo: codigo, Mensaje: mensaje}})
+}
+
+// responderErrorDominio responde el error con su estado y código según
+// erroresAPI, o con 500 si es inesperado.
+func responderErrorDominio(w http.ResponseWriter, r *http.Request, err error) {
+	for _, e := range erroresAPI {
+		if errors.Is(err, e.err) {
+			responderErrorAPI(w, e.estado, e.codigo, err.Error())
+			return
+		}
+	}
+	log.Printf("Error en %s %s: %v", r.Method, r.URL.Path, err)
+	responderErrorAPI(w, http.StatusInternalServerError, "error_interno", "Error interno del servidor")
+}
+
+// leerJSON decodifica el cuerpo de la petición en destino. Si no puede,
+// responde 400 y devuelve false.
+func leerJSON(w http.ResponseWriter, r *http.Request, destino any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(destino); err != nil {
+		responderErrorAPI(w, http.StatusBadRequest, "json_invalido", "El cuerpo no es un JSON válido: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// estadoHTTP registra el estado que escribe un handler y descarta el cuerpo.
+type estadoHTTP struct {
+	http.ResponseWriter
+	estado int
+}
+
+func (e *estadoHTTP) WriteHeader(estado int)      { e.estado = estado }
+func (e *estadoHTTP) Write(b []byte) (int, error) { return len(b), nil }
+
+// servidorAPI registra rutasAPI y el documento OpenAPI. Las peticiones que
+// no coinciden con ninguna ruta reciben el 404 o el 405 (con la cabecera
+// Allow) de http.ServeMux, pero como error JSON.
+func servidorAPI() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/openapi.json", OpenAPIHandler)
+	for _, rt := range rutasAPI {
+		mux.Handle(rt.patron, protegerAPI(rt.acceso, rt.handler))
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h, patron := mux.Handler(r)
+		if patron != "" {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		sonda := &estadoHTTP{ResponseWriter: w}
+		h.ServeHTTP(sonda, r)
+		if sonda.estado == http.StatusMethodNotAllowed {
+			responderErrorAPI(w, http.StatusMethodNotAllowed, "metodo_no_permitido", "Método no permitido: usa "+w.Header().Get("Allow"))
+			return
+		}
+		responderErrorAPI(w, http.StatusNotFound, "ruta_no_encontrada", "No existe la ruta "+r.URL.Path)
+	})
+}
+
+// protegerAPI aplica la regla de acceso como proteger, pero responde con
+// errores JSON: 401 sin token y 403 con un rol no permitido. No hay CSRF
+// porque la API sólo acepta tokens, que el navegador no envía solo.
+func protegerAPI(regla acceso, h http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		persona := personaActual(r)
+		if regla.autenticado && persona == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			responderErrorAPI(w, http.StatusUnauthorized, "no_autenticado", "Falta el token: envía la cabecera Authorization: Bearer <token>")
+			return
+		}
+		if len(regla.roles) > 0 && !slices.Contains(regla.roles, persona.Rol) {
+			log.Printf("Acceso denegado a %s %s para %s (rol %s)", r.Method, r.URL.Path, persona.Nombre, persona.Rol)
+			responderErrorAPI(w, http.StatusForbidden, "acceso_denegado", "Tu rol no permite esta operación")
+			return
+		}
+		h(w, r)
+	})
+}
+
+// personaObjetivo devuelve la persona a cuyo nombre se hace la operación:
+// quien la pide o, si es bibliotecario o administrador, la indicada en
+// personaID.
+func personaObjetivo(r *http.Request, personaID string) (*Persona, error) {
+	quien := personaActual(r)
+	if personaID == "" || personaID == quien.ID {
+		return quien, nil
+	}
+	if !quien.GestionaPrestamos() {
+		return nil, ErrNoAutorizado
+	}
+	return DB.Personas().Obtener(r.Context(), personaID)
+}
+
+// puedeVer indica si quien pide puede ver los datos de personaID.
+func puedeVer(r *http.Request, personaID string) bool {
+	quien := personaActual(r)
+	return quien.ID == personaID || quien.GestionaPrestamos()
+}
+
+// --- Tokens ---
+
+// CrearTokenAPIHandler crea un token a partir del nombre y la contraseña de
+// la persona, para que un script obtenga su token sin pasar por la web.
+func CrearTokenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	var cred credencialesAPI
+	if !leerJSON(w, r, &cred) {
+		return
+	}
+	persona, err := autenticar(r.Context(), DB, cred.Nombre, cred.Contrasena)
+	if errors.Is(err, ErrCredenciales) {
+		responderErrorAPI(w, http.StatusUnauthorized, "credenciales_incorrectas", "Nombre o contraseña incorrectos")
+		return
+	}
+	if err != nil {
+		responderErrorDominio(w, r, err)
+		return
+	}
+	token, guardado, err := crearToken(r.Context(), DB, persona, cred.Descripcion, time.Now())
+	if err != nil {
+		responderErrorDominio(w, r, err)
+		return
+	}
+	log.Printf("🔑 %s creó un token de la API", persona.Nombre)
+	responderJSON(w, http.StatusCreated, TokenCreadoAPI{Token: token, TokenAPI: *guardado, Persona: persona})
+}
+
+// TokensAPIHandler lista los tokens de quien pide.
+func TokensAPIHandler(w http.ResponseWriter, r *http.Request) {
+	tokens, err := DB.Tokens().PorPersona(r.Context(), personaActual(r).ID)
+	if err != nil {
+		responderErrorDominio(w, r, err)
+		return
+	}
+	responderJSON(w, http.StatusOK, ListaAPI[TokenAPI]{Datos: tokens})
+}
+
+// RevocarTokenAPIHandler revoca un token propio (o cualquiera, para un
+// administrador).
+func RevocarTokenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if err := revocarToken(r.Context(), DB, r.PathValue("id"), personaActual(r)); err != nil {
+		responderErrorDominio(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// --- Libros ---
+
+// LibrosAPIHandler lista los libros de a una página; q busca en el índice
+// del catálogo, igual que /libros.
+func LibrosAPIHandler(w http.ResponseWriter, r *http.Request) {
+	libros, _, siguiente, err := paginaLibros(r)
+	if err != nil {
+		responderErrorDominio(w, r, err)
+		return
+	}
+	responderJSON(w, http.StatusOK, ListaAPI[LibroEncontrado]{Datos: libros, Siguiente: siguiente})
+}
+
+func LibroAPIHandler(w http.ResponseWriter, r *http.Request) {
+	libro, err := DB.Libros().Obtener(r.Context(), r.PathValue("id"))
+	if err != nil {
+		responderErrorDominio(w, r, err)
+		return
+	}
+	responderJSON(w, http.StatusOK, libro)
+}
+
+// leerLibroAPI lee y valida el libro del cuerpo. Total es el stock; ID,
+// Copias y Disponible se ignoran porque los calcula el servidor.
+func leerLibroAPI(w http.ResponseWriter, r *http.Request) (*Libro, bool) {
+	var libro Libro
+	if !leerJSON(w, r, &libro) {
+		return nil, false
+	}
+	libro.Nombre = strings.TrimSpace(libro.Nombre)
+	if libro.Nombre == "" || libro.Total < 0 {
+		responderErrorAPI(w, http.StatusBadRequest, "datos_invalidos", "El libro necesita un nombre y un total de ejemplares no negativo")
+		return nil, false
+	}
+	return &libro, true
+}
+
+// CrearLibroAPIHandler registra un libro con Total ejemplares.
+func CrearLibroAPIHandler(w http.ResponseWriter, r *http.Request) {
+	libro, ok := leerLibroAPI(w, r)
+	if !ok {
+		return
+	}
+	nuevo := &Libro{Nombre: libro.Nombre, Autor: libro.Autor, Ano: libro.Ano, Descripcion: libro.Descripcion,
+		ImagenURL: libro.ImagenURL, Restringido: libro.Restringido}
+	if err := registrarLibro(r.Context(), DB, nuevo, libro.Total); err != nil {
+		responderErrorDominio(w, r, err)
+		return
+	}
+	log.Println("✅ Libro registrado desde la API:", nuevo.Nombre)
+	w.Header().Set("Location", "/api/v1/libros/"+nuevo.ID)
+	responderJSON(w, http.StatusCreated, nuevo)
+}
+
+// EditarLibroAPIHandler reemplaza los datos del libro y ajusta su stock a
+// Total, como el formulario de edición.
+func EditarLibroAPIHandler(w http.ResponseWriter, r *http.Request) {
+	libro, ok := leerLibroAPI(w, r)
+	if !ok {
+		return
+	}
+	libro.ID = r.PathValue("id")
+	if err := editarLibro(r.Context(), DB, libro, libro.Total, time.Now()); err != nil {
+		responderErrorDominio(w, r, err)
+		return
+	}
+	LibroAPIHandler(w, r)
+}
+
+// EliminarLibroAPIHandler borra un libro sin préstamos registrados.
+func EliminarLibroAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if err := eliminarLibro(r.Context(), DB, r.PathValue("id"), time.Now()); err != nil {
+		responderErrorDominio(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// --- Personas ---
+
+// PersonasAPIHandler lista las personas de a una página; cedula busca una
+// sola.
+func PersonasAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if cedula := r.URL.Query().Get("cedula"); cedula != "" {
+		persona, err := DB.Personas().BuscarPorCedula(r.Context(), cedula)
+		if errors.Is(err, ErrNoEncontrado) {
+			responderJSON(w, http.StatusOK, ListaAPI[Persona]{Datos: []Persona{}})
+			return
+		}
+		if err != nil {
+			responderErrorDominio(w, r, err)
+			return
+		}
+		responderJSON(w, http.StatusOK, ListaAPI[Persona]{Datos: []Persona{*persona}})
+		return
+	}
+	personas, _, siguiente, err := paginaPersonas(r)
+	if err != nil {
+		responderErrorDominio(w, r, err)
+		return
+	}
+	responderJSON(w, http.StatusOK, ListaAPI[Persona]{Datos: personas, Siguiente: siguiente})
+}
+
+// PersonaAPIHandler muestra una persona a sí misma o a quien gestiona
+// préstamos.
+func PersonaAPIHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !puedeVer(r, id) {
+		responderErrorDominio(w, r, ErrNoAutorizado)
+		return
+	}
+	persona, err := DB.Personas().Obtener(r.Context(), id)
+	if err != nil {
+		responderErrorDominio(w, r, err)
+		return
+	}
+	responderJSON(w, http.StatusOK, persona)
+}
+
+// CrearPersonaAPIHandler registra una persona con el rol indicado.
+func CrearPersonaAPIHandler(w http.ResponseWriter, r *http.Request) {
+	var datos personaAPI
+	if !leerJSON(w, r, &datos) {
+		return
+	}
+	if datos.Rol == "" {
+		datos.Rol = RolUsuario
+	}
+	if datos.Nombre == "" || datos.Cedula == "" || datos.Contrasena == "" ||
+		!slices.Contains(rolesPersona, datos.Rol) {
+		responderErrorAPI(w, http.StatusBadRequest, "datos_invalidos", "La persona necesita nombre, cédula, contraseña y un rol válido (usuario, bibliotecario o admin)")
+		return
+	}
+	persona := &Persona{Nombre: datos.Nombre, Cedula: datos.Cedula, Ano: datos.Ano, Rol: datos.Rol}
+	if err := registrarPersona(r.Context(), DB, persona, datos.Contrasena); err != nil {
+		responderErrorDominio(w, r, err)
+		return
+	}
+	log.Println("✅ Persona registrada desde la API:", persona.Nombre)
+	w.Header().Set("Location", "/api/v1/personas/"+persona.ID)
+	responderJSON(w, http.StatusCreated, persona)
+}
+
+// EliminarPersonaAPIHandler borra una persona sin préstamos ni multas
+// registrados.
+func EliminarPersonaAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if err := eliminarPersona(r.Context(), DB, r.PathValue("id"), time.Now()); err != nil {
+		responderErrorDominio(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// --- Préstamos y devoluciones ---
+
+// PrestamosAPIHandler lista préstamos en cualquier estado, del más reciente
+// al más antiguo, con los filtros del historial (libro, persona, desde y
+// hasta en AAAA-MM-DD) y estado, que se puede repetir. Cada persona sólo ve
+// los suyos; bibliotecarios y administradores, los de cualquiera.
+func PrestamosAPIHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filtros := FiltrosHistorial{LibroID: q.Get("libro"), PersonaID: q.Get("persona"), Desde: q.Get("desde"), Hasta: q.Get("hasta")}
+	quien := personaActual(r)
+	if !quien.GestionaPrestamos() {
+		if filtros.PersonaID != "" && filtros.PersonaID != quien.ID {
+			responderErrorDominio(w, r, ErrNoAutorizado)
+			return
+		}
+		filtros.PersonaID = quien.ID
+	}
+	filtro, err := filtros.aFiltro()
+	if err != nil {
+		responderErrorAPI(w, http.StatusBadRequest, "datos_invalidos", "Las fechas deben tener el formato AAAA-MM-DD")
+		return
+	}
+	filtro.Estados = q["estado"]
+	prestamos, err := DB.Prestamos().Historial(r.Context(), filtro)
+	if err != nil {
+		responderErrorDominio(w, r, err)
+		return
+	}
+	responderJSON(w, http.StatusOK, ListaAPI[Prestamo]{Datos: prestamos})
+}
+
+func PrestamoAPIHandler(w http.ResponseWriter, r *http.Request) {
+	prestamo, err := DB.Prestamos().Obtener(r.Context(), r.PathValue("id"))
+	if err == nil && !puedeVer(r, prestamo.PersonaID) {
+		err = ErrNoAutorizado
+	}
+	if err != nil {
+		responderErrorDominio(w, r, err)
+		return
+	}
+	responderJSON(w, http.StatusOK, prestamo)
+}
+
+// PrestarAPIHandler presta los libros de libroIDs en una sola transacción,
+// como el carrito de Préstamos: se registran todos o ninguno. Si se rechaza,
+// el error trae el resultado de cada libro.
+func PrestarAPIHandler(w http.ResponseWriter, r *http.Request) {
+	var datos prestamoAPI
+	if !leerJSON(w, r, &datos) {
+		return
+	}
+	if len(datos.LibroIDs) == 0 {
+		responderErrorAPI(w, http.StatusBadRequest, "datos_invalidos", "Indica al menos un libro en libroIDs")
+		return
+	}
+	persona, err := personaObjetivo(r, datos.PersonaID)
+	if err != nil {
+		responderErrorDominio(w, r, err)
+		return
+	}
+
+	resultados, err := prestarLibros(r.Context(), DB, datos.LibroIDs, persona.ID, time.Now())
+	if errors.Is(err, ErrCarritoRechazado) {
+		respuesta, estado := respuestaCarrito(resultados, err, persona)
+		responderJSON(w, estado, ErrorAPI{DetalleErrorAPI{Codigo: "carrito_rechazado", Mensaje: respuesta.Mensaje, Items: respuesta.Items}})
+		return
+	}
+	if err != nil {
+		responderErrorDominio(w, r, err)
+		return
+	}
+	var prestamos []Prestamo
+	for _, res := range resultados {
+		prestamos = append(prestamos, *res.Prestamo)
+	}
+	log.Printf("✅ Préstamo registrado desde la API: Libros %v, PersonaID '%s'", datos.LibroIDs, persona.ID)
+	responderJSON(w, http.StatusCreated, ListaAPI[Prestamo]{Datos: prestamos})
+}
+
+// RenovarAPIHandler extiende el vencimiento de un préstamo.
+func RenovarAPIHandler(w http.ResponseWriter, r *http.Request) {
+	prestamo, err := renovarPrestamo(r.Context(), DB, r.PathValue("id"), personaActual(r), time.Now())
+	if err != nil {
+		responderErrorDominio(w, r, err)
+		return
+	}
+	responderJSON(w, http.StatusOK, prestamo)
+}
+
+// DevolverAPIHandler registra la devolución de un préstamo y responde el
+// préstamo cerrado.
+func DevolverAPIHandler(w http.ResponseWriter, r *http.Request) {
+	var datos devolucionAPI
+	if !leerJSON(w, r, &datos) {
+		return
+	}
+	if err := devolverLibro(r.Context(), DB, datos.PrestamoID, personaActual(r), time.Now()); err != nil {
+		responderErrorDominio(w, r, err)
+		return
+	}
+	prestamo, err := DB.Prestamos().Obtener(r.Context(), datos.PrestamoID)
+	if err != nil {
+		responderErrorDominio(w, r, err)
+		return
+	}
+	responderJSON(w, http.StatusOK, prestamo)
+}
+
+// --- Reservas ---
+
+// ReservasAPIHandler lista las reservas activas de quien pide o, para
+// bibliotecarios y administradores, de la persona indicada.
+func ReservasAPIHandler(w http.ResponseWriter, r *http.Request) {
+	persona, err := personaObjetivo(r, r.URL.Query().Get("persona"))
+	if err != nil {
+		responderErrorDominio(w, r, err)
+		return
+	}
+	reservas, err := DB.Reservas().ActivasPorPersona(r.Context(), persona.ID)
+	if err != nil {
+		responderErrorDominio(w, r, err)
+		return
+	}
+	responderJSON(w, http.StatusOK, ListaAPI[Reserva]{Datos: reservas})
+}
+
+func ReservaAPIHandler(w http.ResponseWriter, r *http.Request) {
+	reserva, err := DB.Reservas().Obtener(r.Context(), r.PathValue("id"))
+	if err == nil && !puedeVer(r, reserva.PersonaID) {
+		err = ErrNoAutorizado
+	}
+	if err != nil {
+		responderErrorDominio(w, r, err)
+		return
+	}
+	responderJSON(w, http.StatusOK, reserva)
+}
+
+// ReservarAPIHandler pone a la persona en la cola de espera del libro.
+func ReservarAPIHandler(w http.ResponseWriter, r *http.Request) {
+	var datos reservaAPI
+	if !leerJSON(w, r, &datos) {
+		return
+	}
+	persona, err := personaObjetivo(r, datos.PersonaID)
+	if err != nil {
+		responderErrorDominio(w, r, err)
+		return
+	}
+	reserva, err := reservarLibro(r.Context(), DB, datos.LibroID, persona.ID, time.Now())
+	if err != nil {
+		responderErrorDominio(w, r, err)
+		return
+	}
+	w.Header().Set("Location", "/api/v1/reservas/"+reserva.ID)
+	responderJSON(w, http.StatusCreated, reserva)
+}
+
+// CancelarReservaAPIHandler cancela una reserva activa.
+func CancelarReservaAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if err := cancelarReserva(r.Context(), DB, r.PathValue("id"), personaActual(r), time.Now()); err != nil {
+		responderErrorDominio(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
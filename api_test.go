@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// api hace una petición a la API con el cuerpo en JSON y, si token no está
+// vacío, con la cabecera Authorization. No envía el token CSRF.
+func (c *clientePrueba) api(metodo, ruta, token string, cuerpo any) respuestaPrueba {
+	c.t.Helper()
+	var datos []byte
+	if cuerpo != nil {
+		datos, _ = json.Marshal(cuerpo)
+	}
+	req, _ := http.NewRequest(metodo, c.srv.URL+ruta, bytes.NewReader(datos))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return c.hacer(req)
+}
+
+// leerRespuesta decodifica el cuerpo JSON de la respuesta.
+func leerRespuesta[T any](t *testing.T, resp respuestaPrueba) T {
+	t.Helper()
+	var v T
+	if err := json.Unmarshal([]byte(resp.Cuerpo), &v); err != nil {
+		t.Fatalf("%s %s: respuesta no es JSON: %v\n%s", resp.Request.Method, resp.Request.URL.Path, err, resp.Cuerpo)
+	}
+	return v
+}
+
+// esperarErrorAPI comprueba el estado y el código de un error de la API.
+func esperarErrorAPI(t *testing.T, resp respuestaPrueba, estado int, codigo string) ErrorAPI {
+	t.Helper()
+	esperarEstado(t, resp, estado)
+	e := leerRespuesta[ErrorAPI](t, resp)
+	if e.Error.Codigo != codigo {
+		t.Fatalf("%s %s: código %q, se esperaba %q", resp.Request.Method, resp.Request.URL.Path, e.Error.Codigo, codigo)
+	}
+	return e
+}
+
+// tokenDe pide un token a la API con el nombre y la contraseña.
+func tokenDe(t *testing.T, c *clientePrueba, nombre, contrasena string) string {
+	t.Helper()
+	resp := c.api(http.MethodPost, "/api/v1/tokens", "", credencialesAPI{Nombre: nombre, Contrasena: contrasena, Descripcion: "pruebas"})
+	esperarEstado(t, resp, http.StatusCreated)
+	return leerRespuesta[TokenCreadoAPI](t, resp).Token
+}
+
+func TestAPITokens(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		ana := crearPersona(t, "ana", "clave", "usuario")
+
+		esperarErrorAPI(t, c.api(http.MethodPost, "/api/v1/tokens", "", credencialesAPI{Nombre: "ana", Contrasena: "mal"}), http.StatusUnauthorized, "credenciales_incorrectas")
+		resp := c.api(http.MethodPost, "/api/v1/tokens", "", credencialesAPI{Nombre: "ana", Contrasena: "clave", Descripcion: "inventario"})
+		esperarEstado(t, resp, http.StatusCreated)
+		creado := leerRespuesta[TokenCreadoAPI](t, resp)
+		if !strings.HasPrefix(creado.Token, prefijoToken) || creado.Persona.ID != ana.ID || creado.ID != hashToken(creado.Token) {
+			t.Fatalf("token mal creado: %+v", creado)
+		}
+		if strings.Contains(resp.Cuerpo, "clave") {
+			t.Errorf("la respuesta incluye la contraseña: %s", resp.Cuerpo)
+		}
+		// Sólo se guarda el hash del token
+		if _, err := DB.Tokens().Obtener(context.Background(), creado.Token); err != ErrNoEncontrado {
+			t.Errorf("el token en claro no debería ser un ID: err = %v", err)
+		}
+
+		tokens := leerRespuesta[ListaAPI[TokenAPI]](t, c.api(http.MethodGet, "/api/v1/tokens", creado.Token, nil))
+		if len(tokens.Datos) != 1 || tokens.Datos[0].Descripcion != "inventario" || tokens.Datos[0].UltimoUso.IsZero() {
+			t.Errorf("tokens de ana: %+v", tokens.Datos)
+		}
+
+		esperarErrorAPI(t, c.api(http.MethodGet, "/api/v1/prestamos", "", nil), http.StatusUnauthorized, "no_autenticado")
+		esperarErrorAPI(t, c.api(http.MethodGet, "/api/v1/libros", "bib_falso", nil), http.StatusUnauthorized, "token_invalido")
+
+		// La cookie de sesión no sirve en la API
+		c.login("ana", "clave")
+		esperarErrorAPI(t, c.api(http.MethodGet, "/api/v1/prestamos", "", nil), http.StatusUnauthorized, "no_autenticado")
+
+		esperarEstado(t, c.api(http.MethodDelete, "/api/v1/tokens/"+creado.ID, creado.Token, nil), http.StatusNoContent)
+		esperarErrorAPI(t, c.api(http.MethodGet, "/api/v1/prestamos", creado.Token, nil), http.StatusUnauthorized, "token_invalido")
+	})
+}
+
+func TestAPILibros(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		crearPersona(t, "admin", "clave", "admin")
+		crearPersona(t, "ana", "clave", "usuario")
+		crearLibro(t, "Rayuela", 1)
+		admin := tokenDe(t, c, "admin", "clave")
+		ana := tokenDe(t, c, "ana", "clave")
+
+		libros := leerRespuesta[ListaAPI[Libro]](t, c.api(http.MethodGet, "/api/v1/libros?q=rayu", "", nil))
+		if len(libros.Datos) != 1 || libros.Datos[0].Nombre != "Rayuela" {
+			t.Fatalf("búsqueda pública de libros: %+v", libros.Datos)
+		}
+
+		nuevo := Libro{Nombre: "Ficciones", Autor: "Borges", Ano: 1944, Total: 2}
+		esperarErrorAPI(t, c.api(http.MethodPost, "/api/v1/libros", "", nuevo), http.StatusUnauthorized, "no_autenticado")
+		esperarErrorAPI(t, c.api(http.MethodPost, "/api/v1/libros", ana, nuevo), http.StatusForbidden, "acceso_denegado")
+		esperarErrorAPI(t, c.api(http.MethodPost, "/api/v1/libros", admin, Libro{Total: 1}), http.StatusBadRequest, "datos_invalidos")
+		esperarErrorAPI(t, c.api(http.MethodPost, "/api/v1/libros", admin, map[string]any{"nombre": "X", "paginas": 10}), http.StatusBadRequest, "json_invalido")
+
+		resp := c.api(http.MethodPost, "/api/v1/libros", admin, nuevo)
+		esperarEstado(t, resp, http.StatusCreated)
+		creado := leerRespuesta[Libro](t, resp)
+		if creado.ID == "" || creado.Total != 2 || creado.Copias != 2 || resp.Header.Get("Location") != "/api/v1/libros/"+creado.ID {
+			t.Fatalf("libro creado: %+v (Location %q)", creado, resp.Header.Get("Location"))
+		}
+
+		creado.Total = 3
+		creado.Restringido = true
+		resp = c.api(http.MethodPut, "/api/v1/libros/"+creado.ID, admin, creado)
+		esperarEstado(t, resp, http.StatusOK)
+		if editado := leerRespuesta[Libro](t, resp); editado.Total != 3 || editado.Copias != 3 || !editado.Restringido {
+			t.Errorf("libro editado: %+v", editado)
+		}
+		esperarErrorAPI(t, c.api(http.MethodPut, "/api/v1/libros/no-existe", admin, creado), http.StatusNotFound, "no_encontrado")
+
+		esperarEstado(t, c.api(http.MethodDelete, "/api/v1/libros/"+creado.ID, admin, nil), http.StatusNoContent)
+		esperarErrorAPI(t, c.api(http.MethodGet, "/api/v1/libros/"+creado.ID, "", nil), http.StatusNotFound, "no_encontrado")
+
+		esperarErrorAPI(t, c.api(http.MethodGet, "/api/v1/no-existe", "", nil), http.StatusNotFound, "ruta_no_encontrada")
+		resp = c.api(http.MethodPatch, "/api/v1/libros", admin, nil)
+		esperarErrorAPI(t, resp, http.StatusMethodNotAllowed, "metodo_no_permitido")
+		if !strings.Contains(resp.Header.Get("Allow"), "POST") {
+			t.Errorf("el 405 debe indicar los métodos permitidos: Allow = %q", resp.Header.Get("Allow"))
+		}
+	})
+}
+
+func TestAPIPrestamosYDevoluciones(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		crearPersona(t, "ana", "clave", "usuario")
+		crearPersona(t, "beto", "clave", "usuario")
+		rayuela := crearLibro(t, "Rayuela", 1)
+		ficciones := crearLibro(t, "Ficciones", 1)
+		ana := tokenDe(t, c, "ana", "clave")
+		beto := tokenDe(t, c, "beto", "clave")
+
+		resp := c.api(http.MethodPost, "/api/v1/prestamos", ana, prestamoAPI{LibroIDs: []string{rayuela.ID}})
+		esperarEstado(t, resp, http.StatusCreated)
+		prestados := leerRespuesta[ListaAPI[Prestamo]](t, resp)
+		if len(prestados.Datos) != 1 || prestados.Datos[0].Estado != EstadoActivo {
+			t.Fatalf("préstamo creado: %+v", prestados.Datos)
+		}
+		prestamo := prestados.Datos[0]
+
+		// Todo o nada: Rayuela ya no tiene copias, así que tampoco se presta Ficciones
+		e := esperarErrorAPI(t, c.api(http.MethodPost, "/api/v1/prestamos", beto, prestamoAPI{LibroIDs: []string{ficciones.ID, rayuela.ID}}),
+			http.StatusConflict, "carrito_rechazado")
+		if len(e.Error.Items) != 2 || e.Error.Items[0].Error != "" || e.Error.Items[1].Error == "" {
+			t.Errorf("items del carrito rechazado: %+v", e.Error.Items)
+		}
+		esperarErrorAPI(t, c.api(http.MethodPost, "/api/v1/prestamos", beto, prestamoAPI{}), http.StatusBadRequest, "datos_invalidos")
+
+		esperarErrorAPI(t, c.api(http.MethodGet, "/api/v1/prestamos/"+prestamo.ID, beto, nil), http.StatusForbidden, "no_autorizado")
+		esperarErrorAPI(t, c.api(http.MethodGet, "/api/v1/prestamos?persona="+prestamo.PersonaID, beto, nil), http.StatusForbidden, "no_autorizado")
+		if propios := leerRespuesta[ListaAPI[Prestamo]](t, c.api(http.MethodGet, "/api/v1/prestamos", beto, nil)); len(propios.Datos) != 0 {
+			t.Errorf("beto ve préstamos ajenos: %+v", propios.Datos)
+		}
+		if activos := leerRespuesta[ListaAPI[Prestamo]](t, c.api(http.MethodGet, "/api/v1/prestamos?estado=activo", ana, nil)); len(activos.Datos) != 1 {
+			t.Errorf("préstamos activos de ana: %+v", activos.Datos)
+		}
+
+		resp = c.api(http.MethodPost, "/api/v1/prestamos/"+prestamo.ID+"/renovacion", ana, nil)
+		esperarEstado(t, resp, http.StatusOK)
+		if renovado := leerRespuesta[Prestamo](t, resp); renovado.Renovaciones != 1 || !renovado.FechaVencimiento.After(prestamo.FechaVencimiento) {
+			t.Errorf("préstamo renovado: %+v", renovado)
+		}
+
+		esperarErrorAPI(t, c.api(http.MethodPost, "/api/v1/devoluciones", beto, devolucionAPI{PrestamoID: prestamo.ID}), http.StatusForbidden, "no_autorizado")
+		resp = c.api(http.MethodPost, "/api/v1/devoluciones", ana, devolucionAPI{PrestamoID: prestamo.ID})
+		esperarEstado(t, resp, http.StatusOK)
+		if devuelto := leerRespuesta[Prestamo](t, resp); devuelto.Estado != EstadoDevuelto || devuelto.FechaDevolucion.IsZero() {
+			t.Errorf("préstamo devuelto: %+v", devuelto)
+		}
+		esperarErrorAPI(t, c.api(http.MethodPost, "/api/v1/devoluciones", ana, devolucionAPI{PrestamoID: prestamo.ID}), http.StatusConflict, "prestamo_cerrado")
+		if l := obtenerLibro(t, rayuela.ID); l.Copias != 1 {
+			t.Errorf("copias = %d tras devolver por la API", l.Copias)
+		}
+	})
+}
+
+func TestAPIReservasYPersonas(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		crearPersona(t, "admin", "clave", "admin")
+		crearPersona(t, "bibliotecaria", "clave", "bibliotecario")
+		ana := crearPersona(t, "ana", "clave", "usuario")
+		beto := crearPersona(t, "beto", "clave", "usuario")
+		agotado := crearLibro(t, "El Aleph", 1)
+		prestar(t, agotado, ana)
+		tokenAdmin := tokenDe(t, c, "admin", "clave")
+		tokenBib := tokenDe(t, c, "bibliotecaria", "clave")
+		tokenBeto := tokenDe(t, c, "beto", "clave")
+
+		resp := c.api(http.MethodPost, "/api/v1/reservas", tokenBeto, reservaAPI{LibroID: agotado.ID})
+		esperarEstado(t, resp, http.StatusCreated)
+		reserva := leerRespuesta[Reserva](t, resp)
+		esperarEstado(t, c.api(http.MethodGet, resp.Header.Get("Location"), tokenBeto, nil), http.StatusOK)
+		esperarErrorAPI(t, c.api(http.MethodPost, "/api/v1/reservas", tokenBeto, reservaAPI{LibroID: agotado.ID}), http.StatusConflict, "ya_reservado")
+		esperarErrorAPI(t, c.api(http.MethodPost, "/api/v1/reservas", tokenBeto, reservaAPI{LibroID: agotado.ID, PersonaID: ana.ID}), http.StatusForbidden, "no_autorizado")
+		if reservas := leerRespuesta[ListaAPI[Reserva]](t, c.api(http.MethodGet, "/api/v1/reservas?persona="+beto.ID, tokenBib, nil)); len(reservas.Datos) != 1 {
+			t.Errorf("reservas de beto vistas desde el mostrador: %+v", reservas.Datos)
+		}
+		esperarEstado(t, c.api(http.MethodDelete, "/api/v1/reservas/"+reserva.ID, tokenBeto, nil), http.StatusNoContent)
+		esperarErrorAPI(t, c.api(http.MethodDelete, "/api/v1/reservas/"+reserva.ID, tokenBeto, nil), http.StatusConflict, "reserva_cerrada")
+
+		esperarErrorAPI(t, c.api(http.MethodGet, "/api/v1/personas", tokenBeto, nil), http.StatusForbidden, "acceso_denegado")
+		personas := leerRespuesta[ListaAPI[Persona]](t, c.api(http.MethodGet, "/api/v1/personas?cedula=ced-ana", tokenBib, nil))
+		if len(personas.Datos) != 1 || personas.Datos[0].ID != ana.ID {
+			t.Errorf("búsqueda por cédula: %+v", personas.Datos)
+		}
+		esperarEstado(t, c.api(http.MethodGet, "/api/v1/personas/"+beto.ID, tokenBeto, nil), http.StatusOK)
+		esperarErrorAPI(t, c.api(http.MethodGet, "/api/v1/personas/"+ana.ID, tokenBeto, nil), http.StatusForbidden, "no_autorizado")
+
+		nueva := personaAPI{Nombre: "carla", Cedula: "ced-carla", Ano: 1999, Contrasena: "secreta"}
+		esperarErrorAPI(t, c.api(http.MethodPost, "/api/v1/personas", tokenBib, nueva), http.StatusForbidden, "acceso_denegado")
+		resp = c.api(http.MethodPost, "/api/v1/personas", tokenAdmin, nueva)
+		esperarEstado(t, resp, http.StatusCreated)
+		carla := leerRespuesta[Persona](t, resp)
+		if carla.Rol != RolUsuario || strings.Contains(resp.Cuerpo, "secreta") {
+			t.Errorf("persona creada: %s", resp.Cuerpo)
+		}
+		esperarErrorAPI(t, c.api(http.MethodPost, "/api/v1/personas", tokenAdmin, nueva), http.StatusConflict, "cedula_registrada")
+		tokenDe(t, c, "carla", "secreta")
+
+		// La bibliotecaria presta a nombre de otra persona, como en el mostrador
+		libro := crearLibro(t, "Rayuela", 1)
+		resp = c.api(http.MethodPost, "/api/v1/prestamos", tokenBib, prestamoAPI{LibroIDs: []string{libro.ID}, PersonaID: carla.ID})
+		esperarEstado(t, resp, http.StatusCreated)
+		if p := leerRespuesta[ListaAPI[Prestamo]](t, resp).Datos[0]; p.PersonaID != carla.ID {
+			t.Errorf("el préstamo debe quedar a nombre de carla: %+v", p)
+		}
+		esperarErrorAPI(t, c.api(http.MethodDelete, "/api/v1/personas/"+carla.ID, tokenAdmin, nil), http.StatusConflict, "con_prestamos")
+		esperarErrorAPI(t, c.api(http.MethodDelete, "/api/v1/libros/"+libro.ID, tokenAdmin, nil), http.StatusConflict, "con_prestamos")
+		esperarErrorAPI(t, c.api(http.MethodDelete, "/api/v1/libros/no-existe", tokenAdmin, nil), http.StatusNotFound, "no_encontrado")
+		esperarEstado(t, c.api(http.MethodDelete, "/api/v1/personas/"+beto.ID, tokenAdmin, nil), http.StatusNoContent)
+		esperarErrorAPI(t, c.api(http.MethodGet, "/api/v1/tokens", tokenBeto, nil), http.StatusUnauthorized, "token_invalido")
+	})
+}
+
+var reNuevoToken = regexp.MustCompile(`id="nuevoToken">(bib_[^<]+)<`)
+
+func TestTokensDesdePerfil(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		crearPersona(t, "ana", "clave", "usuario")
+		c.login("ana", "clave")
+
+		resp := c.post("/perfil/tokens", url.Values{"descripcion": {"hoja de cálculo"}})
+		esperarEstado(t, resp, http.StatusOK)
+		m := reNuevoToken.FindStringSubmatch(resp.Cuerpo)
+		if m == nil {
+			t.Fatalf("el perfil no muestra el token recién creado")
+		}
+		token := m[1]
+		esperarEstado(t, c.api(http.MethodGet, "/api/v1/prestamos", token, nil), http.StatusOK)
+
+		resp = c.get("/perfil")
+		if !strings.Contains(resp.Cuerpo, "hoja de cálculo") || strings.Contains(resp.Cuerpo, token) {
+			t.Errorf("el perfil debe listar el token sin volver a mostrarlo")
+		}
+		esperarRedireccion(t, c.post("/perfil/tokens/revocar", url.Values{"id": {hashToken(token)}}), "Token revocado")
+		esperarErrorAPI(t, c.api(http.MethodGet, "/api/v1/prestamos", token, nil), http.StatusUnauthorized, "token_invalido")
+	})
+}
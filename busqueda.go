@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/analysis"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/custom"
+	"github.com/blevesearch/bleve/v2/analysis/lang/es"
+	"github.com/blevesearch/bleve/v2/analysis/token/lowercase"
+	"github.com/blevesearch/bleve/v2/analysis/tokenizer/unicode"
+	"github.com/blevesearch/bleve/v2/search/highlight/highlighter/html"
+	"github.com/blevesearch/bleve/v2/search/query"
+)
+
+// LibroEncontrado es un libro del catálogo con los fragmentos de sus campos
+// que coinciden con la búsqueda.
+type LibroEncontrado struct {
+	Libro
+	Fragmentos map[string]string `json:"fragmentos,omitempty"` // Por campo (nombre, autor, descripcion), en HTML con las coincidencias entre <mark>
+}
+
+// camposBusqueda son los campos de texto que se indexan, con el peso de una
+// coincidencia en cada uno.
+var camposBusqueda = []struct {
+	nombre string
+	peso   float64
+}{
+	{"nombre", 3},
+	{"autor", 2},
+	{"descripcion", 1},
+}
+
+// Analizadores del índice. Cada campo se indexa dos veces: con el analizador
+// de español de Bleve (minúsculas, palabras vacías, sin tildes y raíz
+// aproximada, así "Quijotes" encuentra "quijóte") y, en el campo con
+// sufijoPrefijo, sólo en minúsculas y sin tildes, para encontrar palabras
+// a medio escribir en la búsqueda en tiempo real.
+const (
+	analizadorPrefijo = "es_prefijo"
+	sufijoPrefijo     = "_prefijo"
+)
+
+// IndiceCatalogo es el índice de texto completo de los libros. Vive en
+// memoria: main lo llena al iniciar con indexarCatalogo y registrarLibro,
+// editarLibro y eliminarLibro lo actualizan.
+type IndiceCatalogo struct {
+	indice  bleve.Index
+	prefijo analysis.Analyzer
+}
+
+// Catalogo es el índice que usan los handlers.
+var Catalogo *IndiceCatalogo
+
+// nuevoIndiceCatalogo crea un índice vacío.
+func nuevoIndiceCatalogo() (*IndiceCatalogo, error) {
+	mapeo := bleve.NewIndexMapping()
+	err := mapeo.AddCustomAnalyzer(analizadorPrefijo, map[string]any{
+		"type":          custom.Name,
+		"tokenizer":     unicode.Name,
+		"token_filters": []string{lowercase.Name, es.StopName, es.NormalizeName},
+	})
+	if err != nil {
+		return nil, err
+	}
+	libro := bleve.NewDocumentStaticMapping()
+	for _, campo := range camposBusqueda {
+		texto := bleve.NewTextFieldMapping()
+		texto.Analyzer = es.AnalyzerName
+		texto.IncludeInAll = false
+		prefijo := bleve.NewTextFieldMapping()
+		prefijo.Name = campo.nombre + sufijoPrefijo
+		prefijo.Analyzer = analizadorPrefijo
+		prefijo.IncludeInAll = false
+		libro.AddFieldMappingsAt(campo.nombre, texto, prefijo)
+	}
+	mapeo.DefaultMapping = libro
+
+	indice, err := bleve.NewMemOnly(mapeo)
+	if err != nil {
+		return nil, err
+	}
+	prefijo := mapeo.AnalyzerNamed(analizadorPrefijo)
+	if prefijo == nil {
+		return nil, fmt.Errorf("falta el analizador %s", analizadorPrefijo)
+	}
+	return &IndiceCatalogo{indice: indice, prefijo: prefijo}, nil
+}
+
+// indexarCatalogo crea el índice con todos los libros del store.
+func indexarCatalogo(ctx context.Context, store Store) (*IndiceCatalogo, error) {
+	c, err := nuevoIndiceCatalogo()
+	if err != nil {
+		return nil, err
+	}
+	libros, err := store.Libros().Listar(ctx)
+	if err != nil {
+		return nil, err
+	}
+	lote := c.indice.NewBatch()
+	for _, l := range libros {
+		if err := lote.Index(l.ID, documentoBusqueda(l)); err != nil {
+			return nil, err
+		}
+	}
+	return c, c.indice.Batch(lote)
+}
+
+func documentoBusqueda(l Libro) map[string]any {
+	return map[string]any{"nombre": l.Nombre, "autor": l.Autor, "descripcion": l.Descripcion}
+}
+
+// Indexar agrega el libro al índice o reemplaza su versión anterior.
+func (c *IndiceCatalogo) Indexar(l Libro) {
+	if c == nil {
+		return
+	}
+	if err := c.indice.Index(l.ID, documentoBusqueda(l)); err != nil {
+		log.Printf("Error al indexar el libro %s: %v", l.ID, err)
+	}
+}
+
+// Quitar saca el libro del índice.
+func (c *IndiceCatalogo) Quitar(id string) {
+	if c == nil {
+		return
+	}
+	if err := c.indice.Delete(id); err != nil {
+		log.Printf("Error al quitar el libro %s del índice: %v", id, err)
+	}
+}
+
+// Close libera el índice.
+func (c *IndiceCatalogo) Close() error {
+	return c.indice.Close()
+}
+
+// consulta arma la búsqueda de q: cada palabra tiene que aparecer en algún
+// campo, entera (comparando raíces) o como comienzo de una palabra.
+func (c *IndiceCatalogo) consulta(q string) query.Query {
+	var palabras []query.Query
+	for _, token := range c.prefijo.Analyze([]byte(q)) {
+		palabra := string(token.Term)
+		var alternativas []query.Query
+		for _, campo := range camposBusqueda {
+			entera := bleve.NewMatchQuery(palabra)
+			entera.SetField(campo.nombre)
+			entera.SetBoost(2 * campo.peso)
+			prefijo := bleve.NewPrefixQuery(palabra)
+			prefijo.SetField(campo.nombre + sufijoPrefijo)
+			prefijo.SetBoost(campo.peso)
+			alternativas = append(alternativas, entera, prefijo)
+		}
+		palabras = append(palabras, bleve.NewDisjunctionQuery(alternativas...))
+	}
+	if len(palabras) == 0 {
+		return bleve.NewMatchNoneQuery()
+	}
+	return bleve.NewConjunctionQuery(palabras...)
+}
+
+// ResultadoBusqueda es un libro que coincide con la búsqueda.
+type ResultadoBusqueda struct {
+	ID         string
+	Fragmentos map[string]string
+}
+
+// Buscar devuelve hasta limite libros que coinciden con q, del más al menos
+// relevante, a partir de la posición desde.
+func (c *IndiceCatalogo) Buscar(q string, desde, limite int) ([]ResultadoBusqueda, error) {
+	if c == nil {
+		return nil, errors.New("el índice del catálogo no está cargado")
+	}
+	pedido := bleve.NewSearchRequestOptions(c.consulta(q), limite, desde, false)
+	pedido.SortBy([]string{"-_score", "_id"})
+	pedido.Highlight = bleve.NewHighlightWithStyle(html.Name)
+	respuesta, err := c.indice.Search(pedido)
+	if err != nil {
+		return nil, err
+	}
+	resultados := make([]ResultadoBusqueda, 0, len(respuesta.Hits))
+	for _, hit := range respuesta.Hits {
+		resultados = append(resultados, ResultadoBusqueda{ID: hit.ID, Fragmentos: fragmentos(hit.Fragments)})
+	}
+	return resultados, nil
+}
+
+// Coincidencias devuelve todos los libros que coinciden con q, por ID.
+func (c *IndiceCatalogo) Coincidencias(q string) (map[string]map[string]string, error) {
+	if c == nil {
+		return nil, errors.New("el índice del catálogo no está cargado")
+	}
+	total, err := c.indice.DocCount()
+	if err != nil {
+		return nil, err
+	}
+	resultados, err := c.Buscar(q, 0, int(total))
+	if err != nil {
+		return nil, err
+	}
+	coincidencias := make(map[string]map[string]string, len(resultados))
+	for _, r := range resultados {
+		coincidencias[r.ID] = r.Fragmentos
+	}
+	return coincidencias, nil
+}
+
+// fragmentos toma el primer fragmento de cada campo. Las coincidencias por
+// prefijo se resaltan en el campo "_prefijo", que tiene el mismo texto; se
+// usan sólo si el campo no tiene coincidencias enteras.
+func fragmentos(porCampo map[string][]string) map[string]string {
+	if len(porCampo) == 0 {
+		return nil
+	}
+	resultado := map[string]string{}
+	for campo, lista := range porCampo {
+		if len(lista) == 0 {
+			continue
+		}
+		nombre, esPrefijo := strings.CutSuffix(campo, sufijoPrefijo)
+		if _, ok := resultado[nombre]; ok && esPrefijo {
+			continue
+		}
+		resultado[nombre] = lista[0]
+	}
+	return resultado
+}
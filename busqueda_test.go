@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// catalogoDePrueba registra libros con descripción y devuelve sus IDs por
+// título.
+func catalogoDePrueba(t *testing.T) map[string]string {
+	t.Helper()
+	ids := map[string]string{}
+	for _, l := range []Libro{
+		{Nombre: "Don Quijote de la Mancha", Autor: "Miguel de Cervantes", Ano: 1605, Descripcion: "Un hidalgo enloquece leyendo libros de caballerías y sale a buscar aventuras."},
+		{Nombre: "Cien años de soledad", Autor: "Gabriel García Márquez", Ano: 1967, Descripcion: "La historia de la familia Buendía en Macondo."},
+		{Nombre: "El Aleph", Autor: "Jorge Luis Borges", Ano: 1949, Descripcion: "Cuentos sobre el infinito, los laberintos y los espejos."},
+		{Nombre: "Historia del infinito", Autor: "Ana Pérez", Ano: 2010, Descripcion: "Un ensayo de divulgación."},
+	} {
+		if err := registrarLibro(context.Background(), DB, &l, 1); err != nil {
+			t.Fatalf("creando libro: %v", err)
+		}
+		ids[l.Nombre] = l.ID
+	}
+	return ids
+}
+
+// buscar devuelve los libros que la API encuentra para q, en orden.
+func buscar(t *testing.T, c *clientePrueba, q string) []LibroEncontrado {
+	t.Helper()
+	resp := c.api(http.MethodGet, "/api/v1/libros?q="+url.QueryEscape(q), "", nil)
+	esperarEstado(t, resp, http.StatusOK)
+	return leerRespuesta[ListaAPI[LibroEncontrado]](t, resp).Datos
+}
+
+func nombres(libros []LibroEncontrado) []string {
+	var resultado []string
+	for _, l := range libros {
+		resultado = append(resultado, l.Nombre)
+	}
+	return resultado
+}
+
+func TestBusquedaDelCatalogo(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		catalogoDePrueba(t)
+		for _, caso := range []struct {
+			q        string
+			esperado []string
+		}{
+			{"quijóte", []string{"Don Quijote de la Mancha"}},           // Sin distinguir tildes
+			{"MARQUEZ", []string{"Cien años de soledad"}},               // Ni mayúsculas
+			{"caballerias", []string{"Don Quijote de la Mancha"}},       // Busca en la descripción
+			{"espejo laberinto", []string{"El Aleph"}},                  // Plurales y todas las palabras
+			{"borg", []string{"El Aleph"}},                              // Palabras a medio escribir
+			{"el quijote", []string{"Don Quijote de la Mancha"}},        // Sin palabras vacías
+			{"infinito", []string{"Historia del infinito", "El Aleph"}}, // El título pesa más que la descripción
+			{"soledad borges", nil},
+		} {
+			if encontrados := nombres(buscar(t, c, caso.q)); !slices.Equal(encontrados, caso.esperado) {
+				t.Errorf("q=%q: %q, se esperaba %q", caso.q, encontrados, caso.esperado)
+			}
+		}
+
+		libros := buscar(t, c, "quijote")
+		if len(libros) != 1 || libros[0].Fragmentos["nombre"] != "Don <mark>Quijote</mark> de la Mancha" {
+			t.Errorf("fragmentos: %+v", libros)
+		}
+		if f := buscar(t, c, "caballerías")[0].Fragmentos; !strings.Contains(f["descripcion"], "<mark>caballerías</mark>") || f["nombre"] != "" {
+			t.Errorf("fragmento de la descripción: %+v", f)
+		}
+
+		resp := c.get("/libros?q=quijote")
+		esperarEstado(t, resp, http.StatusOK)
+		if !strings.Contains(resp.Cuerpo, "Don <mark>Quijote</mark> de la Mancha") || strings.Contains(resp.Cuerpo, "Cien años") {
+			t.Errorf("la vista debe mostrar sólo las coincidencias, resaltadas:\n%s", resp.Cuerpo)
+		}
+	})
+}
+
+func TestIndiceSigueAlCatalogo(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		ids := catalogoDePrueba(t)
+		crearPersona(t, "admin", "clave", "admin")
+		admin := tokenDe(t, c, "admin", "clave")
+
+		aleph := leerRespuesta[Libro](t, c.api(http.MethodGet, "/api/v1/libros/"+ids["El Aleph"], "", nil))
+		aleph.Nombre = "Ficciones"
+		aleph.Descripcion = "Cuentos de Tlön y de la biblioteca de Babel."
+		esperarEstado(t, c.api(http.MethodPut, "/api/v1/libros/"+aleph.ID, admin, aleph), http.StatusOK)
+		if encontrados := nombres(buscar(t, c, "aleph")); encontrados != nil {
+			t.Errorf("el nombre anterior sigue en el índice: %q", encontrados)
+		}
+		if encontrados := nombres(buscar(t, c, "babel")); !slices.Equal(encontrados, []string{"Ficciones"}) {
+			t.Errorf("la edición no se indexó: %q", encontrados)
+		}
+
+		nuevo := Libro{Nombre: "Rayuela", Autor: "Julio Cortázar", Ano: 1963, Total: 1}
+		esperarEstado(t, c.api(http.MethodPost, "/api/v1/libros", admin, nuevo), http.StatusCreated)
+		if encontrados := nombres(buscar(t, c, "cortazar")); !slices.Equal(encontrados, []string{"Rayuela"}) {
+			t.Errorf("el alta no se indexó: %q", encontrados)
+		}
+
+		esperarEstado(t, c.api(http.MethodDelete, "/api/v1/libros/"+aleph.ID, admin, nil), http.StatusNoContent)
+		if encontrados := nombres(buscar(t, c, "babel")); encontrados != nil {
+			t.Errorf("el libro eliminado sigue en el índice: %q", encontrados)
+		}
+
+		// Al reiniciar, el índice se reconstruye desde el store
+		reconstruido, err := indexarCatalogo(context.Background(), DB)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reconstruido.Close()
+		if r, _ := reconstruido.Buscar("cortazar", 0, 10); len(r) != 1 || r[0].ID == "" {
+			t.Errorf("índice reconstruido: %+v", r)
+		}
+	})
+}
+
+func TestBusquedaPaginada(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		catalogoDePrueba(t)
+		relevancia := nombres(buscar(t, c, "de"))
+		if ids := recorrerAPI(t, c, "/api/v1/libros?q=de&limite=1", ""); len(ids) != len(relevancia) {
+			t.Errorf("las páginas por relevancia tienen %d libros, la búsqueda %d", len(ids), len(relevancia))
+		}
+		var porAno []string
+		for _, l := range leerRespuesta[ListaAPI[LibroEncontrado]](t, c.api(http.MethodGet, "/api/v1/libros?q=historia&orden=-ano", "", nil)).Datos {
+			porAno = append(porAno, l.Nombre)
+		}
+		if !slices.Equal(porAno, []string{"Historia del infinito", "Cien años de soledad"}) {
+			t.Errorf("búsqueda por año: %q", porAno)
+		}
+
+		esperarEstado(t, c.api(http.MethodGet, "/api/v1/libros?orden=relevancia", "", nil), http.StatusOK)
+		esperarErrorAPI(t, c.api(http.MethodGet, "/api/v1/libros?q=de&orden=-relevancia", "", nil), http.StatusBadRequest, "datos_invalidos")
+
+		// Un cursor armado a mano con una posición fuera de rango se rechaza
+		for _, valor := range []string{"-100", "-1", "10001", "1e300"} {
+			cursor := base64.RawURLEncoding.EncodeToString([]byte(`{"o":"relevancia","v":` + valor + `,"id":"a"}`))
+			esperarErrorAPI(t, c.api(http.MethodGet, "/api/v1/libros?q=de&cursor="+cursor, "", nil), http.StatusBadRequest, "datos_invalidos")
+			esperarEstado(t, c.get("/libros?q=de&cursor="+cursor), http.StatusBadRequest)
+		}
+		cursor := base64.RawURLEncoding.EncodeToString([]byte(`{"o":"ano","v":1e300,"id":"a"}`))
+		esperarErrorAPI(t, c.api(http.MethodGet, "/api/v1/libros?q=de&orden=ano&cursor="+cursor, "", nil), http.StatusBadRequest, "datos_invalidos")
+	})
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func prestamosActivos(t *testing.T, persona *Persona) []Prestamo {
+	t.Helper()
+	activos, err := DB.Prestamos().ActivosPorPersona(context.Background(), persona.ID)
+	if err != nil {
+		t.Fatalf("leyendo préstamos de %s: %v", persona.Nombre, err)
+	}
+	return activos
+}
+
+func TestCarritoPrestaTodosJuntos(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		ana := crearPersona(t, "ana", "clave", "usuario")
+		rayuela := crearLibro(t, "Rayuela", 1)
+		ficciones := crearLibro(t, "Ficciones", 2)
+		c.login("ana", "clave")
+
+		resp := c.ajax("/prestamos", url.Values{"libroID": {rayuela.ID, ficciones.ID}})
+		esperarEstado(t, resp, http.StatusOK)
+		var respuesta CarritoResponse
+		if err := json.Unmarshal([]byte(resp.Cuerpo), &respuesta); err != nil {
+			t.Fatalf("respuesta no es JSON: %v\n%s", err, resp.Cuerpo)
+		}
+		if !respuesta.OK || len(respuesta.Items) != 2 {
+			t.Fatalf("respuesta del carrito: %+v", respuesta)
+		}
+		for _, item := range respuesta.Items {
+			if !item.Prestado || item.PrestamoID == "" || item.Vence == "" || item.Error != "" {
+				t.Errorf("item del carrito mal reportado: %+v", item)
+			}
+		}
+		if respuesta.Items[0].Nombre != "Rayuela" || respuesta.Items[1].Nombre != "Ficciones" {
+			t.Errorf("los items deben seguir el orden del carrito: %+v", respuesta.Items)
+		}
+
+		if activos := prestamosActivos(t, ana); len(activos) != 2 {
+			t.Errorf("ana tiene %d préstamos, se esperaban 2", len(activos))
+		}
+		if l := obtenerLibro(t, rayuela.ID); l.Copias != 0 {
+			t.Errorf("Rayuela: copias = %d, se esperaba 0", l.Copias)
+		}
+		if l := obtenerLibro(t, ficciones.ID); l.Copias != 1 {
+			t.Errorf("Ficciones: copias = %d, se esperaba 1", l.Copias)
+		}
+	})
+}
+
+func TestCarritoTodoONada(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		ana := crearPersona(t, "ana", "clave", "usuario")
+		beto := crearPersona(t, "beto", "clave", "usuario")
+		rayuela := crearLibro(t, "Rayuela", 1)
+		agotado := crearLibro(t, "El Aleph", 1)
+		prestar(t, agotado, beto)
+		c.login("ana", "clave")
+
+		resp := c.ajax("/prestamos", url.Values{"libroID": {rayuela.ID, agotado.ID, "no-existe"}})
+		esperarEstado(t, resp, http.StatusConflict)
+		var respuesta CarritoResponse
+		if err := json.Unmarshal([]byte(resp.Cuerpo), &respuesta); err != nil {
+			t.Fatalf("respuesta no es JSON: %v\n%s", err, resp.Cuerpo)
+		}
+		if respuesta.OK || len(respuesta.Items) != 3 {
+			t.Fatalf("respuesta del carrito: %+v", respuesta)
+		}
+		if item := respuesta.Items[0]; item.Prestado || item.Error != "" {
+			t.Errorf("Rayuela estaba disponible y no debería tener error ni préstamo: %+v", item)
+		}
+		if item := respuesta.Items[1]; item.Error != motivoRechazoPrestamo(ErrSinCopias, ana) {
+			t.Errorf("El Aleph: error = %q", item.Error)
+		}
+		if item := respuesta.Items[2]; item.Error != "El libro no existe." {
+			t.Errorf("libro inexistente: error = %q", item.Error)
+		}
+
+		// Nada se escribió: ni préstamos ni copias
+		if activos := prestamosActivos(t, ana); len(activos) != 0 {
+			t.Errorf("un carrito rechazado dejó %d préstamos", len(activos))
+		}
+		if l := obtenerLibro(t, rayuela.ID); l.Copias != 1 {
+			t.Errorf("Rayuela: copias = %d tras un carrito rechazado", l.Copias)
+		}
+
+		// Sin JavaScript, el formulario recibe el resumen en el mensaje
+		resp = c.post("/prestamos", url.Values{"libroID": {rayuela.ID, agotado.ID}})
+		for _, texto := range []string{"No se prestó ningún libro", "«El Aleph»: El libro no está disponible", "msg_type=danger"} {
+			esperarRedireccion(t, resp, texto)
+		}
+		esperarRedireccion(t, c.post("/prestamos", url.Values{"libroID": {rayuela.ID}}), "Préstamo registrado exitosamente")
+	})
+}
+
+func TestCarritoRespetaLimiteYRepetidos(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		ana := crearPersona(t, "ana", "clave", "usuario")
+		var libros []string
+		for _, nombre := range []string{"Uno", "Dos", "Tres", "Cuatro"} {
+			libros = append(libros, crearLibro(t, nombre, 2).ID)
+		}
+		c.login("ana", "clave")
+
+		// El límite cuenta el carrito entero, no sólo los préstamos que ya tiene
+		resp := c.ajax("/prestamos", url.Values{"libroID": libros})
+		esperarEstado(t, resp, http.StatusConflict)
+		if activos := prestamosActivos(t, ana); len(activos) != 0 {
+			t.Errorf("un carrito sobre el límite dejó %d préstamos", len(activos))
+		}
+
+		resultados, err := prestarLibros(context.Background(), DB, []string{libros[0], libros[0]}, ana.ID, time.Now())
+		if err != ErrCarritoRechazado || resultados[0].Err != nil || resultados[1].Err != ErrLibroRepetido {
+			t.Errorf("carrito con un libro repetido: err = %v, resultados = %+v", err, resultados)
+		}
+	})
+}
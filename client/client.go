@@ -0,0 +1,407 @@
+// Package client es un cliente de Go para la API JSON de la biblioteca
+// (/api/v1), para herramientas como importaciones masivas, quioscos o
+// reportes.
+//
+//	c := client.Nuevo("https://biblioteca.example.com", "")
+//	if _, err := c.Autenticar(ctx, "ana", "clave", "script de inventario"); err != nil {
+//		return err
+//	}
+//	libros, err := c.Libros(ctx, client.ConsultaLibros{Q: "borges"})
+//
+// Los errores de la API se devuelven como *Error y se pueden comparar con
+// errors.Is contra ErrNoEncontrado y los demás errores de este paquete.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Cliente hace peticiones a la API con un token. Es seguro usarlo desde
+// varias goroutines mientras no se llame a Autenticar a la vez.
+type Cliente struct {
+	base  string
+	token string
+
+	// HTTP es el cliente HTTP que se usa; si es nil, http.DefaultClient.
+	HTTP *http.Client
+}
+
+// Nuevo crea un cliente para el servidor en base (por ejemplo
+// "http://localhost:3000") con el token dado. Sin token sólo se pueden usar
+// las rutas públicas y Autenticar.
+func Nuevo(base, token string) *Cliente {
+	return &Cliente{base: strings.TrimSuffix(base, "/"), token: token}
+}
+
+// Token devuelve el token con el que el cliente hace las peticiones.
+func (c *Cliente) Token() string {
+	return c.token
+}
+
+// Error es un error respondido por la API.
+type Error struct {
+	Estado  int           // Estado HTTP
+	Codigo  string        // Código estable, como "no_encontrado" o "sin_copias"
+	Mensaje string        // Para personas
+	Items   []ItemCarrito // Resultado de cada libro de un préstamo rechazado
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("api: %s (%d %s)", e.Mensaje, e.Estado, e.Codigo)
+}
+
+// Is hace que errors.Is compare por código, así que un *Error de la API es
+// igual a ErrNoEncontrado si su código es "no_encontrado".
+func (e *Error) Is(objetivo error) bool {
+	otro, ok := objetivo.(*Error)
+	return ok && otro.Codigo == e.Codigo
+}
+
+// Errores de la API más comunes, para comparar con errors.Is. El resto se
+// distingue por Error.Codigo.
+var (
+	ErrNoAutenticado      = &Error{Codigo: "no_autenticado"}
+	ErrTokenInvalido      = &Error{Codigo: "token_invalido"}
+	ErrCredenciales       = &Error{Codigo: "credenciales_incorrectas"}
+	ErrAccesoDenegado     = &Error{Codigo: "acceso_denegado"} // El rol no permite la operación
+	ErrNoAutorizado       = &Error{Codigo: "no_autorizado"}   // El recurso es de otra persona
+	ErrNoEncontrado       = &Error{Codigo: "no_encontrado"}
+	ErrDatosInvalidos     = &Error{Codigo: "datos_invalidos"}
+	ErrCarritoRechazado   = &Error{Codigo: "carrito_rechazado"}
+	ErrPrestamoCerrado    = &Error{Codigo: "prestamo_cerrado"}
+	ErrMaxRenovaciones    = &Error{Codigo: "max_renovaciones"}
+	ErrYaReservado        = &Error{Codigo: "ya_reservado"}
+	ErrCedulaRegistrada   = &Error{Codigo: "cedula_registrada"}
+	ErrConPrestamos       = &Error{Codigo: "con_prestamos"}
+	ErrContrasenaInvalida = &Error{Codigo: "contrasena_invalida"}
+)
+
+// respuestaError es el cuerpo de los errores de la API.
+type respuestaError struct {
+	Error struct {
+		Codigo  string        `json:"codigo"`
+		Mensaje string        `json:"mensaje"`
+		Items   []ItemCarrito `json:"items"`
+	} `json:"error"`
+}
+
+// pagina es una página de un listado. Si Siguiente no está vacío hay más
+// resultados, que se piden con el parámetro cursor.
+type pagina[T any] struct {
+	Datos     []T    `json:"datos"`
+	Siguiente string `json:"siguiente"`
+}
+
+// hacer envía una petición a la API y decodifica la respuesta en destino
+// (si no es nil). Un estado de error se devuelve como *Error.
+func (c *Cliente) hacer(ctx context.Context, metodo, ruta string, consulta url.Values, cuerpo, destino any) error {
+	u := c.base + ruta
+	if len(consulta) > 0 {
+		u += "?" + consulta.Encode()
+	}
+	var lector io.Reader
+	if cuerpo != nil {
+		datos, err := json.Marshal(cuerpo)
+		if err != nil {
+			return err
+		}
+		lector = bytes.NewReader(datos)
+	}
+	req, err := http.NewRequestWithContext(ctx, metodo, u, lector)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if cuerpo != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	h := c.HTTP
+	if h == nil {
+		h = http.DefaultClient
+	}
+	resp, err := h.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return leerError(resp)
+	}
+	if destino == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(destino); err != nil {
+		return fmt.Errorf("api: respuesta inválida de %s %s: %w", metodo, ruta, err)
+	}
+	return nil
+}
+
+// leerError convierte una respuesta de error en *Error. Si el cuerpo no es
+// el JSON de la API (por ejemplo, el de un proxy), el mensaje es el estado.
+func leerError(resp *http.Response) error {
+	e := &Error{Estado: resp.StatusCode, Mensaje: http.StatusText(resp.StatusCode)}
+	var cuerpo respuestaError
+	if err := json.NewDecoder(resp.Body).Decode(&cuerpo); err == nil && cuerpo.Error.Codigo != "" {
+		e.Codigo = cuerpo.Error.Codigo
+		e.Mensaje = cuerpo.Error.Mensaje
+		e.Items = cuerpo.Error.Items
+	}
+	return e
+}
+
+// listar pide todas las páginas de un listado y junta sus resultados.
+func listar[T any](ctx context.Context, c *Cliente, ruta string, consulta url.Values) ([]T, error) {
+	if consulta == nil {
+		consulta = url.Values{}
+	}
+	todos := []T{}
+	for {
+		var p pagina[T]
+		if err := c.hacer(ctx, http.MethodGet, ruta, consulta, nil, &p); err != nil {
+			return nil, err
+		}
+		todos = append(todos, p.Datos...)
+		if p.Siguiente == "" {
+			return todos, nil
+		}
+		if p.Siguiente == consulta.Get("cursor") {
+			return nil, errors.New("api: el servidor repitió el cursor " + p.Siguiente)
+		}
+		consulta.Set("cursor", p.Siguiente)
+	}
+}
+
+// --- Tokens ---
+
+// Autenticar crea un token con el nombre y la contraseña y lo usa en las
+// peticiones siguientes del cliente.
+func (c *Cliente) Autenticar(ctx context.Context, nombre, contrasena, descripcion string) (*TokenCreado, error) {
+	cuerpo := map[string]string{"nombre": nombre, "contrasena": contrasena, "descripcion": descripcion}
+	var creado TokenCreado
+	if err := c.hacer(ctx, http.MethodPost, "/api/v1/tokens", nil, cuerpo, &creado); err != nil {
+		return nil, err
+	}
+	c.token = creado.Secreto
+	return &creado, nil
+}
+
+// Tokens lista los tokens de la persona del token actual.
+func (c *Cliente) Tokens(ctx context.Context) ([]Token, error) {
+	return listar[Token](ctx, c, "/api/v1/tokens", nil)
+}
+
+// RevocarToken revoca un token por su ID.
+func (c *Cliente) RevocarToken(ctx context.Context, id string) error {
+	return c.hacer(ctx, http.MethodDelete, "/api/v1/tokens/"+url.PathEscape(id), nil, nil, nil)
+}
+
+// --- Libros ---
+
+// Libros lista los libros, por defecto por nombre. Con consulta.Q sólo
+// devuelve los que encuentra la búsqueda de texto completo (título, autor y
+// descripción), del más al menos relevante y con las coincidencias en
+// Libro.Fragmentos.
+func (c *Cliente) Libros(ctx context.Context, consulta ConsultaLibros) ([]Libro, error) {
+	params := url.Values{}
+	if consulta.Q != "" {
+		params.Set("q", consulta.Q)
+	}
+	if consulta.Orden != "" {
+		params.Set("orden", consulta.Orden)
+	}
+	return listar[Libro](ctx, c, "/api/v1/libros", params)
+}
+
+func (c *Cliente) Libro(ctx context.Context, id string) (*Libro, error) {
+	var libro Libro
+	if err := c.hacer(ctx, http.MethodGet, "/api/v1/libros/"+url.PathEscape(id), nil, nil, &libro); err != nil {
+		return nil, err
+	}
+	return &libro, nil
+}
+
+// CrearLibro registra un libro con libro.Total ejemplares y devuelve el
+// libro creado, con su ID.
+func (c *Cliente) CrearLibro(ctx context.Context, libro Libro) (*Libro, error) {
+	var creado Libro
+	libro.Fragmentos = nil
+	if err := c.hacer(ctx, http.MethodPost, "/api/v1/libros", nil, libro, &creado); err != nil {
+		return nil, err
+	}
+	return &creado, nil
+}
+
+// EditarLibro reemplaza los datos del libro con ID libro.ID y ajusta su
+// stock a libro.Total.
+func (c *Cliente) EditarLibro(ctx context.Context, libro Libro) (*Libro, error) {
+	var editado Libro
+	libro.Fragmentos = nil
+	if err := c.hacer(ctx, http.MethodPut, "/api/v1/libros/"+url.PathEscape(libro.ID), nil, libro, &editado); err != nil {
+		return nil, err
+	}
+	return &editado, nil
+}
+
+// EliminarLibro borra un libro sin préstamos registrados.
+func (c *Cliente) EliminarLibro(ctx context.Context, id string) error {
+	return c.hacer(ctx, http.MethodDelete, "/api/v1/libros/"+url.PathEscape(id), nil, nil, nil)
+}
+
+// --- Personas ---
+
+// Personas lista las personas registradas.
+func (c *Cliente) Personas(ctx context.Context) ([]Persona, error) {
+	return listar[Persona](ctx, c, "/api/v1/personas", nil)
+}
+
+// PersonaPorCedula busca una persona por su cédula; devuelve
+// ErrNoEncontrado si no hay ninguna.
+func (c *Cliente) PersonaPorCedula(ctx context.Context, cedula string) (*Persona, error) {
+	personas, err := listar[Persona](ctx, c, "/api/v1/personas", url.Values{"cedula": {cedula}})
+	if err != nil {
+		return nil, err
+	}
+	if len(personas) == 0 {
+		return nil, &Error{Estado: http.StatusNotFound, Codigo: ErrNoEncontrado.Codigo, Mensaje: "No hay ninguna persona con la cédula " + cedula}
+	}
+	return &personas[0], nil
+}
+
+func (c *Cliente) Persona(ctx context.Context, id string) (*Persona, error) {
+	var persona Persona
+	if err := c.hacer(ctx, http.MethodGet, "/api/v1/personas/"+url.PathEscape(id), nil, nil, &persona); err != nil {
+		return nil, err
+	}
+	return &persona, nil
+}
+
+// CrearPersona registra una persona.
+func (c *Cliente) CrearPersona(ctx context.Context, datos NuevaPersona) (*Persona, error) {
+	var persona Persona
+	if err := c.hacer(ctx, http.MethodPost, "/api/v1/personas", nil, datos, &persona); err != nil {
+		return nil, err
+	}
+	return &persona, nil
+}
+
+// EliminarPersona borra una persona sin préstamos registrados.
+func (c *Cliente) EliminarPersona(ctx context.Context, id string) error {
+	return c.hacer(ctx, http.MethodDelete, "/api/v1/personas/"+url.PathEscape(id), nil, nil, nil)
+}
+
+// --- Préstamos ---
+
+// Prestamos lista préstamos, del más reciente al más antiguo. Sin
+// filtro.PersonaID, una persona sin rol de personal sólo recibe los suyos.
+func (c *Cliente) Prestamos(ctx context.Context, filtro FiltroPrestamos) ([]Prestamo, error) {
+	consulta := url.Values{}
+	if filtro.LibroID != "" {
+		consulta.Set("libro", filtro.LibroID)
+	}
+	if filtro.PersonaID != "" {
+		consulta.Set("persona", filtro.PersonaID)
+	}
+	if !filtro.Desde.IsZero() {
+		consulta.Set("desde", filtro.Desde.Format("2006-01-02"))
+	}
+	if !filtro.Hasta.IsZero() {
+		consulta.Set("hasta", filtro.Hasta.Format("2006-01-02"))
+	}
+	for _, estado := range filtro.Estados {
+		consulta.Add("estado", estado)
+	}
+	return listar[Prestamo](ctx, c, "/api/v1/prestamos", consulta)
+}
+
+func (c *Cliente) Prestamo(ctx context.Context, id string) (*Prestamo, error) {
+	var prestamo Prestamo
+	if err := c.hacer(ctx, http.MethodGet, "/api/v1/prestamos/"+url.PathEscape(id), nil, nil, &prestamo); err != nil {
+		return nil, err
+	}
+	return &prestamo, nil
+}
+
+// Prestar presta los libros a personaID (vacío para quien pide) en una sola
+// transacción: se registran todos o ninguno. Si se rechaza, el error es
+// ErrCarritoRechazado y sus Items dicen qué pasó con cada libro.
+func (c *Cliente) Prestar(ctx context.Context, personaID string, libroIDs ...string) ([]Prestamo, error) {
+	cuerpo := map[string]any{"libroIDs": libroIDs}
+	if personaID != "" {
+		cuerpo["personaID"] = personaID
+	}
+	var p pagina[Prestamo]
+	if err := c.hacer(ctx, http.MethodPost, "/api/v1/prestamos", nil, cuerpo, &p); err != nil {
+		return nil, err
+	}
+	return p.Datos, nil
+}
+
+// Renovar extiende el vencimiento de un préstamo.
+func (c *Cliente) Renovar(ctx context.Context, prestamoID string) (*Prestamo, error) {
+	var prestamo Prestamo
+	if err := c.hacer(ctx, http.MethodPost, "/api/v1/prestamos/"+url.PathEscape(prestamoID)+"/renovacion", nil, nil, &prestamo); err != nil {
+		return nil, err
+	}
+	return &prestamo, nil
+}
+
+// Devolver registra la devolución de un préstamo y devuelve el préstamo
+// cerrado.
+func (c *Cliente) Devolver(ctx context.Context, prestamoID string) (*Prestamo, error) {
+	var prestamo Prestamo
+	if err := c.hacer(ctx, http.MethodPost, "/api/v1/devoluciones", nil, map[string]string{"prestamoID": prestamoID}, &prestamo); err != nil {
+		return nil, err
+	}
+	return &prestamo, nil
+}
+
+// --- Reservas ---
+
+// Reservas lista las reservas activas de personaID (vacío para quien pide).
+func (c *Cliente) Reservas(ctx context.Context, personaID string) ([]Reserva, error) {
+	consulta := url.Values{}
+	if personaID != "" {
+		consulta.Set("persona", personaID)
+	}
+	return listar[Reserva](ctx, c, "/api/v1/reservas", consulta)
+}
+
+func (c *Cliente) Reserva(ctx context.Context, id string) (*Reserva, error) {
+	var reserva Reserva
+	if err := c.hacer(ctx, http.MethodGet, "/api/v1/reservas/"+url.PathEscape(id), nil, nil, &reserva); err != nil {
+		return nil, err
+	}
+	return &reserva, nil
+}
+
+// Reservar pone a personaID (vacío para quien pide) en la cola de espera de
+// un libro sin copias.
+func (c *Cliente) Reservar(ctx context.Context, libroID, personaID string) (*Reserva, error) {
+	cuerpo := map[string]string{"libroID": libroID}
+	if personaID != "" {
+		cuerpo["personaID"] = personaID
+	}
+	var reserva Reserva
+	if err := c.hacer(ctx, http.MethodPost, "/api/v1/reservas", nil, cuerpo, &reserva); err != nil {
+		return nil, err
+	}
+	return &reserva, nil
+}
+
+// CancelarReserva cancela una reserva activa.
+func (c *Cliente) CancelarReserva(ctx context.Context, id string) error {
+	return c.hacer(ctx, http.MethodDelete, "/api/v1/reservas/"+url.PathEscape(id), nil, nil, nil)
+}
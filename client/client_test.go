@@ -0,0 +1,208 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+)
+
+// servidorFalso levanta un servidor de prueba con un solo handler y un
+// cliente que apunta a él.
+func servidorFalso(t *testing.T, token string, h http.HandlerFunc) *Cliente {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return Nuevo(srv.URL+"/", token)
+}
+
+func responder(w http.ResponseWriter, estado int, cuerpo any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(estado)
+	json.NewEncoder(w).Encode(cuerpo)
+}
+
+func TestAutenticarGuardaElToken(t *testing.T) {
+	c := servidorFalso(t, "", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/tokens":
+			if r.Method == http.MethodGet {
+				if r.Header.Get("Authorization") != "Bearer bib_secreto" {
+					responder(w, http.StatusUnauthorized, map[string]any{"error": map[string]string{"codigo": "no_autenticado", "mensaje": "Falta el token"}})
+					return
+				}
+				responder(w, http.StatusOK, map[string]any{"datos": []Token{{ID: "abc", Descripcion: "inventario"}}})
+				return
+			}
+			var cred map[string]string
+			json.NewDecoder(r.Body).Decode(&cred)
+			if cred["nombre"] != "ana" || cred["contrasena"] != "clave" || r.Header.Get("Content-Type") != "application/json" {
+				responder(w, http.StatusUnauthorized, map[string]any{"error": map[string]string{"codigo": "credenciales_incorrectas", "mensaje": "Nombre o contraseña incorrectos"}})
+				return
+			}
+			responder(w, http.StatusCreated, map[string]any{"token": "bib_secreto", "id": "abc", "descripcion": cred["descripcion"], "persona": Persona{ID: "p1", Nombre: "ana"}})
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	ctx := context.Background()
+
+	if _, err := c.Tokens(ctx); !errors.Is(err, ErrNoAutenticado) {
+		t.Errorf("sin token: err = %v, se esperaba ErrNoAutenticado", err)
+	}
+	if _, err := c.Autenticar(ctx, "ana", "mal", ""); !errors.Is(err, ErrCredenciales) {
+		t.Errorf("contraseña incorrecta: err = %v", err)
+	}
+	creado, err := c.Autenticar(ctx, "ana", "clave", "inventario")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creado.Secreto != "bib_secreto" || creado.ID != "abc" || creado.Persona.Nombre != "ana" || c.Token() != "bib_secreto" {
+		t.Errorf("token creado: %+v", creado)
+	}
+	tokens, err := c.Tokens(ctx)
+	if err != nil || len(tokens) != 1 || tokens[0].Descripcion != "inventario" {
+		t.Errorf("Tokens = %+v, %v", tokens, err)
+	}
+}
+
+func TestListadosSiguenLasPaginas(t *testing.T) {
+	var cursores []string
+	c := servidorFalso(t, "bib_x", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("q") != "borges" {
+			t.Errorf("q = %q", r.URL.Query().Get("q"))
+		}
+		cursor := r.URL.Query().Get("cursor")
+		cursores = append(cursores, cursor)
+		switch cursor {
+		case "":
+			responder(w, http.StatusOK, map[string]any{"datos": []Libro{{ID: "1"}, {ID: "2"}}, "siguiente": "c2"})
+		case "c2":
+			responder(w, http.StatusOK, map[string]any{"datos": []Libro{{ID: "3"}}, "siguiente": "c3"})
+		default:
+			responder(w, http.StatusOK, map[string]any{"datos": []Libro{}})
+		}
+	})
+	libros, err := c.Libros(context.Background(), ConsultaLibros{Q: "borges"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(libros) != 3 || libros[2].ID != "3" {
+		t.Errorf("libros = %+v", libros)
+	}
+	if !slices.Equal(cursores, []string{"", "c2", "c3"}) {
+		t.Errorf("cursores pedidos = %q", cursores)
+	}
+}
+
+func TestListadoConCursorRepetido(t *testing.T) {
+	c := servidorFalso(t, "bib_x", func(w http.ResponseWriter, r *http.Request) {
+		responder(w, http.StatusOK, map[string]any{"datos": []Persona{{ID: "1"}}, "siguiente": "siempre"})
+	})
+	if _, err := c.Personas(context.Background()); err == nil {
+		t.Error("un servidor que repite el cursor no debe dejar al cliente en un bucle")
+	}
+}
+
+func TestConsultaLibros(t *testing.T) {
+	var consulta url.Values
+	var editado map[string]any
+	c := servidorFalso(t, "bib_x", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			json.NewDecoder(r.Body).Decode(&editado)
+			responder(w, http.StatusOK, Libro{ID: "1"})
+			return
+		}
+		consulta = r.URL.Query()
+		responder(w, http.StatusOK, map[string]any{"datos": []map[string]any{
+			{"id": "1", "nombre": "El Aleph", "fragmentos": map[string]string{"nombre": "El <mark>Aleph</mark>"}},
+		}})
+	})
+	ctx := context.Background()
+
+	libros, err := c.Libros(ctx, ConsultaLibros{Q: "aleph", Orden: "-ano"})
+	if err != nil || len(libros) != 1 {
+		t.Fatalf("Libros = %+v, %v", libros, err)
+	}
+	if esperada := (url.Values{"q": {"aleph"}, "orden": {"-ano"}}); consulta.Encode() != esperada.Encode() {
+		t.Errorf("consulta = %s, se esperaba %s", consulta.Encode(), esperada.Encode())
+	}
+	if libros[0].Fragmentos["nombre"] != "El <mark>Aleph</mark>" {
+		t.Errorf("fragmentos = %v", libros[0].Fragmentos)
+	}
+	if _, err := c.Libros(ctx, ConsultaLibros{}); err != nil || len(consulta) != 0 {
+		t.Errorf("sin consulta: %s, %v", consulta.Encode(), err)
+	}
+
+	// Un libro encontrado se puede editar tal cual: la API no acepta
+	// fragmentos en el cuerpo
+	if _, err := c.EditarLibro(ctx, libros[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := editado["fragmentos"]; ok || editado["nombre"] != "El Aleph" {
+		t.Errorf("cuerpo de EditarLibro = %v", editado)
+	}
+}
+
+func TestFiltroPrestamos(t *testing.T) {
+	var consulta url.Values
+	c := servidorFalso(t, "bib_x", func(w http.ResponseWriter, r *http.Request) {
+		consulta = r.URL.Query()
+		responder(w, http.StatusOK, map[string]any{"datos": []Prestamo{}})
+	})
+	dia := time.Date(2025, 3, 9, 15, 0, 0, 0, time.UTC)
+	prestamos, err := c.Prestamos(context.Background(), FiltroPrestamos{
+		PersonaID: "p1", Estados: []string{EstadoActivo, EstadoPerdido}, Desde: dia, Hasta: dia.AddDate(0, 1, 0),
+	})
+	if err != nil || prestamos == nil {
+		t.Fatalf("Prestamos = %v, %v", prestamos, err)
+	}
+	esperada := url.Values{"persona": {"p1"}, "estado": {"activo", "perdido"}, "desde": {"2025-03-09"}, "hasta": {"2025-04-09"}}
+	if consulta.Encode() != esperada.Encode() {
+		t.Errorf("consulta = %s, se esperaba %s", consulta.Encode(), esperada.Encode())
+	}
+}
+
+func TestErroresDeLaAPI(t *testing.T) {
+	c := servidorFalso(t, "bib_x", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/prestamos":
+			responder(w, http.StatusConflict, map[string]any{"error": map[string]any{
+				"codigo": "carrito_rechazado", "mensaje": "No se prestó ningún libro",
+				"items": []ItemCarrito{{LibroID: "l1", Prestado: false}, {LibroID: "l2", Error: "No hay copias"}},
+			}})
+		case "/api/v1/personas":
+			responder(w, http.StatusOK, map[string]any{"datos": []Persona{}})
+		default:
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte("<html>proxy</html>"))
+		}
+	})
+	ctx := context.Background()
+
+	_, err := c.Prestar(ctx, "", "l1", "l2")
+	var e *Error
+	if !errors.Is(err, ErrCarritoRechazado) || !errors.As(err, &e) {
+		t.Fatalf("err = %v, se esperaba ErrCarritoRechazado", err)
+	}
+	if e.Estado != http.StatusConflict || len(e.Items) != 2 || e.Items[1].Error != "No hay copias" {
+		t.Errorf("error del carrito: %+v", e)
+	}
+	if errors.Is(err, ErrNoEncontrado) {
+		t.Error("los errores se comparan por código")
+	}
+
+	if _, err := c.PersonaPorCedula(ctx, "000"); !errors.Is(err, ErrNoEncontrado) {
+		t.Errorf("cédula inexistente: err = %v", err)
+	}
+
+	_, err = c.Libro(ctx, "l1")
+	if !errors.As(err, &e) || e.Estado != http.StatusBadGateway || e.Codigo != "" {
+		t.Errorf("respuesta que no es de la API: %v", err)
+	}
+}
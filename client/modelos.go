@@ -0,0 +1,141 @@
+package client
+
+import "time"
+
+// Libro es un título del catálogo. Total es el stock; ID, Copias y
+// Disponible los calcula el servidor y se ignoran al crear o editar.
+type Libro struct {
+	ID          string `json:"id"`
+	Nombre      string `json:"nombre"`
+	Autor       string `json:"autor"`
+	Ano         int    `json:"ano"`
+	Descripcion string `json:"descripcion"`
+	ImagenURL   string `json:"imagenURL"`
+	Total       int    `json:"total"`
+	Copias      int    `json:"copias"`
+	Disponible  bool   `json:"disponible"`
+	Restringido bool   `json:"restringido"` // Los préstamos empiezan como solicitudes que aprueba un administrador
+
+	// Fragmentos trae, en una búsqueda, las coincidencias por campo (nombre,
+	// autor, descripcion) en HTML, entre <mark>. CrearLibro y EditarLibro no lo
+	// envían.
+	Fragmentos map[string]string `json:"fragmentos,omitempty"`
+}
+
+// ConsultaLibros elige qué libros lista Libros y en qué orden. Los campos
+// vacíos usan el valor por defecto de la API.
+type ConsultaLibros struct {
+	Q     string // Búsqueda de texto completo
+	Orden string // nombre, autor, ano, copias o, con Q, relevancia (por defecto con Q); "-" delante para descendente
+}
+
+// Persona es un usuario registrado.
+type Persona struct {
+	ID     string `json:"id"`
+	Nombre string `json:"nombre"`
+	Cedula string `json:"cedula"`
+	Ano    int    `json:"ano"`
+	Rol    string `json:"rol"`
+}
+
+// NuevaPersona son los datos para registrar una persona.
+type NuevaPersona struct {
+	Nombre     string `json:"nombre"`
+	Cedula     string `json:"cedula"`
+	Ano        int    `json:"ano"`
+	Contrasena string `json:"contrasena"`
+	Rol        string `json:"rol,omitempty"` // Por defecto RolUsuario
+}
+
+// Roles de Persona.
+const (
+	RolUsuario       = "usuario"
+	RolBibliotecario = "bibliotecario"
+	RolAdmin         = "admin"
+)
+
+// Prestamo es un préstamo en cualquier estado.
+type Prestamo struct {
+	ID               string    `json:"id"`
+	LibroID          string    `json:"libroID"`
+	EjemplarID       string    `json:"ejemplarID"`
+	PersonaID        string    `json:"personaID"`
+	Estado           string    `json:"estado"`
+	FechaPrestamo    time.Time `json:"fechaPrestamo"`
+	FechaDevolucion  time.Time `json:"fechaDevolucion"`
+	FechaVencimiento time.Time `json:"fechaVencimiento"`
+	Renovaciones     int       `json:"renovaciones"`
+	UltimaRenovacion time.Time `json:"ultimaRenovacion"`
+	RetirarHasta     time.Time `json:"retirarHasta"`
+	ResueltoPor      string    `json:"resueltoPor"`
+}
+
+// Estados de Prestamo.
+const (
+	EstadoSolicitado = "solicitado"
+	EstadoAprobado   = "aprobado"
+	EstadoActivo     = "activo"
+	EstadoDevuelto   = "devuelto"
+	EstadoPerdido    = "perdido"
+	EstadoRechazado  = "rechazado"
+	EstadoExpirado   = "expirado"
+)
+
+// FiltroPrestamos filtra Prestamos. Los campos vacíos no filtran; Desde y
+// Hasta se comparan por día.
+type FiltroPrestamos struct {
+	LibroID   string
+	PersonaID string // Sólo bibliotecarios y administradores pueden pedir los de otra persona
+	Estados   []string
+	Desde     time.Time
+	Hasta     time.Time
+}
+
+// Reserva es el lugar de una persona en la cola de espera de un libro.
+type Reserva struct {
+	ID              string    `json:"id"`
+	LibroID         string    `json:"libroID"`
+	PersonaID       string    `json:"personaID"`
+	Creada          time.Time `json:"creada"`
+	Estado          string    `json:"estado"`
+	DisponibleHasta time.Time `json:"disponibleHasta"`
+	EjemplarID      string    `json:"ejemplarID"`
+}
+
+// Estados de Reserva.
+const (
+	ReservaEnEspera  = "en_espera"
+	ReservaLista     = "lista"
+	ReservaCumplida  = "cumplida"
+	ReservaVencida   = "vencida"
+	ReservaCancelada = "cancelada"
+)
+
+// Token es un token de la API. El ID es el hash del token y sirve para
+// revocarlo.
+type Token struct {
+	ID          string    `json:"id"`
+	PersonaID   string    `json:"personaID"`
+	Descripcion string    `json:"descripcion"`
+	Creado      time.Time `json:"creado"`
+	UltimoUso   time.Time `json:"ultimoUso"`
+}
+
+// TokenCreado es un token recién creado: el único momento en que se ve el
+// token en claro, en Secreto.
+type TokenCreado struct {
+	Secreto string `json:"token"`
+	Token
+	Persona Persona `json:"persona"`
+}
+
+// ItemCarrito es el resultado de un libro de un préstamo rechazado.
+type ItemCarrito struct {
+	LibroID    string `json:"libroID"`
+	Nombre     string `json:"nombre"`
+	Prestado   bool   `json:"prestado"`
+	Estado     string `json:"estado"`
+	PrestamoID string `json:"prestamoID"`
+	Vence      string `json:"vence"`
+	Error      string `json:"error"` // Motivo por el que no se pudo prestar
+}
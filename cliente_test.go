@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"deber3/client"
+)
+
+// TestClienteContraElServidor recorre el paquete client contra la aplicación
+// completa, para que sus tipos y rutas no se desfasen de la API.
+func TestClienteContraElServidor(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		ctx := context.Background()
+		crearPersona(t, "admin", "clave", "admin")
+		crearPersona(t, "bibliotecaria", "clave", "bibliotecario")
+
+		admin := client.Nuevo(c.srv.URL, "")
+		if _, err := admin.Autenticar(ctx, "admin", "mal", ""); !errors.Is(err, client.ErrCredenciales) {
+			t.Errorf("err = %v, se esperaba ErrCredenciales", err)
+		}
+		if _, err := admin.Autenticar(ctx, "admin", "clave", "importación"); err != nil {
+			t.Fatal(err)
+		}
+
+		libro, err := admin.CrearLibro(ctx, client.Libro{Nombre: "Ficciones", Autor: "Borges", Ano: 1944, Total: 1})
+		if err != nil || libro.ID == "" || libro.Copias != 1 {
+			t.Fatalf("CrearLibro = %+v, %v", libro, err)
+		}
+		libro.Total = 2
+		if editado, err := admin.EditarLibro(ctx, *libro); err != nil || editado.Copias != 2 {
+			t.Errorf("EditarLibro = %+v, %v", editado, err)
+		}
+		libro.Total = 1
+		if _, err := admin.EditarLibro(ctx, *libro); err != nil {
+			t.Fatal(err)
+		}
+		if libros, err := client.Nuevo(c.srv.URL, "").Libros(ctx, client.ConsultaLibros{Q: "borg", Orden: "-ano"}); err != nil || len(libros) != 1 || !strings.Contains(libros[0].Fragmentos["autor"], "<mark>") {
+			t.Errorf("búsqueda pública = %+v, %v", libros, err)
+		}
+
+		ana, err := admin.CrearPersona(ctx, client.NuevaPersona{Nombre: "ana", Cedula: "ced-ana", Ano: 2000, Contrasena: "clave"})
+		if err != nil || ana.Rol != client.RolUsuario {
+			t.Fatalf("CrearPersona = %+v, %v", ana, err)
+		}
+		if _, err := admin.CrearPersona(ctx, client.NuevaPersona{Nombre: "ana2", Cedula: "ced-ana", Contrasena: "clave"}); !errors.Is(err, client.ErrCedulaRegistrada) {
+			t.Errorf("cédula repetida: err = %v", err)
+		}
+
+		mostrador := client.Nuevo(c.srv.URL, "")
+		if _, err := mostrador.Autenticar(ctx, "bibliotecaria", "clave", "quiosco"); err != nil {
+			t.Fatal(err)
+		}
+		encontrada, err := mostrador.PersonaPorCedula(ctx, "ced-ana")
+		if err != nil || encontrada.ID != ana.ID {
+			t.Fatalf("PersonaPorCedula = %+v, %v", encontrada, err)
+		}
+		prestamos, err := mostrador.Prestar(ctx, ana.ID, libro.ID)
+		if err != nil || len(prestamos) != 1 || prestamos[0].PersonaID != ana.ID || prestamos[0].Estado != client.EstadoActivo {
+			t.Fatalf("Prestar = %+v, %v", prestamos, err)
+		}
+		_, err = mostrador.Prestar(ctx, "", libro.ID)
+		var e *client.Error
+		if !errors.As(err, &e) || !errors.Is(err, client.ErrCarritoRechazado) || len(e.Items) != 1 || e.Items[0].Error == "" {
+			t.Errorf("préstamo sin copias: %v", err)
+		}
+
+		usuaria := client.Nuevo(c.srv.URL, "")
+		if _, err := usuaria.Autenticar(ctx, "ana", "clave", ""); err != nil {
+			t.Fatal(err)
+		}
+		propios, err := usuaria.Prestamos(ctx, client.FiltroPrestamos{Estados: []string{client.EstadoActivo}})
+		if err != nil || len(propios) != 1 {
+			t.Errorf("Prestamos de ana = %+v, %v", propios, err)
+		}
+		if renovado, err := usuaria.Renovar(ctx, prestamos[0].ID); err != nil || renovado.Renovaciones != 1 {
+			t.Errorf("Renovar = %+v, %v", renovado, err)
+		}
+		if _, err := usuaria.Personas(ctx); !errors.Is(err, client.ErrAccesoDenegado) {
+			t.Errorf("una usuaria no lista personas: err = %v", err)
+		}
+
+		reserva, err := mostrador.Reservar(ctx, libro.ID, "")
+		if err != nil || reserva.Estado != client.ReservaEnEspera {
+			t.Fatalf("Reservar = %+v, %v", reserva, err)
+		}
+		if devuelto, err := usuaria.Devolver(ctx, prestamos[0].ID); err != nil || devuelto.Estado != client.EstadoDevuelto {
+			t.Errorf("Devolver = %+v, %v", devuelto, err)
+		}
+		if _, err := usuaria.Devolver(ctx, prestamos[0].ID); !errors.Is(err, client.ErrPrestamoCerrado) {
+			t.Errorf("segunda devolución: err = %v", err)
+		}
+		if r, err := mostrador.Reserva(ctx, reserva.ID); err != nil || r.Estado != client.ReservaLista {
+			t.Errorf("la copia devuelta debe apartarse para la reserva: %+v, %v", r, err)
+		}
+		if err := mostrador.CancelarReserva(ctx, reserva.ID); err != nil {
+			t.Error(err)
+		}
+
+		if err := admin.EliminarLibro(ctx, libro.ID); !errors.Is(err, client.ErrConPrestamos) {
+			t.Errorf("eliminar un libro con préstamos: err = %v", err)
+		}
+		if _, err := admin.Libro(ctx, "no-existe"); !errors.Is(err, client.ErrNoEncontrado) {
+			t.Errorf("libro inexistente: err = %v", err)
+		}
+
+		tokens, err := usuaria.Tokens(ctx)
+		if err != nil || len(tokens) != 1 {
+			t.Fatalf("Tokens = %+v, %v", tokens, err)
+		}
+		if err := usuaria.RevocarToken(ctx, tokens[0].ID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := usuaria.Prestamos(ctx, client.FiltroPrestamos{}); !errors.Is(err, client.ErrTokenInvalido) {
+			t.Errorf("token revocado: err = %v", err)
+		}
+	})
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Config reúne la configuración de la aplicación. Los valores se toman, de
+// menor a mayor prioridad, de: valores por defecto, archivo de configuración
+// JSON (-config o CONFIG_FILE), variables de entorno y flags.
+type Config struct {
+	Puerto              string   `json:"puerto"`
+	Store               string   `json:"store"`
+	DatabaseURL         string   `json:"database_url"`
+	ProyectoID          string   `json:"proyecto_id"`
+	CredencialesArchivo string   `json:"credenciales_archivo"`
+	CredencialesJSON    string   `json:"credenciales_json"`
+	EmuladorFirestore   string   `json:"emulador_firestore"`
+	DirPlantillas       string   `json:"dir_plantillas"`
+	DirEstaticos        string   `json:"dir_estaticos"`
+	SecretoSesion       string   `json:"secreto_sesion"`
+	DuracionSesion      Duracion `json:"duracion_sesion"`
+	CookieSegura        bool     `json:"cookie_segura"`
+	DiasPrestamo        int      `json:"dias_prestamo"`
+	MaxRenovaciones     int      `json:"max_renovaciones"`
+	VentanaRetiro       Duracion `json:"ventana_retiro"`
+	// Préstamos activos simultáneos permitidos por rol; 0 es sin límite.
+	MaxPrestamosUsuario       int `json:"max_prestamos_usuario"`
+	MaxPrestamosBibliotecario int `json:"max_prestamos_bibliotecario"`
+	MaxPrestamosAdmin         int `json:"max_prestamos_admin"`
+	// Multas, en centavos.
+	MultaDiaria     int `json:"multa_diaria"`
+	CostoReposicion int `json:"costo_reposicion"`
+	// Elementos por página en los listados de libros y personas.
+	TamanoPagina int `json:"tamano_pagina"`
+}
+
+// Duracion es un time.Duration que en el archivo JSON se escribe como texto,
+// por ejemplo "24h" o "30m".
+type Duracion struct{ time.Duration }
+
+func (d *Duracion) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	dur, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = dur
+	return nil
+}
+
+// Configuracion es la configuración global; main la carga al iniciar.
+var Configuracion = ConfigPorDefecto()
+
+// ConfigPorDefecto devuelve los valores usados cuando no se configura nada.
+func ConfigPorDefecto() Config {
+	return Config{
+		Puerto:                    "3000",
+		Store:                     "firestore",
+		ProyectoID:                "prestamolibros-556f1",
+		CredencialesArchivo:       "prestamolibros-556f1-firebase-adminsdk-fbsvc-1bc548a5b5.json",
+		DirPlantillas:             "templates",
+		DirEstaticos:              "static",
+		DuracionSesion:            Duracion{24 * time.Hour},
+		DiasPrestamo:              14,
+		MaxRenovaciones:           2,
+		VentanaRetiro:             Duracion{72 * time.Hour},
+		MaxPrestamosUsuario:       3,
+		MaxPrestamosBibliotecario: 10,
+		MaxPrestamosAdmin:         10,
+		MultaDiaria:               25,
+		CostoReposicion:           2500,
+		TamanoPagina:              24,
+	}
+}
+
+// LimitePrestamos devuelve cuántos préstamos activos puede tener a la vez
+// alguien con el rol dado; 0 es sin límite. Un rol desconocido tiene el
+// límite de RolUsuario.
+func (c Config) LimitePrestamos(rol string) int {
+	switch rol {
+	case RolAdmin:
+		return c.MaxPrestamosAdmin
+	case RolBibliotecario:
+		return c.MaxPrestamosBibliotecario
+	}
+	return c.MaxPrestamosUsuario
+}
+
+// opcionConfig describe una opción configurable por flag y variable de entorno.
+type opcionConfig struct {
+	flag  string
+	env   string
+	ayuda string
+	campo func(c *Config) any // Puntero al campo de Config
+}
+
+var opcionesConfig = []opcionConfig{
+	{"port", "PORT", "puerto HTTP", func(c *Config) any { return &c.Puerto }},
+	{"store", "STORE", "backend de datos: firestore, memoria, sqlite o postgres", func(c *Config) any { return &c.Store }},
+	{"database-url", "DATABASE_URL", "cadena de conexión para sqlite o postgres", func(c *Config) any { return &c.DatabaseURL }},
+	{"project", "FIREBASE_PROJECT_ID", "ID del proyecto de Firebase", func(c *Config) any { return &c.ProyectoID }},
+	{"credentials", "GOOGLE_APPLICATION_CREDENTIALS", "ruta al JSON de la cuenta de servicio", func(c *Config) any { return &c.CredencialesArchivo }},
+	{"credentials-json", "GOOGLE_APPLICATION_CREDENTIALS_JSON", "contenido del JSON de la cuenta de servicio", func(c *Config) any { return &c.CredencialesJSON }},
+	{"firestore-emulator", "FIRESTORE_EMULATOR_HOST", "host:puerto del emulador de Firestore", func(c *Config) any { return &c.EmuladorFirestore }},
+	{"templates", "TEMPLATES_DIR", "directorio de plantillas HTML", func(c *Config) any { return &c.DirPlantillas }},
+	{"static", "STATIC_DIR", "directorio de archivos estáticos", func(c *Config) any { return &c.DirEstaticos }},
+	{"session-secret", "SESSION_SECRET", "clave para firmar las cookies de sesión", func(c *Config) any { return &c.SecretoSesion }},
+	{"session-ttl", "SESSION_TTL", "duración de una sesión (ej. 24h)", func(c *Config) any { return &c.DuracionSesion }},
+	{"cookie-secure", "COOKIE_SECURE", "marcar las cookies como Secure (sólo HTTPS)", func(c *Config) any { return &c.CookieSegura }},
+	{"loan-days", "LOAN_DAYS", "plazo de un préstamo en días", func(c *Config) any { return &c.DiasPrestamo }},
+	{"max-renewals", "MAX_RENEWALS", "renovaciones permitidas por préstamo", func(c *Config) any { return &c.MaxRenovaciones }},
+	{"pickup-window", "PICKUP_WINDOW", "plazo para retirar un libro reservado (ej. 72h)", func(c *Config) any { return &c.VentanaRetiro }},
+	{"max-loans-user", "MAX_LOANS_USER", "préstamos simultáneos de un usuario (0 = sin límite)", func(c *Config) any { return &c.MaxPrestamosUsuario }},
+	{"max-loans-librarian", "MAX_LOANS_LIBRARIAN", "préstamos simultáneos de un bibliotecario (0 = sin límite)", func(c *Config) any { return &c.MaxPrestamosBibliotecario }},
+	{"max-loans-admin", "MAX_LOANS_ADMIN", "préstamos simultáneos de un administrador (0 = sin límite)", func(c *Config) any { return &c.MaxPrestamosAdmin }},
+	{"daily-fine", "DAILY_FINE", "multa por cada día de atraso, en centavos", func(c *Config) any { return &c.MultaDiaria }},
+	{"replacement-cost", "REPLACEMENT_COST", "cargo por un ejemplar perdido, en centavos", func(c *Config) any { return &c.CostoReposicion }},
+	{"page-size", "PAGE_SIZE", "elementos por página en los listados", func(c *Config) any { return &c.TamanoPagina }},
+}
+
+// CargarConfig construye la configuración a partir de los argumentos de la
+// línea de comandos (sin el nombre del programa) y del entorno.
+func CargarConfig(args []string) (Config, error) {
+	cfg := ConfigPorDefecto()
+
+	fs := flag.NewFlagSet("biblioteca", flag.ContinueOnError)
+	archivo := fs.String("config", os.Getenv("CONFIG_FILE"), "archivo de configuración JSON")
+	// Los flags se aplican al final para que tengan prioridad sobre el
+	// archivo y el entorno.
+	var pendientes []func() error
+	for _, op := range opcionesConfig {
+		registrar := fs.Func
+		if _, esBool := op.campo(&cfg).(*bool); esBool {
+			registrar = fs.BoolFunc // Permite "-cookie-secure" sin valor
+		}
+		registrar(op.flag, op.ayuda+" (env "+op.env+")", func(v string) error {
+			pendientes = append(pendientes, func() error {
+				if err := asignarValor(op.campo(&cfg), v); err != nil {
+					return fmt.Errorf("flag -%s: %w", op.flag, err)
+				}
+				return nil
+			})
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	if *archivo != "" {
+		datos, err := os.ReadFile(*archivo)
+		if err != nil {
+			return cfg, fmt.Errorf("leyendo archivo de configuración: %w", err)
+		}
+		if err := json.Unmarshal(datos, &cfg); err != nil {
+			return cfg, fmt.Errorf("archivo de configuración %s: %w", *archivo, err)
+		}
+	}
+
+	for _, op := range opcionesConfig {
+		if v, ok := os.LookupEnv(op.env); ok && v != "" {
+			if err := asignarValor(op.campo(&cfg), v); err != nil {
+				return cfg, fmt.Errorf("variable %s: %w", op.env, err)
+			}
+		}
+	}
+
+	for _, aplicar := range pendientes {
+		if err := aplicar(); err != nil {
+			return cfg, err
+		}
+	}
+
+	return cfg, cfg.Validar()
+}
+
+// asignarValor interpreta v según el tipo del campo destino.
+func asignarValor(destino any, v string) error {
+	switch d := destino.(type) {
+	case *string:
+		*d = v
+	case *bool:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		*d = b
+	case *int:
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		*d = n
+	case *Duracion:
+		dur, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		d.Duration = dur
+	default:
+		return fmt.Errorf("tipo de opción no soportado: %T", destino)
+	}
+	return nil
+}
+
+// Validar comprueba que la configuración sea utilizable.
+func (c Config) Validar() error {
+	if c.Puerto == "" {
+		return fmt.Errorf("el puerto no puede estar vacío")
+	}
+	if c.DuracionSesion.Duration <= 0 {
+		return fmt.Errorf("la duración de sesión debe ser positiva")
+	}
+	if c.DiasPrestamo <= 0 {
+		return fmt.Errorf("el plazo de préstamo debe ser de al menos un día")
+	}
+	if c.MaxRenovaciones < 0 {
+		return fmt.Errorf("el máximo de renovaciones no puede ser negativo")
+	}
+	if c.VentanaRetiro.Duration <= 0 {
+		return fmt.Errorf("el plazo de retiro de reservas debe ser positivo")
+	}
+	if c.MaxPrestamosUsuario < 0 || c.MaxPrestamosBibliotecario < 0 || c.MaxPrestamosAdmin < 0 {
+		return fmt.Errorf("el límite de préstamos por rol no puede ser negativo")
+	}
+	if c.MultaDiaria < 0 || c.CostoReposicion < 0 {
+		return fmt.Errorf("las multas no pueden ser negativas")
+	}
+	if c.TamanoPagina <= 0 || c.TamanoPagina > maxLimitePagina {
+		return fmt.Errorf("el tamaño de página debe estar entre 1 y %d", maxLimitePagina)
+	}
+	switch c.Store {
+	case "firestore":
+		if c.ProyectoID == "" {
+			return fmt.Errorf("falta el ID del proyecto de Firebase")
+		}
+	case "memoria", dialectoSQLite, dialectoPostgres:
+	default:
+		return fmt.Errorf("backend de almacenamiento desconocido: %q", c.Store)
+	}
+	return nil
+}
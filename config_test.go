@@ -0,0 +1,119 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sinEntorno vacía las variables de entorno de la configuración durante la
+// prueba; CargarConfig ignora las vacías.
+func sinEntorno(t *testing.T) {
+	t.Helper()
+	t.Setenv("CONFIG_FILE", "")
+	for _, op := range opcionesConfig {
+		t.Setenv(op.env, "")
+	}
+}
+
+// archivoConfig escribe contenido en un archivo de configuración temporal y
+// devuelve su ruta.
+func archivoConfig(t *testing.T, contenido string) string {
+	t.Helper()
+	ruta := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(ruta, []byte(contenido), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return ruta
+}
+
+func TestConfigPrioridades(t *testing.T) {
+	sinEntorno(t)
+	t.Setenv("CONFIG_FILE", archivoConfig(t, `{"store": "memoria", "puerto": "4000", "dias_prestamo": 7, "max_renovaciones": 5, "duracion_sesion": "2h"}`))
+	t.Setenv("PORT", "5000")
+	t.Setenv("LOAN_DAYS", "10")
+	t.Setenv("PICKUP_WINDOW", "48h")
+
+	cfg, err := CargarConfig([]string{"-loan-days", "21"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, caso := range []struct {
+		que                string
+		obtenido, esperado any
+	}{
+		{"store (archivo sobre el valor por defecto)", cfg.Store, "memoria"},
+		{"max_renovaciones (archivo)", cfg.MaxRenovaciones, 5},
+		{"duracion_sesion (archivo)", cfg.DuracionSesion.Duration, 2 * time.Hour},
+		{"puerto (entorno sobre el archivo)", cfg.Puerto, "5000"},
+		{"ventana de retiro (entorno sobre el valor por defecto)", cfg.VentanaRetiro.Duration, 48 * time.Hour},
+		{"dias_prestamo (flag sobre el entorno y el archivo)", cfg.DiasPrestamo, 21},
+		{"tamaño de página (por defecto)", cfg.TamanoPagina, ConfigPorDefecto().TamanoPagina},
+	} {
+		if caso.obtenido != caso.esperado {
+			t.Errorf("%s = %v, se esperaba %v", caso.que, caso.obtenido, caso.esperado)
+		}
+	}
+
+	// -config tiene prioridad sobre CONFIG_FILE
+	otro := archivoConfig(t, `{"store": "memoria", "max_renovaciones": 1}`)
+	if cfg, err := CargarConfig([]string{"-config", otro}); err != nil || cfg.MaxRenovaciones != 1 {
+		t.Errorf("con -config: max_renovaciones = %d, %v", cfg.MaxRenovaciones, err)
+	}
+}
+
+func TestConfigCookieSegura(t *testing.T) {
+	sinEntorno(t)
+	t.Setenv("STORE", "memoria")
+	for _, caso := range []struct {
+		entorno  string
+		args     []string
+		esperado bool
+	}{
+		{"", nil, false},
+		{"", []string{"-cookie-secure"}, true}, // Sin valor
+		{"true", nil, true},
+		{"true", []string{"-cookie-secure=false"}, false},
+	} {
+		t.Setenv("COOKIE_SECURE", caso.entorno)
+		cfg, err := CargarConfig(caso.args)
+		if err != nil || cfg.CookieSegura != caso.esperado {
+			t.Errorf("COOKIE_SECURE=%q %q: CookieSegura = %v, %v; se esperaba %v", caso.entorno, caso.args, cfg.CookieSegura, err, caso.esperado)
+		}
+	}
+}
+
+func TestConfigInvalida(t *testing.T) {
+	for _, caso := range []struct {
+		que     string
+		entorno map[string]string
+		archivo string
+		args    []string
+		mensaje string // Parte del error esperado
+	}{
+		{"duración sin unidad en el entorno", map[string]string{"PICKUP_WINDOW": "72"}, "", nil, "PICKUP_WINDOW"},
+		{"duración ilegible en el entorno", map[string]string{"SESSION_TTL": "mañana"}, "", nil, "SESSION_TTL"},
+		{"duración negativa", map[string]string{"SESSION_TTL": "-1h"}, "", nil, "duración de sesión"},
+		{"duración ilegible en un flag", nil, "", []string{"-pickup-window", "tres días"}, "pickup-window"},
+		{"duración ilegible en el archivo", nil, `{"ventana_retiro": "72"}`, nil, "archivo de configuración"},
+		{"número ilegible en el entorno", map[string]string{"LOAN_DAYS": "catorce"}, "", nil, "LOAN_DAYS"},
+		{"booleano ilegible en el entorno", map[string]string{"COOKIE_SECURE": "quizás"}, "", nil, "COOKIE_SECURE"},
+		{"archivo inexistente", map[string]string{"CONFIG_FILE": filepath.Join(t.TempDir(), "no-existe.json")}, "", nil, "leyendo archivo"},
+	} {
+		t.Run(caso.que, func(t *testing.T) {
+			sinEntorno(t)
+			t.Setenv("STORE", "memoria")
+			if caso.archivo != "" {
+				t.Setenv("CONFIG_FILE", archivoConfig(t, caso.archivo))
+			}
+			for clave, valor := range caso.entorno {
+				t.Setenv(clave, valor)
+			}
+			if _, err := CargarConfig(caso.args); err == nil || !strings.Contains(err.Error(), caso.mensaje) {
+				t.Errorf("err = %v, se esperaba un error con %q", err, caso.mensaje)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrCredenciales es el único error de autenticar ante un nombre o una
+// contraseña incorrectos, para no revelar cuál de los dos falló.
+var ErrCredenciales = errors.New("credenciales incorrectas")
+
+// hashContrasena devuelve el hash bcrypt de la contraseña en texto plano.
+func hashContrasena(plano string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(plano), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// esHashBcrypt indica si el valor guardado ya es un hash bcrypt.
+func esHashBcrypt(guardada string) bool {
+	return strings.HasPrefix(guardada, "$2a$") || strings.HasPrefix(guardada, "$2b$") || strings.HasPrefix(guardada, "$2y$")
+}
+
+// verificarContrasena compara la contraseña ingresada con la guardada. Las
+// personas registradas antes del hashing tienen la contraseña en texto plano;
+// en ese caso migrar es true para que el login la reemplace por su hash.
+func verificarContrasena(guardada, plano string) (ok, migrar bool) {
+	if esHashBcrypt(guardada) {
+		return bcrypt.CompareHashAndPassword([]byte(guardada), []byte(plano)) == nil, false
+	}
+	ok = guardada != "" && subtle.ConstantTimeCompare([]byte(guardada), []byte(plano)) == 1
+	return ok, ok
+}
+
+// hashFicticio se usa cuando la persona no existe, para que el login tarde
+// lo mismo y no revele qué nombres están registrados.
+var hashFicticio, _ = bcrypt.GenerateFromPassword([]byte("contraseña-ficticia"), bcrypt.DefaultCost)
+
+func compararFicticio(plano string) {
+	bcrypt.CompareHashAndPassword(hashFicticio, []byte(plano))
+}
+
+// migrarContrasena reemplaza una contraseña en texto plano por su hash. Se
+// relee la persona dentro de la transacción para no pisar un cambio hecho
+// entretanto.
+func migrarContrasena(ctx context.Context, store Store, personaID, plano string) error {
+	hash, err := hashContrasena(plano)
+	if err != nil {
+		return err
+	}
+	return store.RunTransaction(ctx, func(ctx context.Context, tx Store) error {
+		persona, err := tx.Personas().Obtener(ctx, personaID)
+		if err != nil {
+			return err
+		}
+		if esHashBcrypt(persona.Contrasena) || persona.Contrasena != plano {
+			return nil
+		}
+		persona.Contrasena = hash
+		return tx.Personas().Guardar(ctx, persona)
+	})
+}
+
+// autenticar busca a la persona con ese nombre y esa contraseña. Como el
+// nombre no es único, prueba la contraseña con cada persona que lo tenga.
+// Si la contraseña estaba en texto plano, la reemplaza por su hash.
+func autenticar(ctx context.Context, store Store, nombre, contrasena string) (*Persona, error) {
+	personas, err := store.Personas().PorNombre(ctx, nombre)
+	if err != nil {
+		return nil, err
+	}
+	if len(personas) == 0 {
+		compararFicticio(contrasena)
+		return nil, ErrCredenciales
+	}
+	var persona *Persona
+	var migrar bool
+	for i := range personas {
+		var ok bool
+		if ok, migrar = verificarContrasena(personas[i].Contrasena, contrasena); ok {
+			persona = &personas[i]
+			break
+		}
+	}
+	if persona == nil {
+		return nil, ErrCredenciales
+	}
+	if migrar {
+		// Contraseña heredada en texto plano: reemplazarla por su hash
+		if err := migrarContrasena(ctx, store, persona.ID, contrasena); err != nil {
+			log.Printf("Error al migrar la contraseña de %s: %v", persona.ID, err)
+		} else {
+			log.Printf("🔐 Contraseña de %s migrada a bcrypt", nombre)
+		}
+	}
+	return persona, nil
+}
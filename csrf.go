@@ -0,0 +1,62 @@
+package main
+
+import (
+	"crypto/hmac"
+	"html/template"
+	"net/http"
+)
+
+// Los formularios envían el token CSRF en el campo campoCSRF y las llamadas
+// AJAX en la cabecera cabeceraCSRF.
+const (
+	campoCSRF    = "csrf_token"
+	cabeceraCSRF = "X-CSRF-Token"
+)
+
+// tokenCSRF devuelve el token CSRF de la sesión actual, o "" si no hay
+// sesión. Se deriva del ID de sesión con el secreto, así que es distinto en
+// cada sesión y no hace falta guardarlo.
+func tokenCSRF(r *http.Request) string {
+	sesion := sesionActual(r)
+	if sesion == nil {
+		return ""
+	}
+	return firmar("csrf:" + sesion.ID)
+}
+
+// csrfValido comprueba el token recibido en la cabecera o en el formulario.
+func csrfValido(r *http.Request) bool {
+	esperado := tokenCSRF(r)
+	if esperado == "" {
+		return false
+	}
+	recibido := r.Header.Get(cabeceraCSRF)
+	if recibido == "" {
+		recibido = r.PostFormValue(campoCSRF)
+	}
+	return hmac.Equal([]byte(recibido), []byte(esperado))
+}
+
+func metodoSeguro(metodo string) bool {
+	switch metodo {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// funcsCSRF son las funciones de plantilla que dependen de la petición:
+// csrfToken devuelve el token y csrfCampo el input oculto para formularios.
+func funcsCSRF(r *http.Request) template.FuncMap {
+	token := tokenCSRF(r)
+	return template.FuncMap{
+		"csrfToken": func() string { return token },
+		"csrfCampo": func() template.HTML {
+			if token == "" {
+				return ""
+			}
+			// El token es base64url, no necesita escaparse
+			return template.HTML(`<input type="hidden" name="` + campoCSRF + `" value="` + token + `">`)
+		},
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// postSinCSRF simula un formulario enviado desde otro sitio: lleva la cookie
+// de sesión pero no el token.
+func (c *clientePrueba) postSinCSRF(ruta string, form url.Values, cabeceras map[string]string) respuestaPrueba {
+	c.t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, c.srv.URL+ruta, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for k, v := range cabeceras {
+		req.Header.Set(k, v)
+	}
+	return c.hacer(req)
+}
+
+func TestCSRFRechazaPostsSinToken(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		admin := crearPersona(t, "admin", "clave", "admin")
+		otra := crearPersona(t, "otra", "clave", "usuario")
+		libro := crearLibro(t, "Rayuela", 2)
+		c.login("admin", "clave")
+
+		ajax := map[string]string{"X-Requested-With": "XMLHttpRequest"}
+		casos := []struct {
+			ruta string
+			form url.Values
+		}{
+			{"/eliminar-libro", url.Values{"id": {libro.ID}}},
+			{"/eliminar-persona", url.Values{"id": {otra.ID}}},
+			{"/prestamos", url.Values{"libroID": {libro.ID}}},
+			{"/devoluciones", url.Values{"prestamoID": {"x"}, "libroID": {libro.ID}}},
+			{"/editar-libros", url.Values{"id": {libro.ID}, "nombre": {"Hackeado"}, "ano": {"1"}, "copias": {"0"}}},
+			{"/registrar-libro", url.Values{"nombre": {"Pirata"}, "ano": {"1"}, "copias": {"1"}}},
+			{"/logout", nil},
+		}
+		for _, caso := range casos {
+			esperarEstado(t, c.postSinCSRF(caso.ruta, caso.form, nil), http.StatusForbidden)
+			esperarEstado(t, c.postSinCSRF(caso.ruta, caso.form, ajax), http.StatusForbidden)
+
+			malo := url.Values{campoCSRF: {"token-falso"}}
+			for k, v := range caso.form {
+				malo[k] = v
+			}
+			esperarEstado(t, c.postSinCSRF(caso.ruta, malo, nil), http.StatusForbidden)
+			esperarEstado(t, c.postSinCSRF(caso.ruta, caso.form, map[string]string{cabeceraCSRF: "token-falso"}), http.StatusForbidden)
+		}
+
+		// Nada cambió
+		if l := obtenerLibro(t, libro.ID); l.Nombre != "Rayuela" || l.Copias != 2 {
+			t.Errorf("el libro cambió sin token CSRF: %+v", l)
+		}
+		if _, err := DB.Personas().Obtener(context.Background(), otra.ID); err != nil {
+			t.Errorf("la persona se eliminó sin token CSRF: %v", err)
+		}
+		if ps, _ := DB.Prestamos().ActivosPorPersona(context.Background(), admin.ID); len(ps) != 0 {
+			t.Errorf("se registró un préstamo sin token CSRF")
+		}
+		// La sesión sigue abierta
+		esperarEstado(t, c.get("/personas"), http.StatusOK)
+	})
+}
+
+func TestCSRFTokenDeOtraSesion(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		crearPersona(t, "ana", "clave", "usuario")
+		crearPersona(t, "luis", "clave", "usuario")
+		libro := crearLibro(t, "Rayuela", 2)
+
+		atacante := nuevoClientePrueba(t, c.srv)
+		atacante.login("luis", "clave")
+		c.login("ana", "clave")
+		if atacante.csrf == c.csrf {
+			t.Fatal("dos sesiones comparten el mismo token CSRF")
+		}
+
+		form := url.Values{"libroID": {libro.ID}, campoCSRF: {atacante.csrf}}
+		esperarEstado(t, c.postSinCSRF("/prestamos", form, nil), http.StatusForbidden)
+		esperarRedireccion(t, c.post("/prestamos", url.Values{"libroID": {libro.ID}}), "Préstamo registrado")
+	})
+}
+
+func TestCSRFTokenEnPlantillas(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		crearPersona(t, "admin", "clave", "admin")
+		libro := crearLibro(t, "Rayuela", 2)
+
+		if resp := c.get("/login"); strings.Contains(resp.Cuerpo, `name="`+campoCSRF+`"`) {
+			t.Errorf("sin sesión no debería haber token en los formularios")
+		}
+
+		c.login("admin", "clave")
+		campo := `name="` + campoCSRF + `" value="` + c.csrf + `"`
+		for _, ruta := range []string{"/prestamos", "/registrar-libro", "/editar-libros?id=" + libro.ID} {
+			if resp := c.get(ruta); !strings.Contains(resp.Cuerpo, campo) {
+				t.Errorf("%s no incluye el campo CSRF en el formulario", ruta)
+			}
+		}
+		for _, ruta := range []string{"/libros", "/personas", "/devoluciones"} {
+			if resp := c.get(ruta); !strings.Contains(resp.Cuerpo, `content="`+c.csrf+`"`) {
+				t.Errorf("%s no incluye el meta csrf-token para AJAX", ruta)
+			}
+		}
+	})
+}
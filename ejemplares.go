@@ -0,0 +1,671 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Errores de las operaciones sobre ejemplares.
+var (
+	ErrCodigoDuplicado   = errors.New("ya existe un ejemplar con ese código")
+	ErrCondicionInvalida = errors.New("condición de ejemplar desconocida")
+	ErrEjemplarOcupado   = errors.New("el ejemplar está prestado o apartado")
+	ErrStockOcupado      = errors.New("no hay suficientes ejemplares libres para reducir el stock")
+)
+
+// inventario es el estado de las copias de un libro leído dentro de una
+// transacción: qué ejemplares tiene, cuáles están prestados o apartados y
+// quién espera en la cola. Las funciones que lo modifican sólo tocan memoria;
+// guardar escribe el resultado.
+type inventario struct {
+	libro      *Libro
+	ejemplares []Ejemplar
+	prestamos  []Prestamo      // Préstamos activos del libro
+	aprobados  []Prestamo      // Solicitudes aprobadas que esperan ser retiradas, cada una con su copia apartada
+	cola       []Reserva       // Reservas activas, de la más antigua a la más reciente
+	ocupados   map[string]bool // IDs de ejemplares prestados o apartados
+}
+
+// leerInventario hace todas las lecturas que necesita un inventario. Como
+// RunTransaction pide leer antes de escribir, hay que llamarla antes de
+// cualquier escritura de la transacción.
+func leerInventario(ctx context.Context, tx Store, libroID string) (*inventario, error) {
+	libro, err := tx.Libros().Obtener(ctx, libroID)
+	if err != nil {
+		return nil, err
+	}
+	ejemplares, err := tx.Ejemplares().PorLibro(ctx, libroID)
+	if err != nil {
+		return nil, err
+	}
+	prestamos, err := tx.Prestamos().ActivosPorLibro(ctx, libroID)
+	if err != nil {
+		return nil, err
+	}
+	aprobados, err := tx.Prestamos().Historial(ctx, FiltroPrestamos{LibroID: libroID, Estados: []string{EstadoAprobado}})
+	if err != nil {
+		return nil, err
+	}
+	cola, err := tx.Reservas().ActivasPorLibro(ctx, libroID)
+	if err != nil {
+		return nil, err
+	}
+
+	inv := &inventario{libro: libro, ejemplares: ejemplares, prestamos: prestamos, aprobados: aprobados, cola: cola, ocupados: map[string]bool{}}
+	for _, p := range append(prestamos, aprobados...) {
+		if p.EjemplarID != "" {
+			inv.ocupados[p.EjemplarID] = true
+		}
+	}
+	for _, r := range cola {
+		if r.Estado == ReservaLista && r.EjemplarID != "" {
+			inv.ocupados[r.EjemplarID] = true
+		}
+	}
+	return inv, nil
+}
+
+// libres devuelve los ejemplares prestables que nadie tiene ni tiene
+// apartados, ordenados por código.
+func (inv *inventario) libres() []Ejemplar {
+	var libres []Ejemplar
+	for _, e := range inv.ejemplares {
+		if e.Prestable() && !inv.ocupados[e.ID] {
+			libres = append(libres, e)
+		}
+	}
+	return libres
+}
+
+// reservaDe devuelve la reserva activa de la persona en la cola, o nil.
+func (inv *inventario) reservaDe(personaID string) *Reserva {
+	for i := range inv.cola {
+		if inv.cola[i].PersonaID == personaID {
+			return &inv.cola[i]
+		}
+	}
+	return nil
+}
+
+// cumplirReserva da por cumplida la reserva de quien se lleva el libro y la
+// saca de la cola; su copia apartada queda libre para el préstamo.
+func (inv *inventario) cumplirReserva(ctx context.Context, tx Store, reserva Reserva) error {
+	cumplida := reserva
+	cumplida.Estado = ReservaCumplida
+	cumplida.DisponibleHasta = time.Time{}
+	cumplida.EjemplarID = ""
+	if err := tx.Reservas().Guardar(ctx, &cumplida); err != nil {
+		return err
+	}
+	inv.quitarReserva(reserva.ID)
+	return nil
+}
+
+// quitarReserva saca la reserva de la cola y libera su copia apartada.
+func (inv *inventario) quitarReserva(id string) {
+	for i, r := range inv.cola {
+		if r.ID == id {
+			delete(inv.ocupados, r.EjemplarID)
+			inv.cola = slices.Delete(inv.cola, i, i+1)
+			return
+		}
+	}
+}
+
+// guardar aparta las copias libres para las reservas en espera y guarda el
+// libro con los contadores recalculados.
+func (inv *inventario) guardar(ctx context.Context, tx Store, fecha time.Time) error {
+	if err := inv.apartar(ctx, tx, fecha); err != nil {
+		return err
+	}
+	inv.recontar()
+	return tx.Libros().Guardar(ctx, inv.libro)
+}
+
+// apartar asigna las copias libres a las reservas en espera, por orden de
+// llegada y con el plazo de retiro configurado, y guarda esas reservas.
+func (inv *inventario) apartar(ctx context.Context, tx Store, fecha time.Time) error {
+	libres := inv.libres()
+	for i := range inv.cola {
+		r := &inv.cola[i]
+		if len(libres) == 0 {
+			break
+		}
+		if r.Estado != ReservaEnEspera {
+			continue
+		}
+		r.Estado = ReservaLista
+		r.DisponibleHasta = fecha.Add(Configuracion.VentanaRetiro.Duration)
+		r.EjemplarID = libres[0].ID
+		inv.ocupados[r.EjemplarID] = true
+		libres = libres[1:]
+		if err := tx.Reservas().Guardar(ctx, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recontar deriva del inventario el stock (Total), las copias disponibles
+// (Copias) y Disponible del libro.
+func (inv *inventario) recontar() {
+	inv.libro.Total = len(inv.ejemplares)
+	inv.libro.Copias = len(inv.libres())
+	inv.libro.Disponible = inv.libro.Copias > 0
+}
+
+// nuevosCodigos genera n códigos de inventario ("EJ-" y 8 caracteres) que no
+// usa ningún otro ejemplar. Sólo lee, así que puede ir antes de las
+// escrituras de una transacción.
+func nuevosCodigos(ctx context.Context, tx Store, n int) ([]string, error) {
+	const letras = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	var codigos []string
+	for len(codigos) < n {
+		b := make([]byte, 8)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		for i := range b {
+			b[i] = letras[int(b[i])%len(letras)]
+		}
+		codigo := "EJ-" + string(b)
+		_, err := tx.Ejemplares().BuscarPorCodigo(ctx, codigo)
+		if errors.Is(err, ErrNoEncontrado) && !slices.Contains(codigos, codigo) {
+			codigos = append(codigos, codigo)
+		} else if err != nil && !errors.Is(err, ErrNoEncontrado) {
+			return nil, err
+		}
+	}
+	return codigos, nil
+}
+
+// registrarLibro crea el libro con copias ejemplares en buen estado y códigos
+// generados, en una sola transacción, y lo agrega al índice del catálogo.
+func registrarLibro(ctx context.Context, store Store, libro *Libro, copias int) error {
+	err := store.RunTransaction(ctx, func(ctx context.Context, tx Store) error {
+		codigos, err := nuevosCodigos(ctx, tx, max(copias, 0))
+		if err != nil {
+			return err
+		}
+		libro.Total = len(codigos)
+		libro.Copias = len(codigos)
+		libro.Disponible = libro.Copias > 0
+		if err := tx.Libros().Crear(ctx, libro); err != nil {
+			return err
+		}
+		for _, codigo := range codigos {
+			if err := tx.Ejemplares().Crear(ctx, &Ejemplar{LibroID: libro.ID, Codigo: codigo, Condicion: CondicionBueno}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		Catalogo.Indexar(*libro)
+	}
+	return err
+}
+
+// migrarEjemplares crea los ejemplares de los libros que todavía sólo tienen
+// el contador Copias: uno por cada copia libre, préstamo activo y copia
+// apartada, y asigna los suyos a esos préstamos y reservas. Además recalcula
+// Total, Copias y Disponible de todos los libros, así que corrige también los
+// contadores desfasados. Sólo escribe los libros que cambian y devuelve
+// cuántos son.
+func migrarEjemplares(ctx context.Context, store Store) (int, error) {
+	libros, err := store.Libros().Listar(ctx)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, l := range libros {
+		cambiado := false
+		err := store.RunTransaction(ctx, func(ctx context.Context, tx Store) error {
+			inv, err := leerInventario(ctx, tx, l.ID)
+			if err != nil {
+				return err
+			}
+			antes := *inv.libro
+			var codigos []string
+			var apartadas []*Reserva
+			if len(inv.ejemplares) == 0 {
+				for i := range inv.cola {
+					if inv.cola[i].Estado == ReservaLista {
+						apartadas = append(apartadas, &inv.cola[i])
+					}
+				}
+				codigos, err = nuevosCodigos(ctx, tx, max(inv.libro.Copias, 0)+len(inv.prestamos)+len(apartadas))
+				if err != nil {
+					return err
+				}
+			}
+
+			// Los primeros ejemplares quedan libres; los demás, para los
+			// préstamos y las copias apartadas
+			ocupar := len(codigos) - len(inv.prestamos) - len(apartadas)
+			for i, codigo := range codigos {
+				e := Ejemplar{LibroID: l.ID, Codigo: codigo, Condicion: CondicionBueno}
+				if err := tx.Ejemplares().Crear(ctx, &e); err != nil {
+					return err
+				}
+				inv.ejemplares = append(inv.ejemplares, e)
+				switch j := i - ocupar; {
+				case j < 0:
+				case j < len(inv.prestamos):
+					p := &inv.prestamos[j]
+					p.EjemplarID = e.ID
+					inv.ocupados[e.ID] = true
+					if err := tx.Prestamos().Guardar(ctx, p); err != nil {
+						return err
+					}
+				default:
+					r := apartadas[j-len(inv.prestamos)]
+					r.EjemplarID = e.ID
+					inv.ocupados[e.ID] = true
+					if err := tx.Reservas().Guardar(ctx, r); err != nil {
+						return err
+					}
+				}
+			}
+
+			if err := inv.apartar(ctx, tx, time.Now()); err != nil {
+				return err
+			}
+			inv.recontar()
+			if len(codigos) == 0 && *inv.libro == antes {
+				return nil
+			}
+			cambiado = true
+			return tx.Libros().Guardar(ctx, inv.libro)
+		})
+		if err != nil {
+			return n, fmt.Errorf("libro %s: %w", l.ID, err)
+		}
+		if cambiado {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// editarLibro guarda los datos descriptivos del libro y ajusta su stock a
+// total ejemplares: crea ejemplares nuevos con códigos generados o da de baja
+// ejemplares libres, primero los que no se pueden prestar. Las copias
+// disponibles se recalculan a partir del inventario, así que editar el libro
+// con préstamos activos no las altera. Al terminar reindexa el libro.
+func editarLibro(ctx context.Context, store Store, cambios *Libro, total int, fecha time.Time) error {
+	err := store.RunTransaction(ctx, func(ctx context.Context, tx Store) error {
+		inv, err := leerInventario(ctx, tx, cambios.ID)
+		if err != nil {
+			return err
+		}
+		var codigos []string
+		var sobrantes []Ejemplar
+		switch faltan := total - len(inv.ejemplares); {
+		case faltan > 0:
+			if codigos, err = nuevosCodigos(ctx, tx, faltan); err != nil {
+				return err
+			}
+		case faltan < 0:
+			for _, e := range inv.ejemplares {
+				if !inv.ocupados[e.ID] {
+					sobrantes = append(sobrantes, e)
+				}
+			}
+			if len(sobrantes) < -faltan {
+				return ErrStockOcupado
+			}
+			// Primero los no prestables y, entre iguales, los de código más alto
+			slices.SortStableFunc(sobrantes, func(a, b Ejemplar) int {
+				if a.Prestable() != b.Prestable() {
+					if a.Prestable() {
+						return 1
+					}
+					return -1
+				}
+				return strings.Compare(b.Codigo, a.Codigo)
+			})
+			sobrantes = sobrantes[:-faltan]
+		}
+
+		for _, codigo := range codigos {
+			e := Ejemplar{LibroID: cambios.ID, Codigo: codigo, Condicion: CondicionBueno}
+			if err := tx.Ejemplares().Crear(ctx, &e); err != nil {
+				return err
+			}
+			inv.ejemplares = append(inv.ejemplares, e)
+		}
+		for _, e := range sobrantes {
+			if err := tx.Ejemplares().Eliminar(ctx, e.ID); err != nil {
+				return err
+			}
+			inv.ejemplares = slices.DeleteFunc(inv.ejemplares, func(otro Ejemplar) bool { return otro.ID == e.ID })
+		}
+
+		inv.libro.Nombre = cambios.Nombre
+		inv.libro.Autor = cambios.Autor
+		inv.libro.Descripcion = cambios.Descripcion
+		inv.libro.ImagenURL = cambios.ImagenURL
+		inv.libro.Ano = cambios.Ano
+		inv.libro.Restringido = cambios.Restringido
+		return inv.guardar(ctx, tx, fecha)
+	})
+	if err == nil {
+		Catalogo.Indexar(*cambios)
+	}
+	return err
+}
+
+// validarEjemplar normaliza código, condición y ubicación y comprueba que el
+// código no lo use otro ejemplar. Sólo lee.
+func validarEjemplar(ctx context.Context, tx Store, e *Ejemplar) error {
+	e.Codigo = strings.TrimSpace(e.Codigo)
+	e.Ubicacion = strings.TrimSpace(e.Ubicacion)
+	if e.Condicion == "" {
+		e.Condicion = CondicionBueno
+	}
+	if !slices.Contains(condicionesEjemplar, e.Condicion) {
+		return ErrCondicionInvalida
+	}
+	if e.Codigo == "" {
+		codigos, err := nuevosCodigos(ctx, tx, 1)
+		if err != nil {
+			return err
+		}
+		e.Codigo = codigos[0]
+		return nil
+	}
+	otro, err := tx.Ejemplares().BuscarPorCodigo(ctx, e.Codigo)
+	if err == nil && otro.ID != e.ID {
+		return ErrCodigoDuplicado
+	}
+	if errors.Is(err, ErrNoEncontrado) {
+		return nil
+	}
+	return err
+}
+
+// agregarEjemplar da de alta una copia del libro; si está en condición de
+// prestarse, pasa a la primera reserva en espera o suma una copia disponible.
+// Sin código se genera uno.
+func agregarEjemplar(ctx context.Context, store Store, ejemplar *Ejemplar, fecha time.Time) error {
+	return store.RunTransaction(ctx, func(ctx context.Context, tx Store) error {
+		inv, err := leerInventario(ctx, tx, ejemplar.LibroID)
+		if err != nil {
+			return err
+		}
+		if err := validarEjemplar(ctx, tx, ejemplar); err != nil {
+			return err
+		}
+		if err := tx.Ejemplares().Crear(ctx, ejemplar); err != nil {
+			return err
+		}
+		inv.ejemplares = append(inv.ejemplares, *ejemplar)
+		return inv.guardar(ctx, tx, fecha)
+	})
+}
+
+// editarEjemplar cambia código, condición y ubicación de una copia. Una copia
+// prestada puede marcarse dañada o perdida: sigue en el préstamo y al
+// devolverse ya no vuelve a prestarse.
+func editarEjemplar(ctx context.Context, store Store, cambios *Ejemplar, fecha time.Time) error {
+	return store.RunTransaction(ctx, func(ctx context.Context, tx Store) error {
+		ejemplar, err := tx.Ejemplares().Obtener(ctx, cambios.ID)
+		if err != nil {
+			return err
+		}
+		inv, err := leerInventario(ctx, tx, ejemplar.LibroID)
+		if err != nil {
+			return err
+		}
+		cambios.LibroID = ejemplar.LibroID
+		if err := validarEjemplar(ctx, tx, cambios); err != nil {
+			return err
+		}
+		if err := tx.Ejemplares().Guardar(ctx, cambios); err != nil {
+			return err
+		}
+		for i := range inv.ejemplares {
+			if inv.ejemplares[i].ID == cambios.ID {
+				inv.ejemplares[i] = *cambios
+			}
+		}
+		return inv.guardar(ctx, tx, fecha)
+	})
+}
+
+// eliminarEjemplar da de baja una copia que no está prestada ni apartada.
+func eliminarEjemplar(ctx context.Context, store Store, id string, fecha time.Time) error {
+	return store.RunTransaction(ctx, func(ctx context.Context, tx Store) error {
+		ejemplar, err := tx.Ejemplares().Obtener(ctx, id)
+		if err != nil {
+			return err
+		}
+		inv, err := leerInventario(ctx, tx, ejemplar.LibroID)
+		if err != nil {
+			return err
+		}
+		if inv.ocupados[id] {
+			return ErrEjemplarOcupado
+		}
+		if err := tx.Ejemplares().Eliminar(ctx, id); err != nil {
+			return err
+		}
+		inv.ejemplares = slices.DeleteFunc(inv.ejemplares, func(e Ejemplar) bool { return e.ID == id })
+		return inv.guardar(ctx, tx, fecha)
+	})
+}
+
+// ErrConPrestamos se devuelve al eliminar un libro o una persona que tiene
+// préstamos registrados: el historial se conserva.
+var ErrConPrestamos = errors.New("tiene préstamos registrados y no se puede eliminar")
+
+// eliminarLibro borra el libro junto con sus ejemplares y lo saca del índice.
+// Un libro con préstamos en cualquier estado no se borra (ErrConPrestamos);
+// se comprueba en la misma transacción para que no se cuele un préstamo
+// nuevo. Las reservas activas del libro se cancelan.
+func eliminarLibro(ctx context.Context, store Store, libroID string, fecha time.Time) error {
+	err := store.RunTransaction(ctx, func(ctx context.Context, tx Store) error {
+		if _, err := tx.Libros().Obtener(ctx, libroID); err != nil {
+			return err
+		}
+		prestamos, err := tx.Prestamos().Historial(ctx, FiltroPrestamos{LibroID: libroID})
+		if err != nil {
+			return err
+		}
+		if len(prestamos) > 0 {
+			return ErrConPrestamos
+		}
+		ejemplares, err := tx.Ejemplares().PorLibro(ctx, libroID)
+		if err != nil {
+			return err
+		}
+		reservas, err := tx.Reservas().ActivasPorLibro(ctx, libroID)
+		if err != nil {
+			return err
+		}
+
+		// Las copias apartadas se borran con el libro, así que no hay
+		// inventario al que devolverlas
+		for i := range reservas {
+			if err := cerrarConInventario(ctx, tx, &reservas[i], nil, ReservaCancelada, fecha); err != nil {
+				return err
+			}
+		}
+		for _, e := range ejemplares {
+			if err := tx.Ejemplares().Eliminar(ctx, e.ID); err != nil {
+				return err
+			}
+		}
+		return tx.Libros().Eliminar(ctx, libroID)
+	})
+	if err == nil {
+		Catalogo.Quitar(libroID)
+	}
+	return err
+}
+
+// EjemplarDisplayData es una fila de la tabla de ejemplares de un libro.
+type EjemplarDisplayData struct {
+	Ejemplar
+	Estado  string // Disponible, prestado, apartado o no prestable
+	Ocupado bool
+}
+
+// filasEjemplares describe el estado de cada copia del libro.
+func filasEjemplares(ctx context.Context, libroID string) ([]EjemplarDisplayData, error) {
+	inv, err := leerInventario(ctx, DB, libroID)
+	if err != nil {
+		return nil, err
+	}
+	nombre := func(personaID string) string {
+		if p, err := DB.Personas().Obtener(ctx, personaID); err == nil {
+			return p.Nombre
+		}
+		return "(persona eliminada)"
+	}
+	estados := map[string]string{}
+	for _, p := range inv.prestamos {
+		estados[p.EjemplarID] = "Prestado a " + nombre(p.PersonaID)
+	}
+	for _, p := range inv.aprobados {
+		estados[p.EjemplarID] = "Apartado para " + nombre(p.PersonaID) + " (solicitud aprobada)"
+	}
+	for _, r := range inv.cola {
+		if r.Estado == ReservaLista {
+			estados[r.EjemplarID] = "Apartado para " + nombre(r.PersonaID)
+		}
+	}
+
+	var filas []EjemplarDisplayData
+	for _, e := range inv.ejemplares {
+		fila := EjemplarDisplayData{Ejemplar: e, Estado: estados[e.ID], Ocupado: inv.ocupados[e.ID]}
+		switch {
+		case fila.Ocupado:
+		case e.Prestable():
+			fila.Estado = "Disponible"
+		default:
+			fila.Estado = "No prestable"
+		}
+		filas = append(filas, fila)
+	}
+	return filas, nil
+}
+
+// EjemplaresHandler muestra las copias de un libro con su estado y los
+// formularios para agregarlas, editarlas y darlas de baja.
+func EjemplaresHandler(w http.ResponseWriter, r *http.Request) {
+	libroID := r.URL.Query().Get("libro")
+	libro, err := DB.Libros().Obtener(r.Context(), libroID)
+	if err != nil {
+		http.Error(w, "Libro no encontrado", http.StatusNotFound)
+		return
+	}
+	filas, err := filasEjemplares(r.Context(), libroID)
+	if err != nil {
+		log.Printf("Error al cargar ejemplares de %s: %v", libroID, err)
+		http.Error(w, "Error al cargar los ejemplares", http.StatusInternalServerError)
+		return
+	}
+
+	usuario, rol := usuarioYRol(r)
+	renderTemplate(w, r, "ejemplares.html", DatosPagina{
+		Detalle:     libro,
+		Ejemplares:  filas,
+		Condiciones: condicionesEjemplar,
+		Año:         time.Now().Year(),
+		Usuario:     usuario,
+		Rol:         rol,
+		Mensaje:     r.URL.Query().Get("msg"),
+		TipoMensaje: r.URL.Query().Get("msg_type"),
+	})
+}
+
+// redirigirEjemplares vuelve a la página de ejemplares del libro con un mensaje.
+func redirigirEjemplares(w http.ResponseWriter, r *http.Request, libroID, mensaje, tipo string) {
+	http.Redirect(w, r, "/ejemplares?libro="+url.QueryEscape(libroID)+"&msg="+url.QueryEscape(mensaje)+"&msg_type="+tipo, http.StatusSeeOther)
+}
+
+// mensajeErrorEjemplar traduce los errores de las operaciones sobre
+// ejemplares a un estado HTTP y un mensaje para el usuario.
+func mensajeErrorEjemplar(err error) (int, string) {
+	switch {
+	case errors.Is(err, ErrCodigoDuplicado):
+		return http.StatusConflict, "Ya existe un ejemplar con ese código."
+	case errors.Is(err, ErrCondicionInvalida):
+		return http.StatusBadRequest, "Condición de ejemplar inválida."
+	case errors.Is(err, ErrEjemplarOcupado):
+		return http.StatusConflict, "No se puede dar de baja un ejemplar prestado o apartado."
+	case errors.Is(err, ErrNoEncontrado):
+		return http.StatusNotFound, "El ejemplar o el libro no existe."
+	}
+	return http.StatusInternalServerError, "Error al guardar el ejemplar."
+}
+
+// AgregarEjemplarHandler da de alta una copia de un libro.
+func AgregarEjemplarHandler(w http.ResponseWriter, r *http.Request) {
+	ejemplar := &Ejemplar{
+		LibroID:   r.FormValue("libroID"),
+		Codigo:    r.FormValue("codigo"),
+		Condicion: r.FormValue("condicion"),
+		Ubicacion: r.FormValue("ubicacion"),
+	}
+	if err := agregarEjemplar(r.Context(), DB, ejemplar, time.Now()); err != nil {
+		_, mensaje := mensajeErrorEjemplar(err)
+		log.Printf("Error al agregar ejemplar a %s: %v", ejemplar.LibroID, err)
+		redirigirEjemplares(w, r, ejemplar.LibroID, mensaje, "danger")
+		return
+	}
+	log.Printf("✅ Ejemplar %s agregado al libro %s", ejemplar.Codigo, ejemplar.LibroID)
+	redirigirEjemplares(w, r, ejemplar.LibroID, "Ejemplar "+ejemplar.Codigo+" agregado", "success")
+}
+
+// EditarEjemplarHandler cambia código, condición o ubicación de una copia.
+func EditarEjemplarHandler(w http.ResponseWriter, r *http.Request) {
+	ejemplar := &Ejemplar{
+		ID:        r.FormValue("id"),
+		Codigo:    r.FormValue("codigo"),
+		Condicion: r.FormValue("condicion"),
+		Ubicacion: r.FormValue("ubicacion"),
+	}
+	libroID := r.FormValue("libroID")
+	if err := editarEjemplar(r.Context(), DB, ejemplar, time.Now()); err != nil {
+		_, mensaje := mensajeErrorEjemplar(err)
+		log.Printf("Error al editar ejemplar %s: %v", ejemplar.ID, err)
+		redirigirEjemplares(w, r, libroID, mensaje, "danger")
+		return
+	}
+	redirigirEjemplares(w, r, ejemplar.LibroID, "Ejemplar "+ejemplar.Codigo+" actualizado", "success")
+}
+
+// EliminarEjemplarHandler da de baja una copia que no está prestada ni
+// apartada.
+func EliminarEjemplarHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.FormValue("id")
+	libroID := r.FormValue("libroID")
+	if err := eliminarEjemplar(r.Context(), DB, id, time.Now()); err != nil {
+		estado, mensaje := mensajeErrorEjemplar(err)
+		if estado == http.StatusInternalServerError {
+			log.Printf("Error al eliminar ejemplar %s: %v", id, err)
+		}
+		if esAJAX(r) {
+			http.Error(w, mensaje, estado)
+			return
+		}
+		redirigirEjemplares(w, r, libroID, mensaje, "danger")
+		return
+	}
+	if esAJAX(r) {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	redirigirEjemplares(w, r, libroID, "Ejemplar dado de baja", "success")
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func ejemplaresDe(t *testing.T, libro *Libro) []Ejemplar {
+	t.Helper()
+	ejemplares, err := DB.Ejemplares().PorLibro(context.Background(), libro.ID)
+	if err != nil {
+		t.Fatalf("listando ejemplares de %s: %v", libro.Nombre, err)
+	}
+	return ejemplares
+}
+
+func TestPrestamoUsaUnEjemplar(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		ana := crearPersona(t, "ana", "clave", "usuario")
+		luis := crearPersona(t, "luis", "clave", "usuario")
+		libro := crearLibro(t, "Rayuela", 2)
+
+		ejemplares := ejemplaresDe(t, libro)
+		if len(ejemplares) != 2 || ejemplares[0].Codigo == ejemplares[1].Codigo {
+			t.Fatalf("el libro nuevo debería tener 2 ejemplares con códigos distintos: %+v", ejemplares)
+		}
+
+		deAna, deLuis := prestar(t, libro, ana), prestar(t, libro, luis)
+		if deAna.EjemplarID == "" || deAna.EjemplarID == deLuis.EjemplarID {
+			t.Errorf("cada préstamo debe llevar su propio ejemplar: %q y %q", deAna.EjemplarID, deLuis.EjemplarID)
+		}
+		if l := obtenerLibro(t, libro.ID); l.Copias != 0 || l.Disponible {
+			t.Errorf("con todos los ejemplares prestados: copias=%d disponible=%v", l.Copias, l.Disponible)
+		}
+
+		if err := devolverLibro(context.Background(), DB, deAna.ID, ana, time.Now()); err != nil {
+			t.Fatalf("devolviendo: %v", err)
+		}
+		otro := prestar(t, libro, ana)
+		if otro.EjemplarID != deAna.EjemplarID {
+			t.Errorf("el nuevo préstamo debería usar el ejemplar devuelto")
+		}
+	})
+}
+
+func TestEjemplarDanadoNoSePresta(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		ana := crearPersona(t, "ana", "clave", "usuario")
+		libro := crearLibro(t, "Rayuela", 2)
+		danado := ejemplaresDe(t, libro)[0]
+		danado.Condicion = CondicionDanado
+		if err := editarEjemplar(context.Background(), DB, &danado, time.Now()); err != nil {
+			t.Fatalf("editando ejemplar: %v", err)
+		}
+		if l := obtenerLibro(t, libro.ID); l.Copias != 1 {
+			t.Errorf("copias = %d con un ejemplar dañado, se esperaba 1", l.Copias)
+		}
+
+		if p := prestar(t, libro, ana); p.EjemplarID == danado.ID {
+			t.Errorf("se prestó el ejemplar dañado")
+		}
+		if _, err := prestarLibro(context.Background(), DB, libro.ID, ana.ID, time.Now()); err != ErrSinCopias {
+			t.Errorf("prestar sin ejemplares en buen estado = %v, se esperaba ErrSinCopias", err)
+		}
+
+		danado.Condicion = CondicionRegular
+		if err := editarEjemplar(context.Background(), DB, &danado, time.Now()); err != nil {
+			t.Fatalf("editando ejemplar: %v", err)
+		}
+		if l := obtenerLibro(t, libro.ID); l.Copias != 1 || !l.Disponible {
+			t.Errorf("el ejemplar reparado no volvió a estar disponible: copias=%d disponible=%v", l.Copias, l.Disponible)
+		}
+	})
+}
+
+func TestAdminGestionaEjemplares(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		crearPersona(t, "admin", "clave", "admin")
+		ana := crearPersona(t, "ana", "clave", "usuario")
+		luis := crearPersona(t, "luis", "clave", "usuario")
+		libro := crearLibro(t, "Rayuela", 1)
+		prestamo := prestar(t, libro, ana)
+		reserva := reservar(t, libro, luis, time.Now())
+		c.login("admin", "clave")
+
+		if resp := c.get("/ejemplares?libro=" + libro.ID); !strings.Contains(resp.Cuerpo, "Prestado a ana") {
+			t.Errorf("/ejemplares no muestra a quién está prestado el ejemplar")
+		}
+
+		// Un ejemplar prestado no se da de baja
+		esperarRedireccion(t, c.post("/ejemplares/eliminar", url.Values{"id": {prestamo.EjemplarID}, "libroID": {libro.ID}}), "prestado o apartado")
+
+		// El ejemplar nuevo pasa al primero de la cola
+		form := url.Values{"libroID": {libro.ID}, "codigo": {"INV-0042"}, "condicion": {CondicionBueno}, "ubicacion": {"Estante 3"}}
+		esperarRedireccion(t, c.post("/ejemplares", form), "INV-0042")
+		nuevo, err := DB.Ejemplares().BuscarPorCodigo(context.Background(), "INV-0042")
+		if err != nil || nuevo.Ubicacion != "Estante 3" {
+			t.Fatalf("el ejemplar no quedó guardado: %+v, %v", nuevo, err)
+		}
+		if r := obtenerReserva(t, reserva.ID); r.Estado != ReservaLista || r.EjemplarID != nuevo.ID {
+			t.Errorf("el ejemplar nuevo no se apartó para luis: %+v", r)
+		}
+		if l := obtenerLibro(t, libro.ID); l.Copias != 0 {
+			t.Errorf("copias = %d con el ejemplar nuevo apartado", l.Copias)
+		}
+
+		// Los códigos no se repiten
+		esperarRedireccion(t, c.post("/ejemplares", form), "Ya existe un ejemplar con ese código")
+		if n := len(ejemplaresDe(t, libro)); n != 2 {
+			t.Errorf("el libro tiene %d ejemplares, se esperaban 2", n)
+		}
+
+		// Al devolver, el ejemplar libre se puede dar de baja
+		if err := devolverLibro(context.Background(), DB, prestamo.ID, ana, time.Now()); err != nil {
+			t.Fatalf("devolviendo: %v", err)
+		}
+		esperarRedireccion(t, c.post("/ejemplares/eliminar", url.Values{"id": {prestamo.EjemplarID}, "libroID": {libro.ID}}), "dado de baja")
+		if l := obtenerLibro(t, libro.ID); l.Copias != 0 {
+			t.Errorf("copias = %d tras dar de baja el único ejemplar libre", l.Copias)
+		}
+	})
+}
+
+func TestMigrarEjemplares(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		ctx := context.Background()
+		ana := crearPersona(t, "ana", "clave", "usuario")
+		// Un libro de antes de los ejemplares: sólo el contador y un préstamo sin ejemplar
+		libro := &Libro{Nombre: "Rayuela", Autor: "Cortázar", Ano: 1963, Copias: 1, Disponible: true}
+		if err := DB.Libros().Crear(ctx, libro); err != nil {
+			t.Fatalf("creando libro: %v", err)
+		}
+		prestamo := &Prestamo{LibroID: libro.ID, PersonaID: ana.ID, FechaPrestamo: time.Now(), Estado: EstadoActivo}
+		if err := DB.Prestamos().Crear(ctx, prestamo); err != nil {
+			t.Fatalf("creando préstamo: %v", err)
+		}
+
+		if n, err := migrarEjemplares(ctx, DB); err != nil || n != 1 {
+			t.Fatalf("migrarEjemplares = %d, %v; se esperaba 1 libro", n, err)
+		}
+		if n := len(ejemplaresDe(t, libro)); n != 2 {
+			t.Errorf("se crearon %d ejemplares, se esperaban 2 (uno libre y uno prestado)", n)
+		}
+		p, _ := DB.Prestamos().Obtener(ctx, prestamo.ID)
+		if p.EjemplarID == "" {
+			t.Errorf("el préstamo existente no quedó asociado a un ejemplar")
+		}
+		if l := obtenerLibro(t, libro.ID); l.Copias != 1 || l.Total != 2 {
+			t.Errorf("tras migrar: copias=%d total=%d, se esperaba 1 de 2", l.Copias, l.Total)
+		}
+
+		if n, err := migrarEjemplares(ctx, DB); err != nil || n != 0 {
+			t.Errorf("una segunda migración = %d, %v; no debería tocar nada", n, err)
+		}
+		if err := devolverLibro(ctx, DB, prestamo.ID, ana, time.Now()); err != nil {
+			t.Fatalf("devolviendo: %v", err)
+		}
+		if l := obtenerLibro(t, libro.ID); l.Copias != 2 {
+			t.Errorf("copias = %d tras devolver, se esperaba 2", l.Copias)
+		}
+	})
+}
+
+func TestEditarStockNoAlteraDisponibles(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		crearPersona(t, "admin", "clave", "admin")
+		ana := crearPersona(t, "ana", "clave", "usuario")
+		luis := crearPersona(t, "luis", "clave", "usuario")
+		libro := crearLibro(t, "Rayuela", 3)
+		deAna := prestar(t, libro, ana)
+		prestar(t, libro, luis)
+		c.login("admin", "clave")
+
+		editar := func(copias string) respuestaPrueba {
+			return c.post("/editar-libros", url.Values{
+				"id": {libro.ID}, "nombre": {"Rayuela"}, "autor": {"Cortázar"}, "ano": {"1963"},
+				"descripcion": {"Novela"}, "imagen": {"http://img/rayuela.jpg"}, "copias": {copias},
+			})
+		}
+		esperarStock := func(total, copias int) {
+			t.Helper()
+			if l := obtenerLibro(t, libro.ID); l.Total != total || l.Copias != copias || l.Disponible != (copias > 0) {
+				t.Errorf("total=%d copias=%d disponible=%v; se esperaba total=%d copias=%d", l.Total, l.Copias, l.Disponible, total, copias)
+			}
+		}
+		esperarStock(3, 1)
+
+		esperarRedireccion(t, editar("5"), "Libro actualizado")
+		esperarStock(5, 3)
+		if n := len(ejemplaresDe(t, libro)); n != 5 {
+			t.Errorf("el libro tiene %d ejemplares, se esperaban 5", n)
+		}
+
+		// No se puede bajar el stock por debajo de lo que está prestado
+		esperarRedireccion(t, editar("1"), "No se puede reducir el stock")
+		esperarStock(5, 3)
+
+		esperarRedireccion(t, editar("2"), "Libro actualizado")
+		esperarStock(2, 0)
+		if l := obtenerLibro(t, libro.ID); l.Autor != "Cortázar" {
+			t.Errorf("no se guardaron los datos del libro: %+v", l)
+		}
+
+		if err := devolverLibro(context.Background(), DB, deAna.ID, ana, time.Now()); err != nil {
+			t.Fatalf("devolviendo: %v", err)
+		}
+		esperarStock(2, 1)
+	})
+}
+
+func TestMigrarCorrigeContadores(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		ctx := context.Background()
+		ana := crearPersona(t, "ana", "clave", "usuario")
+		libro := crearLibro(t, "Rayuela", 2)
+		prestar(t, libro, ana)
+
+		desfasado := obtenerLibro(t, libro.ID)
+		desfasado.Copias, desfasado.Total, desfasado.Disponible = 7, 0, false
+		if err := DB.Libros().Guardar(ctx, desfasado); err != nil {
+			t.Fatalf("guardando libro: %v", err)
+		}
+
+		if n, err := migrarEjemplares(ctx, DB); err != nil || n != 1 {
+			t.Fatalf("migrarEjemplares = %d, %v; se esperaba corregir 1 libro", n, err)
+		}
+		if l := obtenerLibro(t, libro.ID); l.Total != 2 || l.Copias != 1 || !l.Disponible {
+			t.Errorf("contadores tras corregir: total=%d copias=%d disponible=%v", l.Total, l.Copias, l.Disponible)
+		}
+	})
+}
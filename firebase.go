@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"os"
 
 	"cloud.google.com/go/firestore"
 	firebase "firebase.google.com/go/v4"
@@ -11,29 +13,41 @@ import (
 
 var FirestoreClient *firestore.Client
 
-func InitFirebase() {
-	ctx := context.Background()
-
-	// Ruta al archivo de credenciales JSON
-	sa := option.WithCredentialsFile("prestamolibros-556f1-firebase-adminsdk-fbsvc-1bc548a5b5.json")
+// InitFirebase inicializa FirestoreClient con el proyecto y las credenciales
+// de cfg. Si hay un emulador configurado no se usan credenciales.
+func InitFirebase(ctx context.Context, cfg Config) error {
+	var opts []option.ClientOption
+	switch {
+	case cfg.EmuladorFirestore != "":
+		// El cliente de Firestore detecta el emulador por esta variable
+		os.Setenv("FIRESTORE_EMULATOR_HOST", cfg.EmuladorFirestore)
+		opts = append(opts, option.WithoutAuthentication())
+		log.Println("Usando el emulador de Firestore en", cfg.EmuladorFirestore)
+	case cfg.CredencialesJSON != "":
+		// Credenciales en línea, por ejemplo desde una variable de entorno en Render
+		opts = append(opts, option.WithCredentialsJSON([]byte(cfg.CredencialesJSON)))
+	case cfg.CredencialesArchivo != "":
+		opts = append(opts, option.WithCredentialsFile(cfg.CredencialesArchivo))
+	}
+	// Sin opciones se usan las credenciales por defecto de la aplicación
 
-	// Configuración explícita con el project ID
 	config := &firebase.Config{
-		ProjectID: "prestamolibros-556f1",
+		ProjectID: cfg.ProyectoID,
 	}
 
 	// Inicializar la app con configuración y credenciales
-	app, err := firebase.NewApp(ctx, config, sa)
+	app, err := firebase.NewApp(ctx, config, opts...)
 	if err != nil {
-		log.Fatalf("Error inicializando Firebase: %v", err)
+		return fmt.Errorf("inicializando Firebase: %w", err)
 	}
 
 	// Inicializar cliente Firestore
 	client, err := app.Firestore(ctx)
 	if err != nil {
-		log.Fatalf("Error inicializando Firestore: %v", err)
+		return fmt.Errorf("inicializando Firestore: %w", err)
 	}
 
 	FirestoreClient = client
 	log.Println("✅ Conexión con Firebase Firestore exitosa")
+	return nil
 }
@@ -3,84 +3,104 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"html/template"
 	"log"
 	"net/http"
 	"net/url" // Importar el paquete url para url.QueryEscape
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
-
-	"cloud.google.com/go/firestore"
-	"google.golang.org/api/iterator"
 )
 
-// Definición de la estructura Libro
-type Libro struct {
-	ID            string `json:"id" firestore:"id,omitempty"`
-	Nombre        string `json:"nombre" firestore:"nombre"`
-	Autor         string `json:"autor" firestore:"autor"`
-	Ano           int    `json:"ano" firestore:"ano"`
-	Descripcion   string `json:"descripcion" firestore:"descripcion"`
-	ImagenURL     string `json:"imagenURL" firestore:"imagen"`
-	Copias        int    `json:"copias" firestore:"copias"`
-	Disponible    bool   `json:"disponible" firestore:"disponible"`                 // Nuevo campo: true si está disponible para préstamo
-	PrestadoPorID string `json:"prestadoPorID" firestore:"prestadoPorID,omitempty"` // ID de la persona que lo tiene prestado
-}
-
-// Definición de la estructura Persona
-type Persona struct {
-	ID         string `json:"id" firestore:"id,omitempty"`
-	Nombre     string `json:"nombre" firestore:"nombre"`
-	Cedula     string `json:"cedula" firestore:"cedula"`
-	Ano        int    `json:"ano" firestore:"ano"`      // CAMBIADO: Ahora es int para consistencia numérica
-	Contrasena string `json:"-" firestore:"contrasena"` // Ignorar en JSON, no almacenar en el cliente
-	Rol        string `json:"rol" firestore:"rol"`
-}
-
-// Definición de la estructura Prestamo
-type Prestamo struct {
-	ID              string    `json:"id" firestore:"id,omitempty"`
-	LibroID         string    `json:"libroID" firestore:"libroID"`                                     // ID del libro prestado
-	PersonaID       string    `json:"personaID" firestore:"personaID"`                                 // ¡Asegúrate de que esta etiqueta firestore sea correcta!
-	FechaPrestamo   time.Time `json:"fechaPrestamo" firestore:"fechaPrestamo"`                         // Fecha en que se realizó el préstamo
-	FechaDevolucion time.Time `json:"fechaDevolucion,omitempty" firestore:"fechaDevolucion,omitempty"` // Fecha de devolución (opcional, se llena al devolver)
-	Activo          bool      `json:"activo" firestore:"activo"`                                       // true si el préstamo está activo, false si ya se devolvió
-}
-
 // DevolucionDisplayData combina Prestamo, Libro, y Persona para mostrar en la tabla de devoluciones
 type DevolucionDisplayData struct {
-	PrestamoID    string
-	LibroID       string // Necesario para la devolución
-	LibroNombre   string
-	AutorNombre   string
-	UsuarioID     string // Necesario para la devolución
-	UsuarioNombre string
-	FechaPrestamo time.Time
-	Activo        bool
+	PrestamoID       string
+	LibroID          string
+	LibroNombre      string
+	AutorNombre      string
+	UsuarioID        string
+	UsuarioNombre    string // Sólo se llena para bibliotecarios y administradores
+	UsuarioCedula    string // Igual que UsuarioNombre
+	FechaPrestamo    time.Time
+	FechaDevolucion  time.Time // Cero mientras el préstamo sigue activo
+	FechaVencimiento time.Time // Cero en préstamos anteriores a los vencimientos
+	DiasAtraso       int       // 0 si no está vencido
+	Renovaciones     int
+	Estado           string
+	RetirarHasta     time.Time // Plazo de retiro de una solicitud aprobada
 }
 
 // Definición de la estructura DatosPagina
 type DatosPagina struct {
 	Libros            []Libro
+	Resultados        []LibroEncontrado       // Catálogo de libros.html, con los fragmentos de la búsqueda
 	LibrosDisponibles []Libro                 // Para el formulario de préstamo
 	Personas          []Persona               // Para el formulario de préstamo (ahora solo para referencia, no para selección)
 	Prestamos         []Prestamo              // Para listar préstamos (sin usar directamente en devoluciones.html)
 	DevolucionesData  []DevolucionDisplayData // Nuevo campo para la tabla de devoluciones
+	GestionaPrestamos bool                    // true para bibliotecarios y administradores
+	Historial         []DevolucionDisplayData // Préstamos activos y devueltos
+	VistaGeneral      bool                    // Historial de toda la biblioteca (con filtros)
+	Filtros           FiltrosHistorial
+	Vencidos          []DevolucionDisplayData // Reporte de préstamos vencidos
+	MaxRenovaciones   int
+	Reservas          []ReservaDisplayData    // Reservas activas de la persona logueada
+	Ejemplares        []EjemplarDisplayData   // Copias del libro en Detalle
+	Condiciones       []string                // Condiciones posibles de un ejemplar
+	Cuenta            *CuentaMultas           // Estado de cuenta de multas (perfil y administración)
+	AdministraMultas  bool                    // Muestra los formularios de pagos y condonaciones
+	Cedula            string                  // Cédula buscada en el mostrador
+	Limite            int                     // Préstamos simultáneos que permite el rol de la persona del mostrador; 0 es sin límite
+	Solicitudes       []DevolucionDisplayData // Solicitudes de préstamo sin retirar (propias o, para el administrador, todas)
+	Tokens            []TokenAPI              // Tokens de la API de la persona logueada
+	NuevoToken        string                  // Token recién creado, en claro; se muestra una sola vez
 	Detalle           *Libro
 	Año               int
 	Usuario           string
 	Rol               string
 	SearchQuery       string
+	Orden             string // Parámetro orden del listado paginado, ej. "-ano"
+	Cursor            string // Cursor de la página actual; vacío en la primera
+	Siguiente         string // Cursor de la página siguiente; vacío en la última
 	Mensaje           string // Nuevo campo para mensajes de éxito/error
 	TipoMensaje       string // "success" o "danger"
 }
 
 // Nueva estructura para la respuesta JSON de LibrosHandler (para AJAX)
 type LibrosResponse struct {
-	Libros  []Libro `json:"libros"`
-	Usuario string  `json:"usuario"`
-	Rol     string  `json:"rol"`
+	Libros    []LibroEncontrado `json:"libros"`
+	Usuario   string            `json:"usuario"`
+	Rol       string            `json:"rol"`
+	Siguiente string            `json:"siguiente"` // Cursor de la página siguiente; vacío en la última
+}
+
+// PersonasResponse es la respuesta JSON de PersonasHandler para AJAX.
+type PersonasResponse struct {
+	Personas  []Persona `json:"personas"`
+	Siguiente string    `json:"siguiente"`
+}
+
+// CarritoResponse es la respuesta JSON de PrestamoHandler: si se prestó el
+// carrito y qué pasó con cada libro.
+type CarritoResponse struct {
+	OK      bool          `json:"ok"`
+	Mensaje string        `json:"mensaje"`
+	Items   []ItemCarrito `json:"items"`
+}
+
+// ItemCarrito es el resultado de un libro del carrito. Error queda vacío si
+// el libro se podía prestar, aunque el carrito se haya rechazado por otro.
+type ItemCarrito struct {
+	LibroID    string `json:"libroID"`
+	Nombre     string `json:"nombre"`
+	Prestado   bool   `json:"prestado"`
+	Estado     string `json:"estado,omitempty"` // activo, o solicitado si el libro es restringido
+	PrestamoID string `json:"prestamoID,omitempty"`
+	Vence      string `json:"vence,omitempty"`
+	Error      string `json:"error,omitempty"`
 }
 
 // NUNCA OLVIDES AGREGAR ESTAS NUEVAS FUNCIONES AL renderTemplate GLOBAL
@@ -92,10 +112,20 @@ var funcs = template.FuncMap{
 	"formatDate": func(t time.Time) string { // Función para formatear fechas en la plantilla
 		return t.Format("02/01/2006") // Formato DD/MM/YYYY
 	},
+	"dinero": formatoDinero, // Centavos como "$12.50"
+	// Fragmento de una búsqueda; Bleve ya escapa el texto y sólo agrega <mark>
+	"resaltado": func(fragmento string) template.HTML { return template.HTML(fragmento) },
 }
 
+// rutaPlantilla devuelve la ruta de una plantilla dentro del directorio configurado.
+func rutaPlantilla(archivo string) string {
+	return filepath.Join(Configuracion.DirPlantillas, archivo)
+}
+
+// renderTemplate ejecuta base.html con la vista dada. Además de funcs, las
+// plantillas tienen csrfToken y csrfCampo con el token de la sesión actual.
 func renderTemplate(w http.ResponseWriter, r *http.Request, archivo string, data interface{}) {
-	tmpl, err := template.New("base.html").Funcs(funcs).ParseFiles("templates/base.html", "templates/"+archivo)
+	tmpl, err := template.New("base.html").Funcs(funcs).Funcs(funcsCSRF(r)).ParseFiles(rutaPlantilla("base.html"), rutaPlantilla(archivo))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		log.Println("Error cargando plantilla:", archivo, err)
@@ -105,72 +135,35 @@ func renderTemplate(w http.ResponseWriter, r *http.Request, archivo string, data
 }
 
 // LibrosHandler fetches and displays the list of books, with optional search.
+// Los libros se muestran de a una página, en el orden del parámetro orden;
+// la búsqueda usa el índice del catálogo y ordena por relevancia.
 func LibrosHandler(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
-
 	searchQuery := r.URL.Query().Get("q")
 	// Obtener mensajes de la URL (si existen)
 	mensaje := r.URL.Query().Get("msg")
 	tipoMensaje := r.URL.Query().Get("msg_type")
 
-	query := FirestoreClient.Collection("libro").Query
-
-	iter := query.Documents(ctx)
-
-	var allLibros []Libro
-	// Declarar err en el ámbito de la función, si se va a usar fuera del bucle.
-	// En este caso, no se usa fuera, así que se puede declarar con := dentro del bucle.
-
-	for {
-		doc, errLoop := iter.Next() // Renombrado err a errLoop
-		if errLoop == iterator.Done {
-			break
-		}
-		if errLoop != nil {
-			log.Printf("Error al iterar libros: %v", errLoop)
-			http.Error(w, "Error al cargar libros", http.StatusInternalServerError)
-			return
-		}
-		var libro Libro
-		if errLoop := doc.DataTo(&libro); errLoop != nil { // Renombrado err a errLoop
-			log.Printf("Error al mapear datos de libro: %v", errLoop)
-			continue // Saltar este documento y continuar con el siguiente
-		}
-		libro.ID = doc.Ref.ID // Asignar el ID del documento
-		allLibros = append(allLibros, libro)
+	filteredLibros, _, siguiente, err := paginaLibros(r)
+	if esErrorPagina(err) {
+		http.Error(w, "Paginación inválida: "+err.Error(), http.StatusBadRequest)
+		return
 	}
-
-	var filteredLibros []Libro
-	if searchQuery != "" {
-		lowerSearchQuery := strings.ToLower(searchQuery)
-		for _, libro := range allLibros {
-			lowerTitle := strings.ToLower(libro.Nombre)
-			lowerAuthor := strings.ToLower(libro.Autor)
-
-			if strings.Contains(lowerTitle, lowerSearchQuery) || strings.Contains(lowerAuthor, lowerSearchQuery) {
-				filteredLibros = append(filteredLibros, libro)
-			}
-		}
-	} else {
-		filteredLibros = allLibros
+	if err != nil {
+		log.Printf("Error al listar libros: %v", err)
+		http.Error(w, "Error al cargar libros", http.StatusInternalServerError)
+		return
 	}
 
-	// Obtener usuario y rol de las cookies para ambas respuestas (HTML y AJAX)
-	usuario := ""
-	rol := ""
-	if c, errCookie := r.Cookie("usuario"); errCookie == nil { // Renombrado err a errCookie
-		usuario = c.Value
-	}
-	if c, errCookie := r.Cookie("rol"); errCookie == nil { // Renombrado err a errCookie
-		rol = c.Value
-	}
+	// Obtener usuario y rol de la sesión para ambas respuestas (HTML y AJAX)
+	usuario, rol := usuarioYRol(r)
 
 	if r.Header.Get("X-Requested-With") == "XMLHttpRequest" {
 		// Para solicitudes AJAX, devolver un JSON con libros, usuario y rol
 		response := LibrosResponse{
-			Libros:  filteredLibros,
-			Usuario: usuario,
-			Rol:     rol,
+			Libros:    filteredLibros,
+			Usuario:   usuario,
+			Rol:       rol,
+			Siguiente: siguiente,
 		}
 		w.Header().Set("Content-Type", "application/json")
 		if err := json.NewEncoder(w).Encode(response); err != nil { // Codificar la nueva estructura
@@ -182,11 +175,14 @@ func LibrosHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Si no es una solicitud AJAX, renderiza la plantilla HTML completa
 	data := DatosPagina{
-		Libros:      filteredLibros,
+		Resultados:  filteredLibros,
 		Año:         time.Now().Year(),
 		Usuario:     usuario, // Asegurarse de que el usuario se pase a la plantilla HTML
 		Rol:         rol,     // Asegurarse de que el rol se pase a la plantilla HTML
 		SearchQuery: searchQuery,
+		Orden:       r.URL.Query().Get("orden"), // Vacío es relevancia si hay búsqueda y título si no
+		Cursor:      r.URL.Query().Get("cursor"),
+		Siguiente:   siguiente,
 		Mensaje:     mensaje,     // Pasar el mensaje a la plantilla
 		TipoMensaje: tipoMensaje, // Pasar el tipo de mensaje a la plantilla
 	}
@@ -194,138 +190,83 @@ func LibrosHandler(w http.ResponseWriter, r *http.Request) {
 	renderTemplate(w, r, "libros.html", data)
 }
 
-// EditarLibroHandler handles displaying the edit form and processing updates.
-func EditarLibroHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println("DEBUG: Entrando a EditarLibroHandler")
-	// Verificar rol de administrador
-	rol := ""
-	if c, errCookie := r.Cookie("rol"); errCookie == nil { // Renombrado err a errCookie
-		rol = c.Value
-	}
-	log.Printf("DEBUG: Rol del usuario: %s", rol)
-	if rol != "admin" {
-		log.Println("DEBUG: Acceso denegado a EditarLibroHandler (no admin)")
-		http.Error(w, "Acceso denegado. Solo administradores pueden editar libros.", http.StatusForbidden)
+// EditarLibroFormHandler muestra el formulario de edición de un libro.
+func EditarLibroFormHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("DEBUG: Entrando a EditarLibroFormHandler")
+
+	usuario, rol := usuarioYRol(r)
+	bookID := r.URL.Query().Get("id")
+	log.Printf("DEBUG: ID del libro a editar (GET): %s", bookID) // Log más específico
+	if bookID == "" {
+		log.Println("DEBUG: ID de libro no proporcionado en GET")
+		http.Error(w, "ID de libro no proporcionado", http.StatusBadRequest)
 		return
 	}
 
-	if r.Method == http.MethodGet {
-		log.Println("DEBUG: Método GET en EditarLibroHandler")
-		bookID := r.URL.Query().Get("id")
-		log.Printf("DEBUG: ID del libro a editar (GET): %s", bookID) // Log más específico
-		if bookID == "" {
-			log.Println("DEBUG: ID de libro no proporcionado en GET")
-			http.Error(w, "ID de libro no proporcionado", http.StatusBadRequest)
-			return
-		}
-
-		ctx := context.Background()
-		doc, err := FirestoreClient.Collection("libro").Doc(bookID).Get(ctx)
-		if err != nil {
-			log.Printf("DEBUG: Error al obtener libro %s de Firestore: %v", bookID, err)
-			http.Error(w, "Libro no encontrado: "+err.Error(), http.StatusNotFound)
-			return
-		}
-		log.Println("DEBUG: Libro obtenido de Firestore")
-
-		var libro Libro
-		if err := doc.DataTo(&libro); err != nil {
-			log.Printf("DEBUG: Error al parsear datos del libro %s: %v", bookID, err)
-			http.Error(w, "Error al parsear datos del libro: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
-		libro.ID = doc.Ref.ID                                                   // Asignar el ID del documento al campo ID de la estructura
-		log.Printf("DEBUG: Datos del libro parseados para edición: %+v", libro) // Log con datos parseados
-
-		usuario := ""
-		if c, errCookie := r.Cookie("usuario"); errCookie == nil { // Renombrado err a errCookie
-			usuario = c.Value
-		}
-		log.Printf("DEBUG: Usuario logueado: %s", usuario)
-
-		data := DatosPagina{
-			Detalle: &libro,
-			Año:     time.Now().Year(),
-			Usuario: usuario,
-			Rol:     rol,
-		}
-		log.Println("DEBUG: Renderizando editar_libros.html")
-		renderTemplate(w, r, "editar_libros.html", data)
+	libro, err := DB.Libros().Obtener(r.Context(), bookID)
+	if err != nil {
+		log.Printf("DEBUG: Error al obtener libro %s: %v", bookID, err)
+		http.Error(w, "Libro no encontrado: "+err.Error(), http.StatusNotFound)
 		return
 	}
+	log.Printf("DEBUG: Datos del libro para edición: %+v", libro)
 
-	if r.Method == http.MethodPost {
-		log.Println("DEBUG: Método POST en EditarLibroHandler")
-		bookID := r.FormValue("id")
-		nombre := r.FormValue("nombre")
-		autor := r.FormValue("autor")
-		descripcion := r.FormValue("descripcion")
-		imagen := r.FormValue("imagen")
-		anoStr := r.FormValue("ano")
-		copiasStr := r.FormValue("copias")
-		disponibleStr := r.FormValue("disponible") // Obtener el valor de disponible
-
-		ano, err := strconv.Atoi(anoStr)
-		if err != nil {
-			log.Printf("DEBUG POST: Año inválido: %s, Error: %v", anoStr, err)
-			http.Redirect(w, r, "/libros?msg=Año inválido&msg_type=danger", http.StatusSeeOther)
-			return
-		}
-		copias, err := strconv.Atoi(copiasStr)
-		if err != nil {
-			log.Printf("DEBUG POST: Número de copias inválido: %s, Error: %v", copiasStr, err)
-			http.Redirect(w, r, "/libros?msg=Número de copias inválido&msg_type=danger", http.StatusSeeOther)
-			return
-		}
-		// Un checkbox no enviado (desmarcado) resulta en un valor vacío, no "off".
-		// Si se envía "on", significa que está marcado. Si es vacío, está desmarcado.
-		disponible := (disponibleStr == "on")
-
-		updates := []firestore.Update{
-			{Path: "nombre", Value: nombre},
-			{Path: "autor", Value: autor},
-			{Path: "descripcion", Value: descripcion},
-			{Path: "imagen", Value: imagen},
-			{Path: "ano", Value: ano},
-			{Path: "copias", Value: copias},
-			{Path: "disponible", Value: disponible}, // Actualizar el campo disponible
-		}
-		log.Printf("DEBUG POST: Actualizaciones a enviar a Firestore: %+v", updates)
-
-		ctx := context.Background()
-		_, err = FirestoreClient.Collection("libro").Doc(bookID).Update(ctx, updates)
-		if err != nil {
-			log.Printf("DEBUG POST: Error al actualizar libro %s en Firestore: %v", bookID, err)
-			http.Redirect(w, r, "/libros?msg=Error al actualizar el libro&msg_type=danger", http.StatusSeeOther)
-			return
-		}
+	log.Printf("DEBUG: Usuario logueado: %s", usuario)
 
-		log.Printf("✅ Libro actualizado exitosamente: %s (ID: %s)", nombre, bookID)
-		http.Redirect(w, r, "/libros?msg=Libro actualizado exitosamente&msg_type=success", http.StatusSeeOther)
+	data := DatosPagina{
+		Detalle: libro,
+		Año:     time.Now().Year(),
+		Usuario: usuario,
+		Rol:     rol,
 	}
+	log.Println("DEBUG: Renderizando editar_libros.html")
+	renderTemplate(w, r, "editar_libros.html", data)
 }
 
-// EliminarLibroHandler handles deleting a book.
-func EliminarLibroHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println("DEBUG: Entrando a EliminarLibroHandler")
-	// Verificar rol de administrador
-	rol := ""
-	if c, errCookie := r.Cookie("rol"); errCookie == nil { // Renombrado err a errCookie
-		rol = c.Value
+// EditarLibroHandler procesa el formulario de edición de un libro.
+func EditarLibroHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("DEBUG: Entrando a EditarLibroHandler")
+	bookID := r.FormValue("id")
+	nombre := r.FormValue("nombre")
+	autor := r.FormValue("autor")
+	descripcion := r.FormValue("descripcion")
+	imagen := r.FormValue("imagen")
+	anoStr := r.FormValue("ano")
+	copiasStr := r.FormValue("copias") // Stock total; las copias disponibles se calculan
+
+	ano, err := strconv.Atoi(anoStr)
+	if err != nil {
+		log.Printf("DEBUG POST: Año inválido: %s, Error: %v", anoStr, err)
+		http.Redirect(w, r, "/libros?msg=Año inválido&msg_type=danger", http.StatusSeeOther)
+		return
 	}
-	log.Printf("DEBUG: Rol del usuario en Eliminar: %s", rol)
-	if rol != "admin" {
-		log.Println("DEBUG: Acceso denegado a EliminarLibroHandler (no admin)")
-		http.Error(w, "Acceso denegado. Solo administradores pueden eliminar libros.", http.StatusForbidden)
+	copias, err := strconv.Atoi(copiasStr)
+	if err != nil || copias < 0 {
+		log.Printf("DEBUG POST: Número de copias inválido: %s, Error: %v", copiasStr, err)
+		http.Redirect(w, r, "/libros?msg=Número de copias inválido&msg_type=danger", http.StatusSeeOther)
 		return
 	}
 
-	if r.Method != http.MethodPost {
-		log.Println("DEBUG: Método no permitido en EliminarLibroHandler")
-		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+	// Las solicitudes ya registradas siguen su curso aunque cambie Restringido
+	cambios := &Libro{ID: bookID, Nombre: nombre, Autor: autor, Descripcion: descripcion, ImagenURL: imagen, Ano: ano,
+		Restringido: r.FormValue("restringido") != ""}
+	if err := editarLibro(r.Context(), DB, cambios, copias, time.Now()); err != nil {
+		log.Printf("DEBUG POST: Error al actualizar libro %s: %v", bookID, err)
+		mensaje := "Error al actualizar el libro"
+		if errors.Is(err, ErrStockOcupado) {
+			mensaje = "No se puede reducir el stock a " + copiasStr + ": hay más ejemplares prestados o apartados."
+		}
+		http.Redirect(w, r, "/libros?msg="+url.QueryEscape(mensaje)+"&msg_type=danger", http.StatusSeeOther)
 		return
 	}
 
+	log.Printf("✅ Libro actualizado exitosamente: %s (ID: %s)", nombre, bookID)
+	http.Redirect(w, r, "/libros?msg=Libro actualizado exitosamente&msg_type=success", http.StatusSeeOther)
+}
+
+// EliminarLibroHandler handles deleting a book.
+func EliminarLibroHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("DEBUG: Entrando a EliminarLibroHandler")
 	bookID := r.FormValue("id")
 	log.Printf("DEBUG: ID del libro a eliminar recibido: %s", bookID)
 	if bookID == "" {
@@ -334,11 +275,16 @@ func EliminarLibroHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ctx := context.Background()
-	_, err := FirestoreClient.Collection("libro").Doc(bookID).Delete(ctx) // Usar := para la primera declaración y asignación
-	if err != nil {
-		log.Printf("DEBUG: Error al eliminar libro %s de Firestore: %v", bookID, err)
-		http.Error(w, "Error al eliminar libro: "+err.Error(), http.StatusInternalServerError)
+	switch err := eliminarLibro(r.Context(), DB, bookID, time.Now()); {
+	case errors.Is(err, ErrConPrestamos):
+		http.Error(w, "No se puede eliminar el libro: tiene préstamos registrados y su historial se conserva.", http.StatusConflict)
+		return
+	case errors.Is(err, ErrNoEncontrado):
+		http.Error(w, "El libro no existe", http.StatusNotFound)
+		return
+	case err != nil:
+		log.Printf("DEBUG: Error al eliminar libro %s: %v", bookID, err)
+		http.Error(w, "Error al eliminar libro", http.StatusInternalServerError)
 		return
 	}
 
@@ -350,191 +296,205 @@ func EliminarLibroHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/libros", http.StatusSeeOther)
 }
 
+// DevolucionesHandler lista los préstamos activos de la persona logueada. A
+// bibliotecarios y administradores les muestra los de todas las personas.
 func DevolucionesHandler(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
-
-	// 1️⃣ Obtener usuario y rol desde cookies
-	usuarioNombre, rol := "", ""
-	if c, err := r.Cookie("usuario"); err == nil {
-		usuarioNombre = c.Value
+	ctx := r.Context()
+	persona := personaActual(r)
+	gestiona := persona.GestionaPrestamos()
+
+	// Traer préstamos activos de esta persona (o de todas)
+	var prestamos []Prestamo
+	var err error
+	if gestiona {
+		prestamos, err = DB.Prestamos().Activos(ctx)
+	} else {
+		prestamos, err = DB.Prestamos().ActivosPorPersona(ctx, persona.ID)
 	}
-	if c, err := r.Cookie("rol"); err == nil {
-		rol = c.Value
+	if err != nil {
+		http.Error(w, "Error al cargar préstamos", http.StatusInternalServerError)
+		return
 	}
 
-	// 2️⃣ Mensajes de la URL
-	mensaje := r.URL.Query().Get("msg")
-	tipoMensaje := r.URL.Query().Get("msg_type")
+	// Renderizar plantilla con datos y mensajes de la URL
+	renderTemplate(w, r, "devoluciones.html", DatosPagina{
+		DevolucionesData:  filasPrestamos(ctx, prestamos, gestiona),
+		GestionaPrestamos: gestiona,
+		MaxRenovaciones:   Configuracion.MaxRenovaciones,
+		Usuario:           persona.Nombre,
+		Rol:               persona.Rol,
+		Mensaje:           r.URL.Query().Get("msg"),
+		TipoMensaje:       r.URL.Query().Get("msg_type"),
+	})
+}
 
-	// 3️⃣ GET: Mostrar la lista de préstamos activos
-	if r.Method == http.MethodGet {
-		// Si no hay usuario logueado
-		if usuarioNombre == "" {
-			renderTemplate(w, r, "devoluciones.html", DatosPagina{
-				Usuario:     usuarioNombre,
-				Rol:         rol,
-				Mensaje:     "Debes iniciar sesión para ver tus préstamos.",
-				TipoMensaje: "info",
-			})
-			return
-		}
+// DevolverHandler procesa la devolución de un préstamo. El libro se toma del
+// préstamo guardado; cualquier libroID enviado por el cliente se ignora.
+func DevolverHandler(w http.ResponseWriter, r *http.Request) {
+	prestamoID := r.FormValue("prestamoID")
+	persona := personaActual(r)
 
-		// Buscar el documento de la persona
-		iterPersona := FirestoreClient.Collection("persona").
-			Where("nombre", "==", usuarioNombre).
-			Documents(ctx)
-		personaDoc, err := iterPersona.Next()
-		if err != nil {
-			renderTemplate(w, r, "devoluciones.html", DatosPagina{
-				Usuario:     usuarioNombre,
-				Rol:         rol,
-				Mensaje:     "No se encontró tu usuario.",
-				TipoMensaje: "danger",
-			})
+	// Validar que venga el ID del préstamo
+	if prestamoID == "" {
+		if esAJAX(r) {
+			http.Error(w, "ID faltante", http.StatusBadRequest)
 			return
 		}
-		// Extraer ID sin mapear toda la estructura Persona
-		personaID := personaDoc.Ref.ID
-
-		// Traer préstamos activos de esta persona
-		var devolucionesData []DevolucionDisplayData
-		iterPrest := FirestoreClient.Collection("prestamos").
-			Where("activo", "==", true).
-			Where("personaID", "==", personaID).
-			Documents(ctx)
-
-		for {
-			doc, err := iterPrest.Next()
-			if err == iterator.Done {
-				break
-			}
-			if err != nil {
-				http.Error(w, "Error al cargar préstamos", http.StatusInternalServerError)
-				return
-			}
-
-			// Mapear el préstamo
-			var p Prestamo
-			if err := doc.DataTo(&p); err != nil {
-				continue
-			}
-			p.ID = doc.Ref.ID
+		http.Redirect(w, r, "/devoluciones?msg=ID faltante&msg_type=danger", http.StatusSeeOther)
+		return
+	}
 
-			// Obtener los datos del libro asociado
-			libDoc, err := FirestoreClient.Collection("libro").Doc(p.LibroID).Get(ctx)
-			if err != nil {
-				continue
-			}
-			var libro Libro
-			if err := libDoc.DataTo(&libro); err != nil {
-				continue
-			}
-			libro.ID = libDoc.Ref.ID
-
-			// Añadir a la lista de datos para la vista
-			devolucionesData = append(devolucionesData, DevolucionDisplayData{
-				PrestamoID:    p.ID,
-				LibroID:       libro.ID,
-				LibroNombre:   libro.Nombre,
-				AutorNombre:   libro.Autor,
-				FechaPrestamo: p.FechaPrestamo,
-			})
+	// Transacción: verificar el dueño, cerrar el préstamo y aumentar copias del libro
+	if err := devolverLibro(r.Context(), DB, prestamoID, persona, time.Now()); err != nil {
+		estado, mensaje := http.StatusInternalServerError, "Error al procesar devolución"
+		switch {
+		case errors.Is(err, ErrNoAutorizado):
+			log.Printf("⚠️ %s intentó devolver el préstamo ajeno %s", persona.Nombre, prestamoID)
+			estado, mensaje = http.StatusForbidden, "No puedes devolver un préstamo de otra persona"
+		case errors.Is(err, ErrNoEncontrado):
+			estado, mensaje = http.StatusNotFound, "El préstamo no existe"
+		case errors.Is(err, ErrPrestamoCerrado):
+			estado, mensaje = http.StatusConflict, "El préstamo ya fue devuelto"
+		default:
+			log.Printf("Error al devolver préstamo %s: %v", prestamoID, err)
+		}
+		if esAJAX(r) {
+			http.Error(w, mensaje, estado)
+			return
 		}
+		http.Redirect(w, r, "/devoluciones?msg="+url.QueryEscape(mensaje)+"&msg_type=danger", http.StatusSeeOther)
+		return
+	}
 
-		// Renderizar plantilla con datos
-		renderTemplate(w, r, "devoluciones.html", DatosPagina{
-			DevolucionesData: devolucionesData,
-			Usuario:          usuarioNombre,
-			Rol:              rol,
-			Mensaje:          mensaje,
-			TipoMensaje:      tipoMensaje,
-		})
+	if esAJAX(r) {
+		w.WriteHeader(http.StatusOK)
 		return
 	}
+	http.Redirect(w, r, "/devoluciones?msg=Devolución exitosa&msg_type=success", http.StatusSeeOther)
+}
 
-	// 4️⃣ POST: Procesar la devolución
-	if r.Method == http.MethodPost {
-		prestamoID := r.FormValue("prestamoID")
-		libroID := r.FormValue("libroID")
+// RenovarHandler extiende el vencimiento de un préstamo. Responde igual que
+// DevolverHandler: texto para AJAX y redirección con mensaje para formularios.
+func RenovarHandler(w http.ResponseWriter, r *http.Request) {
+	prestamoID := r.FormValue("prestamoID")
+	persona := personaActual(r)
 
-		// Validar que vengan ambos IDs
-		if prestamoID == "" || libroID == "" {
-			if r.Header.Get("X-Requested-With") == "XMLHttpRequest" {
-				http.Error(w, "ID faltante", http.StatusBadRequest)
-				return
-			}
-			http.Redirect(w, r, "/devoluciones?msg=ID faltante&msg_type=danger", http.StatusSeeOther)
+	if prestamoID == "" {
+		if esAJAX(r) {
+			http.Error(w, "ID faltante", http.StatusBadRequest)
 			return
 		}
+		http.Redirect(w, r, "/devoluciones?msg=ID faltante&msg_type=danger", http.StatusSeeOther)
+		return
+	}
 
-		// Transacción: borrar préstamo y aumentar copias del libro
-		err := FirestoreClient.RunTransaction(ctx, func(ctxTx context.Context, tx *firestore.Transaction) error {
-			// Leer préstamo
-			prestRef := FirestoreClient.Collection("prestamos").Doc(prestamoID)
-			prestDoc, err := tx.Get(prestRef)
-			if err != nil {
-				return err
-			}
-			var prest Prestamo
-			if err := prestDoc.DataTo(&prest); err != nil {
-				return err
-			}
+	prestamo, err := renovarPrestamo(r.Context(), DB, prestamoID, persona, time.Now())
+	if err != nil {
+		estado, mensaje := http.StatusInternalServerError, "Error al renovar el préstamo"
+		switch {
+		case errors.Is(err, ErrNoAutorizado):
+			log.Printf("⚠️ %s intentó renovar el préstamo ajeno %s", persona.Nombre, prestamoID)
+			estado, mensaje = http.StatusForbidden, "No puedes renovar un préstamo de otra persona"
+		case errors.Is(err, ErrNoEncontrado):
+			estado, mensaje = http.StatusNotFound, "El préstamo no existe"
+		case errors.Is(err, ErrPrestamoCerrado):
+			estado, mensaje = http.StatusConflict, "El préstamo ya fue devuelto"
+		case errors.Is(err, ErrMaxRenovaciones):
+			estado, mensaje = http.StatusConflict, fmt.Sprintf("El préstamo ya se renovó el máximo de %d veces", Configuracion.MaxRenovaciones)
+		case errors.Is(err, ErrLibroReservado):
+			estado, mensaje = http.StatusConflict, "No se puede renovar: otra persona está esperando este libro"
+		default:
+			log.Printf("Error al renovar préstamo %s: %v", prestamoID, err)
+		}
+		if esAJAX(r) {
+			http.Error(w, mensaje, estado)
+			return
+		}
+		http.Redirect(w, r, "/devoluciones?msg="+url.QueryEscape(mensaje)+"&msg_type=danger", http.StatusSeeOther)
+		return
+	}
 
-			// Leer libro
-			libRef := FirestoreClient.Collection("libro").Doc(libroID)
-			libDoc, err := tx.Get(libRef)
-			if err != nil {
-				return err
-			}
-			var lib Libro
-			if err := libDoc.DataTo(&lib); err != nil {
-				return err
-			}
+	mensaje := "Préstamo renovado hasta el " + prestamo.FechaVencimiento.Format("02/01/2006")
+	if esAJAX(r) {
+		fmt.Fprint(w, mensaje)
+		return
+	}
+	http.Redirect(w, r, "/devoluciones?msg="+url.QueryEscape(mensaje)+"&msg_type=success", http.StatusSeeOther)
+}
 
-			// Eliminar documento de préstamo
-			tx.Delete(prestRef)
+// RegistrarFormHandler muestra el formulario de registro.
+func RegistrarFormHandler(w http.ResponseWriter, r *http.Request) {
+	renderTemplate(w, r, "registrar.html", nil)
+}
 
-			// Actualizar las copias del libro
-			nuevasCopias := lib.Copias + 1
-			updates := []firestore.Update{
-				{Path: "copias", Value: nuevasCopias},
-			}
-			// Si antes no estaba disponible y ahora sí, marcarlo
-			if !lib.Disponible && nuevasCopias > 0 {
-				updates = append(updates,
-					firestore.Update{Path: "disponible", Value: true},
-					firestore.Update{Path: "prestadoPorID", Value: ""},
-				)
-			}
-			tx.Update(libRef, updates)
+// Errores del registro de personas.
+var (
+	ErrCedulaRegistrada   = errors.New("ya existe una persona con esa cédula")
+	ErrContrasenaInvalida = errors.New("contraseña inválida (máximo 72 caracteres)")
+)
 
-			return nil
-		})
+// registrarPersona crea la persona con el hash de su contraseña; nunca se
+// guarda la contraseña en texto plano. La cédula no se puede repetir: se
+// busca y se crea en la misma transacción para que dos registros a la vez
+// no la dupliquen.
+func registrarPersona(ctx context.Context, store Store, persona *Persona, contrasena string) error {
+	hash, err := hashContrasena(contrasena)
+	if err != nil {
+		return ErrContrasenaInvalida
+	}
+	persona.Contrasena = hash
+	return store.RunTransaction(ctx, func(ctx context.Context, tx Store) error {
+		if _, err := tx.Personas().BuscarPorCedula(ctx, persona.Cedula); err == nil {
+			return ErrCedulaRegistrada
+		} else if !errors.Is(err, ErrNoEncontrado) {
+			return err
+		}
+		return tx.Personas().Crear(ctx, persona)
+	})
+}
 
-		// Manejo de respuesta
+// eliminarPersona borra a la persona si no tiene préstamos ni movimientos de
+// multas, que forman parte del historial (ErrConPrestamos). Igual que en
+// eliminarLibro, se comprueba dentro de la transacción. Sus reservas activas
+// se cancelan y las copias que tenía apartadas pasan al siguiente de la cola.
+func eliminarPersona(ctx context.Context, store Store, personaID string, fecha time.Time) error {
+	return store.RunTransaction(ctx, func(ctx context.Context, tx Store) error {
+		if _, err := tx.Personas().Obtener(ctx, personaID); err != nil {
+			return err
+		}
+		prestamos, err := tx.Prestamos().Historial(ctx, FiltroPrestamos{PersonaID: personaID})
+		if err != nil {
+			return err
+		}
+		multas, err := tx.Multas().PorPersona(ctx, personaID)
+		if err != nil {
+			return err
+		}
+		if len(prestamos) > 0 || len(multas) > 0 {
+			return ErrConPrestamos
+		}
+		reservas, err := tx.Reservas().ActivasPorPersona(ctx, personaID)
 		if err != nil {
-			if r.Header.Get("X-Requested-With") == "XMLHttpRequest" {
-				http.Error(w, "Error al procesar devolución", http.StatusInternalServerError)
-				return
+			return err
+		}
+		inventarios := make([]*inventario, len(reservas))
+		for i, r := range reservas {
+			if inventarios[i], err = inventarioDeReserva(ctx, tx, r); err != nil {
+				return err
 			}
-			http.Redirect(w, r, "/devoluciones?msg=Error&msg_type=danger", http.StatusSeeOther)
-			return
 		}
 
-		if r.Header.Get("X-Requested-With") == "XMLHttpRequest" {
-			w.WriteHeader(http.StatusOK)
-			return
+		for i := range reservas {
+			if err := cerrarConInventario(ctx, tx, &reservas[i], inventarios[i], ReservaCancelada, fecha); err != nil {
+				return err
+			}
 		}
-		http.Redirect(w, r, "/devoluciones?msg=Devolución exitosa&msg_type=success", http.StatusSeeOther)
-	}
+		return tx.Personas().Eliminar(ctx, personaID)
+	})
 }
 
+// RegistrarHandler crea una persona nueva con rol de usuario.
 func RegistrarHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method == http.MethodGet {
-		renderTemplate(w, r, "registrar.html", nil)
-		return
-	}
-
 	nombre := r.FormValue("nombre")
 	cedula := r.FormValue("cedula")
 	anoStr := r.FormValue("ano")
@@ -546,15 +506,6 @@ func RegistrarHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ctx := context.Background()
-	// Verificar si el usuario ya existe por cédula o nombre (opcional)
-	iter := FirestoreClient.Collection("persona").Where("cedula", "==", cedula).Documents(ctx)
-	doc, errInner := iter.Next()       // Renombrado 'err' a 'errInner'
-	if errInner == nil && doc != nil { // Usar errInner
-		http.Error(w, "Ya existe un usuario con esa cédula.", http.StatusConflict)
-		return
-	}
-
 	// Convertir anoStr a int antes de guardar
 	ano, errAno := strconv.Atoi(anoStr)
 	if errAno != nil {
@@ -564,17 +515,21 @@ func RegistrarHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Crear nuevo documento de persona
-	personaDoc := map[string]interface{}{
-		"nombre":     nombre,
-		"cedula":     cedula,
-		"ano":        ano,        // CAMBIADO: Guardar como int
-		"contrasena": contrasena, // En un entorno real, la contraseña debería ser hasheada
-		"rol":        rol,
-	}
-
-	_, _, errInner = FirestoreClient.Collection("persona").Add(ctx, personaDoc) // Renombrado 'err' a 'errInner'
-	if errInner != nil {                                                        // Usar errInner
-		log.Printf("Error al registrar persona en Firestore: %v", errInner)
+	persona := &Persona{
+		Nombre: nombre,
+		Cedula: cedula,
+		Ano:    ano,
+		Rol:    rol,
+	}
+	switch err := registrarPersona(r.Context(), DB, persona, contrasena); {
+	case errors.Is(err, ErrCedulaRegistrada):
+		http.Error(w, "Ya existe un usuario con esa cédula.", http.StatusConflict)
+		return
+	case errors.Is(err, ErrContrasenaInvalida):
+		http.Error(w, "Contraseña inválida (máximo 72 caracteres)", http.StatusBadRequest)
+		return
+	case err != nil:
+		log.Printf("Error al registrar persona: %v", err)
 		http.Error(w, "Error al registrar usuario", http.StatusInternalServerError)
 		return
 	}
@@ -583,355 +538,321 @@ func RegistrarHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/login", http.StatusSeeOther)
 }
 
+// LoginFormHandler muestra el formulario de inicio de sesión y, si viene de
+// una ruta protegida, el mensaje que explica por qué.
+func LoginFormHandler(w http.ResponseWriter, r *http.Request) {
+	renderTemplate(w, r, "login.html", DatosPagina{
+		Año:         time.Now().Year(),
+		Mensaje:     r.URL.Query().Get("msg"),
+		TipoMensaje: r.URL.Query().Get("msg_type"),
+	})
+}
+
+// LoginHandler verifica las credenciales y abre una sesión.
 func LoginHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method == http.MethodGet {
-		tmpl, err := template.ParseFiles("templates/base.html", "templates/login.html")
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		tmpl.ExecuteTemplate(w, "base", nil)
+	nombre := r.FormValue("nombre")
+	contrasena := r.FormValue("contrasena")
+
+	if nombre == "" || contrasena == "" {
+		http.Error(w, "Campos requeridos", http.StatusBadRequest)
 		return
 	}
 
-	if r.Method == http.MethodPost {
-		nombre := r.FormValue("nombre")
-		contrasena := r.FormValue("contrasena")
-
-		if nombre == "" || contrasena == "" {
-			http.Error(w, "Campos requeridos", http.StatusBadRequest)
-			return
-		}
-
-		// *** ESTA ES LA PARTE QUE CONSULTA FIRESTORE PARA EL LOGIN ***
-		iter := FirestoreClient.Collection("persona").
-			Where("nombre", "==", nombre).
-			Where("contrasena", "==", contrasena). // En un entorno real, comparar hash de contraseñas
-			Documents(r.Context())
-
-		doc, errInner := iter.Next() // Renombrado 'err' a 'errInner'
-		if errInner != nil {         // Usar errInner
-			// Si no se encuentra un documento o hay otro error, las credenciales son incorrectas
-			http.Error(w, "Credenciales incorrectas", http.StatusUnauthorized)
-			return
-		}
-
-		rol := "usuario" // Rol por defecto
-		if rdoc := doc.Data()["rol"]; rdoc != nil {
-			if val, ok := rdoc.(string); ok {
-				rol = val
-			}
-		}
-
-		http.SetCookie(w, &http.Cookie{Name: "usuario", Value: nombre, Path: "/"})
-		http.SetCookie(w, &http.Cookie{Name: "rol", Value: rol, Path: "/"})
+	persona, err := autenticar(r.Context(), DB, nombre, contrasena)
+	if errors.Is(err, ErrCredenciales) {
+		http.Error(w, "Credenciales incorrectas", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Printf("Error al buscar a %s: %v", nombre, err)
+		http.Error(w, "Error al iniciar sesión", http.StatusInternalServerError)
+		return
+	}
 
-		log.Println("✅ Sesión iniciada:", nombre, "| Rol:", rol)
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+	// El rol no se guarda en la cookie: se resuelve en cada petición a
+	// partir de la persona de la sesión.
+	if err := iniciarSesion(w, r, persona); err != nil {
+		log.Printf("Error al crear sesión para %s: %v", nombre, err)
+		http.Error(w, "Error al iniciar sesión", http.StatusInternalServerError)
+		return
 	}
+	borrarCookiesHeredadas(w)
+
+	log.Println("✅ Sesión iniciada:", nombre, "| Rol:", persona.Rol)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
 func LogoutHandler(w http.ResponseWriter, r *http.Request) {
-	http.SetCookie(w, &http.Cookie{Name: "usuario", Value: "", Path: "/", MaxAge: -1})
-	http.SetCookie(w, &http.Cookie{Name: "rol", Value: "", Path: "/", MaxAge: -1})
+	cerrarSesion(w, r)
+	borrarCookiesHeredadas(w)
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
-// PrestamoHandler maneja la visualización del formulario de préstamo y el procesamiento de envíos.
-func PrestamoHandler(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
-	usuario := ""
-	rol := ""
-	if c, errCookie := r.Cookie("usuario"); errCookie == nil { // Renombrado err a errCookie
-		usuario = c.Value
-	}
-	if c, errCookie := r.Cookie("rol"); errCookie == nil { // Renombrado err a errCookie
-		rol = c.Value
-	}
-
-	// Obtener mensajes de la URL (si existen)
-	mensaje := r.URL.Query().Get("msg")
-	tipoMensaje := r.URL.Query().Get("msg_type")
-
-	if r.Method == http.MethodGet {
-		// --- Lógica para el método GET: Cargar todos los libros y el usuario logueado ---
-		var allLibros []Libro
-		iterLibros := FirestoreClient.Collection("libro").Documents(ctx) // Obtener todos los libros
-		for {
-			doc, errLoop := iterLibros.Next() // Usar := para declarar una nueva 'errLoop' en el ámbito del bucle
-			if errLoop == iterator.Done {
-				break
-			}
-			if errLoop != nil {
-				log.Printf("Error al iterar libros: %v", errLoop)
-				http.Error(w, "Error al cargar libros", http.StatusInternalServerError)
-				return
-			}
-			var libro Libro
-			if errLoop := doc.DataTo(&libro); errLoop != nil { // Renombrado err a errLoop
-				log.Printf("Error al mapear datos de libro: %v", errLoop)
-				continue
-			}
-			libro.ID = doc.Ref.ID
-			allLibros = append(allLibros, libro)
-		}
+// PrestamoFormHandler muestra el formulario de préstamo con todos los libros.
+func PrestamoFormHandler(w http.ResponseWriter, r *http.Request) {
+	usuario, rol := usuarioYRol(r)
 
-		// No necesitamos cargar todas las personas para el GET, ya que el usuario es autodetectado.
-		// Sin embargo, mantenemos DatosPagina.Personas como slice vacío o nil si no se usa.
-		data := DatosPagina{
-			LibrosDisponibles: allLibros,   // Ahora pasamos todos los libros aquí para la selección
-			Personas:          []Persona{}, // Ya no necesitamos la lista completa de personas para el select
-			Año:               time.Now().Year(),
-			Usuario:           usuario, // Se pasa el nombre del usuario logueado
-			Rol:               rol,
-			Mensaje:           mensaje,
-			TipoMensaje:       tipoMensaje,
-		}
-		renderTemplate(w, r, "prestamos.html", data)
+	allLibros, err := DB.Libros().Listar(r.Context())
+	if err != nil {
+		log.Printf("Error al listar libros: %v", err)
+		http.Error(w, "Error al cargar libros", http.StatusInternalServerError)
 		return
 	}
+	reservas, err := misReservas(r.Context(), personaActual(r).ID)
+	if err != nil {
+		log.Printf("Error al cargar reservas: %v", err)
+	}
+	solicitudes, err := solicitudesPendientes(r.Context(), personaActual(r).ID)
+	if err != nil {
+		log.Printf("Error al cargar solicitudes: %v", err)
+	}
 
-	if r.Method == http.MethodPost {
-		// --- Lógica para el método POST: Registrar el préstamo ---
-		libroID := r.FormValue("libroID")
-		// La fecha y el usuario se obtienen internamente, no del formulario
-		fechaPrestamo := time.Now() // Obtener la fecha actual directamente en el backend
-
-		if libroID == "" { // Solo validar que el libroID no esté vacío
-			http.Redirect(w, r, "/prestamos?msg=Seleccione un libro para el préstamo&msg_type=danger", http.StatusBadRequest)
-			return
-		}
+	// No necesitamos cargar todas las personas para el GET, ya que el usuario es autodetectado.
+	// Sin embargo, mantenemos DatosPagina.Personas como slice vacío o nil si no se usa.
+	data := DatosPagina{
+		LibrosDisponibles: allLibros,   // Ahora pasamos todos los libros aquí para la selección
+		Personas:          []Persona{}, // Ya no necesitamos la lista completa de personas para el select
+		Reservas:          reservas,
+		Solicitudes:       solicitudes,
+		Año:               time.Now().Year(),
+		Usuario:           usuario, // Se pasa el nombre del usuario logueado
+		Rol:               rol,
+		Mensaje:           r.URL.Query().Get("msg"),
+		TipoMensaje:       r.URL.Query().Get("msg_type"),
+	}
+	renderTemplate(w, r, "prestamos.html", data)
+}
 
-		// Obtener el ID de la persona logueada
-		var personaID string
-		if usuario == "" {
-			http.Redirect(w, r, "/login?msg="+url.QueryEscape("Debes iniciar sesión para registrar un préstamo")+"&msg_type=danger", http.StatusSeeOther)
-			return
+// PrestamoHandler presta a la persona logueada los libros del carrito (uno o
+// más libroID) en una sola transacción. A las peticiones AJAX responde un
+// CarritoResponse con el resultado de cada libro; a los formularios, una
+// redirección con el resumen.
+func PrestamoHandler(w http.ResponseWriter, r *http.Request) {
+	// Uno o más libroID: el carrito completo o un solo libro
+	r.ParseForm()
+	var libroIDs []string
+	for _, id := range r.PostForm["libroID"] {
+		if id = strings.TrimSpace(id); id != "" {
+			libroIDs = append(libroIDs, id)
 		}
-
-		// Buscar el ID de la persona en Firestore basado en el nombre de usuario de la cookie
-		iterPersona := FirestoreClient.Collection("persona").Where("nombre", "==", usuario).Documents(ctx)
-		personaDoc, errInner := iterPersona.Next() // Renombrado err a errInner
-		if errInner != nil {                       // Usar errInner
-			log.Printf("DEBUG Prestamo POST: Error al buscar persona '%s': %v", usuario, errInner)
-			http.Redirect(w, r, "/prestamos?msg="+url.QueryEscape("No se encontró tu usuario en la base de datos.")+"&msg_type=danger", http.StatusSeeOther)
+	}
+	if len(libroIDs) == 0 {
+		if esAJAX(r) {
+			http.Error(w, "Seleccione un libro para el préstamo", http.StatusBadRequest)
 			return
 		}
-		personaID = personaDoc.Ref.ID
-		log.Printf("DEBUG Prestamo POST: Usuario logueado: %s (ID: %s)", usuario, personaID)
-
-		// Iniciar una transacción de Firestore
-		err := FirestoreClient.RunTransaction(ctx, func(ctx_tx context.Context, tx *firestore.Transaction) error {
-			// 1. Obtener el libro para verificar disponibilidad
-			libroRef := FirestoreClient.Collection("libro").Doc(libroID)
-			libroDoc, errTx := tx.Get(libroRef) // Renombrado err a errTx
-			if errTx != nil {
-				log.Printf("DEBUG Prestamo POST: Error al obtener libro %s: %v", libroID, errTx)
-				return errTx // Libro no encontrado o error de Firestore
-			}
-			var libro Libro
-			if errTx := libroDoc.DataTo(&libro); errTx != nil { // Renombrado err a errTx
-				log.Printf("DEBUG Prestamo POST: Error al mapear datos de libro %s: %v", libroID, errTx)
-				return errTx // Error al mapear datos del libro
-			}
-			// La lógica de disponibilidad se basa en `Copias`
-			if libro.Copias <= 0 { // Verificar si hay copias disponibles
-				log.Printf("DEBUG Prestamo POST: Libro %s no disponible (copias: %d).", libroID, libro.Copias)
-				return &http.ProtocolError{ErrorString: "El libro no está disponible para préstamo o no quedan copias."}
-			}
-			log.Printf("DEBUG Prestamo POST: Libro %s disponible (copias: %d).", libroID, libro.Copias)
-
-			// 2. Crear el nuevo documento de préstamo
-			nuevoPrestamoRef := FirestoreClient.Collection("prestamos").NewDoc()
-			nuevoPrestamo := Prestamo{
-				LibroID:       libroID,
-				PersonaID:     personaID, // ¡Este es el campo crucial que se guarda!
-				FechaPrestamo: fechaPrestamo,
-				Activo:        true,
-			}
-			errTx = tx.Create(nuevoPrestamoRef, nuevoPrestamo) // Renombrado err a errTx
-			if errTx != nil {
-				log.Printf("DEBUG Prestamo POST: Error al crear el préstamo en Firestore: %v", errTx)
-				return errTx // Error al crear el préstamo
-			}
-			log.Printf("DEBUG Prestamo POST: Préstamo creado en Firestore para libro %s y persona %s", libroID, personaID)
+		http.Redirect(w, r, "/prestamos?msg=Seleccione un libro para el préstamo&msg_type=danger", http.StatusBadRequest)
+		return
+	}
 
-			// 3. Actualizar el libro: reducir copias y marcar como no disponible (si las copias llegan a 0)
-			nuevasCopias := libro.Copias - 1
-			actualizacionesLibro := []firestore.Update{
-				{Path: "copias", Value: nuevasCopias},
-			}
-			if nuevasCopias <= 0 {
-				actualizacionesLibro = append(actualizacionesLibro, firestore.Update{Path: "disponible", Value: false})
-				actualizacionesLibro = append(actualizacionesLibro, firestore.Update{Path: "prestadoPorID", Value: personaID}) // Limpiar quien lo tiene prestado
-			}
-			tx.Update(libroRef, actualizacionesLibro)
-			log.Printf("DEBUG Prestamo POST: Libro %s copias actualizadas a %d.", libroID, nuevasCopias)
+	// La persona sale de la sesión, nunca del formulario
+	persona := personaActual(r)
+	log.Printf("DEBUG Prestamo POST: Usuario logueado: %s (ID: %s)", persona.Nombre, persona.ID)
 
-			return nil // Transacción exitosa
-		})
+	// Todos los libros en una sola transacción: se prestan todos o ninguno
+	resultados, err := prestarLibros(r.Context(), DB, libroIDs, persona.ID, time.Now())
+	respuesta, estado := respuestaCarrito(resultados, err, persona)
+	if err != nil && estado == http.StatusInternalServerError {
+		log.Printf("Error en transacción de préstamo: %v", err)
+	}
 
-		if err != nil {
-			log.Printf("Error en transacción de préstamo: %v", err)
-			if protoErr, ok := err.(*http.ProtocolError); ok {
-				http.Redirect(w, r, "/prestamos?msg="+url.QueryEscape(protoErr.Error())+"&msg_type=danger", http.StatusSeeOther)
-				return
-			}
-			http.Redirect(w, r, "/prestamos?msg=Error al registrar el préstamo&msg_type=danger", http.StatusSeeOther)
-			return
+	if esAJAX(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(estado)
+		if err := json.NewEncoder(w).Encode(respuesta); err != nil {
+			log.Printf("Error al codificar JSON del carrito: %v", err)
 		}
-
-		log.Printf("✅ Préstamo registrado exitosamente: LibroID '%s', PersonaID '%s'", libroID, personaID)
-		http.Redirect(w, r, "/prestamos?msg=Préstamo registrado exitosamente&msg_type=success", http.StatusSeeOther)
 		return
 	}
-}
 
-func RegistrarLibroHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method == http.MethodGet {
-		usuario := ""
-		rol := ""
-		if c, errCookie := r.Cookie("usuario"); errCookie == nil { // Renombrado err a errCookie
-			usuario = c.Value
+	mensaje := respuesta.Mensaje
+	if !respuesta.OK {
+		if len(respuesta.Items) == 1 && respuesta.Items[0].Error != "" {
+			mensaje = respuesta.Items[0].Error
+		} else if rechazados := itemsRechazados(respuesta.Items); rechazados != "" {
+			mensaje += " " + rechazados
 		}
-		if c, errCookie := r.Cookie("rol"); errCookie == nil { // Renombrado err a errCookie
-			rol = c.Value
-		}
-
-		data := DatosPagina{
-			Año:     time.Now().Year(),
-			Usuario: usuario,
-			Rol:     rol,
-		}
-		renderTemplate(w, r, "registrar_libro.html", data)
+		http.Redirect(w, r, "/prestamos?msg="+url.QueryEscape(mensaje)+"&msg_type=danger", http.StatusSeeOther)
 		return
 	}
+	log.Printf("✅ Préstamo registrado exitosamente: Libros %v, PersonaID '%s'", libroIDs, persona.ID)
+	http.Redirect(w, r, "/prestamos?msg="+url.QueryEscape(mensaje)+"&msg_type=success", http.StatusSeeOther)
+}
 
-	if r.Method == http.MethodPost {
-		nombre := r.FormValue("nombre")
-		autor := r.FormValue("autor")
-		descripcion := r.FormValue("descripcion")
-		imagen := r.FormValue("imagen")
-		anoStr := r.FormValue("ano")
-		copiasStr := r.FormValue("copias")
+// respuestaCarrito arma la respuesta de un préstamo del carrito con el
+// resultado de cada libro, y el estado HTTP que le corresponde.
+func respuestaCarrito(resultados []ResultadoCarrito, err error, persona *Persona) (CarritoResponse, int) {
+	respuesta := CarritoResponse{OK: err == nil}
+	solicitudes := 0
+	for _, res := range resultados {
+		item := ItemCarrito{LibroID: res.LibroID, Nombre: res.LibroNombre}
+		if res.Prestamo != nil {
+			item.Prestado = res.Prestamo.Estado == EstadoActivo
+			item.Estado = res.Prestamo.Estado
+			item.PrestamoID = res.Prestamo.ID
+			if item.Prestado {
+				item.Vence = res.Prestamo.FechaVencimiento.Format("02/01/2006")
+			} else {
+				solicitudes++
+			}
+		}
+		if res.Err != nil {
+			item.Error = motivoRechazoPrestamo(res.Err, persona)
+		}
+		respuesta.Items = append(respuesta.Items, item)
+	}
+
+	switch {
+	case err == nil && len(resultados) == 1 && solicitudes == 1:
+		respuesta.Mensaje = "Solicitud registrada: el libro es de colección restringida y un administrador debe aprobar el préstamo"
+	case err == nil && len(resultados) == 1:
+		respuesta.Mensaje = "Préstamo registrado exitosamente"
+	case err == nil && solicitudes > 0:
+		respuesta.Mensaje = fmt.Sprintf("Se registraron los %d libros del carrito; %d son de colección restringida y quedan pendientes de aprobación",
+			len(resultados), solicitudes)
+	case err == nil:
+		respuesta.Mensaje = fmt.Sprintf("Se prestaron los %d libros del carrito", len(resultados))
+	case errors.Is(err, ErrCarritoRechazado):
+		respuesta.Mensaje = "No se prestó ningún libro del carrito: quita los libros rechazados e inténtalo de nuevo."
+		return respuesta, http.StatusConflict
+	case errors.Is(err, ErrTieneVencidos), errors.Is(err, ErrMultasPendientes), errors.Is(err, ErrLimitePrestamos):
+		respuesta.Mensaje = motivoRechazoPrestamo(err, persona)
+		return respuesta, http.StatusConflict
+	case errors.Is(err, ErrNoEncontrado):
+		respuesta.Mensaje = motivoRechazoPrestamo(err, persona)
+		return respuesta, http.StatusNotFound
+	default:
+		respuesta.Mensaje = motivoRechazoPrestamo(err, persona)
+		return respuesta, http.StatusInternalServerError
+	}
+	return respuesta, http.StatusOK
+}
 
-		ano, errInner := strconv.Atoi(anoStr) // Renombrado err a errInner
-		if errInner != nil {                  // Usar errInner
-			http.Error(w, "Año inválido", http.StatusBadRequest)
-			return
+// itemsRechazados resume los libros rechazados del carrito y sus motivos,
+// para el mensaje de la página.
+func itemsRechazados(items []ItemCarrito) string {
+	var partes []string
+	for _, item := range items {
+		if item.Error == "" {
+			continue
 		}
-		copias, errInner := strconv.Atoi(copiasStr) // Renombrado err a errInner
-		if errInner != nil {                        // Usar errInner
-			http.Error(w, "Número de copias inválido", http.StatusBadRequest)
-			return
+		nombre := item.Nombre
+		if nombre == "" {
+			nombre = item.LibroID
 		}
+		partes = append(partes, fmt.Sprintf("«%s»: %s", nombre, item.Error))
+	}
+	return strings.Join(partes, " ")
+}
 
-		// Al registrar un libro, inicialmente está disponible
-		doc := Libro{
-			Nombre:      nombre,
-			Autor:       autor,
-			Descripcion: descripcion,
-			Ano:         ano,
-			ImagenURL:   imagen,
-			Copias:      copias,
-			Disponible:  true, // Nuevo libro, por defecto disponible
-		}
+// motivoRechazoPrestamo explica a la persona por qué no se registró su
+// préstamo.
+func motivoRechazoPrestamo(err error, persona *Persona) string {
+	switch {
+	case errors.Is(err, ErrSinCopias):
+		return "El libro no está disponible: no quedan copias. Puedes reservarlo para recibir la próxima copia que se devuelva."
+	case errors.Is(err, ErrTieneVencidos):
+		return "Tienes préstamos vencidos. Devuélvelos para poder pedir otro libro."
+	case errors.Is(err, ErrMultasPendientes):
+		return "Tienes multas sin pagar. Revisa tu saldo en Mi cuenta y págalas en la biblioteca para poder pedir otro libro."
+	case errors.Is(err, ErrLimitePrestamos):
+		return fmt.Sprintf("Alcanzaste el límite de %d préstamos simultáneos para tu rol (%s). Devuelve un libro para pedir otro.",
+			Configuracion.LimitePrestamos(persona.Rol), persona.Rol)
+	case errors.Is(err, ErrSolicitudPendiente):
+		return "Ya pediste este libro y tu solicitud espera la aprobación de un administrador."
+	case errors.Is(err, ErrLibroRepetido):
+		return "El libro está más de una vez en el carrito."
+	case errors.Is(err, ErrNoEncontrado):
+		return "El libro no existe."
+	}
+	return "Error al registrar el préstamo"
+}
 
-		_, _, errInner = FirestoreClient.Collection("libro").Add(r.Context(), doc) // Renombrado 'err' a 'errInner'
-		if errInner != nil {                                                       // Usar errInner
-			http.Error(w, "Error al registrar libro", http.StatusInternalServerError)
-			log.Println("Error Firestore libro:", errInner)
-			return
-		}
+// RegistrarLibroFormHandler muestra el formulario de alta de libros.
+func RegistrarLibroFormHandler(w http.ResponseWriter, r *http.Request) {
+	usuario, rol := usuarioYRol(r)
 
-		log.Println("✅ Libro registrado:", nombre)
-		// Redirige a la página de libros con un parámetro de éxito
-		http.Redirect(w, r, "/libros?msg=Libro registrado exitosamente&msg_type=success", http.StatusSeeOther)
-		return // Asegúrate de retornar después de la redirección
+	data := DatosPagina{
+		Año:     time.Now().Year(),
+		Usuario: usuario,
+		Rol:     rol,
 	}
+	renderTemplate(w, r, "registrar_libro.html", data)
 }
 
-func PersonasHandler(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
-	usuario := ""
-	rol := ""
-	if c, errCookie := r.Cookie("usuario"); errCookie == nil { // Renombrado err a errCookie
-		usuario = c.Value
-	}
-	if c, errCookie := r.Cookie("rol"); errCookie == nil { // Renombrado err a errCookie
-		rol = c.Value
-	}
-
-	var personas []Persona
-	iter := FirestoreClient.Collection("persona").Documents(ctx)
-	for {
-		doc, errLoop := iter.Next() // Renombrado err a errLoop
-		if errLoop == iterator.Done {
-			break
-		}
-		if errLoop != nil {
-			log.Printf("Error al iterar personas: %v", errLoop)
-			http.Error(w, "Error al cargar personas", http.StatusInternalServerError)
-			return
-		}
+// RegistrarLibroHandler crea un libro nuevo.
+func RegistrarLibroHandler(w http.ResponseWriter, r *http.Request) {
+	nombre := r.FormValue("nombre")
+	autor := r.FormValue("autor")
+	descripcion := r.FormValue("descripcion")
+	imagen := r.FormValue("imagen")
+	anoStr := r.FormValue("ano")
+	copiasStr := r.FormValue("copias")
 
-		// Mapeo manual para manejar inconsistencias de tipo
-		var persona Persona
-		persona.ID = doc.Ref.ID
-		data := doc.Data()
+	ano, errInner := strconv.Atoi(anoStr) // Renombrado err a errInner
+	if errInner != nil {                  // Usar errInner
+		http.Error(w, "Año inválido", http.StatusBadRequest)
+		return
+	}
+	copias, errInner := strconv.Atoi(copiasStr) // Renombrado err a errInner
+	if errInner != nil {                        // Usar errInner
+		http.Error(w, "Número de copias inválido", http.StatusBadRequest)
+		return
+	}
 
-		if nombre, ok := data["nombre"].(string); ok {
-			persona.Nombre = nombre
-		} else {
-			log.Printf("Advertencia: Tipo inesperado para 'nombre' en persona %s: %T", persona.ID, data["nombre"])
-		}
+	// Al registrar un libro se crea un ejemplar por copia; está disponible si tiene alguna
+	doc := Libro{
+		Nombre:      nombre,
+		Autor:       autor,
+		Descripcion: descripcion,
+		Ano:         ano,
+		ImagenURL:   imagen,
+		Restringido: r.FormValue("restringido") != "",
+	}
 
-		if cedula, ok := data["cedula"].(string); ok {
-			persona.Cedula = cedula
-		} else if cedulaFloat, ok := data["cedula"].(float64); ok {
-			// Si es un número, convertir a string (ej. 1234567890.0 -> "1234567890")
-			persona.Cedula = strconv.FormatFloat(cedulaFloat, 'f', 0, 64)
-			log.Printf("DEBUG: Convertida cédula float a string para persona %s: %s", persona.ID, persona.Cedula)
-		} else {
-			log.Printf("Advertencia: Tipo inesperado para 'cedula' en persona %s: %T", persona.ID, data["cedula"])
-			persona.Cedula = "" // Valor por defecto
-		}
+	errInner = registrarLibro(r.Context(), DB, &doc, copias)
+	if errInner != nil {
+		http.Error(w, "Error al registrar libro", http.StatusInternalServerError)
+		log.Println("Error al registrar libro:", errInner)
+		return
+	}
 
-		if anoFloat, ok := data["ano"].(float64); ok { // Firestore devuelve números como float64
-			persona.Ano = int(anoFloat)
-		} else if anoStr, ok := data["ano"].(string); ok { // Si es un string, intentar convertir a int
-			parsedAno, errParseAno := strconv.Atoi(anoStr)
-			if errParseAno == nil {
-				persona.Ano = parsedAno
-			} else {
-				log.Printf("Advertencia: No se pudo convertir 'ano' '%s' a int para persona %s: %v", anoStr, persona.ID, errParseAno)
-				persona.Ano = 0 // Valor por defecto o manejar como error
-			}
-		} else {
-			log.Printf("Advertencia: Tipo inesperado para 'ano' en persona %s: %T", persona.ID, data["ano"])
-			persona.Ano = 0 // Valor por defecto
-		}
+	log.Println("✅ Libro registrado:", nombre)
+	// Redirige a la página de libros con un parámetro de éxito
+	http.Redirect(w, r, "/libros?msg=Libro registrado exitosamente&msg_type=success", http.StatusSeeOther)
+}
 
-		if contrasena, ok := data["contrasena"].(string); ok {
-			persona.Contrasena = contrasena
-		} else {
-			log.Printf("Advertencia: Tipo inesperado para 'contrasena' en persona %s: %T", persona.ID, data["contrasena"])
-		}
+// PersonasHandler lista las personas de a una página, igual que LibrosHandler.
+func PersonasHandler(w http.ResponseWriter, r *http.Request) {
+	usuario, rol := usuarioYRol(r)
 
-		if rolData, ok := data["rol"].(string); ok {
-			persona.Rol = rolData
-		} else {
-			log.Printf("Advertencia: Tipo inesperado para 'rol' en persona %s: %T", persona.ID, data["rol"])
-		}
+	personas, consulta, siguiente, err := paginaPersonas(r)
+	if esErrorPagina(err) {
+		http.Error(w, "Paginación inválida: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error al listar personas: %v", err)
+		http.Error(w, "Error al cargar personas", http.StatusInternalServerError)
+		return
+	}
 
-		personas = append(personas, persona)
+	if r.Header.Get("X-Requested-With") == "XMLHttpRequest" {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(PersonasResponse{Personas: personas, Siguiente: siguiente}); err != nil {
+			log.Printf("Error al codificar JSON para AJAX: %v", err)
+		}
+		return
 	}
 
 	data := DatosPagina{
-		Personas: personas,
-		Año:      time.Now().Year(),
-		Usuario:  usuario,
-		Rol:      rol,
+		Personas:  personas,
+		Orden:     paramOrden(consulta),
+		Cursor:    r.URL.Query().Get("cursor"),
+		Siguiente: siguiente,
+		Año:       time.Now().Year(),
+		Usuario:   usuario,
+		Rol:       rol,
 	}
 	renderTemplate(w, r, "personas.html", data)
 }
@@ -939,22 +860,6 @@ func PersonasHandler(w http.ResponseWriter, r *http.Request) {
 func EliminarPersonaHandler(w http.ResponseWriter, r *http.Request) {
 	log.Println("DEBUG: Entrando a EliminarPersonaHandler")
 
-	// Verificar rol
-	rol := ""
-	if c, errCookie := r.Cookie("rol"); errCookie == nil {
-		rol = c.Value
-	}
-	log.Printf("Rol detectado: %s", rol)
-	if rol != "admin" {
-		http.Error(w, "Acceso denegado", http.StatusForbidden)
-		return
-	}
-
-	if r.Method != http.MethodPost {
-		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
-		return
-	}
-
 	personID := r.FormValue("id")
 	log.Printf("ID recibido para eliminar: %s", personID)
 	if personID == "" {
@@ -962,11 +867,16 @@ func EliminarPersonaHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ctx := context.Background()
-	_, err := FirestoreClient.Collection("persona").Doc(personID).Delete(ctx)
-	if err != nil {
+	switch err := eliminarPersona(r.Context(), DB, personID, time.Now()); {
+	case errors.Is(err, ErrConPrestamos):
+		http.Error(w, "No se puede eliminar: tiene préstamos o multas registrados y su historial se conserva.", http.StatusConflict)
+		return
+	case errors.Is(err, ErrNoEncontrado):
+		http.Error(w, "La persona no existe", http.StatusNotFound)
+		return
+	case err != nil:
 		log.Printf("🔥 Error al eliminar persona con ID %s: %v", personID, err)
-		http.Error(w, "Error al eliminar persona: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Error al eliminar persona", http.StatusInternalServerError)
 		return
 	}
 
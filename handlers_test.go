@@ -0,0 +1,467 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// backendsDePrueba devuelve los stores contra los que corre la suite. El
+// emulador de Firestore sólo se usa si FIRESTORE_EMULATOR_HOST está definido.
+func backendsDePrueba() map[string]func(t *testing.T) Store {
+	backends := map[string]func(t *testing.T) Store{
+		"memoria": func(t *testing.T) Store { return NuevoMemoriaStore() },
+		"sqlite": func(t *testing.T) Store {
+			store, err := NuevoSQLStore(context.Background(), dialectoSQLite, ":memory:")
+			if err != nil {
+				t.Fatalf("abriendo sqlite: %v", err)
+			}
+			return store
+		},
+	}
+	if host := os.Getenv("FIRESTORE_EMULATOR_HOST"); host != "" {
+		backends["firestore"] = func(t *testing.T) Store {
+			cfg := ConfigPorDefecto()
+			cfg.EmuladorFirestore = host
+			// Un proyecto distinto por prueba aísla los datos en el emulador
+			cfg.ProyectoID = "demo-" + strings.ToLower(nuevoID())
+			if err := InitFirebase(context.Background(), cfg); err != nil {
+				t.Fatalf("conectando al emulador: %v", err)
+			}
+			return NuevoFirestoreStore(FirestoreClient)
+		}
+	}
+	return backends
+}
+
+// paraCadaBackend ejecuta prueba una vez por backend, con un store vacío y
+// un servidor httptest nuevo en cada caso.
+func paraCadaBackend(t *testing.T, prueba func(t *testing.T, c *clientePrueba)) {
+	for nombre, nuevo := range backendsDePrueba() {
+		t.Run(nombre, func(t *testing.T) {
+			store := nuevo(t)
+			catalogo, err := indexarCatalogo(context.Background(), store)
+			if err != nil {
+				t.Fatalf("creando el índice: %v", err)
+			}
+			anterior, catalogoAnterior := DB, Catalogo
+			DB, Catalogo = store, catalogo
+			srv := httptest.NewServer(NuevoServidor(ConfigPorDefecto()))
+			t.Cleanup(func() {
+				srv.Close()
+				store.Close()
+				catalogo.Close()
+				DB, Catalogo = anterior, catalogoAnterior
+			})
+			prueba(t, nuevoClientePrueba(t, srv))
+		})
+	}
+}
+
+// clientePrueba es un navegador mínimo: guarda cookies, no sigue
+// redirecciones y reenvía el token CSRF que leyó de la última página.
+type clientePrueba struct {
+	t    *testing.T
+	srv  *httptest.Server
+	hc   *http.Client
+	csrf string
+}
+
+func nuevoClientePrueba(t *testing.T, srv *httptest.Server) *clientePrueba {
+	jar, _ := cookiejar.New(nil)
+	return &clientePrueba{t: t, srv: srv, hc: &http.Client{
+		Jar: jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}}
+}
+
+// respuestaPrueba es una respuesta con el cuerpo ya leído.
+type respuestaPrueba struct {
+	*http.Response
+	Cuerpo string
+}
+
+func (c *clientePrueba) hacer(req *http.Request) respuestaPrueba {
+	c.t.Helper()
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		c.t.Fatalf("%s %s: %v", req.Method, req.URL.Path, err)
+	}
+	defer resp.Body.Close()
+	cuerpo, _ := io.ReadAll(resp.Body)
+	return respuestaPrueba{Response: resp, Cuerpo: string(cuerpo)}
+}
+
+func (c *clientePrueba) get(ruta string) respuestaPrueba {
+	c.t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, c.srv.URL+ruta, nil)
+	return c.hacer(req)
+}
+
+// post envía el formulario con el campo csrf_token, como los formularios
+// de las plantillas.
+func (c *clientePrueba) post(ruta string, form url.Values) respuestaPrueba {
+	c.t.Helper()
+	datos := url.Values{}
+	for k, v := range form {
+		datos[k] = v
+	}
+	if c.csrf != "" && datos.Get(campoCSRF) == "" {
+		datos.Set(campoCSRF, c.csrf)
+	}
+	req, _ := http.NewRequest(http.MethodPost, c.srv.URL+ruta, strings.NewReader(datos.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return c.hacer(req)
+}
+
+// ajax envía el formulario como lo hacen los fetch de las plantillas, con
+// el token CSRF en la cabecera.
+func (c *clientePrueba) ajax(ruta string, form url.Values) respuestaPrueba {
+	c.t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, c.srv.URL+ruta, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Requested-With", "XMLHttpRequest")
+	if c.csrf != "" {
+		req.Header.Set(cabeceraCSRF, c.csrf)
+	}
+	return c.hacer(req)
+}
+
+var reTokenCSRF = regexp.MustCompile(`<meta name="csrf-token" content="([^"]*)">`)
+
+// leerCSRF carga la página de inicio y guarda el token CSRF de la sesión.
+func (c *clientePrueba) leerCSRF() {
+	c.t.Helper()
+	m := reTokenCSRF.FindStringSubmatch(c.get("/").Cuerpo)
+	if m == nil {
+		c.t.Fatalf("la página de inicio no tiene el meta csrf-token")
+	}
+	c.csrf = m[1]
+}
+
+func (c *clientePrueba) login(nombre, contrasena string) {
+	c.t.Helper()
+	resp := c.post("/login", url.Values{"nombre": {nombre}, "contrasena": {contrasena}})
+	if resp.StatusCode != http.StatusSeeOther {
+		c.t.Fatalf("login de %s: estado %d: %s", nombre, resp.StatusCode, resp.Cuerpo)
+	}
+	c.leerCSRF()
+}
+
+func esperarEstado(t *testing.T, resp respuestaPrueba, estado int) {
+	t.Helper()
+	if resp.StatusCode != estado {
+		t.Fatalf("%s %s: estado %d, se esperaba %d: %s", resp.Request.Method, resp.Request.URL.Path, resp.StatusCode, estado, resp.Cuerpo)
+	}
+}
+
+func esperarRedireccion(t *testing.T, resp respuestaPrueba, contiene string) {
+	t.Helper()
+	esperarEstado(t, resp, http.StatusSeeOther)
+	destino, _ := url.QueryUnescape(resp.Header.Get("Location"))
+	if !strings.Contains(destino, contiene) {
+		t.Fatalf("redirección a %q, se esperaba que contenga %q", destino, contiene)
+	}
+}
+
+// crearPersona inserta una persona directamente en el store, con la
+// contraseña en texto plano como las personas anteriores al hashing.
+func crearPersona(t *testing.T, nombre, contrasena, rol string) *Persona {
+	t.Helper()
+	p := &Persona{Nombre: nombre, Cedula: "ced-" + nombre, Ano: 2000, Contrasena: contrasena, Rol: rol}
+	if err := DB.Personas().Crear(context.Background(), p); err != nil {
+		t.Fatalf("creando persona: %v", err)
+	}
+	return p
+}
+
+// crearLibro registra un libro con sus ejemplares directamente en el store.
+func crearLibro(t *testing.T, nombre string, copias int) *Libro {
+	t.Helper()
+	l := &Libro{Nombre: nombre, Autor: "Autor de " + nombre, Ano: 2001}
+	if err := registrarLibro(context.Background(), DB, l, copias); err != nil {
+		t.Fatalf("creando libro: %v", err)
+	}
+	return l
+}
+
+func obtenerLibro(t *testing.T, id string) *Libro {
+	t.Helper()
+	l, err := DB.Libros().Obtener(context.Background(), id)
+	if err != nil {
+		t.Fatalf("obteniendo libro %s: %v", id, err)
+	}
+	return l
+}
+
+func TestRegistroYLogin(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		esperarEstado(t, c.get("/registrar"), http.StatusOK)
+
+		form := url.Values{"nombre": {"ana"}, "cedula": {"1712345678"}, "ano": {"2001"}, "contrasena": {"secreta"}}
+		esperarRedireccion(t, c.post("/registrar", form), "/login")
+		esperarEstado(t, c.post("/registrar", form), http.StatusConflict)
+
+		p, err := DB.Personas().BuscarPorCedula(context.Background(), "1712345678")
+		if err != nil {
+			t.Fatalf("la persona no quedó registrada: %v", err)
+		}
+		if p.Rol != "usuario" {
+			t.Errorf("rol = %q, se esperaba usuario", p.Rol)
+		}
+		if !esHashBcrypt(p.Contrasena) {
+			t.Errorf("la contraseña se guardó sin hashear: %q", p.Contrasena)
+		}
+
+		esperarEstado(t, c.post("/login", url.Values{"nombre": {"ana"}, "contrasena": {"otra"}}), http.StatusUnauthorized)
+		c.login("ana", "secreta")
+		if resp := c.get("/"); !strings.Contains(resp.Cuerpo, "ana") {
+			t.Errorf("la página de inicio no muestra al usuario logueado")
+		}
+	})
+}
+
+func TestMigracionContrasenaTextoPlano(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		ctx := context.Background()
+		// Persona registrada antes del hashing, con la contraseña en claro
+		persona := crearPersona(t, "rosa", "antigua", "usuario")
+
+		esperarEstado(t, c.post("/login", url.Values{"nombre": {"rosa"}, "contrasena": {"otra"}}), http.StatusUnauthorized)
+		if p, _ := DB.Personas().Obtener(ctx, persona.ID); p.Contrasena != "antigua" {
+			t.Fatalf("un login fallido no debe migrar la contraseña")
+		}
+
+		c.login("rosa", "antigua")
+		p, err := DB.Personas().Obtener(ctx, persona.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !esHashBcrypt(p.Contrasena) {
+			t.Fatalf("la contraseña no se migró: %q", p.Contrasena)
+		}
+
+		// Después de migrar, el login sigue funcionando con la misma contraseña
+		nuevoClientePrueba(t, c.srv).login("rosa", "antigua")
+	})
+}
+
+func TestLoginConNombreRepetido(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		// El nombre no es único: cada "ana" entra con su propia contraseña
+		primera := &Persona{Nombre: "ana", Cedula: "0101", Contrasena: "clave-1", Rol: RolUsuario}
+		segunda := &Persona{Nombre: "ana", Cedula: "0202", Contrasena: "clave-2", Rol: RolUsuario}
+		for _, p := range []*Persona{primera, segunda} {
+			if err := DB.Personas().Crear(context.Background(), p); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, p := range []*Persona{primera, segunda} {
+			encontrada, err := autenticar(context.Background(), DB, "ana", p.Contrasena)
+			if err != nil || encontrada.ID != p.ID {
+				t.Errorf("autenticar con %s = %+v, %v; se esperaba %s", p.Contrasena, encontrada, err, p.ID)
+			}
+			nuevoClientePrueba(t, c.srv).login("ana", p.Contrasena)
+		}
+		esperarEstado(t, c.post("/login", url.Values{"nombre": {"ana"}, "contrasena": {"clave-3"}}), http.StatusUnauthorized)
+	})
+}
+
+func TestCedulaRepetidaEnRegistrosSimultaneos(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		errores := make(chan error, 4)
+		for i := range cap(errores) {
+			go func() {
+				persona := &Persona{Nombre: fmt.Sprintf("ana %d", i), Cedula: "0101", Rol: RolUsuario}
+				errores <- registrarPersona(context.Background(), DB, persona, "clave")
+			}()
+		}
+		creadas := 0
+		for range cap(errores) {
+			switch err := <-errores; {
+			case err == nil:
+				creadas++
+			case !errors.Is(err, ErrCedulaRegistrada):
+				t.Errorf("err = %v, se esperaba ErrCedulaRegistrada", err)
+			}
+		}
+		if creadas != 1 {
+			t.Errorf("se registraron %d personas con la misma cédula", creadas)
+		}
+	})
+}
+
+func TestPrestamoYDevolucion(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		ctx := context.Background()
+		persona := crearPersona(t, "luis", "clave", "usuario")
+		libro := crearLibro(t, "Rayuela", 1)
+
+		// Sin sesión no se puede prestar
+		esperarRedireccion(t, c.post("/prestamos", url.Values{"libroID": {libro.ID}}), "/login")
+
+		c.login("luis", "clave")
+		esperarEstado(t, c.get("/prestamos"), http.StatusOK)
+		esperarRedireccion(t, c.post("/prestamos", url.Values{"libroID": {libro.ID}}), "Préstamo registrado")
+		if l := obtenerLibro(t, libro.ID); l.Copias != 0 || l.Disponible {
+			t.Errorf("después del préstamo: copias=%d disponible=%v", l.Copias, l.Disponible)
+		}
+
+		// No quedan copias
+		esperarRedireccion(t, c.post("/prestamos", url.Values{"libroID": {libro.ID}}), "no quedan copias")
+
+		activos, err := DB.Prestamos().ActivosPorPersona(ctx, persona.ID)
+		if err != nil || len(activos) != 1 {
+			t.Fatalf("préstamos activos = %v, %v; se esperaba 1", activos, err)
+		}
+		prestamo := activos[0]
+		if resp := c.get("/devoluciones"); !strings.Contains(resp.Cuerpo, prestamo.ID) {
+			t.Errorf("la página de devoluciones no lista el préstamo %s", prestamo.ID)
+		}
+
+		resp := c.ajax("/devoluciones", url.Values{"prestamoID": {prestamo.ID}, "libroID": {libro.ID}})
+		esperarEstado(t, resp, http.StatusOK)
+		if l := obtenerLibro(t, libro.ID); l.Copias != 1 || !l.Disponible {
+			t.Errorf("después de la devolución: copias=%d disponible=%v", l.Copias, l.Disponible)
+		}
+		if activos, _ := DB.Prestamos().ActivosPorPersona(ctx, persona.ID); len(activos) != 0 {
+			t.Errorf("quedan %d préstamos activos después de devolver", len(activos))
+		}
+	})
+}
+
+// prestar registra un préstamo directamente en el store.
+func prestar(t *testing.T, libro *Libro, persona *Persona) *Prestamo {
+	t.Helper()
+	p, err := prestarLibro(context.Background(), DB, libro.ID, persona.ID, time.Now())
+	if err != nil {
+		t.Fatalf("prestando %s a %s: %v", libro.Nombre, persona.Nombre, err)
+	}
+	return p
+}
+
+func TestDevolucionDePrestamoAjeno(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		ana := crearPersona(t, "ana", "clave", "usuario")
+		crearPersona(t, "luis", "clave", "usuario")
+		libro := crearLibro(t, "Rayuela", 1)
+		prestamo := prestar(t, libro, ana)
+
+		c.login("luis", "clave")
+		if resp := c.get("/devoluciones"); strings.Contains(resp.Cuerpo, prestamo.ID) {
+			t.Errorf("luis ve el préstamo de ana en devoluciones")
+		}
+		esperarEstado(t, c.ajax("/devoluciones", url.Values{"prestamoID": {prestamo.ID}}), http.StatusForbidden)
+		esperarRedireccion(t, c.post("/devoluciones", url.Values{"prestamoID": {prestamo.ID}}), "otra persona")
+		esperarEstado(t, c.ajax("/devoluciones", url.Values{"prestamoID": {"no-existe"}}), http.StatusNotFound)
+
+		if _, err := DB.Prestamos().Obtener(context.Background(), prestamo.ID); err != nil {
+			t.Errorf("el préstamo de ana desapareció: %v", err)
+		}
+		if l := obtenerLibro(t, libro.ID); l.Copias != 0 {
+			t.Errorf("copias = %d después de una devolución rechazada", l.Copias)
+		}
+	})
+}
+
+func TestDevolucionTomaElLibroDelPrestamo(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		ana := crearPersona(t, "ana", "clave", "usuario")
+		prestado := crearLibro(t, "Rayuela", 1)
+		otro := crearLibro(t, "Ficciones", 5)
+		prestamo := prestar(t, prestado, ana)
+
+		c.login("ana", "clave")
+		// Un libroID manipulado no debe sumar copias a otro libro
+		form := url.Values{"prestamoID": {prestamo.ID}, "libroID": {otro.ID}}
+		esperarEstado(t, c.ajax("/devoluciones", form), http.StatusOK)
+		if l := obtenerLibro(t, otro.ID); l.Copias != 5 {
+			t.Errorf("copias del libro ajeno = %d, se esperaba 5", l.Copias)
+		}
+		if l := obtenerLibro(t, prestado.ID); l.Copias != 1 || !l.Disponible {
+			t.Errorf("libro prestado: copias=%d disponible=%v", l.Copias, l.Disponible)
+		}
+	})
+}
+
+func TestBibliotecarioDevuelvePrestamosAjenos(t *testing.T) {
+	for _, rol := range []string{RolBibliotecario, RolAdmin} {
+		t.Run(rol, func(t *testing.T) {
+			paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+				ana := crearPersona(t, "ana", "clave", "usuario")
+				crearPersona(t, "staff", "clave", rol)
+				libro := crearLibro(t, "Rayuela", 1)
+				prestamo := prestar(t, libro, ana)
+
+				c.login("staff", "clave")
+				resp := c.get("/devoluciones")
+				if !strings.Contains(resp.Cuerpo, prestamo.ID) || !strings.Contains(resp.Cuerpo, "ana") {
+					t.Errorf("%s no ve el préstamo de ana con su nombre", rol)
+				}
+				esperarEstado(t, c.ajax("/devoluciones", url.Values{"prestamoID": {prestamo.ID}}), http.StatusOK)
+				if l := obtenerLibro(t, libro.ID); l.Copias != 1 {
+					t.Errorf("copias = %d después de la devolución", l.Copias)
+				}
+			})
+		})
+	}
+}
+
+func TestCRUDLibrosAdmin(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		ctx := context.Background()
+		crearPersona(t, "admin", "admin", "admin")
+		c.login("admin", "admin")
+
+		esperarEstado(t, c.get("/registrar-libro"), http.StatusOK)
+		esperarRedireccion(t, c.post("/registrar-libro", url.Values{
+			"nombre": {"Cien años de soledad"}, "autor": {"García Márquez"}, "ano": {"1967"},
+			"descripcion": {"Macondo"}, "imagen": {"http://img/cien.jpg"}, "copias": {"3"},
+		}), "Libro registrado")
+
+		libros, err := DB.Libros().Listar(ctx)
+		if err != nil || len(libros) != 1 {
+			t.Fatalf("libros = %v, %v; se esperaba 1", libros, err)
+		}
+		id := libros[0].ID
+
+		if resp := c.get("/editar-libros?id=" + id); !strings.Contains(resp.Cuerpo, "Cien años de soledad") {
+			t.Errorf("el formulario de edición no muestra el libro")
+		}
+		esperarRedireccion(t, c.post("/editar-libros", url.Values{
+			"id": {id}, "nombre": {"Cien años de soledad"}, "autor": {"Gabriel García Márquez"}, "ano": {"1967"},
+			"descripcion": {"Macondo"}, "imagen": {"http://img/cien.jpg"}, "copias": {"5"}, "disponible": {"on"},
+		}), "Libro actualizado")
+		if l := obtenerLibro(t, id); l.Autor != "Gabriel García Márquez" || l.Copias != 5 {
+			t.Errorf("libro editado = %+v", l)
+		}
+
+		esperarEstado(t, c.ajax("/eliminar-libro", url.Values{"id": {id}}), http.StatusOK)
+		if _, err := DB.Libros().Obtener(ctx, id); err != ErrNoEncontrado {
+			t.Errorf("el libro sigue existiendo: %v", err)
+		}
+	})
+}
+
+func TestAdminRequeridoParaEditarLibros(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		crearPersona(t, "eva", "clave", "usuario")
+		libro := crearLibro(t, "Ficciones", 2)
+		c.login("eva", "clave")
+
+		esperarEstado(t, c.get("/editar-libros?id="+libro.ID), http.StatusForbidden)
+		esperarEstado(t, c.ajax("/eliminar-libro", url.Values{"id": {libro.ID}}), http.StatusForbidden)
+	})
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+)
+
+// formatoFechaFiltro es el formato de los <input type="date"> del historial.
+const formatoFechaFiltro = "2006-01-02"
+
+// FiltrosHistorial son los filtros tal como llegan en la URL, para volver a
+// mostrarlos en el formulario.
+type FiltrosHistorial struct {
+	LibroID   string
+	PersonaID string
+	Desde     string
+	Hasta     string
+}
+
+// aFiltro convierte los filtros de la URL en un FiltroPrestamos. Las fechas
+// se interpretan en la zona horaria local y Hasta incluye el día completo.
+func (f FiltrosHistorial) aFiltro() (FiltroPrestamos, error) {
+	filtro := FiltroPrestamos{LibroID: f.LibroID, PersonaID: f.PersonaID}
+	if f.Desde != "" {
+		desde, err := time.ParseInLocation(formatoFechaFiltro, f.Desde, time.Local)
+		if err != nil {
+			return filtro, err
+		}
+		filtro.Desde = desde
+	}
+	if f.Hasta != "" {
+		hasta, err := time.ParseInLocation(formatoFechaFiltro, f.Hasta, time.Local)
+		if err != nil {
+			return filtro, err
+		}
+		filtro.Hasta = hasta.AddDate(0, 0, 1)
+	}
+	return filtro, nil
+}
+
+// filasPrestamos arma las filas de las tablas de préstamos con los datos del
+// libro, el atraso a la fecha actual y, si conUsuario, el nombre y la cédula
+// de la persona.
+func filasPrestamos(ctx context.Context, prestamos []Prestamo, conUsuario bool) []DevolucionDisplayData {
+	ahora := time.Now()
+	libros := map[string]*Libro{}
+	personas := map[string]*Persona{}
+	var filas []DevolucionDisplayData
+	for _, p := range prestamos {
+		fila := DevolucionDisplayData{
+			PrestamoID:       p.ID,
+			LibroID:          p.LibroID,
+			LibroNombre:      "(libro eliminado)",
+			UsuarioID:        p.PersonaID,
+			FechaPrestamo:    p.FechaPrestamo,
+			FechaDevolucion:  p.FechaDevolucion,
+			FechaVencimiento: p.FechaVencimiento,
+			DiasAtraso:       p.DiasDeAtraso(ahora),
+			Renovaciones:     p.Renovaciones,
+			Estado:           p.Estado,
+			RetirarHasta:     p.RetirarHasta,
+		}
+		libro, ok := libros[p.LibroID]
+		if !ok {
+			libro, _ = DB.Libros().Obtener(ctx, p.LibroID)
+			libros[p.LibroID] = libro
+		}
+		if libro != nil {
+			fila.LibroNombre, fila.AutorNombre = libro.Nombre, libro.Autor
+		}
+		if conUsuario {
+			persona, ok := personas[p.PersonaID]
+			if !ok {
+				persona, _ = DB.Personas().Obtener(ctx, p.PersonaID)
+				personas[p.PersonaID] = persona
+			}
+			if persona != nil {
+				fila.UsuarioNombre, fila.UsuarioCedula = persona.Nombre, persona.Cedula
+			}
+		}
+		filas = append(filas, fila)
+	}
+	return filas
+}
+
+// MiHistorialHandler muestra todos los préstamos, activos y devueltos, de la
+// persona logueada.
+func MiHistorialHandler(w http.ResponseWriter, r *http.Request) {
+	persona := personaActual(r)
+	prestamos, err := DB.Prestamos().Historial(r.Context(), FiltroPrestamos{PersonaID: persona.ID})
+	if err != nil {
+		log.Printf("Error al cargar historial de %s: %v", persona.ID, err)
+		http.Error(w, "Error al cargar el historial", http.StatusInternalServerError)
+		return
+	}
+
+	renderTemplate(w, r, "historial.html", DatosPagina{
+		Historial: filasPrestamos(r.Context(), prestamos, false),
+		Año:       time.Now().Year(),
+		Usuario:   persona.Nombre,
+		Rol:       persona.Rol,
+	})
+}
+
+// HistorialHandler muestra el historial de préstamos de toda la biblioteca,
+// filtrable por libro, persona y rango de fechas de préstamo.
+func HistorialHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	persona := personaActual(r)
+	q := r.URL.Query()
+	filtros := FiltrosHistorial{
+		LibroID:   q.Get("libro"),
+		PersonaID: q.Get("persona"),
+		Desde:     q.Get("desde"),
+		Hasta:     q.Get("hasta"),
+	}
+
+	data := DatosPagina{
+		VistaGeneral: true,
+		Filtros:      filtros,
+		Año:          time.Now().Year(),
+		Usuario:      persona.Nombre,
+		Rol:          persona.Rol,
+	}
+
+	// Libros y personas para los selects de filtros
+	var err error
+	if data.Libros, err = DB.Libros().Listar(ctx); err != nil {
+		log.Printf("Error al listar libros: %v", err)
+	}
+	if data.Personas, err = DB.Personas().Listar(ctx); err != nil {
+		log.Printf("Error al listar personas: %v", err)
+	}
+
+	filtro, err := filtros.aFiltro()
+	if err != nil {
+		data.Mensaje, data.TipoMensaje = "Fecha inválida en los filtros.", "danger"
+		w.WriteHeader(http.StatusBadRequest)
+		renderTemplate(w, r, "historial.html", data)
+		return
+	}
+
+	prestamos, err := DB.Prestamos().Historial(ctx, filtro)
+	if err != nil {
+		log.Printf("Error al cargar historial: %v", err)
+		http.Error(w, "Error al cargar el historial", http.StatusInternalServerError)
+		return
+	}
+	data.Historial = filasPrestamos(ctx, prestamos, true)
+	renderTemplate(w, r, "historial.html", data)
+}
+
+// VencidosHandler muestra el reporte de préstamos vencidos, del más atrasado
+// al menos atrasado, con la persona, su cédula y los días de atraso.
+func VencidosHandler(w http.ResponseWriter, r *http.Request) {
+	persona := personaActual(r)
+	prestamos, err := DB.Prestamos().Vencidos(r.Context(), time.Now())
+	if err != nil {
+		log.Printf("Error al cargar préstamos vencidos: %v", err)
+		http.Error(w, "Error al cargar los préstamos vencidos", http.StatusInternalServerError)
+		return
+	}
+
+	renderTemplate(w, r, "vencidos.html", DatosPagina{
+		Vencidos: filasPrestamos(r.Context(), prestamos, true),
+		Año:      time.Now().Year(),
+		Usuario:  persona.Nombre,
+		Rol:      persona.Rol,
+	})
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// prestarEl registra un préstamo con una fecha dada.
+func prestarEl(t *testing.T, libro *Libro, persona *Persona, fecha time.Time) *Prestamo {
+	t.Helper()
+	p, err := prestarLibro(context.Background(), DB, libro.ID, persona.ID, fecha)
+	if err != nil {
+		t.Fatalf("prestando %s a %s: %v", libro.Nombre, persona.Nombre, err)
+	}
+	return p
+}
+
+func TestDevolucionCierraElPrestamo(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		ana := crearPersona(t, "ana", "clave", "usuario")
+		libro := crearLibro(t, "Rayuela", 1)
+		prestamo := prestar(t, libro, ana)
+		c.login("ana", "clave")
+
+		esperarEstado(t, c.ajax("/devoluciones", url.Values{"prestamoID": {prestamo.ID}}), http.StatusOK)
+
+		cerrado, err := DB.Prestamos().Obtener(context.Background(), prestamo.ID)
+		if err != nil {
+			t.Fatalf("el préstamo devuelto ya no existe: %v", err)
+		}
+		if cerrado.Estado != EstadoDevuelto || cerrado.FechaDevolucion.IsZero() {
+			t.Errorf("préstamo devuelto sin cerrar: %+v", cerrado)
+		}
+		if l := obtenerLibro(t, libro.ID); l.Copias != 1 {
+			t.Errorf("copias = %d tras la devolución, se esperaba 1", l.Copias)
+		}
+
+		// Devolverlo otra vez no suma copias
+		esperarEstado(t, c.ajax("/devoluciones", url.Values{"prestamoID": {prestamo.ID}}), http.StatusConflict)
+		if l := obtenerLibro(t, libro.ID); l.Copias != 1 {
+			t.Errorf("copias = %d tras la doble devolución, se esperaba 1", l.Copias)
+		}
+		if ps, _ := DB.Prestamos().ActivosPorPersona(context.Background(), ana.ID); len(ps) != 0 {
+			t.Errorf("el préstamo devuelto sigue entre los activos")
+		}
+	})
+}
+
+func TestMiHistorialSoloMuestraLosPropios(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		ana := crearPersona(t, "ana", "clave", "usuario")
+		luis := crearPersona(t, "luis", "clave", "usuario")
+		rayuela := crearLibro(t, "Rayuela", 2)
+		ficciones := crearLibro(t, "Ficciones", 2)
+		aleph := crearLibro(t, "El Aleph", 2)
+
+		devuelto := prestar(t, rayuela, ana)
+		if err := devolverLibro(context.Background(), DB, devuelto.ID, ana, time.Now()); err != nil {
+			t.Fatalf("devolviendo: %v", err)
+		}
+		prestar(t, ficciones, ana)
+		prestar(t, aleph, luis)
+
+		c.login("ana", "clave")
+		resp := c.get("/mi-historial")
+		esperarEstado(t, resp, http.StatusOK)
+		for _, nombre := range []string{"Rayuela", "Ficciones", "En préstamo"} {
+			if !strings.Contains(resp.Cuerpo, nombre) {
+				t.Errorf("/mi-historial no muestra %q", nombre)
+			}
+		}
+		if strings.Contains(resp.Cuerpo, "El Aleph") {
+			t.Errorf("/mi-historial muestra un préstamo de otra persona")
+		}
+		if strings.Contains(resp.Cuerpo, `action="/historial"`) {
+			t.Errorf("/mi-historial no debería mostrar los filtros generales")
+		}
+		esperarEstado(t, c.get("/historial"), http.StatusForbidden)
+	})
+}
+
+func TestHistorialConFiltros(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		crearPersona(t, "admin", "clave", "admin")
+		ana := crearPersona(t, "ana", "clave", "usuario")
+		luis := crearPersona(t, "luis", "clave", "usuario")
+		rayuela := crearLibro(t, "Rayuela", 3)
+		ficciones := crearLibro(t, "Ficciones", 3)
+
+		enero := time.Date(2024, 1, 15, 10, 0, 0, 0, time.Local)
+		marzo := time.Date(2024, 3, 10, 18, 30, 0, 0, time.Local)
+		viejo := prestarEl(t, rayuela, ana, enero)
+		if err := devolverLibro(context.Background(), DB, viejo.ID, ana, enero.AddDate(0, 0, 7)); err != nil {
+			t.Fatalf("devolviendo: %v", err)
+		}
+		prestarEl(t, ficciones, ana, marzo)
+		prestarEl(t, rayuela, luis, marzo)
+
+		c.login("admin", "clave")
+		casos := []struct {
+			filtros url.Values
+			filas   int
+		}{
+			{url.Values{}, 3},
+			{url.Values{"libro": {rayuela.ID}}, 2},
+			{url.Values{"persona": {ana.ID}}, 2},
+			{url.Values{"libro": {rayuela.ID}, "persona": {luis.ID}}, 1},
+			{url.Values{"desde": {"2024-02-01"}}, 2},
+			{url.Values{"hasta": {"2024-01-15"}}, 1},
+			{url.Values{"desde": {"2024-03-10"}, "hasta": {"2024-03-10"}}, 2},
+			{url.Values{"desde": {"2024-04-01"}}, 0},
+		}
+		for _, caso := range casos {
+			if n := len(historial(t, caso.filtros)); n != caso.filas {
+				t.Errorf("historial con %v: %d préstamos, se esperaban %d", caso.filtros, n, caso.filas)
+			}
+			resp := c.get("/historial?" + caso.filtros.Encode())
+			esperarEstado(t, resp, http.StatusOK)
+			if caso.filas == 0 {
+				if !strings.Contains(resp.Cuerpo, "No hay préstamos") {
+					t.Errorf("/historial?%s no avisa que no hay resultados", caso.filtros.Encode())
+				}
+			} else if n := strings.Count(resp.Cuerpo, "<tr>") - 1; n != caso.filas {
+				t.Errorf("/historial?%s muestra %d filas, se esperaban %d", caso.filtros.Encode(), n, caso.filas)
+			}
+		}
+
+		esperarEstado(t, c.get("/historial?desde=ayer"), http.StatusBadRequest)
+	})
+}
+
+// historial aplica los filtros de la URL directamente contra el store.
+func historial(t *testing.T, q url.Values) []Prestamo {
+	t.Helper()
+	filtro, err := FiltrosHistorial{LibroID: q.Get("libro"), PersonaID: q.Get("persona"), Desde: q.Get("desde"), Hasta: q.Get("hasta")}.aFiltro()
+	if err != nil {
+		t.Fatalf("filtros %v: %v", q, err)
+	}
+	ps, err := DB.Prestamos().Historial(context.Background(), filtro)
+	if err != nil {
+		t.Fatalf("Historial: %v", err)
+	}
+	return ps
+}
+
+func TestBibliotecarioVeElHistorial(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		crearPersona(t, "marta", "clave", RolBibliotecario)
+		ana := crearPersona(t, "ana", "clave", "usuario")
+		prestar(t, crearLibro(t, "Rayuela", 1), ana)
+
+		c.login("marta", "clave")
+		resp := c.get("/historial")
+		esperarEstado(t, resp, http.StatusOK)
+		if !strings.Contains(resp.Cuerpo, "Rayuela") || !strings.Contains(resp.Cuerpo, "ana") {
+			t.Errorf("el bibliotecario no ve los préstamos de otros en /historial")
+		}
+	})
+}
+
+func TestNoSeBorraLoQueTieneHistorial(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		crearPersona(t, "admin", "clave", "admin")
+		ana := crearPersona(t, "ana", "clave", "usuario")
+		libro := crearLibro(t, "Rayuela", 1)
+		prestamo := prestar(t, libro, ana)
+		c.login("admin", "clave")
+
+		rechazar := func(ruta, id string) {
+			t.Helper()
+			resp := c.ajax(ruta, url.Values{"id": {id}})
+			esperarEstado(t, resp, http.StatusConflict)
+			if !strings.Contains(resp.Cuerpo, "préstamos") || strings.Contains(resp.Cuerpo, "constraint") {
+				t.Errorf("%s: mensaje %q", ruta, resp.Cuerpo)
+			}
+		}
+		rechazar("/eliminar-libro", libro.ID)
+		rechazar("/eliminar-persona", ana.ID)
+
+		// El préstamo sigue pudiéndose cerrar, y después el historial se conserva
+		esperarEstado(t, c.ajax("/devoluciones", url.Values{"prestamoID": {prestamo.ID}}), http.StatusOK)
+		rechazar("/eliminar-libro", libro.ID)
+		rechazar("/eliminar-persona", ana.ID)
+		if ps, _ := DB.Prestamos().Historial(context.Background(), FiltroPrestamos{LibroID: libro.ID}); len(ps) != 1 || ps[0].Estado != EstadoDevuelto {
+			t.Errorf("historial del libro: %+v", ps)
+		}
+
+		// Sin préstamos se borran, y borrar otra vez no encuentra nada
+		sinPrestamos := crearLibro(t, "Ficciones", 1)
+		luis := crearPersona(t, "luis", "clave", "usuario")
+		for ruta, id := range map[string]string{"/eliminar-libro": sinPrestamos.ID, "/eliminar-persona": luis.ID} {
+			esperarEstado(t, c.ajax(ruta, url.Values{"id": {id}}), http.StatusOK)
+			esperarEstado(t, c.ajax(ruta, url.Values{"id": {id}}), http.StatusNotFound)
+		}
+	})
+}
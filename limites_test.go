@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestLimitePrestamosPorRol(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		ana := crearPersona(t, "ana", "clave", "usuario")
+		bib := crearPersona(t, "bibliotecaria", "clave", "bibliotecario")
+		limite := Configuracion.LimitePrestamos(RolUsuario)
+		for i := range limite {
+			prestar(t, crearLibro(t, fmt.Sprintf("Libro %d", i), 1), ana)
+		}
+
+		otro := crearLibro(t, "Uno de más", 1)
+		if _, err := prestarLibro(context.Background(), DB, otro.ID, ana.ID, time.Now()); err != ErrLimitePrestamos {
+			t.Fatalf("préstamo %d = %v, se esperaba ErrLimitePrestamos", limite+1, err)
+		}
+
+		c.login("ana", "clave")
+		esperarRedireccion(t, c.post("/prestamos", url.Values{"libroID": {otro.ID}}), fmt.Sprintf("límite de %d préstamos", limite))
+
+		// Otro rol, otro límite
+		for i := range limite {
+			prestar(t, crearLibro(t, fmt.Sprintf("Otro %d", i), 1), bib)
+		}
+		prestar(t, otro, bib)
+	})
+}
+
+func TestVencidoBloqueaPrestamos(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		ana := crearPersona(t, "ana", "clave", "usuario")
+		vencido := prestarEl(t, crearLibro(t, "Rayuela", 1), ana, time.Now().AddDate(0, 0, -Configuracion.DiasPrestamo-1))
+		libro := crearLibro(t, "Ficciones", 1)
+
+		c.login("ana", "clave")
+		esperarRedireccion(t, c.post("/prestamos", url.Values{"libroID": {libro.ID}}), "préstamos vencidos")
+		if l := obtenerLibro(t, libro.ID); l.Copias != 1 {
+			t.Errorf("el préstamo rechazado se llevó una copia: copias=%d", l.Copias)
+		}
+
+		// Al devolverlo queda la multa por el atraso, que también bloquea
+		if err := devolverLibro(context.Background(), DB, vencido.ID, ana, time.Now()); err != nil {
+			t.Fatalf("devolviendo: %v", err)
+		}
+		esperarRedireccion(t, c.post("/prestamos", url.Values{"libroID": {libro.ID}}), "multas sin pagar")
+		pagar(t, ana, Configuracion.MultaDiaria)
+		esperarRedireccion(t, c.post("/prestamos", url.Values{"libroID": {libro.ID}}), "Préstamo registrado")
+	})
+}
+
+func TestLimiteCeroEsSinLimite(t *testing.T) {
+	cfg := ConfigPorDefecto()
+	cfg.MaxPrestamosAdmin = 0
+	if err := cfg.Validar(); err != nil {
+		t.Fatalf("un límite 0 debería ser válido: %v", err)
+	}
+	cfg.MaxPrestamosUsuario = -1
+	if err := cfg.Validar(); err == nil {
+		t.Errorf("un límite negativo debería ser inválido")
+	}
+	if got := cfg.LimitePrestamos("desconocido"); got != cfg.MaxPrestamosUsuario {
+		t.Errorf("un rol desconocido tiene límite %d, se esperaba el de usuario", got)
+	}
+}
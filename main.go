@@ -1,26 +1,20 @@
 package main
 
 import (
-	"html/template"
+	"context"
+	"errors"
+	"flag"
 	"log"
 	"net/http"
+	"os"
 	"time"
 )
 
-// La definición de Libro y DatosPagina se ha movido a handlers.go
-// para que sea accesible por todas las funciones que la utilizan.
-// Si el proyecto crece, estas estructuras deberían ir en un archivo models.go.
-// Se asume que DatosPagina está definida en handlers.go y es accesible.
+// Las entidades (Libro, Persona, Prestamo) están en models.go y las
+// estructuras de vista como DatosPagina en handlers.go.
 
 func Index(w http.ResponseWriter, r *http.Request) {
-	usuario := ""
-	rol := ""
-	if cookie, err := r.Cookie("usuario"); err == nil {
-		usuario = cookie.Value
-	}
-	if cookie, err := r.Cookie("rol"); err == nil {
-		rol = cookie.Value
-	}
+	usuario, rol := usuarioYRol(r)
 
 	data := DatosPagina{
 		Libros:  nil,
@@ -30,36 +24,137 @@ func Index(w http.ResponseWriter, r *http.Request) {
 		Rol:     rol,
 	}
 
-	tmpl, err := template.ParseFiles("templates/base.html", "templates/index.html")
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		log.Println("Error en plantilla:", err)
+	renderTemplate(w, r, "index.html", data)
+}
+
+func main() {
+	cfg, err := CargarConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
 		return
 	}
+	if err != nil {
+		log.Fatalf("Error en la configuración: %v", err)
+	}
+	Configuracion = cfg
 
-	err = tmpl.ExecuteTemplate(w, "base", data)
+	store, err := NuevoStore(context.Background(), cfg)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		log.Println("Error al ejecutar template:", err)
+		log.Fatalf("Error inicializando el almacenamiento: %v", err)
+	}
+	defer store.Close()
+	DB = store
+	if n, err := migrarEjemplares(context.Background(), store); err != nil {
+		log.Fatalf("Error creando los ejemplares de los libros existentes: %v", err)
+	} else if n > 0 {
+		log.Printf("📚 Se crearon los ejemplares de %d libros existentes", n)
+	}
+	if Catalogo, err = indexarCatalogo(context.Background(), store); err != nil {
+		log.Fatalf("Error creando el índice de búsqueda: %v", err)
 	}
+	go limpiarSesiones(store, time.Hour)
+	go vencerReservasPeriodicamente(store, 5*time.Minute)
+	go vencerSolicitudesPeriodicamente(store, 5*time.Minute)
+
+	log.Printf("Servidor corriendo en http://localhost:%s/ (store: %s)", cfg.Puerto, cfg.Store)
+	log.Fatal(http.ListenAndServe(":"+cfg.Puerto, NuevoServidor(cfg)))
 }
 
-func main() {
-	InitFirebase() // Asume que esta función inicializa FirestoreClient globalmente
-
-	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
-	http.HandleFunc("/", Index)
-	http.HandleFunc("/registrar", RegistrarHandler)
-	http.HandleFunc("/login", LoginHandler)
-	http.HandleFunc("/logout", LogoutHandler)
-	http.HandleFunc("/registrar-libro", RegistrarLibroHandler)
-	http.HandleFunc("/libros", LibrosHandler)
-	http.HandleFunc("/devoluciones", DevolucionesHandler)
-	http.HandleFunc("/personas", PersonasHandler)
-	http.HandleFunc("/prestamos", PrestamoHandler)
-	http.HandleFunc("/editar-libros", EditarLibroHandler)
-	http.HandleFunc("/eliminar-libro", EliminarLibroHandler)
-	http.HandleFunc("/eliminar-persona", EliminarPersonaHandler)
-	log.Println("Servidor corriendo en http://localhost:3000/")
-	log.Fatal(http.ListenAndServe(":3000", nil))
+// ruta asocia un patrón de http.ServeMux (método y camino) con su handler y
+// la regla de acceso que se aplica antes de llamarlo.
+type ruta struct {
+	patron  string
+	acceso  acceso
+	handler http.HandlerFunc
+}
+
+// rutas es la tabla central de la aplicación.
+var rutas = []ruta{
+	{"GET /{$}", publico, Index},
+	{"GET /registrar", publico, RegistrarFormHandler},
+	{"POST /registrar", publico, RegistrarHandler},
+	{"GET /login", publico, LoginFormHandler},
+	{"POST /login", publico, LoginHandler},
+	{"POST /logout", autenticado, LogoutHandler},
+	{"GET /libros", publico, LibrosHandler},
+
+	{"GET /prestamos", autenticado, PrestamoFormHandler},
+	{"POST /prestamos", autenticado, PrestamoHandler},
+	{"GET /devoluciones", autenticado, DevolucionesHandler},
+	{"POST /devoluciones", autenticado, DevolverHandler},
+	{"POST /renovar", autenticado, RenovarHandler},
+	{"POST /reservas", autenticado, ReservarHandler},
+	{"POST /reservas/cancelar", autenticado, CancelarReservaHandler},
+	{"GET /mi-historial", autenticado, MiHistorialHandler},
+	{"GET /perfil", autenticado, PerfilHandler},
+	{"POST /perfil/tokens", autenticado, CrearTokenHandler},
+	{"POST /perfil/tokens/revocar", autenticado, RevocarTokenHandler},
+	{"GET /historial", soloRoles(RolAdmin, RolBibliotecario), HistorialHandler},
+	{"GET /vencidos", soloRoles(RolAdmin, RolBibliotecario), VencidosHandler},
+	{"POST /perdido", soloRoles(RolAdmin, RolBibliotecario), PerdidoHandler},
+	{"GET /mostrador", soloRoles(RolAdmin, RolBibliotecario), MostradorHandler},
+	{"POST /mostrador/prestar", soloRoles(RolAdmin, RolBibliotecario), MostradorPrestarHandler},
+	{"POST /mostrador/devolver", soloRoles(RolAdmin, RolBibliotecario), MostradorDevolverHandler},
+
+	{"GET /registrar-libro", soloRoles(RolAdmin), RegistrarLibroFormHandler},
+	{"POST /registrar-libro", soloRoles(RolAdmin), RegistrarLibroHandler},
+	{"GET /editar-libros", soloRoles(RolAdmin), EditarLibroFormHandler},
+	{"POST /editar-libros", soloRoles(RolAdmin), EditarLibroHandler},
+	{"POST /eliminar-libro", soloRoles(RolAdmin), EliminarLibroHandler},
+	{"GET /ejemplares", soloRoles(RolAdmin), EjemplaresHandler},
+	{"POST /ejemplares", soloRoles(RolAdmin), AgregarEjemplarHandler},
+	{"POST /ejemplares/editar", soloRoles(RolAdmin), EditarEjemplarHandler},
+	{"POST /ejemplares/eliminar", soloRoles(RolAdmin), EliminarEjemplarHandler},
+	{"GET /personas", soloRoles(RolAdmin), PersonasHandler},
+	{"POST /eliminar-persona", soloRoles(RolAdmin), EliminarPersonaHandler},
+	{"GET /multas", soloRoles(RolAdmin), MultasHandler},
+	{"POST /multas", soloRoles(RolAdmin), AbonoHandler},
+	{"GET /solicitudes", soloRoles(RolAdmin), SolicitudesHandler},
+	{"POST /solicitudes/aprobar", soloRoles(RolAdmin), AprobarSolicitudHandler},
+	{"POST /solicitudes/rechazar", soloRoles(RolAdmin), RechazarSolicitudHandler},
+}
+
+// rutasAPI es la tabla de la API JSON versionada. Usa las mismas reglas de
+// acceso que rutas, pero la persona sale del token (ver conToken). Cada
+// patrón se documenta en operacionesAPI (openapi.go).
+var rutasAPI = []ruta{
+	{"POST /api/v1/tokens", publico, CrearTokenAPIHandler},
+	{"GET /api/v1/tokens", autenticado, TokensAPIHandler},
+	{"DELETE /api/v1/tokens/{id}", autenticado, RevocarTokenAPIHandler},
+
+	{"GET /api/v1/libros", publico, LibrosAPIHandler},
+	{"GET /api/v1/libros/{id}", publico, LibroAPIHandler},
+	{"POST /api/v1/libros", soloRoles(RolAdmin), CrearLibroAPIHandler},
+	{"PUT /api/v1/libros/{id}", soloRoles(RolAdmin), EditarLibroAPIHandler},
+	{"DELETE /api/v1/libros/{id}", soloRoles(RolAdmin), EliminarLibroAPIHandler},
+
+	{"GET /api/v1/personas", soloRoles(RolAdmin, RolBibliotecario), PersonasAPIHandler},
+	{"GET /api/v1/personas/{id}", autenticado, PersonaAPIHandler},
+	{"POST /api/v1/personas", soloRoles(RolAdmin), CrearPersonaAPIHandler},
+	{"DELETE /api/v1/personas/{id}", soloRoles(RolAdmin), EliminarPersonaAPIHandler},
+
+	{"GET /api/v1/prestamos", autenticado, PrestamosAPIHandler},
+	{"GET /api/v1/prestamos/{id}", autenticado, PrestamoAPIHandler},
+	{"POST /api/v1/prestamos", autenticado, PrestarAPIHandler},
+	{"POST /api/v1/prestamos/{id}/renovacion", autenticado, RenovarAPIHandler},
+	{"POST /api/v1/devoluciones", autenticado, DevolverAPIHandler},
+
+	{"GET /api/v1/reservas", autenticado, ReservasAPIHandler},
+	{"GET /api/v1/reservas/{id}", autenticado, ReservaAPIHandler},
+	{"POST /api/v1/reservas", autenticado, ReservarAPIHandler},
+	{"DELETE /api/v1/reservas/{id}", autenticado, CancelarReservaAPIHandler},
+}
+
+// NuevoServidor registra todas las rutas de la aplicación: las páginas, con
+// el middleware de sesión, y la API bajo /api/, con el de tokens. Lo usan
+// main y las pruebas con httptest.
+func NuevoServidor(cfg Config) http.Handler {
+	web := http.NewServeMux()
+	web.Handle("GET /static/", http.StripPrefix("/static/", http.FileServer(http.Dir(cfg.DirEstaticos))))
+	for _, rt := range rutas {
+		web.Handle(rt.patron, proteger(rt.acceso, rt.handler))
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/api/", conToken(servidorAPI()))
+	mux.Handle("/", conSesion(web))
+	return mux
 }
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+)
+
+// claveContexto evita choques con claves de contexto de otros paquetes.
+type claveContexto int
+
+const (
+	clavePersona claveContexto = iota
+	claveSesion
+)
+
+// conSesion resuelve la sesión una sola vez por petición y deja la sesión y
+// la persona autenticada (si las hay) en el contexto.
+func conSesion(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if sesion, persona := sesionPeticion(r); persona != nil {
+			ctx := context.WithValue(r.Context(), clavePersona, persona)
+			r = r.WithContext(context.WithValue(ctx, claveSesion, sesion))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sesionActual devuelve la sesión que dejó conSesion, o nil.
+func sesionActual(r *http.Request) *Sesion {
+	sesion, _ := r.Context().Value(claveSesion).(*Sesion)
+	return sesion
+}
+
+// personaActual devuelve la persona autenticada que dejó conSesion, o nil.
+func personaActual(r *http.Request) *Persona {
+	persona, _ := r.Context().Value(clavePersona).(*Persona)
+	return persona
+}
+
+// acceso es la regla de autorización de una ruta.
+type acceso struct {
+	autenticado bool
+	roles       []string // Si no está vacío, el rol de la persona debe estar aquí
+}
+
+var (
+	publico     = acceso{}
+	autenticado = acceso{autenticado: true}
+)
+
+// soloRoles exige una sesión con alguno de los roles dados.
+func soloRoles(roles ...string) acceso {
+	return acceso{autenticado: true, roles: roles}
+}
+
+func esAJAX(r *http.Request) bool {
+	return r.Header.Get("X-Requested-With") == "XMLHttpRequest"
+}
+
+// proteger aplica la regla de acceso antes de llamar al handler. Sin sesión,
+// las peticiones AJAX reciben 401 y las demás se redirigen al login; con un
+// rol no permitido o sin token CSRF válido se responde 403.
+func proteger(regla acceso, h http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		persona := personaActual(r)
+		if regla.autenticado && persona == nil {
+			if esAJAX(r) {
+				http.Error(w, "Debes iniciar sesión", http.StatusUnauthorized)
+				return
+			}
+			http.Redirect(w, r, "/login?msg="+url.QueryEscape("Debes iniciar sesión para continuar")+"&msg_type=warning", http.StatusSeeOther)
+			return
+		}
+		if len(regla.roles) > 0 && !slices.Contains(regla.roles, persona.Rol) {
+			log.Printf("Acceso denegado a %s %s para %s (rol %s)", r.Method, r.URL.Path, persona.Nombre, persona.Rol)
+			http.Error(w, "Acceso denegado", http.StatusForbidden)
+			return
+		}
+		if persona != nil && !metodoSeguro(r.Method) && !csrfValido(r) {
+			log.Printf("Token CSRF inválido en %s %s para %s", r.Method, r.URL.Path, persona.Nombre)
+			http.Error(w, "Token CSRF inválido. Recarga la página e inténtalo de nuevo.", http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	})
+}
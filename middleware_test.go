@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// TestRutasProtegidasSinSesion recorre la tabla de rutas y comprueba que
+// ninguna ruta no pública atienda a un visitante anónimo.
+func TestRutasProtegidasSinSesion(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		for _, rt := range rutas {
+			if !rt.acceso.autenticado {
+				continue
+			}
+			metodo, camino, _ := strings.Cut(rt.patron, " ")
+			req, _ := http.NewRequest(metodo, c.srv.URL+camino, strings.NewReader(""))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			esperarRedireccion(t, c.hacer(req), "/login")
+
+			req, _ = http.NewRequest(metodo, c.srv.URL+camino, strings.NewReader(""))
+			req.Header.Set("X-Requested-With", "XMLHttpRequest")
+			esperarEstado(t, c.hacer(req), http.StatusUnauthorized)
+		}
+	})
+}
+
+func TestRutasDeAdminParaUsuarioNormal(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		crearPersona(t, "eva", "clave", "usuario")
+		c.login("eva", "clave")
+
+		esperarEstado(t, c.get("/personas"), http.StatusForbidden)
+		esperarEstado(t, c.get("/registrar-libro"), http.StatusForbidden)
+		form := url.Values{"nombre": {"Pirata"}, "autor": {"X"}, "ano": {"2000"}, "copias": {"1"}}
+		esperarEstado(t, c.post("/registrar-libro", form), http.StatusForbidden)
+		esperarEstado(t, c.ajax("/eliminar-persona", url.Values{"id": {"x"}}), http.StatusForbidden)
+
+		// Las rutas de usuario sí están permitidas
+		esperarEstado(t, c.get("/prestamos"), http.StatusOK)
+		esperarEstado(t, c.get("/devoluciones"), http.StatusOK)
+	})
+}
+
+func TestRutasSegunMetodo(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		esperarEstado(t, c.get("/eliminar-libro"), http.StatusMethodNotAllowed)
+		esperarEstado(t, c.post("/libros", nil), http.StatusMethodNotAllowed)
+		esperarEstado(t, c.get("/no-existe"), http.StatusNotFound)
+	})
+}
+
+func TestLoginMuestraMotivoDeRedireccion(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		resp := c.get("/devoluciones")
+		esperarRedireccion(t, resp, "/login")
+		login := c.get(resp.Header.Get("Location"))
+		esperarEstado(t, login, http.StatusOK)
+		if !strings.Contains(login.Cuerpo, "Debes iniciar sesión") {
+			t.Errorf("la página de login no muestra el motivo de la redirección")
+		}
+	})
+}
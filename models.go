@@ -0,0 +1,203 @@
+package main
+
+import "time"
+
+// Definición de la estructura Libro
+type Libro struct {
+	ID          string `json:"id" firestore:"id,omitempty"`
+	Nombre      string `json:"nombre" firestore:"nombre"`
+	Autor       string `json:"autor" firestore:"autor"`
+	Ano         int    `json:"ano" firestore:"ano"`
+	Descripcion string `json:"descripcion" firestore:"descripcion"`
+	ImagenURL   string `json:"imagenURL" firestore:"imagen"`
+	Total       int    `json:"total" firestore:"total"`             // Stock: ejemplares del libro, prestados o no
+	Copias      int    `json:"copias" firestore:"copias"`           // Ejemplares que se pueden prestar ahora; se deriva del inventario, nunca se edita
+	Disponible  bool   `json:"disponible" firestore:"disponible"`   // Copias > 0
+	Restringido bool   `json:"restringido" firestore:"restringido"` // Colección restringida: los préstamos empiezan como solicitudes que aprueba un administrador
+}
+
+// Ejemplar es una copia física de un libro.
+type Ejemplar struct {
+	ID        string `json:"id" firestore:"id,omitempty"`
+	LibroID   string `json:"libroID" firestore:"libroID"`
+	Codigo    string `json:"codigo" firestore:"codigo"` // Código de barras o número de inventario, único
+	Condicion string `json:"condicion" firestore:"condicion"`
+	Ubicacion string `json:"ubicacion" firestore:"ubicacion"` // Estante, sala, sede...
+}
+
+// Condiciones de Ejemplar.
+const (
+	CondicionBueno   = "bueno"
+	CondicionRegular = "regular"
+	CondicionDanado  = "danado"  // No se presta hasta repararlo
+	CondicionPerdido = "perdido" // No se presta
+)
+
+// condicionesEjemplar son las condiciones válidas, en el orden en que se
+// ofrecen en los formularios.
+var condicionesEjemplar = []string{CondicionBueno, CondicionRegular, CondicionDanado, CondicionPerdido}
+
+// Prestable indica si la condición del ejemplar permite prestarlo.
+func (e *Ejemplar) Prestable() bool {
+	return e.Condicion == CondicionBueno || e.Condicion == CondicionRegular
+}
+
+// Definición de la estructura Persona
+type Persona struct {
+	ID         string `json:"id" firestore:"id,omitempty"`
+	Nombre     string `json:"nombre" firestore:"nombre"`
+	Cedula     string `json:"cedula" firestore:"cedula"`
+	Ano        int    `json:"ano" firestore:"ano"`
+	Contrasena string `json:"-" firestore:"contrasena"` // Ignorar en JSON, no almacenar en el cliente
+	Rol        string `json:"rol" firestore:"rol"`
+}
+
+// Roles de Persona.
+const (
+	RolUsuario       = "usuario"
+	RolBibliotecario = "bibliotecario" // Gestiona préstamos y devoluciones de cualquier persona
+	RolAdmin         = "admin"
+)
+
+// rolesPersona son los roles válidos.
+var rolesPersona = []string{RolUsuario, RolBibliotecario, RolAdmin}
+
+// GestionaPrestamos indica si la persona puede procesar préstamos ajenos.
+func (p *Persona) GestionaPrestamos() bool {
+	return p.Rol == RolAdmin || p.Rol == RolBibliotecario
+}
+
+// Definición de la estructura Prestamo
+type Prestamo struct {
+	ID               string    `json:"id" firestore:"id,omitempty"`
+	LibroID          string    `json:"libroID" firestore:"libroID"`                                      // ID del libro prestado
+	EjemplarID       string    `json:"ejemplarID" firestore:"ejemplarID"`                                // Copia física prestada (vacío en préstamos anteriores a los ejemplares)
+	PersonaID        string    `json:"personaID" firestore:"personaID"`                                  // ID de la persona que lo tiene
+	Estado           string    `json:"estado" firestore:"estado"`                                        // Ver EstadoSolicitado y siguientes
+	FechaPrestamo    time.Time `json:"fechaPrestamo" firestore:"fechaPrestamo"`                          // Fecha en que se realizó el préstamo; en una solicitud, la fecha en que se pidió
+	FechaDevolucion  time.Time `json:"fechaDevolucion,omitzero" firestore:"fechaDevolucion,omitempty"`   // Fecha de devolución (opcional, se llena al devolver)
+	FechaVencimiento time.Time `json:"fechaVencimiento,omitzero" firestore:"fechaVencimiento,omitempty"` // Fecha límite de devolución (cero en préstamos anteriores a los vencimientos)
+	Renovaciones     int       `json:"renovaciones" firestore:"renovaciones"`                            // Veces que se extendió el vencimiento
+	UltimaRenovacion time.Time `json:"ultimaRenovacion,omitzero" firestore:"ultimaRenovacion,omitempty"` // Fecha de la última renovación
+	RetirarHasta     time.Time `json:"retirarHasta,omitzero" firestore:"retirarHasta,omitempty"`         // Plazo para retirar una solicitud aprobada
+	ResueltoPor      string    `json:"resueltoPor,omitempty" firestore:"resueltoPor,omitempty"`          // Administrador que aprobó o rechazó la solicitud
+}
+
+// Estados de Prestamo. Un préstamo de un libro restringido empieza como
+// solicitud: un administrador la aprueba (y se aparta una copia) o la
+// rechaza, y la solicitud aprobada pasa a activo cuando la persona la
+// retira o a expirado si no la retira a tiempo. Los demás préstamos
+// empiezan activos. Un préstamo activo termina devuelto o perdido.
+const (
+	EstadoSolicitado = "solicitado"
+	EstadoAprobado   = "aprobado"
+	EstadoActivo     = "activo"
+	EstadoDevuelto   = "devuelto"
+	EstadoPerdido    = "perdido" // Se cerró sin devolver el ejemplar; se cobró su reposición
+	EstadoRechazado  = "rechazado"
+	EstadoExpirado   = "expirado" // Aprobado pero no retirado a tiempo
+)
+
+// estadosPrestamo son los estados válidos de un préstamo.
+var estadosPrestamo = []string{EstadoSolicitado, EstadoAprobado, EstadoActivo, EstadoDevuelto, EstadoPerdido, EstadoRechazado, EstadoExpirado}
+
+// Pendiente indica si el préstamo es una solicitud que todavía no se retiró.
+func (p Prestamo) Pendiente() bool {
+	return p.Estado == EstadoSolicitado || p.Estado == EstadoAprobado
+}
+
+// DiasDeAtraso devuelve cuántos días completos lleva vencido el préstamo en
+// ahora (al menos 1 apenas vence); 0 si no está vencido o no está activo.
+func (p *Prestamo) DiasDeAtraso(ahora time.Time) int {
+	if p.Estado != EstadoActivo || p.FechaVencimiento.IsZero() || !ahora.After(p.FechaVencimiento) {
+		return 0
+	}
+	return max(1, int(ahora.Sub(p.FechaVencimiento)/(24*time.Hour)))
+}
+
+// MovimientoMulta es un asiento del libro de multas de una persona. Los
+// cargos (atraso y reposición) aumentan lo que debe y los pagos y
+// condonaciones lo reducen; los asientos nunca se editan ni se borran.
+type MovimientoMulta struct {
+	ID            string    `json:"id" firestore:"id,omitempty"`
+	PersonaID     string    `json:"personaID" firestore:"personaID"`
+	PrestamoID    string    `json:"prestamoID" firestore:"prestamoID"` // Préstamo que originó el cargo; opcional en pagos y condonaciones
+	Tipo          string    `json:"tipo" firestore:"tipo"`
+	Monto         int       `json:"monto" firestore:"monto"` // En centavos y siempre positivo; el signo lo da Tipo
+	Motivo        string    `json:"motivo" firestore:"motivo"`
+	RegistradoPor string    `json:"registradoPor" firestore:"registradoPor"` // Quien registró un pago o condonación; vacío en cargos automáticos
+	Fecha         time.Time `json:"fecha" firestore:"fecha"`
+}
+
+// Tipos de MovimientoMulta.
+const (
+	MultaAtraso      = "atraso"     // Cargo por los días de atraso al cerrar un préstamo
+	MultaReposicion  = "reposicion" // Cargo por un ejemplar perdido
+	MultaPago        = "pago"
+	MultaCondonacion = "condonacion"
+)
+
+// EsCargo indica si el movimiento aumenta la deuda.
+func (m MovimientoMulta) EsCargo() bool {
+	return m.Tipo == MultaAtraso || m.Tipo == MultaReposicion
+}
+
+// Importe es el efecto del movimiento en el saldo: positivo en los cargos y
+// negativo en los pagos y condonaciones.
+func (m MovimientoMulta) Importe() int {
+	if m.EsCargo() {
+		return m.Monto
+	}
+	return -m.Monto
+}
+
+// Reserva es el lugar de una persona en la cola de espera de un libro.
+type Reserva struct {
+	ID        string    `json:"id" firestore:"id,omitempty"`
+	LibroID   string    `json:"libroID" firestore:"libroID"`
+	PersonaID string    `json:"personaID" firestore:"personaID"`
+	Creada    time.Time `json:"creada" firestore:"creada"` // Define el orden en la cola
+	Estado    string    `json:"estado" firestore:"estado"`
+	// DisponibleHasta es el fin del plazo para retirar la copia apartada;
+	// sólo tiene valor en estado ReservaLista.
+	DisponibleHasta time.Time `json:"disponibleHasta,omitzero" firestore:"disponibleHasta,omitempty"`
+	// EjemplarID es la copia apartada; sólo tiene valor en estado ReservaLista.
+	EjemplarID string `json:"ejemplarID,omitempty" firestore:"ejemplarID,omitempty"`
+}
+
+// Estados de Reserva.
+const (
+	ReservaEnEspera  = "en_espera" // En la cola, esperando una copia
+	ReservaLista     = "lista"     // Tiene una copia apartada hasta DisponibleHasta
+	ReservaCumplida  = "cumplida"  // Se retiró el libro
+	ReservaVencida   = "vencida"   // No se retiró a tiempo; la copia pasó al siguiente
+	ReservaCancelada = "cancelada"
+)
+
+// estadosReserva son los estados válidos de una reserva.
+var estadosReserva = []string{ReservaEnEspera, ReservaLista, ReservaCumplida, ReservaVencida, ReservaCancelada}
+
+// estadosReservaActivos son los estados en los que una reserva ocupa su
+// lugar en la cola del libro.
+var estadosReservaActivos = []string{ReservaEnEspera, ReservaLista}
+
+// TokenAPI es un token de acceso a la API JSON (/api/v1) para scripts y
+// otros sistemas. Sólo se guarda el hash SHA-256 del token, que hace de ID;
+// el token en claro se muestra una sola vez, al crearlo.
+type TokenAPI struct {
+	ID          string    `json:"id" firestore:"-"`
+	PersonaID   string    `json:"personaID" firestore:"personaID"`
+	Descripcion string    `json:"descripcion" firestore:"descripcion"` // Para qué se usa; la elige la persona
+	Creado      time.Time `json:"creado" firestore:"creado"`
+	UltimoUso   time.Time `json:"ultimoUso,omitzero" firestore:"ultimoUso,omitempty"`
+}
+
+// Sesion es una sesión iniciada. El ID viaja firmado en la cookie "sesion";
+// el rol nunca se guarda en el navegador, se lee de la persona en cada
+// solicitud.
+type Sesion struct {
+	ID        string    `json:"-" firestore:"-"`
+	PersonaID string    `json:"personaID" firestore:"personaID"`
+	Creada    time.Time `json:"creada" firestore:"creada"`
+	Expira    time.Time `json:"expira" firestore:"expira"`
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrEjemplarNoPrestado indica que el código leído en el mostrador no
+// corresponde a un ejemplar prestado.
+var ErrEjemplarNoPrestado = errors.New("el ejemplar no está prestado")
+
+// prestamoPorCodigo busca el préstamo activo del ejemplar con el código dado,
+// para devolverlo leyendo la etiqueta del libro.
+func prestamoPorCodigo(ctx context.Context, codigo string) (*Prestamo, error) {
+	ejemplar, err := DB.Ejemplares().BuscarPorCodigo(ctx, strings.TrimSpace(codigo))
+	if err != nil {
+		return nil, err
+	}
+	activos, err := DB.Prestamos().ActivosPorLibro(ctx, ejemplar.LibroID)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range activos {
+		if p.EjemplarID == ejemplar.ID {
+			return &p, nil
+		}
+	}
+	return nil, ErrEjemplarNoPrestado
+}
+
+// redirigirMostrador vuelve al mostrador con la persona de la cédula dada
+// cargada y un mensaje.
+func redirigirMostrador(w http.ResponseWriter, r *http.Request, cedula, mensaje, tipo string) {
+	http.Redirect(w, r, "/mostrador?cedula="+url.QueryEscape(cedula)+"&msg="+url.QueryEscape(mensaje)+"&msg_type="+tipo, http.StatusSeeOther)
+}
+
+// MostradorHandler es el modo mostrador: el bibliotecario busca a una
+// persona por cédula y ve sus préstamos, su saldo de multas y el formulario
+// para prestarle libros.
+func MostradorHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	q := r.URL.Query()
+	usuario, rol := usuarioYRol(r)
+	data := DatosPagina{
+		Cedula:      strings.TrimSpace(q.Get("cedula")),
+		Año:         time.Now().Year(),
+		Usuario:     usuario,
+		Rol:         rol,
+		Mensaje:     q.Get("msg"),
+		TipoMensaje: q.Get("msg_type"),
+	}
+	if data.Cedula == "" {
+		renderTemplate(w, r, "mostrador.html", data)
+		return
+	}
+
+	persona, err := DB.Personas().BuscarPorCedula(ctx, data.Cedula)
+	if errors.Is(err, ErrNoEncontrado) {
+		if data.Mensaje == "" {
+			data.Mensaje, data.TipoMensaje = "No hay ninguna persona registrada con la cédula "+data.Cedula+".", "warning"
+		}
+		w.WriteHeader(http.StatusNotFound)
+		renderTemplate(w, r, "mostrador.html", data)
+		return
+	}
+	if err != nil {
+		log.Printf("Error al buscar la cédula %s: %v", data.Cedula, err)
+		http.Error(w, "Error al buscar la persona", http.StatusInternalServerError)
+		return
+	}
+
+	if data.Cuenta, err = cuentaMultas(ctx, persona, time.Now()); err != nil {
+		log.Printf("Error al cargar las multas de %s: %v", persona.ID, err)
+		http.Error(w, "Error al cargar la persona", http.StatusInternalServerError)
+		return
+	}
+	prestamos, err := DB.Prestamos().ActivosPorPersona(ctx, persona.ID)
+	if err != nil {
+		log.Printf("Error al cargar préstamos de %s: %v", persona.ID, err)
+		http.Error(w, "Error al cargar la persona", http.StatusInternalServerError)
+		return
+	}
+	data.DevolucionesData = filasPrestamos(ctx, prestamos, false)
+	if data.Reservas, err = misReservas(ctx, persona.ID); err != nil {
+		log.Printf("Error al cargar reservas de %s: %v", persona.ID, err)
+	}
+	if data.LibrosDisponibles, err = DB.Libros().Listar(ctx); err != nil {
+		log.Printf("Error al listar libros: %v", err)
+	}
+	data.Limite = Configuracion.LimitePrestamos(persona.Rol)
+	renderTemplate(w, r, "mostrador.html", data)
+}
+
+// MostradorPrestarHandler presta uno o más libros a la persona de la cédula
+// indicada. Cada libro es un préstamo aparte: si uno se rechaza, los demás
+// se registran igual y el mensaje explica cuáles fallaron y por qué.
+func MostradorPrestarHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	cedula := strings.TrimSpace(r.FormValue("cedula"))
+	persona, err := DB.Personas().BuscarPorCedula(ctx, cedula)
+	if err != nil {
+		redirigirMostrador(w, r, cedula, "No hay ninguna persona registrada con esa cédula.", "danger")
+		return
+	}
+	if err := r.ParseForm(); err != nil || len(r.PostForm["libroID"]) == 0 {
+		redirigirMostrador(w, r, cedula, "Seleccione al menos un libro para prestar.", "danger")
+		return
+	}
+
+	bibliotecario := personaActual(r)
+	var prestados, rechazados []string
+	for _, libroID := range r.PostForm["libroID"] {
+		nombre := libroID
+		if libro, err := DB.Libros().Obtener(ctx, libroID); err == nil {
+			nombre = libro.Nombre
+		}
+		prestamo, err := prestarLibro(ctx, DB, libroID, persona.ID, time.Now())
+		if err != nil {
+			log.Printf("Mostrador: préstamo de %s a %s rechazado: %v", libroID, persona.ID, err)
+			rechazados = append(rechazados, fmt.Sprintf("«%s»: %s", nombre, motivoRechazoPrestamo(err, persona)))
+			continue
+		}
+		log.Printf("✅ Mostrador: %s prestó %s a %s (%s)", bibliotecario.Nombre, libroID, persona.ID, prestamo.Estado)
+		if prestamo.Estado == EstadoSolicitado {
+			nombre += " (colección restringida: solicitud pendiente de aprobación)"
+		}
+		prestados = append(prestados, "«"+nombre+"»")
+	}
+
+	var partes []string
+	if len(prestados) > 0 {
+		partes = append(partes, "Prestado a "+persona.Nombre+": "+strings.Join(prestados, ", ")+".")
+	}
+	if len(rechazados) > 0 {
+		partes = append(partes, "No se prestó "+strings.Join(rechazados, " "))
+	}
+	tipo := "success"
+	switch {
+	case len(prestados) == 0:
+		tipo = "danger"
+	case len(rechazados) > 0:
+		tipo = "warning"
+	}
+	redirigirMostrador(w, r, cedula, strings.Join(partes, " "), tipo)
+}
+
+// MostradorDevolverHandler registra en el mostrador la devolución de un
+// préstamo, elegido de la lista de la persona (prestamoID) o leyendo el
+// código del ejemplar (codigo). Vuelve al mostrador con la persona que tenía
+// el libro.
+func MostradorDevolverHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	cedula := strings.TrimSpace(r.FormValue("cedula"))
+	prestamoID := r.FormValue("prestamoID")
+	if codigo := r.FormValue("codigo"); prestamoID == "" && codigo != "" {
+		prestamo, err := prestamoPorCodigo(ctx, codigo)
+		switch {
+		case errors.Is(err, ErrNoEncontrado):
+			redirigirMostrador(w, r, cedula, "No hay ningún ejemplar con el código "+codigo+".", "danger")
+			return
+		case errors.Is(err, ErrEjemplarNoPrestado):
+			redirigirMostrador(w, r, cedula, "El ejemplar "+codigo+" no está prestado.", "danger")
+			return
+		case err != nil:
+			log.Printf("Error al buscar el ejemplar %s: %v", codigo, err)
+			redirigirMostrador(w, r, cedula, "Error al buscar el ejemplar.", "danger")
+			return
+		}
+		prestamoID = prestamo.ID
+		if p, err := DB.Personas().Obtener(ctx, prestamo.PersonaID); err == nil {
+			cedula = p.Cedula
+		}
+	}
+	if prestamoID == "" {
+		redirigirMostrador(w, r, cedula, "Indique el préstamo o el código del ejemplar a devolver.", "danger")
+		return
+	}
+
+	if err := devolverLibro(ctx, DB, prestamoID, personaActual(r), time.Now()); err != nil {
+		mensaje := "Error al procesar devolución"
+		switch {
+		case errors.Is(err, ErrNoEncontrado):
+			mensaje = "El préstamo no existe"
+		case errors.Is(err, ErrPrestamoCerrado):
+			mensaje = "El préstamo ya fue devuelto"
+		default:
+			log.Printf("Error al devolver préstamo %s en el mostrador: %v", prestamoID, err)
+		}
+		redirigirMostrador(w, r, cedula, mensaje, "danger")
+		return
+	}
+	redirigirMostrador(w, r, cedula, "Devolución registrada", "success")
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestMostradorPrestaAOtraPersona(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		bib := crearPersona(t, "bibliotecaria", "clave", "bibliotecario")
+		ana := crearPersona(t, "ana", "clave", "usuario")
+		rayuela := crearLibro(t, "Rayuela", 1)
+		ficciones := crearLibro(t, "Ficciones", 1)
+		agotado := crearLibro(t, "El Aleph", 1)
+		prestar(t, agotado, bib)
+		c.login("bibliotecaria", "clave")
+
+		resp := c.get("/mostrador?cedula=ced-ana")
+		esperarEstado(t, resp, http.StatusOK)
+		if !strings.Contains(resp.Cuerpo, "Prestar libros a ana") {
+			t.Errorf("/mostrador no muestra a la persona buscada")
+		}
+
+		form := url.Values{"cedula": {"ced-ana"}, "libroID": {rayuela.ID, ficciones.ID, agotado.ID}}
+		resp = c.post("/mostrador/prestar", form)
+		for _, texto := range []string{"Prestado a ana", "Rayuela", "Ficciones", "«El Aleph»: El libro no está disponible", "msg_type=warning"} {
+			esperarRedireccion(t, resp, texto)
+		}
+
+		activos, _ := DB.Prestamos().ActivosPorPersona(context.Background(), ana.ID)
+		if len(activos) != 2 {
+			t.Errorf("ana tiene %d préstamos, se esperaban 2", len(activos))
+		}
+		if propios, _ := DB.Prestamos().ActivosPorPersona(context.Background(), bib.ID); len(propios) != 1 {
+			t.Errorf("los préstamos del mostrador no deben quedar a nombre de la bibliotecaria")
+		}
+	})
+}
+
+func TestMostradorRespetaBloqueos(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		crearPersona(t, "bibliotecaria", "clave", "bibliotecario")
+		ana := crearPersona(t, "ana", "clave", "usuario")
+		libros := []string{}
+		for _, nombre := range []string{"Uno", "Dos", "Tres", "Cuatro"} {
+			libros = append(libros, crearLibro(t, nombre, 1).ID)
+		}
+		c.login("bibliotecaria", "clave")
+
+		resp := c.post("/mostrador/prestar", url.Values{"cedula": {"ced-ana"}, "libroID": libros})
+		esperarRedireccion(t, resp, "«Cuatro»: Alcanzaste el límite")
+		activos, _ := DB.Prestamos().ActivosPorPersona(context.Background(), ana.ID)
+		if len(activos) != Configuracion.LimitePrestamos(RolUsuario) {
+			t.Errorf("ana tiene %d préstamos, se esperaba el límite", len(activos))
+		}
+	})
+}
+
+func TestMostradorDevuelve(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		crearPersona(t, "bibliotecaria", "clave", "bibliotecario")
+		ana := crearPersona(t, "ana", "clave", "usuario")
+		rayuela := crearLibro(t, "Rayuela", 1)
+		ficciones := crearLibro(t, "Ficciones", 1)
+		deRayuela := prestar(t, rayuela, ana)
+		deFicciones := prestar(t, ficciones, ana)
+		c.login("bibliotecaria", "clave")
+
+		esperarRedireccion(t, c.post("/mostrador/devolver", url.Values{"cedula": {"ced-ana"}, "prestamoID": {deRayuela.ID}}), "Devolución registrada")
+
+		// Leyendo la etiqueta, sin buscar antes a la persona
+		ejemplar, _ := DB.Ejemplares().Obtener(context.Background(), deFicciones.EjemplarID)
+		resp := c.post("/mostrador/devolver", url.Values{"codigo": {ejemplar.Codigo}})
+		esperarRedireccion(t, resp, "Devolución registrada")
+		esperarRedireccion(t, resp, "cedula=ced-ana")
+		if l := obtenerLibro(t, ficciones.ID); l.Copias != 1 {
+			t.Errorf("copias = %d tras devolver por código", l.Copias)
+		}
+
+		esperarRedireccion(t, c.post("/mostrador/devolver", url.Values{"codigo": {ejemplar.Codigo}}), "no está prestado")
+		esperarRedireccion(t, c.post("/mostrador/devolver", url.Values{"codigo": {"NO-EXISTE"}}), "No hay ningún ejemplar")
+	})
+}
+
+func TestMostradorSoloPersonal(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		crearPersona(t, "bibliotecaria", "clave", "bibliotecario")
+		crearPersona(t, "ana", "clave", "usuario")
+		c.login("ana", "clave")
+		esperarEstado(t, c.get("/mostrador"), http.StatusForbidden)
+		esperarEstado(t, c.post("/mostrador/prestar", url.Values{"cedula": {"ced-ana"}}), http.StatusForbidden)
+
+		c.login("bibliotecaria", "clave")
+		resp := c.get("/mostrador?cedula=no-existe")
+		esperarEstado(t, resp, http.StatusNotFound)
+		if !strings.Contains(resp.Cuerpo, "No hay ninguna persona registrada") {
+			t.Errorf("/mostrador no avisa que la cédula no existe")
+		}
+	})
+}
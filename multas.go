@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Errores del libro de multas.
+var (
+	ErrMultasPendientes = errors.New("la persona tiene multas sin pagar")
+	ErrMontoInvalido    = errors.New("el monto debe ser un número positivo")
+	ErrMontoExcedeSaldo = errors.New("el monto supera lo que la persona debe")
+	ErrMotivoRequerido  = errors.New("falta el motivo del movimiento")
+	ErrTipoMovimiento   = errors.New("tipo de movimiento inválido")
+)
+
+// formatoDinero muestra un monto en centavos como "$12.50".
+func formatoDinero(centavos int) string {
+	signo := ""
+	if centavos < 0 {
+		signo, centavos = "-", -centavos
+	}
+	return fmt.Sprintf("%s$%d.%02d", signo, centavos/100, centavos%100)
+}
+
+// leerMonto convierte un monto escrito en un formulario ("12.50" o "12,50")
+// a centavos.
+func leerMonto(s string) (int, error) {
+	f, err := strconv.ParseFloat(strings.ReplaceAll(strings.TrimSpace(s), ",", "."), 64)
+	if err != nil || f <= 0 || math.IsInf(f, 0) {
+		return 0, ErrMontoInvalido
+	}
+	return int(math.Round(f * 100)), nil
+}
+
+// saldoMultas es lo que debe la persona dueña de los movimientos, en
+// centavos.
+func saldoMultas(movimientos []MovimientoMulta) int {
+	saldo := 0
+	for _, m := range movimientos {
+		saldo += m.Importe()
+	}
+	return saldo
+}
+
+// cargarAtraso registra el cargo por los días de atraso con que se cierra
+// prestamo, si los hay.
+func cargarAtraso(ctx context.Context, tx Store, prestamo *Prestamo, dias int, fecha time.Time) error {
+	if dias == 0 || Configuracion.MultaDiaria == 0 {
+		return nil
+	}
+	motivo := fmt.Sprintf("%d días de atraso", dias)
+	if dias == 1 {
+		motivo = "1 día de atraso"
+	}
+	return tx.Multas().Crear(ctx, &MovimientoMulta{
+		PersonaID:  prestamo.PersonaID,
+		PrestamoID: prestamo.ID,
+		Tipo:       MultaAtraso,
+		Monto:      dias * Configuracion.MultaDiaria,
+		Motivo:     motivo,
+		Fecha:      fecha,
+	})
+}
+
+// marcarPerdido cierra un préstamo cuyo ejemplar no se va a devolver: el
+// ejemplar queda en condición perdido y a la persona se le cargan el atraso
+// acumulado y la reposición. Sólo quien gestiona préstamos puede hacerlo.
+func marcarPerdido(ctx context.Context, store Store, prestamoID string, quien *Persona, fecha time.Time) error {
+	if !quien.GestionaPrestamos() {
+		return ErrNoAutorizado
+	}
+	return store.RunTransaction(ctx, func(ctx context.Context, tx Store) error {
+		prestamo, err := tx.Prestamos().Obtener(ctx, prestamoID)
+		if err != nil {
+			return err
+		}
+		if prestamo.Estado != EstadoActivo {
+			return ErrPrestamoCerrado
+		}
+		inv, err := leerInventario(ctx, tx, prestamo.LibroID)
+		if err != nil {
+			return err
+		}
+
+		dias := prestamo.DiasDeAtraso(fecha)
+		prestamo.Estado = EstadoPerdido
+		prestamo.FechaDevolucion = fecha
+		if err := tx.Prestamos().Guardar(ctx, prestamo); err != nil {
+			return err
+		}
+		for i := range inv.ejemplares {
+			if e := &inv.ejemplares[i]; e.ID == prestamo.EjemplarID {
+				e.Condicion = CondicionPerdido
+				if err := tx.Ejemplares().Guardar(ctx, e); err != nil {
+					return err
+				}
+			}
+		}
+		delete(inv.ocupados, prestamo.EjemplarID)
+		if err := cargarAtraso(ctx, tx, prestamo, dias, fecha); err != nil {
+			return err
+		}
+		if Configuracion.CostoReposicion > 0 {
+			err := tx.Multas().Crear(ctx, &MovimientoMulta{
+				PersonaID:  prestamo.PersonaID,
+				PrestamoID: prestamo.ID,
+				Tipo:       MultaReposicion,
+				Monto:      Configuracion.CostoReposicion,
+				Motivo:     "Reposición de " + inv.libro.Nombre,
+				Fecha:      fecha,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return inv.guardar(ctx, tx, fecha)
+	})
+}
+
+// registrarAbono agrega al libro de multas un pago o una condonación que
+// registra quien. mov debe traer PersonaID, Tipo, Monto y Motivo; PrestamoID
+// es opcional y, si viene, debe ser un préstamo con cargos de esa persona.
+// No se puede abonar más de lo que la persona debe.
+func registrarAbono(ctx context.Context, store Store, mov *MovimientoMulta, quien *Persona, fecha time.Time) error {
+	if mov.Tipo != MultaPago && mov.Tipo != MultaCondonacion {
+		return ErrTipoMovimiento
+	}
+	if mov.Monto <= 0 {
+		return ErrMontoInvalido
+	}
+	mov.Motivo = strings.TrimSpace(mov.Motivo)
+	if mov.Motivo == "" {
+		return ErrMotivoRequerido
+	}
+	return store.RunTransaction(ctx, func(ctx context.Context, tx Store) error {
+		if _, err := tx.Personas().Obtener(ctx, mov.PersonaID); err != nil {
+			return err
+		}
+		movimientos, err := tx.Multas().PorPersona(ctx, mov.PersonaID)
+		if err != nil {
+			return err
+		}
+		if mov.PrestamoID != "" && !slices.ContainsFunc(movimientos, func(m MovimientoMulta) bool {
+			return m.PrestamoID == mov.PrestamoID && m.EsCargo()
+		}) {
+			return ErrNoEncontrado
+		}
+		if mov.Monto > saldoMultas(movimientos) {
+			return ErrMontoExcedeSaldo
+		}
+
+		mov.RegistradoPor = quien.ID
+		mov.Fecha = fecha
+		return tx.Multas().Crear(ctx, mov)
+	})
+}
+
+// MovimientoDisplayData es una fila del libro de multas.
+type MovimientoDisplayData struct {
+	MovimientoMulta
+	LibroNombre string // Libro del préstamo asociado, si hay
+}
+
+// PrestamoConCargo es un préstamo con cargos, para asociarle un pago.
+type PrestamoConCargo struct {
+	PrestamoID  string
+	LibroNombre string
+}
+
+// CuentaMultas es el estado de cuenta de una persona.
+type CuentaMultas struct {
+	Persona       *Persona
+	Saldo         int                     // Lo que debe, en centavos
+	AtrasoEnCurso int                     // Lo que acumulan sus préstamos vencidos; se carga al cerrarlos
+	Movimientos   []MovimientoDisplayData // Del más reciente al más antiguo
+	Prestamos     []PrestamoConCargo
+}
+
+// cuentaMultas arma el estado de cuenta de la persona a la fecha dada.
+func cuentaMultas(ctx context.Context, persona *Persona, ahora time.Time) (*CuentaMultas, error) {
+	movimientos, err := DB.Multas().PorPersona(ctx, persona.ID)
+	if err != nil {
+		return nil, err
+	}
+	activos, err := DB.Prestamos().ActivosPorPersona(ctx, persona.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	cuenta := &CuentaMultas{Persona: persona, Saldo: saldoMultas(movimientos)}
+	for _, p := range activos {
+		cuenta.AtrasoEnCurso += p.DiasDeAtraso(ahora) * Configuracion.MultaDiaria
+	}
+	libros := map[string]string{} // Nombre del libro por ID de préstamo
+	nombreLibro := func(prestamoID string) string {
+		if nombre, ok := libros[prestamoID]; ok {
+			return nombre
+		}
+		nombre := "(libro eliminado)"
+		if p, err := DB.Prestamos().Obtener(ctx, prestamoID); err == nil {
+			if l, err := DB.Libros().Obtener(ctx, p.LibroID); err == nil {
+				nombre = l.Nombre
+			}
+		}
+		libros[prestamoID] = nombre
+		return nombre
+	}
+	conCargo := map[string]bool{}
+	for _, m := range slices.Backward(movimientos) {
+		fila := MovimientoDisplayData{MovimientoMulta: m}
+		if m.PrestamoID != "" {
+			fila.LibroNombre = nombreLibro(m.PrestamoID)
+			if m.EsCargo() && !conCargo[m.PrestamoID] {
+				conCargo[m.PrestamoID] = true
+				cuenta.Prestamos = append(cuenta.Prestamos, PrestamoConCargo{m.PrestamoID, fila.LibroNombre})
+			}
+		}
+		cuenta.Movimientos = append(cuenta.Movimientos, fila)
+	}
+	return cuenta, nil
+}
+
+// PerfilHandler muestra los datos de la persona logueada, su estado de
+// cuenta de multas y sus tokens de la API.
+func PerfilHandler(w http.ResponseWriter, r *http.Request) {
+	mostrarPerfil(w, r, DatosPagina{
+		Mensaje:     r.URL.Query().Get("msg"),
+		TipoMensaje: r.URL.Query().Get("msg_type"),
+	})
+}
+
+// mostrarPerfil completa datos con la cuenta y los tokens de la persona
+// logueada y muestra su perfil.
+func mostrarPerfil(w http.ResponseWriter, r *http.Request, datos DatosPagina) {
+	persona := personaActual(r)
+	cuenta, err := cuentaMultas(r.Context(), persona, time.Now())
+	if err != nil {
+		log.Printf("Error al cargar las multas de %s: %v", persona.ID, err)
+		http.Error(w, "Error al cargar el perfil", http.StatusInternalServerError)
+		return
+	}
+	tokens, err := DB.Tokens().PorPersona(r.Context(), persona.ID)
+	if err != nil {
+		log.Printf("Error al cargar los tokens de %s: %v", persona.ID, err)
+	}
+
+	datos.Cuenta = cuenta
+	datos.Tokens = tokens
+	datos.Año = time.Now().Year()
+	datos.Usuario = persona.Nombre
+	datos.Rol = persona.Rol
+	renderTemplate(w, r, "perfil.html", datos)
+}
+
+// MultasHandler muestra al administrador el estado de cuenta de una persona
+// con los formularios para registrar pagos y condonaciones.
+func MultasHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	persona, err := DB.Personas().Obtener(r.Context(), q.Get("persona"))
+	if err != nil {
+		http.Error(w, "Persona no encontrada", http.StatusNotFound)
+		return
+	}
+	cuenta, err := cuentaMultas(r.Context(), persona, time.Now())
+	if err != nil {
+		log.Printf("Error al cargar las multas de %s: %v", persona.ID, err)
+		http.Error(w, "Error al cargar las multas", http.StatusInternalServerError)
+		return
+	}
+
+	usuario, rol := usuarioYRol(r)
+	renderTemplate(w, r, "perfil.html", DatosPagina{
+		Cuenta:           cuenta,
+		AdministraMultas: true,
+		Año:              time.Now().Year(),
+		Usuario:          usuario,
+		Rol:              rol,
+		Mensaje:          q.Get("msg"),
+		TipoMensaje:      q.Get("msg_type"),
+	})
+}
+
+// AbonoHandler registra un pago o una condonación en el libro de multas de
+// una persona.
+func AbonoHandler(w http.ResponseWriter, r *http.Request) {
+	personaID := r.FormValue("personaID")
+	volver := func(mensaje, tipo string) {
+		http.Redirect(w, r, "/multas?persona="+url.QueryEscape(personaID)+"&msg="+url.QueryEscape(mensaje)+"&msg_type="+tipo, http.StatusSeeOther)
+	}
+
+	monto, err := leerMonto(r.FormValue("monto"))
+	if err == nil {
+		err = registrarAbono(r.Context(), DB, &MovimientoMulta{
+			PersonaID:  personaID,
+			PrestamoID: r.FormValue("prestamoID"),
+			Tipo:       r.FormValue("tipo"),
+			Monto:      monto,
+			Motivo:     r.FormValue("motivo"),
+		}, personaActual(r), time.Now())
+	}
+	if err != nil {
+		mensaje := "Error al registrar el movimiento"
+		switch {
+		case errors.Is(err, ErrMontoInvalido):
+			mensaje = "El monto debe ser un número positivo, por ejemplo 2.50."
+		case errors.Is(err, ErrMontoExcedeSaldo):
+			mensaje = "El monto supera lo que la persona debe."
+		case errors.Is(err, ErrMotivoRequerido):
+			mensaje = "Indica el motivo del pago o la condonación."
+		case errors.Is(err, ErrTipoMovimiento):
+			mensaje = "Tipo de movimiento inválido."
+		case errors.Is(err, ErrNoEncontrado):
+			mensaje = "La persona o el préstamo no existe."
+		default:
+			log.Printf("Error al registrar abono para %s: %v", personaID, err)
+		}
+		volver(mensaje, "danger")
+		return
+	}
+
+	log.Printf("💵 %s de %s registrado para %s", r.FormValue("tipo"), formatoDinero(monto), personaID)
+	volver("Movimiento registrado", "success")
+}
+
+// PerdidoHandler cierra un préstamo cuyo ejemplar se perdió y carga su
+// reposición. Responde igual que DevolverHandler.
+func PerdidoHandler(w http.ResponseWriter, r *http.Request) {
+	prestamoID := r.FormValue("prestamoID")
+	persona := personaActual(r)
+
+	if err := marcarPerdido(r.Context(), DB, prestamoID, persona, time.Now()); err != nil {
+		estado, mensaje := http.StatusInternalServerError, "Error al marcar el préstamo como perdido"
+		switch {
+		case errors.Is(err, ErrNoAutorizado):
+			estado, mensaje = http.StatusForbidden, "No puedes marcar préstamos como perdidos"
+		case errors.Is(err, ErrNoEncontrado):
+			estado, mensaje = http.StatusNotFound, "El préstamo no existe"
+		case errors.Is(err, ErrPrestamoCerrado):
+			estado, mensaje = http.StatusConflict, "El préstamo ya fue devuelto"
+		default:
+			log.Printf("Error al marcar perdido el préstamo %s: %v", prestamoID, err)
+		}
+		if esAJAX(r) {
+			http.Error(w, mensaje, estado)
+			return
+		}
+		http.Redirect(w, r, "/devoluciones?msg="+url.QueryEscape(mensaje)+"&msg_type=danger", http.StatusSeeOther)
+		return
+	}
+
+	mensaje := "Préstamo cerrado como perdido; se cargó la reposición de " + formatoDinero(Configuracion.CostoReposicion)
+	if esAJAX(r) {
+		fmt.Fprint(w, mensaje)
+		return
+	}
+	http.Redirect(w, r, "/devoluciones?msg="+url.QueryEscape(mensaje)+"&msg_type=success", http.StatusSeeOther)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pagar registra, como un administrador, un pago de monto centavos de persona.
+func pagar(t *testing.T, persona *Persona, monto int) {
+	t.Helper()
+	mov := &MovimientoMulta{PersonaID: persona.ID, Tipo: MultaPago, Monto: monto, Motivo: "Pago en caja"}
+	if err := registrarAbono(context.Background(), DB, mov, &Persona{ID: "caja", Rol: RolAdmin}, time.Now()); err != nil {
+		t.Fatalf("pagando %d de %s: %v", monto, persona.Nombre, err)
+	}
+}
+
+// movimientoDe devuelve el primer movimiento del tipo dado, o nil. Los
+// movimientos con la misma fecha no tienen un orden garantizado.
+func movimientoDe(movimientos []MovimientoMulta, tipo string) *MovimientoMulta {
+	for i := range movimientos {
+		if movimientos[i].Tipo == tipo {
+			return &movimientos[i]
+		}
+	}
+	return nil
+}
+
+func multasDe(t *testing.T, persona *Persona) []MovimientoMulta {
+	t.Helper()
+	movimientos, err := DB.Multas().PorPersona(context.Background(), persona.ID)
+	if err != nil {
+		t.Fatalf("leyendo multas de %s: %v", persona.Nombre, err)
+	}
+	return movimientos
+}
+
+func TestDevolucionConAtrasoCargaMulta(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		ana := crearPersona(t, "ana", "clave", "usuario")
+		aTiempo := prestar(t, crearLibro(t, "Ficciones", 1), ana)
+		vencido := prestarEl(t, crearLibro(t, "Rayuela", 1), ana, time.Now().AddDate(0, 0, -Configuracion.DiasPrestamo-3))
+
+		if err := devolverLibro(context.Background(), DB, aTiempo.ID, ana, time.Now()); err != nil {
+			t.Fatalf("devolviendo: %v", err)
+		}
+		if movs := multasDe(t, ana); len(movs) != 0 {
+			t.Fatalf("una devolución a tiempo cargó multas: %+v", movs)
+		}
+
+		c.login("ana", "clave")
+		if resp := c.get("/perfil"); !strings.Contains(resp.Cuerpo, formatoDinero(3*Configuracion.MultaDiaria)) {
+			t.Errorf("/perfil no muestra el atraso en curso")
+		}
+
+		if err := devolverLibro(context.Background(), DB, vencido.ID, ana, time.Now()); err != nil {
+			t.Fatalf("devolviendo: %v", err)
+		}
+		movs := multasDe(t, ana)
+		if len(movs) != 1 || movs[0].Tipo != MultaAtraso || movs[0].PrestamoID != vencido.ID || movs[0].Monto != 3*Configuracion.MultaDiaria {
+			t.Fatalf("movimientos tras devolver con 3 días de atraso: %+v", movs)
+		}
+		resp := c.get("/perfil")
+		esperarEstado(t, resp, http.StatusOK)
+		for _, texto := range []string{"Rayuela", "3 días de atraso", formatoDinero(3 * Configuracion.MultaDiaria)} {
+			if !strings.Contains(resp.Cuerpo, texto) {
+				t.Errorf("/perfil no muestra %q", texto)
+			}
+		}
+	})
+}
+
+func TestAdminRegistraPagosYCondonaciones(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		admin := crearPersona(t, "admin", "clave", "admin")
+		ana := crearPersona(t, "ana", "clave", "usuario")
+		vencido := prestarEl(t, crearLibro(t, "Rayuela", 1), ana, time.Now().AddDate(0, 0, -Configuracion.DiasPrestamo-4))
+		if err := devolverLibro(context.Background(), DB, vencido.ID, ana, time.Now()); err != nil {
+			t.Fatalf("devolviendo: %v", err)
+		}
+		deuda := 4 * Configuracion.MultaDiaria
+		c.login("admin", "clave")
+
+		abonar := func(tipo, monto, motivo string) respuestaPrueba {
+			return c.post("/multas", url.Values{"personaID": {ana.ID}, "tipo": {tipo}, "monto": {monto}, "motivo": {motivo}, "prestamoID": {vencido.ID}})
+		}
+		esperarRedireccion(t, abonar(MultaCondonacion, "0.25", ""), "Indica el motivo")
+		esperarRedireccion(t, abonar(MultaPago, "abc", "Caja"), "número positivo")
+		esperarRedireccion(t, abonar(MultaPago, "1000", "Caja"), "supera lo que la persona debe")
+
+		esperarRedireccion(t, abonar(MultaPago, "0,25", "Pago en caja"), "Movimiento registrado")
+		esperarRedireccion(t, abonar(MultaCondonacion, formatoDinero(deuda - 25)[1:], "Primera vez"), "Movimiento registrado")
+
+		movs := multasDe(t, ana)
+		if saldo := saldoMultas(movs); saldo != 0 || len(movs) != 3 {
+			t.Fatalf("saldo = %d con %d movimientos, se esperaba 0 con 3", saldo, len(movs))
+		}
+		if condonacion := movimientoDe(movs, MultaCondonacion); condonacion == nil || condonacion.Motivo != "Primera vez" ||
+			condonacion.RegistradoPor != admin.ID || condonacion.PrestamoID != vencido.ID {
+			t.Errorf("condonación mal registrada: %+v", condonacion)
+		}
+		if resp := c.get("/multas?persona=" + ana.ID); !strings.Contains(resp.Cuerpo, "Primera vez") {
+			t.Errorf("/multas no muestra el motivo de la condonación")
+		}
+	})
+}
+
+func TestSoloAdminRegistraAbonos(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		ana := crearPersona(t, "ana", "clave", "usuario")
+		c.login("ana", "clave")
+		esperarEstado(t, c.get("/multas?persona="+ana.ID), http.StatusForbidden)
+		esperarEstado(t, c.post("/multas", url.Values{"personaID": {ana.ID}, "tipo": {MultaCondonacion}, "monto": {"1"}, "motivo": {"yo"}}), http.StatusForbidden)
+	})
+}
+
+func TestPerdidoCobraReposicion(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		crearPersona(t, "bibliotecaria", "clave", "bibliotecario")
+		ana := crearPersona(t, "ana", "clave", "usuario")
+		libro := crearLibro(t, "Rayuela", 2)
+		prestamo := prestarEl(t, libro, ana, time.Now().AddDate(0, 0, -Configuracion.DiasPrestamo-2))
+
+		c.login("ana", "clave")
+		esperarEstado(t, c.post("/perdido", url.Values{"prestamoID": {prestamo.ID}}), http.StatusForbidden)
+
+		c.login("bibliotecaria", "clave")
+		esperarRedireccion(t, c.post("/perdido", url.Values{"prestamoID": {prestamo.ID}}), "reposición")
+
+		p, _ := DB.Prestamos().Obtener(context.Background(), prestamo.ID)
+		if p.Estado != EstadoPerdido {
+			t.Errorf("el préstamo debería quedar cerrado como perdido: %+v", p)
+		}
+		ejemplar, _ := DB.Ejemplares().Obtener(context.Background(), prestamo.EjemplarID)
+		if ejemplar.Condicion != CondicionPerdido {
+			t.Errorf("condición del ejemplar = %q, se esperaba perdido", ejemplar.Condicion)
+		}
+		if l := obtenerLibro(t, libro.ID); l.Copias != 1 || l.Total != 2 {
+			t.Errorf("tras la pérdida: copias=%d total=%d, se esperaba 1 de 2", l.Copias, l.Total)
+		}
+
+		movs := multasDe(t, ana)
+		reposicion := movimientoDe(movs, MultaReposicion)
+		if len(movs) != 2 || movimientoDe(movs, MultaAtraso) == nil || reposicion == nil ||
+			reposicion.Monto != Configuracion.CostoReposicion || reposicion.PrestamoID != prestamo.ID {
+			t.Fatalf("movimientos tras la pérdida: %+v", movs)
+		}
+		if saldo := saldoMultas(movs); saldo != 2*Configuracion.MultaDiaria+Configuracion.CostoReposicion {
+			t.Errorf("saldo = %d", saldo)
+		}
+		esperarRedireccion(t, c.post("/perdido", url.Values{"prestamoID": {prestamo.ID}}), "ya fue devuelto")
+	})
+}
+
+func TestLeerMonto(t *testing.T) {
+	casos := map[string]int{"2.50": 250, "2,5": 250, " 10 ": 1000, "0.01": 1}
+	for texto, centavos := range casos {
+		if got, err := leerMonto(texto); err != nil || got != centavos {
+			t.Errorf("leerMonto(%q) = %d, %v; se esperaba %d", texto, got, err, centavos)
+		}
+	}
+	for _, texto := range []string{"", "-1", "0", "abc"} {
+		if _, err := leerMonto(texto); err == nil {
+			t.Errorf("leerMonto(%q) debería fallar", texto)
+		}
+	}
+	if got := formatoDinero(-1205); got != "-$12.05" {
+		t.Errorf("formatoDinero(-1205) = %q", got)
+	}
+}
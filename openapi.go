@@ -0,0 +1,355 @@
+package main
+
+import (
+	"cmp"
+	"maps"
+	"net/http"
+	"reflect"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// operacionAPI documenta una ruta de rutasAPI. Los esquemas del cuerpo y de
+// la respuesta se generan por reflexión a partir de los tipos de Go que usan
+// los handlers, así que el documento no se desfasa al cambiar un struct.
+type operacionAPI struct {
+	resumen   string
+	consulta  []parametroAPI // Parámetros de la query string
+	cuerpo    any            // Un valor del tipo del cuerpo de la petición; nil si no lleva
+	estado    int            // Estado de la respuesta exitosa
+	respuesta any            // Un valor del tipo de la respuesta; nil si no tiene cuerpo
+	errores   []int          // Estados de error propios de la operación, además de los que se deducen de la ruta
+}
+
+type parametroAPI struct {
+	nombre      string
+	descripcion string
+	tipo        string // Tipo OpenAPI del valor; por defecto "string"
+	formato     string // Formato OpenAPI del valor, como "date"
+	valores     []string
+	repetible   bool
+}
+
+// parametrosPagina documenta los parámetros de un listado paginado con los
+// órdenes dados, en los dos sentidos, y otros órdenes sólo ascendentes.
+func parametrosPagina[T any](ordenes map[string]func(T) any, porDefecto string, otros ...string) []parametroAPI {
+	var valores []string
+	for _, orden := range slices.Sorted(maps.Keys(ordenes)) {
+		valores = append(valores, orden, "-"+orden)
+	}
+	return []parametroAPI{
+		{nombre: "orden", descripcion: "Campo por el que se ordena, con \"-\" delante para orden descendente; por defecto " + porDefecto, valores: append(valores, otros...)},
+		{nombre: "cursor", descripcion: "Valor de siguiente de la página anterior; se usa con el mismo orden"},
+		{nombre: "limite", descripcion: "Elementos por página (máximo " + strconv.Itoa(maxLimitePagina) + ")", tipo: "integer"},
+	}
+}
+
+// operacionesAPI tiene la documentación de cada patrón de rutasAPI.
+var operacionesAPI = map[string]operacionAPI{
+	"POST /api/v1/tokens": {resumen: "Crea un token con el nombre y la contraseña; el token sólo se muestra en esta respuesta",
+		cuerpo: credencialesAPI{}, estado: http.StatusCreated, respuesta: TokenCreadoAPI{}, errores: []int{http.StatusUnauthorized}},
+	"GET /api/v1/tokens":         {resumen: "Lista los tokens propios", estado: http.StatusOK, respuesta: ListaAPI[TokenAPI]{}},
+	"DELETE /api/v1/tokens/{id}": {resumen: "Revoca un token propio (un administrador, cualquiera)", estado: http.StatusNoContent, errores: []int{http.StatusForbidden}},
+
+	"GET /api/v1/libros": {resumen: "Lista una página de libros",
+		consulta: append([]parametroAPI{{nombre: "q", descripcion: "Busca en el título, el autor y la descripción, sin distinguir tildes ni plurales"}},
+			parametrosPagina(ordenesLibro, ordenPorDefecto+", o "+ordenRelevancia+" si hay búsqueda", ordenRelevancia)...),
+		estado: http.StatusOK, respuesta: ListaAPI[LibroEncontrado]{}},
+	"GET /api/v1/libros/{id}": {resumen: "Obtiene un libro", estado: http.StatusOK, respuesta: Libro{}},
+	"POST /api/v1/libros":     {resumen: "Registra un libro con total ejemplares", cuerpo: Libro{}, estado: http.StatusCreated, respuesta: Libro{}},
+	"PUT /api/v1/libros/{id}": {resumen: "Edita un libro y ajusta su stock a total", cuerpo: Libro{}, estado: http.StatusOK, respuesta: Libro{},
+		errores: []int{http.StatusConflict}},
+	"DELETE /api/v1/libros/{id}": {resumen: "Elimina un libro sin préstamos registrados", estado: http.StatusNoContent, errores: []int{http.StatusConflict}},
+
+	"GET /api/v1/personas": {resumen: "Lista una página de personas",
+		consulta: append([]parametroAPI{{nombre: "cedula", descripcion: "Busca la persona con esta cédula; sin paginación"}}, parametrosPagina(ordenesPersona, ordenPorDefecto)...),
+		estado:   http.StatusOK, respuesta: ListaAPI[Persona]{}},
+	"GET /api/v1/personas/{id}": {resumen: "Obtiene una persona: la propia o, para bibliotecarios y administradores, cualquiera",
+		estado: http.StatusOK, respuesta: Persona{}, errores: []int{http.StatusForbidden}},
+	"POST /api/v1/personas": {resumen: "Registra una persona", cuerpo: personaAPI{}, estado: http.StatusCreated, respuesta: Persona{},
+		errores: []int{http.StatusConflict}},
+	"DELETE /api/v1/personas/{id}": {resumen: "Elimina una persona sin préstamos registrados", estado: http.StatusNoContent, errores: []int{http.StatusConflict}},
+
+	"GET /api/v1/prestamos": {resumen: "Lista préstamos en cualquier estado, del más reciente al más antiguo; cada persona sólo ve los suyos",
+		consulta: []parametroAPI{
+			{nombre: "libro", descripcion: "ID del libro"},
+			{nombre: "persona", descripcion: "ID de la persona (sólo bibliotecarios y administradores pueden pedir la de otra)"},
+			{nombre: "estado", descripcion: "Estados a incluir; se puede repetir", repetible: true},
+			{nombre: "desde", descripcion: "Prestados desde esta fecha", formato: "date"},
+			{nombre: "hasta", descripcion: "Prestados hasta esta fecha, inclusive", formato: "date"},
+		},
+		estado: http.StatusOK, respuesta: ListaAPI[Prestamo]{}, errores: []int{http.StatusForbidden}},
+	"GET /api/v1/prestamos/{id}": {resumen: "Obtiene un préstamo", estado: http.StatusOK, respuesta: Prestamo{}, errores: []int{http.StatusForbidden}},
+	"POST /api/v1/prestamos": {resumen: "Presta varios libros en una sola transacción: se registran todos o ninguno",
+		cuerpo: prestamoAPI{}, estado: http.StatusCreated, respuesta: ListaAPI[Prestamo]{},
+		errores: []int{http.StatusForbidden, http.StatusNotFound, http.StatusConflict}},
+	"POST /api/v1/prestamos/{id}/renovacion": {resumen: "Renueva un préstamo", estado: http.StatusOK, respuesta: Prestamo{},
+		errores: []int{http.StatusForbidden, http.StatusConflict}},
+	"POST /api/v1/devoluciones": {resumen: "Devuelve un préstamo", cuerpo: devolucionAPI{}, estado: http.StatusOK, respuesta: Prestamo{},
+		errores: []int{http.StatusForbidden, http.StatusNotFound, http.StatusConflict}},
+
+	"GET /api/v1/reservas": {resumen: "Lista las reservas activas",
+		consulta: []parametroAPI{{nombre: "persona", descripcion: "ID de la persona (sólo bibliotecarios y administradores pueden pedir la de otra)"}},
+		estado:   http.StatusOK, respuesta: ListaAPI[Reserva]{}, errores: []int{http.StatusForbidden}},
+	"GET /api/v1/reservas/{id}": {resumen: "Obtiene una reserva", estado: http.StatusOK, respuesta: Reserva{}, errores: []int{http.StatusForbidden}},
+	"POST /api/v1/reservas": {resumen: "Pone a una persona en la cola de espera de un libro sin copias", cuerpo: reservaAPI{}, estado: http.StatusCreated,
+		respuesta: Reserva{}, errores: []int{http.StatusForbidden, http.StatusNotFound, http.StatusConflict}},
+	"DELETE /api/v1/reservas/{id}": {resumen: "Cancela una reserva activa", estado: http.StatusNoContent,
+		errores: []int{http.StatusForbidden, http.StatusConflict}},
+}
+
+// campoAPI completa el esquema de un campo con lo que la reflexión no ve.
+type campoAPI struct {
+	descripcion string
+	valores     []string // Valores válidos (enum)
+	soloLectura bool     // Lo calcula el servidor; se ignora en las peticiones
+	obligatorio bool     // El handler rechaza la petición sin este campo
+}
+
+// camposAPI tiene los detalles de algunos campos, con clave "Tipo.campo" y
+// el nombre del campo en JSON. Los obligatorios son los que validan los
+// handlers al leer el cuerpo; omitempty y omitzero sólo dicen qué se omite
+// al responder.
+var camposAPI = map[string]campoAPI{
+	"credencialesAPI.nombre":     {obligatorio: true},
+	"credencialesAPI.contrasena": {obligatorio: true},
+
+	"Libro.id":          {soloLectura: true},
+	"Libro.nombre":      {obligatorio: true},
+	"Libro.total":       {descripcion: "Stock: ejemplares del libro, prestados o no"},
+	"Libro.copias":      {descripcion: "Ejemplares que se pueden prestar ahora", soloLectura: true},
+	"Libro.disponible":  {descripcion: "copias > 0", soloLectura: true},
+	"Libro.restringido": {descripcion: "Colección restringida: los préstamos empiezan como solicitudes que aprueba un administrador"},
+
+	"LibroEncontrado.fragmentos": {descripcion: "Sólo en búsquedas: por campo (nombre, autor, descripcion), el pasaje que coincide en HTML, con las coincidencias entre <mark>", soloLectura: true},
+
+	"Persona.id":            {soloLectura: true},
+	"Persona.rol":           {valores: rolesPersona},
+	"personaAPI.nombre":     {obligatorio: true},
+	"personaAPI.cedula":     {obligatorio: true},
+	"personaAPI.contrasena": {obligatorio: true},
+	"personaAPI.rol":        {descripcion: "Por defecto usuario", valores: rolesPersona},
+
+	"Prestamo.estado":           {valores: estadosPrestamo},
+	"Prestamo.fechaVencimiento": {descripcion: "Fecha límite de devolución"},
+	"Prestamo.retirarHasta":     {descripcion: "Plazo para retirar una solicitud aprobada"},
+	"prestamoAPI.libroIDs":      {descripcion: "Al menos un libro", obligatorio: true},
+	"devolucionAPI.prestamoID":  {obligatorio: true},
+	"prestamoAPI.personaID":     {descripcion: "Persona a cuyo nombre se presta; sólo para bibliotecarios y administradores, por defecto quien pide"},
+
+	"Reserva.estado":       {valores: estadosReserva},
+	"reservaAPI.libroID":   {obligatorio: true},
+	"reservaAPI.personaID": {descripcion: "Persona a cuyo nombre se reserva; sólo para bibliotecarios y administradores, por defecto quien pide"},
+
+	"TokenAPI.id":            {descripcion: "SHA-256 del token; sirve para revocarlo"},
+	"TokenCreadoAPI.token":   {descripcion: "El token, para la cabecera Authorization: Bearer; no se vuelve a mostrar"},
+	"DetalleErrorAPI.codigo": {descripcion: "Código estable del error, como no_encontrado, sin_copias o carrito_rechazado"},
+	"DetalleErrorAPI.items":  {descripcion: "Resultado de cada libro de un carrito rechazado"},
+	"ItemCarrito.error":      {descripcion: "Motivo por el que no se pudo prestar el libro"},
+}
+
+var (
+	tipoFecha    = reflect.TypeFor[time.Time]()
+	tipoErrorAPI = reflect.TypeFor[ErrorAPI]()
+
+	reParametroRuta = regexp.MustCompile(`\{(\w+)\}`)
+)
+
+// esquemasAPI convierte tipos de Go en esquemas con las reglas de
+// encoding/json. Los structs con nombre van a components/schemas y se
+// referencian; los anónimos y los genéricos (ListaAPI[T]) van en línea.
+type esquemasAPI map[string]any
+
+func (c esquemasAPI) esquema(t reflect.Type) map[string]any {
+	if t == tipoFecha {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return c.esquema(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": c.esquema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object"}
+	case reflect.Struct:
+		nombre := t.Name()
+		if nombre == "" || strings.Contains(nombre, "[") {
+			return c.objeto(t)
+		}
+		if _, ok := c[nombre]; !ok {
+			c[nombre] = nil // Reservado mientras se genera, por si el tipo es recursivo
+			c[nombre] = c.objeto(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + nombre}
+	}
+	return map[string]any{}
+}
+
+func (c esquemasAPI) objeto(t reflect.Type) map[string]any {
+	propiedades := map[string]any{}
+	var requeridos []string
+	c.campos(t, propiedades, &requeridos)
+	esquema := map[string]any{"type": "object", "properties": propiedades}
+	if len(requeridos) > 0 {
+		esquema["required"] = requeridos
+	}
+	return esquema
+}
+
+// campos agrega las propiedades de los campos de t. Como en encoding/json,
+// los campos con json:"-" no aparecen y los structs embebidos sin nombre
+// aportan sus propios campos. Sólo son obligatorios los marcados así en
+// camposAPI.
+func (c esquemasAPI) campos(t reflect.Type, propiedades map[string]any, requeridos *[]string) {
+	for i := range t.NumField() {
+		f := t.Field(i)
+		etiqueta := f.Tag.Get("json")
+		if etiqueta == "-" || (!f.IsExported() && !f.Anonymous) {
+			continue
+		}
+		nombre, _, _ := strings.Cut(etiqueta, ",")
+		if f.Anonymous && nombre == "" && f.Type.Kind() == reflect.Struct {
+			c.campos(f.Type, propiedades, requeridos)
+			continue
+		}
+		if nombre == "" {
+			nombre = f.Name
+		}
+		esquema := c.esquema(f.Type)
+		if detalle, ok := camposAPI[t.Name()+"."+nombre]; ok {
+			if detalle.descripcion != "" {
+				esquema["description"] = detalle.descripcion
+			}
+			if len(detalle.valores) > 0 {
+				esquema["enum"] = detalle.valores
+			}
+			if detalle.soloLectura {
+				esquema["readOnly"] = true
+			}
+			if detalle.obligatorio {
+				*requeridos = append(*requeridos, nombre)
+			}
+		}
+		propiedades[nombre] = esquema
+	}
+}
+
+func contenidoJSON(esquema map[string]any) map[string]any {
+	return map[string]any{"application/json": map[string]any{"schema": esquema}}
+}
+
+// operacion documenta una ruta. El ID de la operación sale del nombre del
+// handler, y los errores 400, 401, 403 y 404 se deducen del cuerpo, de la
+// regla de acceso y de los parámetros de la ruta.
+func (c esquemasAPI) operacion(rt ruta, op operacionAPI) map[string]any {
+	_, camino, _ := strings.Cut(rt.patron, " ")
+	handler := runtime.FuncForPC(reflect.ValueOf(rt.handler).Pointer()).Name()
+	handler = handler[strings.LastIndex(handler, ".")+1:] // Sin el paquete
+	resultado := map[string]any{
+		"operationId": strings.TrimSuffix(handler, "APIHandler"),
+		"summary":     op.resumen,
+	}
+
+	errores := slices.Clone(op.errores)
+	var parametros []any
+	for _, m := range reParametroRuta.FindAllStringSubmatch(camino, -1) {
+		parametros = append(parametros, map[string]any{"name": m[1], "in": "path", "required": true, "schema": map[string]any{"type": "string"}})
+		errores = append(errores, http.StatusNotFound)
+	}
+	for _, p := range op.consulta {
+		esquema := map[string]any{"type": cmp.Or(p.tipo, "string")}
+		if p.formato != "" {
+			esquema["format"] = p.formato
+		}
+		if p.valores != nil {
+			esquema["enum"] = p.valores
+		}
+		if p.tipo != "" || p.formato != "" || p.valores != nil {
+			errores = append(errores, http.StatusBadRequest)
+		}
+		if p.repetible {
+			esquema = map[string]any{"type": "array", "items": esquema}
+		}
+		parametros = append(parametros, map[string]any{"name": p.nombre, "in": "query", "description": p.descripcion, "schema": esquema})
+	}
+	if len(parametros) > 0 {
+		resultado["parameters"] = parametros
+	}
+	if op.cuerpo != nil {
+		resultado["requestBody"] = map[string]any{"required": true, "content": contenidoJSON(c.esquema(reflect.TypeOf(op.cuerpo)))}
+		errores = append(errores, http.StatusBadRequest)
+	}
+
+	if rt.acceso.autenticado {
+		resultado["security"] = []any{map[string]any{"token": []string{}}}
+		errores = append(errores, http.StatusUnauthorized)
+	}
+	if len(rt.acceso.roles) > 0 {
+		resultado["description"] = "Sólo para los roles: " + strings.Join(rt.acceso.roles, ", ") + "."
+		errores = append(errores, http.StatusForbidden)
+	}
+
+	exito := map[string]any{"description": http.StatusText(op.estado)}
+	if op.respuesta != nil {
+		exito["content"] = contenidoJSON(c.esquema(reflect.TypeOf(op.respuesta)))
+	}
+	respuestas := map[string]any{strconv.Itoa(op.estado): exito}
+	for _, estado := range errores {
+		respuestas[strconv.Itoa(estado)] = map[string]any{"description": http.StatusText(estado), "content": contenidoJSON(c.esquema(tipoErrorAPI))}
+	}
+	resultado["responses"] = respuestas
+	return resultado
+}
+
+// documentoOpenAPI genera el documento OpenAPI 3 de rutasAPI.
+func documentoOpenAPI() map[string]any {
+	esquemas := esquemasAPI{}
+	caminos := map[string]map[string]any{}
+	for _, rt := range rutasAPI {
+		op, ok := operacionesAPI[rt.patron]
+		if !ok {
+			continue
+		}
+		metodo, camino, _ := strings.Cut(rt.patron, " ")
+		if caminos[camino] == nil {
+			caminos[camino] = map[string]any{}
+		}
+		caminos[camino][strings.ToLower(metodo)] = esquemas.operacion(rt, op)
+	}
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "API de la Biblioteca PUCE",
+			"version": "1",
+			"description": "API JSON para scripts y otros sistemas. Las peticiones se autentican con un token en la cabecera " +
+				"Authorization: Bearer <token>; los errores siempre tienen la forma ErrorAPI.",
+		},
+		"paths": caminos,
+		"components": map[string]any{
+			"schemas": esquemas,
+			"securitySchemes": map[string]any{
+				"token": map[string]any{"type": "http", "scheme": "bearer", "description": "Token creado en Mi cuenta o con POST /api/v1/tokens"},
+			},
+		},
+	}
+}
+
+// OpenAPIHandler publica el documento OpenAPI de la API. No está en
+// rutasAPI (el documento se genera a partir de esa tabla) sino que lo
+// registra servidorAPI.
+func OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	responderJSON(w, http.StatusOK, documentoOpenAPI())
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestOpenAPIDocumentaTodasLasRutas(t *testing.T) {
+	for _, rt := range rutasAPI {
+		if _, ok := operacionesAPI[rt.patron]; !ok {
+			t.Errorf("falta documentar %q en operacionesAPI", rt.patron)
+		}
+	}
+	for patron := range operacionesAPI {
+		if !slices.ContainsFunc(rutasAPI, func(rt ruta) bool { return rt.patron == patron }) {
+			t.Errorf("operacionesAPI documenta %q, que no está en rutasAPI", patron)
+		}
+	}
+
+	doc := documentoOpenAPI()
+	ids := map[any]bool{}
+	for camino, operaciones := range doc["paths"].(map[string]map[string]any) {
+		for metodo, op := range operaciones {
+			id := op.(map[string]any)["operationId"]
+			if ids[id] {
+				t.Errorf("%s %s: operationId %v repetido", metodo, camino, id)
+			}
+			ids[id] = true
+		}
+	}
+
+	esquemas := doc["components"].(map[string]any)["schemas"].(esquemasAPI)
+	for clave := range camposAPI {
+		tipo, campo, _ := strings.Cut(clave, ".")
+		esquema, ok := esquemas[tipo].(map[string]any)
+		if !ok {
+			t.Errorf("camposAPI[%q]: el tipo %s no aparece en el documento", clave, tipo)
+			continue
+		}
+		if _, ok := esquema["properties"].(map[string]any)[campo]; !ok {
+			t.Errorf("camposAPI[%q]: %s no tiene el campo %s", clave, tipo, campo)
+		}
+	}
+}
+
+// documentoPublicado descarga y decodifica /api/openapi.json.
+func documentoPublicado(t *testing.T, c *clientePrueba) map[string]any {
+	t.Helper()
+	resp := c.api(http.MethodGet, "/api/openapi.json", "", nil)
+	esperarEstado(t, resp, http.StatusOK)
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		t.Errorf("Content-Type = %q", resp.Header.Get("Content-Type"))
+	}
+	return leerRespuesta[map[string]any](t, resp)
+}
+
+// en recorre el documento decodificado por claves de objetos.
+func en(v any, claves ...string) any {
+	for _, k := range claves {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil
+		}
+		v = m[k]
+	}
+	return v
+}
+
+func TestOpenAPIPublicado(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		doc := documentoPublicado(t, c)
+		if doc["openapi"] != "3.0.3" {
+			t.Fatalf("openapi = %v", doc["openapi"])
+		}
+
+		crear := en(doc, "paths", "/api/v1/libros", "post")
+		if en(crear, "operationId") != "CrearLibro" || en(crear, "description") != "Sólo para los roles: admin." {
+			t.Errorf("POST /api/v1/libros: %v", crear)
+		}
+		if ref := en(crear, "requestBody", "content", "application/json", "schema", "$ref"); ref != "#/components/schemas/Libro" {
+			t.Errorf("cuerpo de POST /api/v1/libros: %v", ref)
+		}
+		for _, estado := range []string{"201", "400", "401", "403"} {
+			if en(crear, "responses", estado) == nil {
+				t.Errorf("POST /api/v1/libros no documenta la respuesta %s", estado)
+			}
+		}
+		if en(doc, "paths", "/api/v1/libros", "get", "security") != nil {
+			t.Errorf("GET /api/v1/libros es público y no debería pedir token")
+		}
+		if en(doc, "paths", "/api/v1/prestamos", "get", "security") == nil {
+			t.Errorf("GET /api/v1/prestamos debería pedir token")
+		}
+		if en(doc, "paths", "/api/v1/prestamos/{id}/renovacion", "post", "parameters") == nil {
+			t.Errorf("falta el parámetro id de la renovación")
+		}
+
+		libro := en(doc, "components", "schemas", "Libro", "properties")
+		if en(libro, "copias", "readOnly") != true || en(libro, "restringido", "type") != "boolean" {
+			t.Errorf("esquema de Libro: %v", libro)
+		}
+		if en(doc, "components", "schemas", "Persona", "properties", "contrasena") != nil {
+			t.Errorf("el esquema de Persona no debe incluir la contraseña")
+		}
+		if estados := en(doc, "components", "schemas", "Prestamo", "properties", "estado", "enum"); len(estados.([]any)) != len(estadosPrestamo) {
+			t.Errorf("estados de Prestamo: %v", estados)
+		}
+	})
+}
+
+// cumpleEsquema comprueba que obj tenga los campos obligatorios del esquema
+// del componente, ningún campo desconocido y valores del enum.
+func cumpleEsquema(t *testing.T, doc map[string]any, componente string, obj map[string]any) {
+	t.Helper()
+	esquema := en(doc, "components", "schemas", componente)
+	propiedades, _ := en(esquema, "properties").(map[string]any)
+	requeridos, _ := en(esquema, "required").([]any)
+	for _, r := range requeridos {
+		if _, ok := obj[r.(string)]; !ok {
+			t.Errorf("%s: falta el campo obligatorio %s en %v", componente, r, obj)
+		}
+	}
+	for campo, valor := range obj {
+		if _, ok := propiedades[campo]; !ok {
+			t.Errorf("%s: el campo %s no está en el esquema", componente, campo)
+			continue
+		}
+		if valores, ok := en(propiedades[campo], "enum").([]any); ok && !slices.Contains(valores, valor) {
+			t.Errorf("%s.%s = %v, fuera de %v", componente, campo, valor, valores)
+		}
+	}
+}
+
+func TestRespuestasCumplenElEsquema(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		crearPersona(t, "ana", "clave", "usuario")
+		beto := crearPersona(t, "beto", "clave", "usuario")
+		libro := crearLibro(t, "Rayuela", 1)
+		token := tokenDe(t, c, "ana", "clave")
+		doc := documentoPublicado(t, c)
+
+		objeto := func(resp respuestaPrueba) map[string]any {
+			t.Helper()
+			return leerRespuesta[map[string]any](t, resp)
+		}
+		cumpleEsquema(t, doc, "Libro", objeto(c.api(http.MethodGet, "/api/v1/libros/"+libro.ID, "", nil)))
+		prestados := objeto(c.api(http.MethodPost, "/api/v1/prestamos", token, prestamoAPI{LibroIDs: []string{libro.ID}}))
+		prestado := prestados["datos"].([]any)[0].(map[string]any)
+		cumpleEsquema(t, doc, "Prestamo", prestado)
+		// Las fechas en cero no se envían
+		for _, campo := range []string{"fechaDevolucion", "ultimaRenovacion", "retirarHasta"} {
+			if v, ok := prestado[campo]; ok {
+				t.Errorf("un préstamo activo trae %s = %v", campo, v)
+			}
+		}
+
+		tokenBeto := tokenDe(t, c, "beto", "clave")
+		cumpleEsquema(t, doc, "Persona", objeto(c.api(http.MethodGet, "/api/v1/personas/"+beto.ID, tokenBeto, nil)))
+		reserva := objeto(c.api(http.MethodPost, "/api/v1/reservas", tokenBeto, reservaAPI{LibroID: libro.ID}))
+		cumpleEsquema(t, doc, "Reserva", reserva)
+		if v, ok := reserva["disponibleHasta"]; ok {
+			t.Errorf("una reserva en espera trae disponibleHasta = %v", v)
+		}
+		tokens := objeto(c.api(http.MethodGet, "/api/v1/tokens", tokenBeto, nil))
+		cumpleEsquema(t, doc, "TokenAPI", tokens["datos"].([]any)[0].(map[string]any))
+
+		e := objeto(c.api(http.MethodPost, "/api/v1/prestamos", tokenBeto, prestamoAPI{LibroIDs: []string{libro.ID}}))
+		cumpleEsquema(t, doc, "ErrorAPI", e)
+		cumpleEsquema(t, doc, "DetalleErrorAPI", e["error"].(map[string]any))
+
+		// Una petición sin los campos opcionales también cumple el esquema
+		cuerpo, _ := json.Marshal(credencialesAPI{Nombre: "ana", Contrasena: "clave"})
+		var credenciales map[string]any
+		json.Unmarshal(cuerpo, &credenciales)
+		cumpleEsquema(t, doc, "credencialesAPI", credenciales)
+	})
+}
+
+func TestObligatoriosSonLosQuePidenLosHandlers(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		doc := documentoPublicado(t, c)
+		requeridos := func(componente string) []string {
+			var nombres []string
+			for _, r := range en(doc, "components", "schemas", componente, "required").([]any) {
+				nombres = append(nombres, r.(string))
+			}
+			return nombres
+		}
+		for componente, esperado := range map[string][]string{
+			"credencialesAPI": {"nombre", "contrasena"},
+			"Libro":           {"nombre"},
+			"personaAPI":      {"nombre", "cedula", "contrasena"},
+			"prestamoAPI":     {"libroIDs"},
+			"devolucionAPI":   {"prestamoID"},
+			"reservaAPI":      {"libroID"},
+		} {
+			if obtenido := requeridos(componente); !slices.Equal(obtenido, esperado) {
+				t.Errorf("%s: required = %q, se esperaba %q", componente, obtenido, esperado)
+			}
+		}
+		// Las respuestas pueden traer fechas en cero u omitir campos, así que
+		// sus esquemas no exigen nada
+		for _, componente := range []string{"Prestamo", "Persona", "Reserva"} {
+			if r := en(doc, "components", "schemas", componente, "required"); r != nil {
+				t.Errorf("%s: required = %v", componente, r)
+			}
+		}
+
+		// Con los campos obligatorios basta; sin ellos, el handler rechaza la
+		// petición
+		crearPersona(t, "admin", "clave", "admin")
+		admin := tokenDe(t, c, "admin", "clave")
+		esperarEstado(t, c.api(http.MethodPost, "/api/v1/libros", admin, map[string]any{"nombre": "Aura"}), http.StatusCreated)
+		esperarErrorAPI(t, c.api(http.MethodPost, "/api/v1/libros", admin, map[string]any{"autor": "Carlos Fuentes"}), http.StatusBadRequest, "datos_invalidos")
+		persona := map[string]any{"nombre": "beto", "cedula": "2", "contrasena": "clave"}
+		esperarEstado(t, c.api(http.MethodPost, "/api/v1/personas", admin, persona), http.StatusCreated)
+		delete(persona, "cedula")
+		esperarErrorAPI(t, c.api(http.MethodPost, "/api/v1/personas", admin, persona), http.StatusBadRequest, "datos_invalidos")
+	})
+}
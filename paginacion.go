@@ -0,0 +1,300 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"maps"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrOrdenInvalido  = errors.New("orden desconocido")
+	ErrCursorInvalido = errors.New("cursor inválido o de otro orden")
+	ErrLimiteInvalido = errors.New("el límite debe ser un número positivo")
+)
+
+// maxLimitePagina es el mayor límite que se acepta en una petición.
+const maxLimitePagina = 100
+
+// Campos por los que se pueden ordenar los listados, por su nombre en JSON,
+// que es también el de la columna SQL y el del campo en Firestore. Los dos
+// tienen ordenPorDefecto.
+var (
+	ordenesLibro = map[string]func(Libro) any{
+		"nombre": func(l Libro) any { return l.Nombre },
+		"autor":  func(l Libro) any { return l.Autor },
+		"ano":    func(l Libro) any { return l.Ano },
+		"copias": func(l Libro) any { return l.Copias },
+	}
+	ordenesPersona = map[string]func(Persona) any{
+		"nombre": func(p Persona) any { return p.Nombre },
+		"cedula": func(p Persona) any { return p.Cedula },
+		"ano":    func(p Persona) any { return p.Ano },
+	}
+)
+
+// ordenPorDefecto es el orden de los listados cuando no se pide otro.
+const ordenPorDefecto = "nombre"
+
+// ordenRelevancia ordena una búsqueda de libros del resultado más relevante
+// al menos relevante; es el orden por defecto cuando hay búsqueda. Su valor
+// en el cursor es la posición del último resultado entregado.
+const ordenRelevancia = "relevancia"
+
+// maxPosicionRelevancia es la mayor posición que se acepta en un cursor por
+// relevancia; el índice reserva memoria según la posición pedida, así que no
+// se pagina más allá.
+const maxPosicionRelevancia = 10000
+
+// ordenesBusqueda son los órdenes de una búsqueda de libros.
+var ordenesBusqueda = func() map[string]func(Libro) any {
+	ordenes := maps.Clone(ordenesLibro)
+	ordenes[ordenRelevancia] = func(Libro) any { return 0 }
+	return ordenes
+}()
+
+// compararPosiciones ordena por valor y, a igual valor, por ID. Los valores
+// de una misma consulta son todos string o todos int.
+func compararPosiciones(a, b PosicionPagina) int {
+	var c int
+	switch va := a.Valor.(type) {
+	case string:
+		vb, _ := b.Valor.(string)
+		c = strings.Compare(va, vb)
+	case int:
+		vb, _ := b.Valor.(int)
+		c = cmp.Compare(va, vb)
+	}
+	if c != 0 {
+		return c
+	}
+	return strings.Compare(a.ID, b.ID)
+}
+
+// cursorPagina es el contenido de un cursor: la posición del último
+// elemento entregado y el orden en que se entregó, para rechazar el cursor
+// si se usa con otro orden.
+type cursorPagina struct {
+	Orden string `json:"o"`
+	Desc  bool   `json:"d,omitempty"`
+	Valor any    `json:"v"`
+	ID    string `json:"id"`
+}
+
+// codificarCursor devuelve el cursor opaco que sigue a posicion.
+func codificarCursor(consulta ConsultaPagina, posicion PosicionPagina) string {
+	datos, _ := json.Marshal(cursorPagina{consulta.Orden, consulta.Desc, posicion.Valor, posicion.ID})
+	return base64.RawURLEncoding.EncodeToString(datos)
+}
+
+// decodificarCursor lee un cursor de codificarCursor. cero es el valor del
+// campo de orden en un elemento vacío y da el tipo que debe tener el valor.
+func decodificarCursor(cursor string, consulta ConsultaPagina, cero any) (*PosicionPagina, error) {
+	datos, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrCursorInvalido
+	}
+	var c cursorPagina
+	if err := json.Unmarshal(datos, &c); err != nil || c.Orden != consulta.Orden || c.Desc != consulta.Desc || c.ID == "" {
+		return nil, ErrCursorInvalido
+	}
+	switch cero.(type) {
+	case string:
+		if _, ok := c.Valor.(string); !ok {
+			return nil, ErrCursorInvalido
+		}
+	case int:
+		// JSON decodifica los números como float64; fuera del rango de int32
+		// no hay ningún año ni número de copias
+		n, ok := c.Valor.(float64)
+		if !ok || n != math.Trunc(n) || n < math.MinInt32 || n > math.MaxInt32 {
+			return nil, ErrCursorInvalido
+		}
+		if c.Orden == ordenRelevancia && (n < 0 || n > maxPosicionRelevancia) {
+			return nil, ErrCursorInvalido
+		}
+		c.Valor = int(n)
+	}
+	return &PosicionPagina{Valor: c.Valor, ID: c.ID}, nil
+}
+
+// leerPagina arma la consulta con los parámetros orden (un campo de
+// ordenes; con "-" delante, descendente; por defecto porDefecto), cursor y
+// limite de la query string.
+func leerPagina[T any](q url.Values, ordenes map[string]func(T) any, porDefecto string) (ConsultaPagina, error) {
+	consulta := ConsultaPagina{Orden: porDefecto, Limite: Configuracion.TamanoPagina}
+	if orden := q.Get("orden"); orden != "" {
+		consulta.Orden, consulta.Desc = strings.CutPrefix(orden, "-")
+	}
+	valor, ok := ordenes[consulta.Orden]
+	if !ok {
+		return consulta, ErrOrdenInvalido
+	}
+	if limite := q.Get("limite"); limite != "" {
+		n, err := strconv.Atoi(limite)
+		if err != nil || n <= 0 {
+			return consulta, ErrLimiteInvalido
+		}
+		consulta.Limite = min(n, maxLimitePagina)
+	}
+	if cursor := q.Get("cursor"); cursor != "" {
+		var cero T
+		despues, err := decodificarCursor(cursor, consulta, valor(cero))
+		if err != nil {
+			return consulta, err
+		}
+		consulta.Despues = despues
+	}
+	return consulta, nil
+}
+
+// paramOrden es el valor del parámetro orden que pide la consulta.
+func paramOrden(consulta ConsultaPagina) string {
+	if consulta.Desc {
+		return "-" + consulta.Orden
+	}
+	return consulta.Orden
+}
+
+// paginar pide al store lotes de la consulta hasta juntar consulta.Limite
+// elementos que cumplan incluir (nil los incluye todos). Devuelve también
+// el cursor de la página siguiente, vacío si no quedan más elementos.
+func paginar[T any](ctx context.Context, consulta ConsultaPagina, pedir func(context.Context, ConsultaPagina) ([]T, error), posicion func(T) PosicionPagina, incluir func(T) bool) ([]T, string, error) {
+	// Se pide uno más de la cuenta para saber si hay otra página
+	lote := consulta
+	lote.Limite = consulta.Limite + 1
+	pagina := []T{}
+	for {
+		valores, err := pedir(ctx, lote)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, v := range valores {
+			if incluir != nil && !incluir(v) {
+				continue
+			}
+			if len(pagina) == consulta.Limite {
+				return pagina, codificarCursor(consulta, posicion(pagina[len(pagina)-1])), nil
+			}
+			pagina = append(pagina, v)
+		}
+		if len(valores) < lote.Limite {
+			return pagina, "", nil
+		}
+		ultimo := posicion(valores[len(valores)-1])
+		lote.Despues = &ultimo
+	}
+}
+
+// paginaLibros devuelve la página de libros que pide r y el cursor de la
+// siguiente. Si hay búsqueda (parámetro q), sólo incluye los libros que
+// encuentra el índice del catálogo, con sus fragmentos.
+func paginaLibros(r *http.Request) ([]LibroEncontrado, ConsultaPagina, string, error) {
+	params := r.URL.Query()
+	q := params.Get("q")
+	if q == "" {
+		if params.Get("orden") == ordenRelevancia {
+			params.Del("orden") // Sin búsqueda no hay relevancia
+		}
+		consulta, err := leerPagina(params, ordenesLibro, ordenPorDefecto)
+		if err != nil {
+			return nil, consulta, "", err
+		}
+		libros, siguiente, err := paginarLibros(r.Context(), consulta, nil)
+		return encontrados(libros, nil), consulta, siguiente, err
+	}
+
+	consulta, err := leerPagina(params, ordenesBusqueda, ordenRelevancia)
+	if err != nil {
+		return nil, consulta, "", err
+	}
+	if consulta.Orden == ordenRelevancia {
+		if consulta.Desc {
+			return nil, consulta, "", ErrOrdenInvalido
+		}
+		libros, siguiente, err := paginaRelevancia(r.Context(), q, consulta)
+		return libros, consulta, siguiente, err
+	}
+	coincidencias, err := Catalogo.Coincidencias(q)
+	if err != nil {
+		return nil, consulta, "", err
+	}
+	libros, siguiente, err := paginarLibros(r.Context(), consulta, func(l Libro) bool {
+		_, ok := coincidencias[l.ID]
+		return ok
+	})
+	return encontrados(libros, coincidencias), consulta, siguiente, err
+}
+
+// paginarLibros pagina los libros del store en el orden de la consulta.
+func paginarLibros(ctx context.Context, consulta ConsultaPagina, incluir func(Libro) bool) ([]Libro, string, error) {
+	valor := ordenesLibro[consulta.Orden]
+	return paginar(ctx, consulta, DB.Libros().Pagina,
+		func(l Libro) PosicionPagina { return PosicionPagina{valor(l), l.ID} }, incluir)
+}
+
+// encontrados agrega a cada libro sus fragmentos de la búsqueda.
+func encontrados(libros []Libro, fragmentos map[string]map[string]string) []LibroEncontrado {
+	resultado := make([]LibroEncontrado, len(libros))
+	for i, l := range libros {
+		resultado[i] = LibroEncontrado{Libro: l, Fragmentos: fragmentos[l.ID]}
+	}
+	return resultado
+}
+
+// paginaRelevancia devuelve la página de la búsqueda q en orden de
+// relevancia. Los libros que el índice encuentra pero ya no están en el
+// store se omiten.
+func paginaRelevancia(ctx context.Context, q string, consulta ConsultaPagina) ([]LibroEncontrado, string, error) {
+	desde := 0
+	if consulta.Despues != nil {
+		desde = consulta.Despues.Valor.(int) + 1
+	}
+	resultados, err := Catalogo.Buscar(q, desde, consulta.Limite+1)
+	if err != nil {
+		return nil, "", err
+	}
+	siguiente := ""
+	if len(resultados) > consulta.Limite {
+		resultados = resultados[:consulta.Limite]
+		ultimo := len(resultados) - 1
+		siguiente = codificarCursor(consulta, PosicionPagina{desde + ultimo, resultados[ultimo].ID})
+	}
+	libros := []LibroEncontrado{}
+	for _, res := range resultados {
+		libro, err := DB.Libros().Obtener(ctx, res.ID)
+		if errors.Is(err, ErrNoEncontrado) {
+			continue
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		libros = append(libros, LibroEncontrado{Libro: *libro, Fragmentos: res.Fragmentos})
+	}
+	return libros, siguiente, nil
+}
+
+// paginaPersonas devuelve la página de personas que pide r y el cursor de
+// la siguiente.
+func paginaPersonas(r *http.Request) ([]Persona, ConsultaPagina, string, error) {
+	consulta, err := leerPagina(r.URL.Query(), ordenesPersona, ordenPorDefecto)
+	if err != nil {
+		return nil, consulta, "", err
+	}
+	valor := ordenesPersona[consulta.Orden]
+	personas, siguiente, err := paginar(r.Context(), consulta, DB.Personas().Pagina,
+		func(p Persona) PosicionPagina { return PosicionPagina{valor(p), p.ID} }, nil)
+	return personas, consulta, siguiente, err
+}
+
+// esErrorPagina indica si err se debe a parámetros de paginación inválidos.
+func esErrorPagina(err error) bool {
+	return errors.Is(err, ErrOrdenInvalido) || errors.Is(err, ErrCursorInvalido) || errors.Is(err, ErrLimiteInvalido)
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"deber3/client"
+)
+
+// librosParaPaginar crea libros con años y copias repetidos, para que el
+// desempate por ID importe.
+func librosParaPaginar(t *testing.T) []Libro {
+	t.Helper()
+	var libros []Libro
+	for i, nombre := range []string{"Rayuela", "Ficciones", "Aura", "Pedro Páramo", "Ficciones", "Bestiario", "Aleph"} {
+		l := &Libro{Nombre: nombre, Autor: fmt.Sprintf("Autor %d", i%3), Ano: 1940 + i%2*10}
+		if err := registrarLibro(context.Background(), DB, l, i%3); err != nil {
+			t.Fatalf("creando libro: %v", err)
+		}
+		libros = append(libros, *obtenerLibro(t, l.ID))
+	}
+	return libros
+}
+
+// ordenEsperado ordena los libros como deben salir de la consulta.
+func ordenEsperado(libros []Libro, orden string, desc bool) []string {
+	valor := ordenesLibro[orden]
+	libros = slices.Clone(libros)
+	slices.SortFunc(libros, func(a, b Libro) int {
+		c := compararPosiciones(PosicionPagina{valor(a), a.ID}, PosicionPagina{valor(b), b.ID})
+		if desc {
+			return -c
+		}
+		return c
+	})
+	var ids []string
+	for _, l := range libros {
+		ids = append(ids, l.ID)
+	}
+	return ids
+}
+
+func TestPaginaDelStore(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		ctx := context.Background()
+		libros := librosParaPaginar(t)
+		for orden := range ordenesLibro {
+			for _, desc := range []bool{false, true} {
+				consulta := ConsultaPagina{Orden: orden, Desc: desc, Limite: 2}
+				var ids []string
+				for {
+					pagina, err := DB.Libros().Pagina(ctx, consulta)
+					if err != nil {
+						t.Fatal(err)
+					}
+					for _, l := range pagina {
+						ids = append(ids, l.ID)
+					}
+					if len(pagina) < consulta.Limite {
+						break
+					}
+					ultimo := pagina[len(pagina)-1]
+					consulta.Despues = &PosicionPagina{ordenesLibro[orden](ultimo), ultimo.ID}
+				}
+				if esperado := ordenEsperado(libros, orden, desc); !slices.Equal(ids, esperado) {
+					t.Errorf("orden %s desc=%v: %v, se esperaba %v", orden, desc, ids, esperado)
+				}
+			}
+		}
+		if _, err := DB.Libros().Pagina(ctx, ConsultaPagina{Orden: "total; DROP TABLE libro", Limite: 1}); !errors.Is(err, ErrOrdenInvalido) {
+			t.Errorf("orden desconocido: err = %v", err)
+		}
+	})
+}
+
+// recorrerAPI sigue el cursor de siguiente desde ruta y devuelve los IDs de
+// todas las páginas.
+func recorrerAPI(t *testing.T, c *clientePrueba, ruta, token string) []string {
+	t.Helper()
+	var ids []string
+	cursor := ""
+	for paginas := 0; paginas < 20; paginas++ {
+		siguiente := ruta
+		if cursor != "" {
+			siguiente += "&cursor=" + url.QueryEscape(cursor)
+		}
+		resp := c.api(http.MethodGet, siguiente, token, nil)
+		esperarEstado(t, resp, http.StatusOK)
+		lista := leerRespuesta[ListaAPI[struct {
+			ID string `json:"id"`
+		}]](t, resp)
+		for _, p := range lista.Datos {
+			ids = append(ids, p.ID)
+		}
+		if lista.Siguiente == "" {
+			return ids
+		}
+		cursor = lista.Siguiente
+	}
+	t.Fatalf("%s: la paginación no termina", ruta)
+	return nil
+}
+
+func TestAPIPaginada(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		libros := librosParaPaginar(t)
+		if ids := recorrerAPI(t, c, "/api/v1/libros?orden=-ano&limite=3", ""); !slices.Equal(ids, ordenEsperado(libros, "ano", true)) {
+			t.Errorf("libros por año descendente: %v", ids)
+		}
+		if ids := recorrerAPI(t, c, "/api/v1/libros?limite=2", ""); !slices.Equal(ids, ordenEsperado(libros, "nombre", false)) {
+			t.Errorf("libros por nombre: %v", ids)
+		}
+
+		// La búsqueda se aplica antes de cortar las páginas
+		var ficciones []string
+		for _, l := range libros {
+			if l.Nombre == "Ficciones" {
+				ficciones = append(ficciones, l.ID)
+			}
+		}
+		slices.Sort(ficciones)
+		if ids := recorrerAPI(t, c, "/api/v1/libros?q=ficc&limite=1", ""); !slices.Equal(ids, ficciones) {
+			t.Errorf("búsqueda paginada: %v, se esperaba %v", ids, ficciones)
+		}
+
+		primera := leerRespuesta[ListaAPI[Libro]](t, c.api(http.MethodGet, "/api/v1/libros?orden=autor&limite=2", "", nil))
+		if len(primera.Datos) != 2 || primera.Siguiente == "" {
+			t.Fatalf("primera página: %+v", primera)
+		}
+		for _, ruta := range []string{
+			"/api/v1/libros?orden=total",
+			"/api/v1/libros?limite=0",
+			"/api/v1/libros?cursor=no-es-un-cursor",
+			"/api/v1/libros?orden=-autor&cursor=" + primera.Siguiente, // Cursor de otro orden
+		} {
+			esperarErrorAPI(t, c.api(http.MethodGet, ruta, "", nil), http.StatusBadRequest, "datos_invalidos")
+		}
+
+		crearPersona(t, "admin", "clave", "admin")
+		for _, nombre := range []string{"carla", "ana", "beto", "dora"} {
+			crearPersona(t, nombre, "clave", "usuario")
+		}
+		token := tokenDe(t, c, "admin", "clave")
+		personas, _ := DB.Personas().Listar(context.Background())
+		slices.SortFunc(personas, func(a, b Persona) int { return strings.Compare(b.Cedula, a.Cedula) })
+		var esperado []string
+		for _, p := range personas {
+			esperado = append(esperado, p.ID)
+		}
+		if ids := recorrerAPI(t, c, "/api/v1/personas?orden=-cedula&limite=2", token); !slices.Equal(ids, esperado) {
+			t.Errorf("personas por cédula descendente: %v, se esperaba %v", ids, esperado)
+		}
+	})
+}
+
+// getAJAX pide la ruta como los fetch de las plantillas.
+func (c *clientePrueba) getAJAX(ruta string) respuestaPrueba {
+	c.t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, c.srv.URL+ruta, nil)
+	req.Header.Set("X-Requested-With", "XMLHttpRequest")
+	return c.hacer(req)
+}
+
+func TestVistasPaginadas(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		libros := librosParaPaginar(t)
+		esperado := ordenEsperado(libros, "copias", true)
+
+		resp := c.get("/libros?orden=-copias&limite=4")
+		esperarEstado(t, resp, http.StatusOK)
+		if !strings.Contains(resp.Cuerpo, `<option value="-copias" selected>`) {
+			t.Error("el selector debe mostrar el orden actual")
+		}
+		ajax := leerRespuesta[LibrosResponse](t, c.getAJAX("/libros?orden=-copias&limite=4"))
+		if len(ajax.Libros) != 4 || ajax.Libros[0].ID != esperado[0] || ajax.Siguiente == "" {
+			t.Fatalf("primera página AJAX: %+v", ajax)
+		}
+		if !strings.Contains(resp.Cuerpo, "cursor="+ajax.Siguiente) {
+			t.Error("falta el enlace a la página siguiente")
+		}
+
+		resp = c.get("/libros?orden=-copias&limite=4&cursor=" + ajax.Siguiente)
+		esperarEstado(t, resp, http.StatusOK)
+		if !strings.Contains(resp.Cuerpo, "Volver al inicio") {
+			t.Error("falta el enlace a la primera página")
+		}
+		ajax = leerRespuesta[LibrosResponse](t, c.getAJAX("/libros?orden=-copias&limite=4&cursor="+ajax.Siguiente))
+		if len(ajax.Libros) != 3 || ajax.Libros[0].ID != esperado[4] || ajax.Siguiente != "" {
+			t.Errorf("última página AJAX: %+v", ajax)
+		}
+		esperarEstado(t, c.get("/libros?cursor=basura"), http.StatusBadRequest)
+
+		crearPersona(t, "admin", "clave", "admin")
+		crearPersona(t, "beto", "clave", "usuario")
+		c.login("admin", "clave")
+		personas := leerRespuesta[PersonasResponse](t, c.getAJAX("/personas?limite=1"))
+		if len(personas.Personas) != 1 || personas.Personas[0].Nombre != "admin" || personas.Siguiente == "" {
+			t.Fatalf("personas AJAX: %+v", personas)
+		}
+		resp = c.get("/personas?limite=1&cursor=" + personas.Siguiente)
+		if !strings.Contains(resp.Cuerpo, "beto") || strings.Contains(resp.Cuerpo, "Página siguiente") {
+			t.Errorf("segunda página de personas:\n%s", resp.Cuerpo)
+		}
+	})
+}
+
+func TestClienteRecorreLasPaginas(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		for i := range Configuracion.TamanoPagina + 5 {
+			crearLibro(t, fmt.Sprintf("Libro %02d", i), 1)
+		}
+		libros, err := client.Nuevo(c.srv.URL, "").Libros(context.Background(), client.ConsultaLibros{})
+		if err != nil || len(libros) != Configuracion.TamanoPagina+5 {
+			t.Fatalf("Libros = %d libros, %v", len(libros), err)
+		}
+		if libros[0].Nombre != "Libro 00" || libros[len(libros)-1].Nombre != fmt.Sprintf("Libro %02d", Configuracion.TamanoPagina+4) {
+			t.Errorf("orden: %s ... %s", libros[0].Nombre, libros[len(libros)-1].Nombre)
+		}
+	})
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+)
+
+// Errores de las operaciones sobre préstamos.
+var (
+	ErrNoAutorizado    = errors.New("no autorizado para este préstamo")
+	ErrPrestamoCerrado = errors.New("el préstamo ya fue devuelto")
+	ErrMaxRenovaciones = errors.New("se alcanzó el máximo de renovaciones")
+	ErrLibroReservado  = errors.New("otra persona reservó el libro")
+	ErrLimitePrestamos = errors.New("se alcanzó el límite de préstamos simultáneos")
+	ErrTieneVencidos   = errors.New("la persona tiene préstamos vencidos")
+	ErrLibroRepetido   = errors.New("el libro está más de una vez en el carrito")
+	// ErrCarritoRechazado indica que algún libro del carrito no se pudo
+	// prestar; el motivo de cada uno está en su ResultadoCarrito.
+	ErrCarritoRechazado = errors.New("no se pudieron prestar todos los libros del carrito")
+)
+
+// fechaVencimiento calcula la fecha límite de un préstamo hecho en fecha
+// según el plazo configurado.
+func fechaVencimiento(fecha time.Time) time.Time {
+	return fecha.AddDate(0, 0, Configuracion.DiasPrestamo)
+}
+
+// verificarPuedePedir comprueba que la persona pueda pedir los libros dados
+// en fecha: que no tenga préstamos vencidos ni multas sin pagar y que no
+// supere el límite de préstamos simultáneos de su rol. Las solicitudes
+// pendientes cuentan para el límite, y retirar una solicitud aprobada no
+// suma un préstamo. Devuelve las solicitudes pendientes de la persona. Sólo
+// lee, así que va antes de las escrituras de la transacción.
+func verificarPuedePedir(ctx context.Context, tx Store, personaID string, libroIDs []string, fecha time.Time) ([]Prestamo, error) {
+	persona, err := tx.Personas().Obtener(ctx, personaID)
+	if err != nil {
+		return nil, err
+	}
+	activos, err := tx.Prestamos().ActivosPorPersona(ctx, personaID)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range activos {
+		if p.DiasDeAtraso(fecha) > 0 {
+			return nil, ErrTieneVencidos
+		}
+	}
+	pendientes, err := tx.Prestamos().Historial(ctx, FiltroPrestamos{PersonaID: personaID, Estados: []string{EstadoSolicitado, EstadoAprobado}})
+	if err != nil {
+		return nil, err
+	}
+	movimientos, err := tx.Multas().PorPersona(ctx, personaID)
+	if err != nil {
+		return nil, err
+	}
+	if saldoMultas(movimientos) > 0 {
+		return nil, ErrMultasPendientes
+	}
+
+	nuevos := 0
+	for _, libroID := range libroIDs {
+		if !slices.ContainsFunc(pendientes, func(p Prestamo) bool { return p.LibroID == libroID && p.Estado == EstadoAprobado }) {
+			nuevos++
+		}
+	}
+	if limite := Configuracion.LimitePrestamos(persona.Rol); limite > 0 && len(activos)+len(pendientes)+nuevos > limite {
+		return nil, ErrLimitePrestamos
+	}
+	return pendientes, nil
+}
+
+// ResultadoCarrito es lo que pasó con un libro del carrito.
+type ResultadoCarrito struct {
+	LibroID     string
+	LibroNombre string
+	Prestamo    *Prestamo // nil si no se registró; en estado solicitado si el libro es restringido
+	Err         error     // Por qué no se puede prestar este libro; nil si el problema fue otro libro
+}
+
+// prestarLibros presta todos los libros del carrito a personaID en una sola
+// transacción, con vencimiento según el plazo configurado: o se registran
+// todos o ninguno. Cada préstamo usa una copia libre del libro o, si la
+// persona tiene una copia apartada por una reserva o por una solicitud
+// aprobada, esa copia; la reserva queda cumplida y la solicitud pasa a
+// activa. De los libros restringidos se registra una solicitud, que no
+// ocupa ninguna copia hasta que se aprueba.
+//
+// Si algún libro no se puede prestar, devuelve ErrCarritoRechazado y en los
+// resultados el motivo de cada libro rechazado. Si el problema es de la
+// persona (ver verificarPuedePedir), devuelve ese error.
+func prestarLibros(ctx context.Context, store Store, libroIDs []string, personaID string, fecha time.Time) ([]ResultadoCarrito, error) {
+	var resultados []ResultadoCarrito
+	err := store.RunTransaction(ctx, func(ctx context.Context, tx Store) error {
+		resultados = make([]ResultadoCarrito, len(libroIDs))
+
+		// 1. Verificar a la persona y leer las copias de cada libro y su cola
+		// de reservas, todo antes de escribir
+		pendientes, err := verificarPuedePedir(ctx, tx, personaID, libroIDs, fecha)
+		if err != nil {
+			return err
+		}
+		inventarios := make([]*inventario, len(libroIDs))
+		rechazado := false
+		for i, libroID := range libroIDs {
+			resultados[i].LibroID = libroID
+			if slices.Contains(libroIDs[:i], libroID) {
+				resultados[i].Err, rechazado = ErrLibroRepetido, true
+				continue
+			}
+			inv, err := leerInventario(ctx, tx, libroID)
+			if errors.Is(err, ErrNoEncontrado) {
+				resultados[i].Err, rechazado = err, true
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			inventarios[i] = inv
+			resultados[i].LibroNombre = inv.libro.Nombre
+		}
+
+		// 2. Decidir qué se registra de cada libro y con qué copia
+		ejemplares := make([]string, len(libroIDs))
+		reservas := make([]*Reserva, len(libroIDs))     // Reserva propia que cada préstamo cumple
+		solicitudes := make([]*Prestamo, len(libroIDs)) // Solicitud propia que cada préstamo retira
+		for i, inv := range inventarios {
+			if inv == nil {
+				continue
+			}
+			for j := range pendientes {
+				if pendientes[j].LibroID == libroIDs[i] {
+					solicitudes[i] = &pendientes[j]
+				}
+			}
+			reservas[i] = inv.reservaDe(personaID)
+			switch {
+			case solicitudes[i] != nil && solicitudes[i].Estado == EstadoAprobado:
+				ejemplares[i] = solicitudes[i].EjemplarID
+			case solicitudes[i] != nil:
+				resultados[i].Err, rechazado = ErrSolicitudPendiente, true
+			case inv.libro.Restringido:
+				// Se registra la solicitud; la copia (la de su reserva, si la tiene
+				// apartada) se elige al aprobarla
+			case reservas[i] != nil && reservas[i].Estado == ReservaLista:
+				ejemplares[i] = reservas[i].EjemplarID
+			default:
+				if libres := inv.libres(); len(libres) > 0 {
+					ejemplares[i] = libres[0].ID
+				} else {
+					resultados[i].Err, rechazado = ErrSinCopias, true
+				}
+			}
+		}
+		if rechazado {
+			return ErrCarritoRechazado
+		}
+
+		// 3. Registrar los préstamos y solicitudes y actualizar cada libro:
+		// las copias disponibles salen de los ejemplares libres
+		for i, inv := range inventarios {
+			if solicitud := solicitudes[i]; solicitud != nil {
+				prestamo := *solicitud
+				prestamo.Estado = EstadoActivo
+				prestamo.FechaPrestamo = fecha
+				prestamo.FechaVencimiento = fechaVencimiento(fecha)
+				prestamo.RetirarHasta = time.Time{}
+				if err := tx.Prestamos().Guardar(ctx, &prestamo); err != nil {
+					return err
+				}
+				resultados[i].Prestamo = &prestamo
+				continue
+			}
+			if inv.libro.Restringido {
+				prestamo := &Prestamo{LibroID: libroIDs[i], PersonaID: personaID, Estado: EstadoSolicitado, FechaPrestamo: fecha}
+				if err := tx.Prestamos().Crear(ctx, prestamo); err != nil {
+					return err
+				}
+				resultados[i].Prestamo = prestamo
+				continue
+			}
+
+			prestamo := &Prestamo{
+				LibroID:          libroIDs[i],
+				EjemplarID:       ejemplares[i],
+				PersonaID:        personaID,
+				Estado:           EstadoActivo,
+				FechaPrestamo:    fecha,
+				FechaVencimiento: fechaVencimiento(fecha),
+			}
+			if err := tx.Prestamos().Crear(ctx, prestamo); err != nil {
+				return err
+			}
+			if reserva := reservas[i]; reserva != nil {
+				if err := inv.cumplirReserva(ctx, tx, *reserva); err != nil {
+					return err
+				}
+			}
+			inv.ocupados[ejemplares[i]] = true
+			if err := inv.guardar(ctx, tx, fecha); err != nil {
+				return err
+			}
+			resultados[i].Prestamo = prestamo
+		}
+		return nil
+	})
+	return resultados, err
+}
+
+// prestarLibro presta un solo libro (ver prestarLibros) y devuelve el motivo
+// concreto si no se pudo.
+func prestarLibro(ctx context.Context, store Store, libroID, personaID string, fecha time.Time) (*Prestamo, error) {
+	resultados, err := prestarLibros(ctx, store, []string{libroID}, personaID, fecha)
+	if errors.Is(err, ErrCarritoRechazado) {
+		return nil, resultados[0].Err
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resultados[0].Prestamo, nil
+}
+
+// devolverLibro cierra el préstamo (estado devuelto y FechaDevolucion) y
+// entrega la copia a la siguiente reserva en espera o, si no hay, la deja
+// disponible, en una sola transacción; el préstamo se conserva como
+// historial y, si se devuelve con atraso, se carga la multa. Sólo el dueño
+// del préstamo o quien gestiona préstamos puede devolverlo; el libro
+// siempre se toma del propio préstamo.
+func devolverLibro(ctx context.Context, store Store, prestamoID string, quien *Persona, fecha time.Time) error {
+	return store.RunTransaction(ctx, func(ctx context.Context, tx Store) error {
+		prestamo, err := tx.Prestamos().Obtener(ctx, prestamoID)
+		if err != nil {
+			return err
+		}
+		if prestamo.PersonaID != quien.ID && !quien.GestionaPrestamos() {
+			return ErrNoAutorizado
+		}
+		if prestamo.Estado != EstadoActivo {
+			return ErrPrestamoCerrado
+		}
+		inv, err := leerInventario(ctx, tx, prestamo.LibroID)
+		if err != nil {
+			return err
+		}
+
+		dias := prestamo.DiasDeAtraso(fecha)
+		prestamo.Estado = EstadoDevuelto
+		prestamo.FechaDevolucion = fecha
+		if err := tx.Prestamos().Guardar(ctx, prestamo); err != nil {
+			return err
+		}
+		if err := cargarAtraso(ctx, tx, prestamo, dias, fecha); err != nil {
+			return err
+		}
+		delete(inv.ocupados, prestamo.EjemplarID)
+		return inv.guardar(ctx, tx, fecha)
+	})
+}
+
+// renovarPrestamo extiende el vencimiento de un préstamo activo por otro
+// plazo completo, contado desde el vencimiento actual o desde fecha si ya
+// venció. No se puede renovar más de Configuracion.MaxRenovaciones veces ni
+// mientras otra persona tenga el libro reservado.
+func renovarPrestamo(ctx context.Context, store Store, prestamoID string, quien *Persona, fecha time.Time) (*Prestamo, error) {
+	var prestamo *Prestamo
+	err := store.RunTransaction(ctx, func(ctx context.Context, tx Store) error {
+		var err error
+		prestamo, err = tx.Prestamos().Obtener(ctx, prestamoID)
+		if err != nil {
+			return err
+		}
+		if prestamo.PersonaID != quien.ID && !quien.GestionaPrestamos() {
+			return ErrNoAutorizado
+		}
+		if prestamo.Estado != EstadoActivo {
+			return ErrPrestamoCerrado
+		}
+		if prestamo.Renovaciones >= Configuracion.MaxRenovaciones {
+			return ErrMaxRenovaciones
+		}
+		reservas, err := tx.Reservas().ActivasPorLibro(ctx, prestamo.LibroID)
+		if err != nil {
+			return err
+		}
+		for _, r := range reservas {
+			if r.PersonaID != prestamo.PersonaID {
+				return ErrLibroReservado
+			}
+		}
+
+		desde := prestamo.FechaVencimiento
+		if desde.Before(fecha) {
+			desde = fecha
+		}
+		prestamo.FechaVencimiento = fechaVencimiento(desde)
+		prestamo.Renovaciones++
+		prestamo.UltimaRenovacion = fecha
+		return tx.Prestamos().Guardar(ctx, prestamo)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return prestamo, nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenovarExtiendeElVencimiento(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		ana := crearPersona(t, "ana", "clave", "usuario")
+		prestamo := prestar(t, crearLibro(t, "Rayuela", 1), ana)
+		c.login("ana", "clave")
+
+		resp := c.get("/devoluciones")
+		if !strings.Contains(resp.Cuerpo, `action="/renovar"`) {
+			t.Fatalf("/devoluciones no muestra el botón Renovar")
+		}
+
+		esperarRedireccion(t, c.post("/renovar", url.Values{"prestamoID": {prestamo.ID}}), "Préstamo renovado hasta el")
+		renovado, err := DB.Prestamos().Obtener(context.Background(), prestamo.ID)
+		if err != nil {
+			t.Fatalf("obteniendo préstamo: %v", err)
+		}
+		if want := fechaVencimiento(prestamo.FechaVencimiento); !renovado.FechaVencimiento.Equal(want) {
+			t.Errorf("vencimiento tras renovar = %v, se esperaba %v", renovado.FechaVencimiento, want)
+		}
+		if renovado.Renovaciones != 1 || renovado.UltimaRenovacion.IsZero() {
+			t.Errorf("la renovación no quedó registrada: %+v", renovado)
+		}
+	})
+}
+
+func TestRenovarPrestamoVencidoCuentaDesdeHoy(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		ana := crearPersona(t, "ana", "clave", "usuario")
+		prestamo := prestarEl(t, crearLibro(t, "Rayuela", 1), ana, time.Now().AddDate(0, -2, 0))
+
+		antes := time.Now()
+		renovado, err := renovarPrestamo(context.Background(), DB, prestamo.ID, ana, antes)
+		if err != nil {
+			t.Fatalf("renovando: %v", err)
+		}
+		if !renovado.FechaVencimiento.Equal(fechaVencimiento(antes)) || renovado.DiasDeAtraso(antes) != 0 {
+			t.Errorf("vencimiento tras renovar un préstamo vencido = %v", renovado.FechaVencimiento)
+		}
+	})
+}
+
+func TestRenovarRespetaElMaximo(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		ana := crearPersona(t, "ana", "clave", "usuario")
+		prestamo := prestar(t, crearLibro(t, "Rayuela", 1), ana)
+		c.login("ana", "clave")
+
+		form := url.Values{"prestamoID": {prestamo.ID}}
+		for range Configuracion.MaxRenovaciones {
+			esperarEstado(t, c.ajax("/renovar", form), http.StatusOK)
+		}
+		resp := c.ajax("/renovar", form)
+		esperarEstado(t, resp, http.StatusConflict)
+		if !strings.Contains(resp.Cuerpo, "máximo") {
+			t.Errorf("el rechazo no explica el motivo: %q", resp.Cuerpo)
+		}
+		if p, _ := DB.Prestamos().Obtener(context.Background(), prestamo.ID); p.Renovaciones != Configuracion.MaxRenovaciones {
+			t.Errorf("renovaciones = %d, se esperaban %d", p.Renovaciones, Configuracion.MaxRenovaciones)
+		}
+		if strings.Contains(c.get("/devoluciones").Cuerpo, `action="/renovar"`) {
+			t.Errorf("/devoluciones ofrece Renovar después del máximo")
+		}
+	})
+}
+
+func TestRenovarBloqueadoPorReserva(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		ana := crearPersona(t, "ana", "clave", "usuario")
+		luis := crearPersona(t, "luis", "clave", "usuario")
+		libro := crearLibro(t, "Rayuela", 1)
+		prestamo := prestar(t, libro, ana)
+		reserva := &Reserva{LibroID: libro.ID, PersonaID: luis.ID, Creada: time.Now(), Estado: ReservaEnEspera}
+		if err := DB.Reservas().Crear(context.Background(), reserva); err != nil {
+			t.Fatalf("creando reserva: %v", err)
+		}
+
+		c.login("ana", "clave")
+		resp := c.ajax("/renovar", url.Values{"prestamoID": {prestamo.ID}})
+		esperarEstado(t, resp, http.StatusConflict)
+		if !strings.Contains(resp.Cuerpo, "esperando") {
+			t.Errorf("el rechazo no menciona la reserva: %q", resp.Cuerpo)
+		}
+		if p, _ := DB.Prestamos().Obtener(context.Background(), prestamo.ID); p.Renovaciones != 0 || !p.FechaVencimiento.Equal(prestamo.FechaVencimiento) {
+			t.Errorf("el préstamo cambió pese a la reserva: %+v", p)
+		}
+	})
+}
+
+func TestRenovarPrestamoAjeno(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		ana := crearPersona(t, "ana", "clave", "usuario")
+		crearPersona(t, "luis", "clave", "usuario")
+		crearPersona(t, "marta", "clave", RolBibliotecario)
+		prestamo := prestar(t, crearLibro(t, "Rayuela", 1), ana)
+
+		c.login("luis", "clave")
+		esperarEstado(t, c.ajax("/renovar", url.Values{"prestamoID": {prestamo.ID}}), http.StatusForbidden)
+
+		bibliotecaria := nuevoClientePrueba(t, c.srv)
+		bibliotecaria.login("marta", "clave")
+		esperarEstado(t, bibliotecaria.ajax("/renovar", url.Values{"prestamoID": {prestamo.ID}}), http.StatusOK)
+
+		if err := devolverLibro(context.Background(), DB, prestamo.ID, ana, time.Now()); err != nil {
+			t.Fatalf("devolviendo: %v", err)
+		}
+		esperarEstado(t, bibliotecaria.ajax("/renovar", url.Values{"prestamoID": {prestamo.ID}}), http.StatusConflict)
+	})
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Errores de las operaciones sobre reservas.
+var (
+	ErrHayCopias      = errors.New("el libro tiene copias disponibles")
+	ErrYaReservado    = errors.New("la persona ya está en la cola del libro")
+	ErrReservaCerrada = errors.New("la reserva ya no está activa")
+)
+
+// reservarLibro pone a personaID al final de la cola de espera de libroID.
+// Sólo se puede reservar un libro sin copias disponibles, y una sola vez.
+func reservarLibro(ctx context.Context, store Store, libroID, personaID string, fecha time.Time) (*Reserva, error) {
+	var reserva *Reserva
+	err := store.RunTransaction(ctx, func(ctx context.Context, tx Store) error {
+		inv, err := leerInventario(ctx, tx, libroID)
+		if err != nil {
+			return err
+		}
+		for _, r := range inv.cola {
+			if r.PersonaID == personaID {
+				return ErrYaReservado
+			}
+		}
+		if len(inv.libres()) > 0 {
+			return ErrHayCopias
+		}
+
+		reserva = &Reserva{LibroID: libroID, PersonaID: personaID, Creada: fecha, Estado: ReservaEnEspera}
+		return tx.Reservas().Crear(ctx, reserva)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reserva, nil
+}
+
+// cancelarReserva cancela una reserva activa. Si ya tenía una copia
+// apartada, la copia pasa a la siguiente persona de la cola. Sólo la dueña
+// de la reserva o quien gestiona préstamos puede cancelarla.
+func cancelarReserva(ctx context.Context, store Store, reservaID string, quien *Persona, fecha time.Time) error {
+	return store.RunTransaction(ctx, func(ctx context.Context, tx Store) error {
+		reserva, err := tx.Reservas().Obtener(ctx, reservaID)
+		if err != nil {
+			return err
+		}
+		if reserva.PersonaID != quien.ID && !quien.GestionaPrestamos() {
+			return ErrNoAutorizado
+		}
+		return cerrarReserva(ctx, tx, reserva, ReservaCancelada, fecha)
+	})
+}
+
+// vencerReservas marca como vencidas las reservas cuyo plazo de retiro
+// terminó y pasa cada copia apartada a la siguiente persona de la cola.
+// Cada reserva se procesa en su propia transacción; devuelve cuántas venció.
+func vencerReservas(ctx context.Context, store Store, ahora time.Time) (int, error) {
+	vencidas, err := store.Reservas().ListasVencidas(ctx, ahora)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, v := range vencidas {
+		err := store.RunTransaction(ctx, func(ctx context.Context, tx Store) error {
+			// Releer dentro de la transacción: otra petición pudo haberla
+			// retirado o cancelado
+			reserva, err := tx.Reservas().Obtener(ctx, v.ID)
+			if err != nil {
+				return err
+			}
+			if reserva.Estado != ReservaLista || !reserva.DisponibleHasta.Before(ahora) {
+				return ErrReservaCerrada
+			}
+			return cerrarReserva(ctx, tx, reserva, ReservaVencida, ahora)
+		})
+		switch {
+		case err == nil:
+			n++
+		case errors.Is(err, ErrReservaCerrada):
+		default:
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// cerrarReserva deja la reserva en el estado final dado y, si tenía una copia
+// apartada, se la entrega al siguiente de la cola. Hace sus lecturas antes
+// de escribir, como pide RunTransaction.
+func cerrarReserva(ctx context.Context, tx Store, reserva *Reserva, estado string, fecha time.Time) error {
+	if reserva.Estado != ReservaEnEspera && reserva.Estado != ReservaLista {
+		return ErrReservaCerrada
+	}
+	inv, err := inventarioDeReserva(ctx, tx, *reserva)
+	if err != nil {
+		return err
+	}
+	return cerrarConInventario(ctx, tx, reserva, inv, estado, fecha)
+}
+
+// inventarioDeReserva lee el inventario del libro de una reserva con copia
+// apartada, que hace falta para pasar la copia al cerrarla; nil si no tiene
+// copia apartada o el libro ya no existe.
+func inventarioDeReserva(ctx context.Context, tx Store, reserva Reserva) (*inventario, error) {
+	if reserva.Estado != ReservaLista {
+		return nil, nil
+	}
+	inv, err := leerInventario(ctx, tx, reserva.LibroID)
+	if errors.Is(err, ErrNoEncontrado) {
+		return nil, nil // El libro se eliminó; no hay copia que pasar
+	}
+	return inv, err
+}
+
+// cerrarConInventario es la parte de cerrarReserva que escribe: inv es el
+// de inventarioDeReserva.
+func cerrarConInventario(ctx context.Context, tx Store, reserva *Reserva, inv *inventario, estado string, fecha time.Time) error {
+	reserva.Estado = estado
+	reserva.DisponibleHasta = time.Time{}
+	reserva.EjemplarID = ""
+	if err := tx.Reservas().Guardar(ctx, reserva); err != nil {
+		return err
+	}
+	if inv == nil {
+		return nil
+	}
+	inv.quitarReserva(reserva.ID)
+	return inv.guardar(ctx, tx, fecha)
+}
+
+// vencerReservasPeriodicamente ejecuta vencerReservas cada cierto tiempo.
+func vencerReservasPeriodicamente(store Store, cada time.Duration) {
+	for range time.Tick(cada) {
+		n, err := vencerReservas(context.Background(), store, time.Now())
+		if err != nil {
+			log.Printf("Error al vencer reservas: %v", err)
+		} else if n > 0 {
+			log.Printf("⏳ %d reservas no retiradas a tiempo pasaron al siguiente de la cola", n)
+		}
+	}
+}
+
+// ReservaDisplayData es una fila de "Mis reservas".
+type ReservaDisplayData struct {
+	ReservaID       string
+	LibroID         string
+	LibroNombre     string
+	Estado          string
+	Posicion        int // Lugar en la cola (1 = siguiente); sólo en espera
+	DisponibleHasta time.Time
+}
+
+// misReservas arma la lista de reservas activas de la persona con su lugar
+// en la cola de cada libro.
+func misReservas(ctx context.Context, personaID string) ([]ReservaDisplayData, error) {
+	reservas, err := DB.Reservas().ActivasPorPersona(ctx, personaID)
+	if err != nil {
+		return nil, err
+	}
+	var filas []ReservaDisplayData
+	for _, r := range reservas {
+		fila := ReservaDisplayData{
+			ReservaID:       r.ID,
+			LibroID:         r.LibroID,
+			LibroNombre:     "(libro eliminado)",
+			Estado:          r.Estado,
+			DisponibleHasta: r.DisponibleHasta,
+		}
+		if libro, err := DB.Libros().Obtener(ctx, r.LibroID); err == nil {
+			fila.LibroNombre = libro.Nombre
+		}
+		if r.Estado == ReservaEnEspera {
+			cola, err := DB.Reservas().ActivasPorLibro(ctx, r.LibroID)
+			if err != nil {
+				return nil, err
+			}
+			for _, otra := range cola {
+				if otra.Estado == ReservaEnEspera {
+					fila.Posicion++
+				}
+				if otra.ID == r.ID {
+					break
+				}
+			}
+		}
+		filas = append(filas, fila)
+	}
+	return filas, nil
+}
+
+// ReservarHandler pone a la persona logueada en la cola de espera de un libro
+// sin copias.
+func ReservarHandler(w http.ResponseWriter, r *http.Request) {
+	libroID := r.FormValue("libroID")
+	persona := personaActual(r)
+	if libroID == "" {
+		http.Redirect(w, r, "/prestamos?msg="+url.QueryEscape("Seleccione un libro para reservar")+"&msg_type=danger", http.StatusSeeOther)
+		return
+	}
+
+	if _, err := reservarLibro(r.Context(), DB, libroID, persona.ID, time.Now()); err != nil {
+		mensaje := "Error al registrar la reserva"
+		switch {
+		case errors.Is(err, ErrHayCopias):
+			mensaje = "El libro tiene copias disponibles: puedes pedirlo prestado directamente."
+		case errors.Is(err, ErrYaReservado):
+			mensaje = "Ya estás en la cola de espera de este libro."
+		case errors.Is(err, ErrNoEncontrado):
+			mensaje = "El libro no existe."
+		default:
+			log.Printf("Error al reservar libro %s para %s: %v", libroID, persona.ID, err)
+		}
+		http.Redirect(w, r, "/prestamos?msg="+url.QueryEscape(mensaje)+"&msg_type=danger", http.StatusSeeOther)
+		return
+	}
+
+	log.Printf("📌 Reserva registrada: LibroID '%s', PersonaID '%s'", libroID, persona.ID)
+	http.Redirect(w, r, "/prestamos?msg="+url.QueryEscape("Reserva registrada. Te apartaremos una copia cuando se devuelva.")+"&msg_type=success", http.StatusSeeOther)
+}
+
+// CancelarReservaHandler cancela una reserva de la persona logueada (o de
+// cualquiera, para bibliotecarios y administradores).
+func CancelarReservaHandler(w http.ResponseWriter, r *http.Request) {
+	reservaID := r.FormValue("reservaID")
+	persona := personaActual(r)
+
+	if err := cancelarReserva(r.Context(), DB, reservaID, persona, time.Now()); err != nil {
+		estado, mensaje := http.StatusInternalServerError, "Error al cancelar la reserva"
+		switch {
+		case errors.Is(err, ErrNoAutorizado):
+			estado, mensaje = http.StatusForbidden, "No puedes cancelar una reserva de otra persona"
+		case errors.Is(err, ErrNoEncontrado):
+			estado, mensaje = http.StatusNotFound, "La reserva no existe"
+		case errors.Is(err, ErrReservaCerrada):
+			estado, mensaje = http.StatusConflict, "La reserva ya no está activa"
+		default:
+			log.Printf("Error al cancelar reserva %s: %v", reservaID, err)
+		}
+		if esAJAX(r) {
+			http.Error(w, mensaje, estado)
+			return
+		}
+		http.Redirect(w, r, "/prestamos?msg="+url.QueryEscape(mensaje)+"&msg_type=danger", http.StatusSeeOther)
+		return
+	}
+
+	if esAJAX(r) {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	http.Redirect(w, r, "/prestamos?msg=Reserva cancelada&msg_type=success", http.StatusSeeOther)
+}
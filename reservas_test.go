@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// reservar registra una reserva directamente en el store.
+func reservar(t *testing.T, libro *Libro, persona *Persona, fecha time.Time) *Reserva {
+	t.Helper()
+	r, err := reservarLibro(context.Background(), DB, libro.ID, persona.ID, fecha)
+	if err != nil {
+		t.Fatalf("reservando %s para %s: %v", libro.Nombre, persona.Nombre, err)
+	}
+	return r
+}
+
+func obtenerReserva(t *testing.T, id string) *Reserva {
+	t.Helper()
+	r, err := DB.Reservas().Obtener(context.Background(), id)
+	if err != nil {
+		t.Fatalf("obteniendo reserva %s: %v", id, err)
+	}
+	return r
+}
+
+func TestReservarSoloSinCopias(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		ana := crearPersona(t, "ana", "clave", "usuario")
+		crearPersona(t, "luis", "clave", "usuario")
+		libro := crearLibro(t, "Rayuela", 1)
+		c.login("luis", "clave")
+
+		esperarRedireccion(t, c.post("/reservas", url.Values{"libroID": {libro.ID}}), "copias disponibles")
+
+		prestar(t, libro, ana)
+		esperarRedireccion(t, c.post("/reservas", url.Values{"libroID": {libro.ID}}), "Reserva registrada")
+		esperarRedireccion(t, c.post("/reservas", url.Values{"libroID": {libro.ID}}), "Ya estás en la cola")
+
+		if cola, _ := DB.Reservas().ActivasPorLibro(context.Background(), libro.ID); len(cola) != 1 {
+			t.Errorf("la cola tiene %d reservas, se esperaba 1", len(cola))
+		}
+	})
+}
+
+func TestDevolucionApartaCopiaParaElPrimeroDeLaCola(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		ana := crearPersona(t, "ana", "clave", "usuario")
+		luis := crearPersona(t, "luis", "clave", "usuario")
+		eva := crearPersona(t, "eva", "clave", "usuario")
+		libro := crearLibro(t, "Rayuela", 1)
+		prestamo := prestar(t, libro, ana)
+
+		ahora := time.Now()
+		deLuis := reservar(t, libro, luis, ahora)
+		deEva := reservar(t, libro, eva, ahora.Add(time.Second))
+
+		if err := devolverLibro(context.Background(), DB, prestamo.ID, ana, ahora); err != nil {
+			t.Fatalf("devolviendo: %v", err)
+		}
+		r := obtenerReserva(t, deLuis.ID)
+		if r.Estado != ReservaLista || !r.DisponibleHasta.Equal(ahora.Add(Configuracion.VentanaRetiro.Duration)) {
+			t.Errorf("la reserva de luis no quedó lista: %+v", r)
+		}
+		if obtenerReserva(t, deEva.ID).Estado != ReservaEnEspera {
+			t.Errorf("la reserva de eva no debería cambiar")
+		}
+		if l := obtenerLibro(t, libro.ID); l.Copias != 0 {
+			t.Errorf("la copia apartada volvió al estante: copias = %d", l.Copias)
+		}
+
+		// Nadie más puede llevarse la copia apartada
+		if _, err := prestarLibro(context.Background(), DB, libro.ID, eva.ID, ahora); err != ErrSinCopias {
+			t.Errorf("eva se llevó la copia apartada para luis: %v", err)
+		}
+
+		c.login("luis", "clave")
+		resp := c.get("/prestamos")
+		if !strings.Contains(resp.Cuerpo, "Lista para retirar") {
+			t.Errorf("/prestamos no avisa que la reserva está lista")
+		}
+		esperarRedireccion(t, c.post("/prestamos", url.Values{"libroID": {libro.ID}}), "Préstamo registrado")
+		if obtenerReserva(t, deLuis.ID).Estado != ReservaCumplida {
+			t.Errorf("la reserva retirada no quedó cumplida")
+		}
+		if l := obtenerLibro(t, libro.ID); l.Copias != 0 {
+			t.Errorf("retirar la copia apartada descontó otra copia: copias = %d", l.Copias)
+		}
+		if ps, _ := DB.Prestamos().ActivosPorPersona(context.Background(), luis.ID); len(ps) != 1 {
+			t.Errorf("luis tiene %d préstamos activos, se esperaba 1", len(ps))
+		}
+	})
+}
+
+func TestReservaNoRetiradaPasaAlSiguiente(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		ana := crearPersona(t, "ana", "clave", "usuario")
+		luis := crearPersona(t, "luis", "clave", "usuario")
+		eva := crearPersona(t, "eva", "clave", "usuario")
+		libro := crearLibro(t, "Rayuela", 1)
+		prestamo := prestar(t, libro, ana)
+
+		ahora := time.Now()
+		deLuis := reservar(t, libro, luis, ahora)
+		deEva := reservar(t, libro, eva, ahora.Add(time.Second))
+		if err := devolverLibro(context.Background(), DB, prestamo.ID, ana, ahora); err != nil {
+			t.Fatalf("devolviendo: %v", err)
+		}
+
+		// Dentro del plazo no vence nada
+		if n, err := vencerReservas(context.Background(), DB, ahora.Add(time.Hour)); err != nil || n != 0 {
+			t.Fatalf("vencerReservas dentro del plazo = %d, %v", n, err)
+		}
+
+		despues := ahora.Add(Configuracion.VentanaRetiro.Duration + time.Minute)
+		if n, err := vencerReservas(context.Background(), DB, despues); err != nil || n != 1 {
+			t.Fatalf("vencerReservas = %d, %v; se esperaba 1", n, err)
+		}
+		if obtenerReserva(t, deLuis.ID).Estado != ReservaVencida {
+			t.Errorf("la reserva de luis no venció")
+		}
+		r := obtenerReserva(t, deEva.ID)
+		if r.Estado != ReservaLista || !r.DisponibleHasta.Equal(despues.Add(Configuracion.VentanaRetiro.Duration)) {
+			t.Errorf("la copia no pasó a eva: %+v", r)
+		}
+
+		// Sin nadie más en la cola, la copia vuelve al estante
+		masTarde := despues.Add(Configuracion.VentanaRetiro.Duration + time.Minute)
+		if n, err := vencerReservas(context.Background(), DB, masTarde); err != nil || n != 1 {
+			t.Fatalf("vencerReservas = %d, %v; se esperaba 1", n, err)
+		}
+		if l := obtenerLibro(t, libro.ID); l.Copias != 1 || !l.Disponible {
+			t.Errorf("la copia no volvió al estante: %+v", l)
+		}
+	})
+}
+
+func TestCancelarReserva(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		ana := crearPersona(t, "ana", "clave", "usuario")
+		luis := crearPersona(t, "luis", "clave", "usuario")
+		eva := crearPersona(t, "eva", "clave", "usuario")
+		libro := crearLibro(t, "Rayuela", 1)
+		prestamo := prestar(t, libro, ana)
+
+		ahora := time.Now()
+		deLuis := reservar(t, libro, luis, ahora)
+		deEva := reservar(t, libro, eva, ahora.Add(time.Second))
+
+		c.login("eva", "clave")
+		resp := c.get("/prestamos")
+		if !strings.Contains(resp.Cuerpo, "Lugar en la cola: 2") {
+			t.Errorf("/prestamos no muestra el lugar de eva en la cola")
+		}
+		esperarEstado(t, c.ajax("/reservas/cancelar", url.Values{"reservaID": {deLuis.ID}}), http.StatusForbidden)
+
+		if err := devolverLibro(context.Background(), DB, prestamo.ID, ana, ahora); err != nil {
+			t.Fatalf("devolviendo: %v", err)
+		}
+		// Luis renuncia a la copia apartada: pasa a eva
+		if err := cancelarReserva(context.Background(), DB, deLuis.ID, luis, ahora); err != nil {
+			t.Fatalf("cancelando: %v", err)
+		}
+		if obtenerReserva(t, deLuis.ID).Estado != ReservaCancelada || obtenerReserva(t, deEva.ID).Estado != ReservaLista {
+			t.Errorf("cancelar una reserva lista no pasó la copia al siguiente")
+		}
+
+		esperarRedireccion(t, c.post("/reservas/cancelar", url.Values{"reservaID": {deEva.ID}}), "Reserva cancelada")
+		esperarEstado(t, c.ajax("/reservas/cancelar", url.Values{"reservaID": {deEva.ID}}), http.StatusConflict)
+		if l := obtenerLibro(t, libro.ID); l.Copias != 1 {
+			t.Errorf("la copia no volvió al estante tras cancelar todas las reservas: copias = %d", l.Copias)
+		}
+	})
+}
+
+func TestEliminarCancelaLasReservas(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		ctx := context.Background()
+		crearPersona(t, "admin", "clave", "admin")
+		ana := crearPersona(t, "ana", "clave", "usuario")
+		luis := crearPersona(t, "luis", "clave", "usuario")
+		eva := crearPersona(t, "eva", "clave", "usuario")
+		libro := crearLibro(t, "Rayuela", 1)
+		prestamo := prestar(t, libro, ana)
+		ahora := time.Now()
+		reservar(t, libro, luis, ahora)
+		deEva := reservar(t, libro, eva, ahora.Add(time.Second))
+		if err := devolverLibro(ctx, DB, prestamo.ID, ana, ahora); err != nil {
+			t.Fatalf("devolviendo: %v", err)
+		}
+		c.login("admin", "clave")
+
+		// Luis tenía la copia apartada: al borrarlo, pasa a eva
+		esperarEstado(t, c.ajax("/eliminar-persona", url.Values{"id": {luis.ID}}), http.StatusOK)
+		if activas, _ := DB.Reservas().ActivasPorPersona(ctx, luis.ID); len(activas) != 0 {
+			t.Errorf("quedaron reservas activas de la persona borrada: %+v", activas)
+		}
+		if r := obtenerReserva(t, deEva.ID); r.Estado != ReservaLista || r.EjemplarID == "" {
+			t.Errorf("la copia apartada no pasó a eva: %+v", r)
+		}
+
+		// Al borrar un libro sin préstamos, su cola se cancela
+		sinCopias := crearLibro(t, "Ficciones", 0)
+		reservar(t, sinCopias, ana, ahora)
+		esperarEstado(t, c.ajax("/eliminar-libro", url.Values{"id": {sinCopias.ID}}), http.StatusOK)
+		if activas, _ := DB.Reservas().ActivasPorPersona(ctx, ana.ID); len(activas) != 0 {
+			t.Errorf("quedaron reservas activas del libro borrado: %+v", activas)
+		}
+	})
+}
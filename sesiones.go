@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// nombreCookieSesion es la única cookie de autenticación. Su valor es
+// "<id>.<firma>", donde la firma es un HMAC-SHA256 del ID con el secreto de
+// sesión; el rol y el usuario nunca viajan en la cookie.
+const nombreCookieSesion = "sesion"
+
+var (
+	secretoGenerado     []byte
+	secretoGeneradoOnce sync.Once
+)
+
+// secretoSesion devuelve la clave de firma configurada. Si no hay ninguna se
+// genera una aleatoria, válida sólo mientras el proceso siga vivo.
+func secretoSesion() []byte {
+	if Configuracion.SecretoSesion != "" {
+		return []byte(Configuracion.SecretoSesion)
+	}
+	secretoGeneradoOnce.Do(func() {
+		secretoGenerado = make([]byte, 32)
+		if _, err := rand.Read(secretoGenerado); err != nil {
+			log.Fatalf("No se pudo generar el secreto de sesión: %v", err)
+		}
+		log.Println("⚠️ SESSION_SECRET no configurado: se usa un secreto temporal; las sesiones no sobreviven a un reinicio")
+	})
+	return secretoGenerado
+}
+
+// firmar devuelve el HMAC-SHA256 de datos con el secreto de sesión.
+func firmar(datos string) string {
+	mac := hmac.New(sha256.New, secretoSesion())
+	mac.Write([]byte(datos))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func firmarSesion(id string) string { return firmar(id) }
+
+// idDeCookie valida la firma del valor de la cookie y devuelve el ID de sesión.
+func idDeCookie(valor string) (string, bool) {
+	id, firma, ok := strings.Cut(valor, ".")
+	if !ok || id == "" {
+		return "", false
+	}
+	return id, hmac.Equal([]byte(firma), []byte(firmarSesion(id)))
+}
+
+// nuevoIDSesion genera un identificador opaco de 256 bits.
+func nuevoIDSesion() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func cookieSesion(valor string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     nombreCookieSesion,
+		Value:    valor,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   Configuracion.CookieSegura,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// iniciarSesion guarda una sesión nueva para la persona y envía la cookie.
+func iniciarSesion(w http.ResponseWriter, r *http.Request, persona *Persona) error {
+	id, err := nuevoIDSesion()
+	if err != nil {
+		return err
+	}
+	ahora := time.Now()
+	sesion := &Sesion{
+		ID:        id,
+		PersonaID: persona.ID,
+		Creada:    ahora,
+		Expira:    ahora.Add(Configuracion.DuracionSesion.Duration),
+	}
+	if err := DB.Sesiones().Guardar(r.Context(), sesion); err != nil {
+		return err
+	}
+	http.SetCookie(w, cookieSesion(id+"."+firmarSesion(id), int(Configuracion.DuracionSesion.Seconds())))
+	return nil
+}
+
+// cerrarSesion elimina la sesión del store y borra la cookie.
+func cerrarSesion(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie(nombreCookieSesion); err == nil {
+		if id, ok := idDeCookie(c.Value); ok {
+			if err := DB.Sesiones().Eliminar(r.Context(), id); err != nil {
+				log.Printf("Error al eliminar sesión: %v", err)
+			}
+		}
+	}
+	http.SetCookie(w, cookieSesion("", -1))
+}
+
+// borrarCookiesHeredadas elimina las cookies "usuario" y "rol" que usaban
+// versiones anteriores para identificar al usuario.
+func borrarCookiesHeredadas(w http.ResponseWriter) {
+	for _, nombre := range []string{"usuario", "rol"} {
+		http.SetCookie(w, &http.Cookie{Name: nombre, Value: "", Path: "/", MaxAge: -1})
+	}
+}
+
+// sesionPeticion devuelve la sesión de la petición y su persona, o nil si no
+// hay una sesión válida. El rol se lee siempre de la persona guardada.
+func sesionPeticion(r *http.Request) (*Sesion, *Persona) {
+	c, err := r.Cookie(nombreCookieSesion)
+	if err != nil {
+		return nil, nil
+	}
+	id, ok := idDeCookie(c.Value)
+	if !ok {
+		log.Println("⚠️ Cookie de sesión con firma inválida")
+		return nil, nil
+	}
+	ctx := r.Context()
+	sesion, err := DB.Sesiones().Obtener(ctx, id)
+	if err != nil {
+		if !errors.Is(err, ErrNoEncontrado) {
+			log.Printf("Error al obtener sesión: %v", err)
+		}
+		return nil, nil
+	}
+	if time.Now().After(sesion.Expira) {
+		if err := DB.Sesiones().Eliminar(ctx, id); err != nil {
+			log.Printf("Error al eliminar sesión expirada: %v", err)
+		}
+		return nil, nil
+	}
+	persona, err := DB.Personas().Obtener(ctx, sesion.PersonaID)
+	if err != nil {
+		return nil, nil
+	}
+	if persona.Rol == "" {
+		persona.Rol = "usuario" // Rol por defecto
+	}
+	return sesion, persona
+}
+
+// usuarioYRol devuelve el nombre y el rol de la sesión actual para las
+// plantillas; ambos vacíos si no hay sesión.
+func usuarioYRol(r *http.Request) (string, string) {
+	if p := personaActual(r); p != nil {
+		return p.Nombre, p.Rol
+	}
+	return "", ""
+}
+
+// limpiarSesiones borra periódicamente las sesiones expiradas.
+func limpiarSesiones(store Store, cada time.Duration) {
+	for range time.Tick(cada) {
+		n, err := store.Sesiones().EliminarExpiradas(context.Background(), time.Now())
+		if err != nil {
+			log.Printf("Error al limpiar sesiones: %v", err)
+		} else if n > 0 {
+			log.Printf("🧹 %d sesiones expiradas eliminadas", n)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// cookieSesionActual devuelve el valor de la cookie de sesión guardada en el
+// cliente de prueba.
+func (c *clientePrueba) cookieSesionActual() string {
+	c.t.Helper()
+	u, _ := url.Parse(c.srv.URL)
+	for _, ck := range c.hc.Jar.Cookies(u) {
+		if ck.Name == nombreCookieSesion {
+			return ck.Value
+		}
+	}
+	c.t.Fatalf("el cliente no tiene cookie de sesión")
+	return ""
+}
+
+// ponerCookies guarda las cookies en el cliente, reemplazando las del mismo nombre.
+func (c *clientePrueba) ponerCookies(cookies ...*http.Cookie) {
+	u, _ := url.Parse(c.srv.URL)
+	c.hc.Jar.SetCookies(u, cookies)
+}
+
+func TestLoginCreaCookieDeSesionSegura(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		crearPersona(t, "ana", "clave", "usuario")
+		resp := c.post("/login", url.Values{"nombre": {"ana"}, "contrasena": {"clave"}})
+		esperarEstado(t, resp, http.StatusSeeOther)
+
+		var sesion *http.Cookie
+		for _, ck := range resp.Cookies() {
+			switch ck.Name {
+			case nombreCookieSesion:
+				sesion = ck
+			case "usuario", "rol":
+				if ck.MaxAge >= 0 {
+					t.Errorf("la cookie heredada %q no se borró", ck.Name)
+				}
+			}
+		}
+		if sesion == nil {
+			t.Fatal("el login no envió la cookie de sesión")
+		}
+		if !sesion.HttpOnly || sesion.SameSite != http.SameSiteLaxMode {
+			t.Errorf("cookie de sesión sin HttpOnly/SameSite: %+v", sesion)
+		}
+		if strings.Contains(sesion.Value, "ana") || strings.Contains(sesion.Value, "usuario") {
+			t.Errorf("la cookie de sesión expone datos del usuario: %q", sesion.Value)
+		}
+	})
+}
+
+func TestCookieDeRolFalsificadaNoDaAcceso(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		crearPersona(t, "eva", "clave", "usuario")
+		libro := crearLibro(t, "Ficciones", 2)
+
+		// Sin sesión, las cookies antiguas no sirven de nada
+		c.ponerCookies(&http.Cookie{Name: "usuario", Value: "eva"}, &http.Cookie{Name: "rol", Value: "admin"})
+		esperarRedireccion(t, c.get("/editar-libros?id="+libro.ID), "/login")
+
+		// Con sesión de usuario normal el rol sale del store
+		c.login("eva", "clave")
+		c.ponerCookies(&http.Cookie{Name: "rol", Value: "admin"})
+		esperarEstado(t, c.get("/editar-libros?id="+libro.ID), http.StatusForbidden)
+	})
+}
+
+func TestCookieDeSesionAlterada(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		crearPersona(t, "admin", "clave", "admin")
+		libro := crearLibro(t, "Ficciones", 2)
+		c.login("admin", "clave")
+		esperarEstado(t, c.get("/editar-libros?id="+libro.ID), http.StatusOK)
+
+		id, _, _ := strings.Cut(c.cookieSesionActual(), ".")
+		for _, valor := range []string{id, id + ".firmafalsa", "otro." + firmarSesion(id)} {
+			c.ponerCookies(&http.Cookie{Name: nombreCookieSesion, Value: valor})
+			esperarRedireccion(t, c.get("/editar-libros?id="+libro.ID), "/login")
+		}
+	})
+}
+
+func TestLogoutInvalidaSesion(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		crearPersona(t, "admin", "clave", "admin")
+		libro := crearLibro(t, "Ficciones", 2)
+		c.login("admin", "clave")
+		valor := c.cookieSesionActual()
+
+		esperarRedireccion(t, c.post("/logout", nil), "/")
+		// Reutilizar la cookie robada tras el logout no debe funcionar
+		c.ponerCookies(&http.Cookie{Name: nombreCookieSesion, Value: valor})
+		esperarRedireccion(t, c.get("/editar-libros?id="+libro.ID), "/login")
+	})
+}
+
+func TestSesionExpirada(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		crearPersona(t, "admin", "clave", "admin")
+		libro := crearLibro(t, "Ficciones", 2)
+		c.login("admin", "clave")
+
+		ctx := context.Background()
+		id, _, _ := strings.Cut(c.cookieSesionActual(), ".")
+		sesion, err := DB.Sesiones().Obtener(ctx, id)
+		if err != nil {
+			t.Fatalf("la sesión no quedó guardada: %v", err)
+		}
+		sesion.Expira = time.Now().Add(-time.Minute)
+		if err := DB.Sesiones().Guardar(ctx, sesion); err != nil {
+			t.Fatalf("guardando sesión: %v", err)
+		}
+		esperarRedireccion(t, c.get("/editar-libros?id="+libro.ID), "/login")
+
+		if n, err := DB.Sesiones().EliminarExpiradas(ctx, time.Now()); err != nil || n != 0 {
+			t.Errorf("EliminarExpiradas = %d, %v; la sesión vencida ya debía estar borrada", n, err)
+		}
+	})
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+)
+
+// Errores de las solicitudes de préstamo de libros restringidos.
+var (
+	ErrSolicitudPendiente = errors.New("la persona ya pidió el libro y espera la aprobación")
+	ErrSolicitudCerrada   = errors.New("la solicitud ya fue resuelta")
+)
+
+// aprobarSolicitud aprueba una solicitud de préstamo: le aparta una copia
+// libre del libro y le da el plazo de retiro configurado. Si la persona
+// tenía una reserva del libro, igual que al prestar, la reserva se cumple y
+// la solicitud se queda con la copia que la reserva tenía apartada. La
+// persona la retira desde Préstamos como cualquier préstamo; si no lo hace
+// a tiempo, vencerSolicitudes la da por expirada. Sólo un administrador
+// puede aprobar.
+func aprobarSolicitud(ctx context.Context, store Store, prestamoID string, quien *Persona, fecha time.Time) (*Prestamo, error) {
+	if quien.Rol != RolAdmin {
+		return nil, ErrNoAutorizado
+	}
+	var prestamo *Prestamo
+	err := store.RunTransaction(ctx, func(ctx context.Context, tx Store) error {
+		var err error
+		prestamo, err = tx.Prestamos().Obtener(ctx, prestamoID)
+		if err != nil {
+			return err
+		}
+		if prestamo.Estado != EstadoSolicitado {
+			return ErrSolicitudCerrada
+		}
+		inv, err := leerInventario(ctx, tx, prestamo.LibroID)
+		if err != nil {
+			return err
+		}
+		var ejemplarID string
+		reserva := inv.reservaDe(prestamo.PersonaID)
+		if reserva != nil && reserva.Estado == ReservaLista {
+			ejemplarID = reserva.EjemplarID
+		} else if libres := inv.libres(); len(libres) > 0 {
+			ejemplarID = libres[0].ID
+		} else {
+			return ErrSinCopias
+		}
+
+		prestamo.Estado = EstadoAprobado
+		prestamo.EjemplarID = ejemplarID
+		prestamo.RetirarHasta = fecha.Add(Configuracion.VentanaRetiro.Duration)
+		prestamo.ResueltoPor = quien.ID
+		if err := tx.Prestamos().Guardar(ctx, prestamo); err != nil {
+			return err
+		}
+		if reserva != nil {
+			if err := inv.cumplirReserva(ctx, tx, *reserva); err != nil {
+				return err
+			}
+		}
+		inv.ocupados[prestamo.EjemplarID] = true
+		return inv.guardar(ctx, tx, fecha)
+	})
+	return prestamo, err
+}
+
+// rechazarSolicitud cierra una solicitud de préstamo sin prestar el libro.
+// Sólo un administrador puede rechazar.
+func rechazarSolicitud(ctx context.Context, store Store, prestamoID string, quien *Persona, fecha time.Time) error {
+	if quien.Rol != RolAdmin {
+		return ErrNoAutorizado
+	}
+	return store.RunTransaction(ctx, func(ctx context.Context, tx Store) error {
+		prestamo, err := tx.Prestamos().Obtener(ctx, prestamoID)
+		if err != nil {
+			return err
+		}
+		if prestamo.Estado != EstadoSolicitado {
+			return ErrSolicitudCerrada
+		}
+		prestamo.Estado = EstadoRechazado
+		prestamo.FechaDevolucion = fecha
+		prestamo.ResueltoPor = quien.ID
+		return tx.Prestamos().Guardar(ctx, prestamo)
+	})
+}
+
+// vencerSolicitudes da por expiradas las solicitudes aprobadas que no se
+// retiraron a tiempo y libera sus copias, que pasan a la cola de reservas o
+// quedan disponibles. Devuelve cuántas expiraron.
+func vencerSolicitudes(ctx context.Context, store Store, ahora time.Time) (int, error) {
+	vencidas, err := store.Prestamos().AprobadosVencidos(ctx, ahora)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, v := range vencidas {
+		err := store.RunTransaction(ctx, func(ctx context.Context, tx Store) error {
+			// Releer dentro de la transacción: la persona pudo haberla
+			// retirado mientras tanto
+			prestamo, err := tx.Prestamos().Obtener(ctx, v.ID)
+			if err != nil {
+				return err
+			}
+			if prestamo.Estado != EstadoAprobado || !prestamo.RetirarHasta.Before(ahora) {
+				return ErrSolicitudCerrada
+			}
+			inv, err := leerInventario(ctx, tx, prestamo.LibroID)
+			if errors.Is(err, ErrNoEncontrado) {
+				inv = nil // El libro se eliminó; no hay copia que liberar
+			} else if err != nil {
+				return err
+			}
+
+			prestamo.Estado = EstadoExpirado
+			prestamo.FechaDevolucion = ahora
+			if err := tx.Prestamos().Guardar(ctx, prestamo); err != nil {
+				return err
+			}
+			if inv == nil {
+				return nil
+			}
+			delete(inv.ocupados, prestamo.EjemplarID)
+			return inv.guardar(ctx, tx, ahora)
+		})
+		switch {
+		case err == nil:
+			n++
+		case errors.Is(err, ErrSolicitudCerrada):
+		default:
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// vencerSolicitudesPeriodicamente ejecuta vencerSolicitudes cada cierto
+// tiempo.
+func vencerSolicitudesPeriodicamente(store Store, cada time.Duration) {
+	for range time.Tick(cada) {
+		n, err := vencerSolicitudes(context.Background(), store, time.Now())
+		if err != nil {
+			log.Printf("Error al vencer solicitudes: %v", err)
+		} else if n > 0 {
+			log.Printf("⏳ %d solicitudes aprobadas no se retiraron a tiempo y expiraron", n)
+		}
+	}
+}
+
+// solicitudesPendientes devuelve las filas de las solicitudes sin retirar,
+// de la más antigua a la más reciente; de una persona o, si personaID está
+// vacío, de todas.
+func solicitudesPendientes(ctx context.Context, personaID string) ([]DevolucionDisplayData, error) {
+	filtro := FiltroPrestamos{PersonaID: personaID, Estados: []string{EstadoSolicitado, EstadoAprobado}}
+	prestamos, err := DB.Prestamos().Historial(ctx, filtro)
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(prestamos)
+	return filasPrestamos(ctx, prestamos, personaID == ""), nil
+}
+
+// SolicitudesHandler muestra al administrador las solicitudes por aprobar y
+// las aprobadas que esperan ser retiradas.
+func SolicitudesHandler(w http.ResponseWriter, r *http.Request) {
+	usuario, rol := usuarioYRol(r)
+	filas, err := solicitudesPendientes(r.Context(), "")
+	if err != nil {
+		log.Printf("Error al listar solicitudes: %v", err)
+		http.Error(w, "Error al cargar las solicitudes", http.StatusInternalServerError)
+		return
+	}
+	renderTemplate(w, r, "solicitudes.html", DatosPagina{
+		Solicitudes: filas,
+		Año:         time.Now().Year(),
+		Usuario:     usuario,
+		Rol:         rol,
+		Mensaje:     r.URL.Query().Get("msg"),
+		TipoMensaje: r.URL.Query().Get("msg_type"),
+	})
+}
+
+// AprobarSolicitudHandler aprueba la solicitud prestamoID.
+func AprobarSolicitudHandler(w http.ResponseWriter, r *http.Request) {
+	resolverSolicitud(w, r, true)
+}
+
+// RechazarSolicitudHandler rechaza la solicitud prestamoID.
+func RechazarSolicitudHandler(w http.ResponseWriter, r *http.Request) {
+	resolverSolicitud(w, r, false)
+}
+
+// resolverSolicitud aprueba o rechaza la solicitud del formulario y vuelve a
+// la lista de solicitudes con el resultado.
+func resolverSolicitud(w http.ResponseWriter, r *http.Request, aprobar bool) {
+	prestamoID := r.FormValue("prestamoID")
+	quien := personaActual(r)
+
+	mensaje := "Solicitud rechazada"
+	var err error
+	if aprobar {
+		var prestamo *Prestamo
+		if prestamo, err = aprobarSolicitud(r.Context(), DB, prestamoID, quien, time.Now()); err == nil {
+			mensaje = "Solicitud aprobada: se apartó una copia hasta el " + prestamo.RetirarHasta.Format("02/01/2006 15:04")
+		}
+	} else {
+		err = rechazarSolicitud(r.Context(), DB, prestamoID, quien, time.Now())
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrNoEncontrado):
+			mensaje = "La solicitud no existe"
+		case errors.Is(err, ErrSolicitudCerrada):
+			mensaje = "La solicitud ya fue resuelta"
+		case errors.Is(err, ErrSinCopias):
+			mensaje = "No hay copias libres del libro para apartar; apruébala cuando se devuelva una"
+		default:
+			log.Printf("Error al resolver la solicitud %s: %v", prestamoID, err)
+			mensaje = "Error al resolver la solicitud"
+		}
+		http.Redirect(w, r, "/solicitudes?msg="+url.QueryEscape(mensaje)+"&msg_type=danger", http.StatusSeeOther)
+		return
+	}
+	log.Printf("✅ %s: %s (%s)", quien.Nombre, mensaje, prestamoID)
+	http.Redirect(w, r, "/solicitudes?msg="+url.QueryEscape(mensaje)+"&msg_type=success", http.StatusSeeOther)
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// crearLibroRestringido registra un libro de colección restringida.
+func crearLibroRestringido(t *testing.T, nombre string, copias int) *Libro {
+	t.Helper()
+	l := &Libro{Nombre: nombre, Autor: "Autor de " + nombre, Ano: 2001, Restringido: true}
+	if err := registrarLibro(context.Background(), DB, l, copias); err != nil {
+		t.Fatalf("creando libro: %v", err)
+	}
+	return l
+}
+
+func obtenerPrestamo(t *testing.T, id string) *Prestamo {
+	t.Helper()
+	p, err := DB.Prestamos().Obtener(context.Background(), id)
+	if err != nil {
+		t.Fatalf("obteniendo préstamo %s: %v", id, err)
+	}
+	return p
+}
+
+func TestSolicitudAprobadaYRetirada(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		crearPersona(t, "admin", "clave", "admin")
+		ana := crearPersona(t, "ana", "clave", "usuario")
+		libro := crearLibroRestringido(t, "Códice", 1)
+		c.login("ana", "clave")
+
+		esperarRedireccion(t, c.post("/prestamos", url.Values{"libroID": {libro.ID}}), "Solicitud registrada")
+		solicitudes, _ := DB.Prestamos().Historial(context.Background(), FiltroPrestamos{PersonaID: ana.ID})
+		if len(solicitudes) != 1 || solicitudes[0].Estado != EstadoSolicitado || solicitudes[0].EjemplarID != "" {
+			t.Fatalf("se esperaba una solicitud sin copia: %+v", solicitudes)
+		}
+		solicitud := solicitudes[0]
+		if l := obtenerLibro(t, libro.ID); l.Copias != 1 {
+			t.Errorf("una solicitud no debe ocupar copias: copias = %d", l.Copias)
+		}
+		if resp := c.get("/prestamos"); !strings.Contains(resp.Cuerpo, "Esperando aprobación") {
+			t.Errorf("/prestamos no muestra la solicitud pendiente")
+		}
+		esperarRedireccion(t, c.post("/prestamos", url.Values{"libroID": {libro.ID}}), "Ya pediste este libro")
+		esperarEstado(t, c.post("/solicitudes/aprobar", url.Values{"prestamoID": {solicitud.ID}}), http.StatusForbidden)
+
+		c.login("admin", "clave")
+		if resp := c.get("/solicitudes"); !strings.Contains(resp.Cuerpo, "Códice") || !strings.Contains(resp.Cuerpo, "ana") {
+			t.Errorf("/solicitudes no muestra la solicitud de ana")
+		}
+		esperarRedireccion(t, c.post("/solicitudes/aprobar", url.Values{"prestamoID": {solicitud.ID}}), "Solicitud aprobada")
+		aprobada := obtenerPrestamo(t, solicitud.ID)
+		if aprobada.Estado != EstadoAprobado || aprobada.EjemplarID == "" || aprobada.RetirarHasta.IsZero() {
+			t.Fatalf("solicitud mal aprobada: %+v", aprobada)
+		}
+		if l := obtenerLibro(t, libro.ID); l.Copias != 0 {
+			t.Errorf("la aprobación debe apartar la copia: copias = %d", l.Copias)
+		}
+		esperarRedireccion(t, c.post("/solicitudes/aprobar", url.Values{"prestamoID": {solicitud.ID}}), "ya fue resuelta")
+
+		c.login("ana", "clave")
+		if resp := c.get("/prestamos"); !strings.Contains(resp.Cuerpo, "Retírala hasta el") {
+			t.Errorf("/prestamos no muestra la solicitud aprobada")
+		}
+		esperarRedireccion(t, c.post("/prestamos", url.Values{"libroID": {libro.ID}}), "Préstamo registrado")
+		activo := obtenerPrestamo(t, solicitud.ID)
+		if activo.Estado != EstadoActivo || activo.EjemplarID != aprobada.EjemplarID || activo.FechaVencimiento.IsZero() {
+			t.Errorf("retirar la solicitud debe activar ese mismo préstamo: %+v", activo)
+		}
+		if activos := prestamosActivos(t, ana); len(activos) != 1 {
+			t.Errorf("ana tiene %d préstamos activos, se esperaba 1", len(activos))
+		}
+	})
+}
+
+func TestSolicitudRechazada(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		crearPersona(t, "admin", "clave", "admin")
+		ana := crearPersona(t, "ana", "clave", "usuario")
+		libro := crearLibroRestringido(t, "Códice", 1)
+		solicitud := prestarEl(t, libro, ana, time.Now())
+
+		c.login("admin", "clave")
+		esperarRedireccion(t, c.post("/solicitudes/rechazar", url.Values{"prestamoID": {solicitud.ID}}), "Solicitud rechazada")
+		if p := obtenerPrestamo(t, solicitud.ID); p.Estado != EstadoRechazado || p.FechaDevolucion.IsZero() {
+			t.Errorf("solicitud mal rechazada: %+v", p)
+		}
+		if otra := prestarEl(t, libro, ana, time.Now()); otra.Estado != EstadoSolicitado {
+			t.Errorf("tras un rechazo se puede volver a pedir: %+v", otra)
+		}
+	})
+}
+
+func TestSolicitudesCuentanParaElLimite(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		ana := crearPersona(t, "ana", "clave", "usuario")
+		limite := Configuracion.LimitePrestamos(RolUsuario)
+		for i := range limite {
+			prestarEl(t, crearLibroRestringido(t, "Restringido "+string(rune('A'+i)), 1), ana, time.Now())
+		}
+		_, err := prestarLibro(context.Background(), DB, crearLibro(t, "Rayuela", 1).ID, ana.ID, time.Now())
+		if err != ErrLimitePrestamos {
+			t.Errorf("err = %v, se esperaba ErrLimitePrestamos", err)
+		}
+	})
+}
+
+func TestSolicitudNoRetiradaExpira(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		admin := crearPersona(t, "admin", "clave", "admin")
+		ana := crearPersona(t, "ana", "clave", "usuario")
+		beto := crearPersona(t, "beto", "clave", "usuario")
+		libro := crearLibroRestringido(t, "Códice", 1)
+		solicitud := prestarEl(t, libro, ana, time.Now())
+
+		hace := time.Now().Add(-2 * Configuracion.VentanaRetiro.Duration)
+		if _, err := aprobarSolicitud(context.Background(), DB, solicitud.ID, admin, hace); err != nil {
+			t.Fatalf("aprobando: %v", err)
+		}
+		if _, err := aprobarSolicitud(context.Background(), DB, solicitud.ID, beto, hace); err != ErrNoAutorizado {
+			t.Errorf("sólo un administrador aprueba: err = %v", err)
+		}
+		reserva := reservar(t, libro, beto, time.Now())
+
+		n, err := vencerSolicitudes(context.Background(), DB, time.Now())
+		if err != nil || n != 1 {
+			t.Fatalf("vencerSolicitudes = %d, %v; se esperaba 1", n, err)
+		}
+		if p := obtenerPrestamo(t, solicitud.ID); p.Estado != EstadoExpirado {
+			t.Errorf("estado = %q, se esperaba expirado", p.Estado)
+		}
+		// La copia liberada pasa al primero de la cola de reservas
+		r, _ := DB.Reservas().Obtener(context.Background(), reserva.ID)
+		if r.Estado != ReservaLista || r.EjemplarID == "" {
+			t.Errorf("la copia de la solicitud expirada debería apartarse para beto: %+v", r)
+		}
+		if n, _ := vencerSolicitudes(context.Background(), DB, time.Now()); n != 0 {
+			t.Errorf("una solicitud expirada no vuelve a expirar: %d", n)
+		}
+	})
+}
+
+func TestSolicitudUsaLaCopiaDeLaReserva(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		ctx := context.Background()
+		admin := crearPersona(t, "admin", "clave", "admin")
+		ana := crearPersona(t, "ana", "clave", "usuario")
+		beto := crearPersona(t, "beto", "clave", "usuario")
+		libro := crearLibroRestringido(t, "Códice", 1)
+		solicitud := prestarEl(t, libro, ana, time.Now())
+		if _, err := aprobarSolicitud(ctx, DB, solicitud.ID, admin, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		prestamo := prestarEl(t, libro, ana, time.Now())
+
+		// Al devolverse, la única copia queda apartada para la reserva de beto
+		reserva := reservar(t, libro, beto, time.Now())
+		if err := devolverLibro(ctx, DB, prestamo.ID, ana, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		apartada := obtenerReserva(t, reserva.ID)
+		if apartada.Estado != ReservaLista {
+			t.Fatalf("reserva: %+v", apartada)
+		}
+
+		// Su solicitud se aprueba con esa copia y la reserva queda cumplida
+		pedida := prestarEl(t, libro, beto, time.Now())
+		aprobada, err := aprobarSolicitud(ctx, DB, pedida.ID, admin, time.Now())
+		if err != nil {
+			t.Fatalf("aprobando la solicitud de quien tiene la copia apartada: %v", err)
+		}
+		if aprobada.EjemplarID != apartada.EjemplarID {
+			t.Errorf("copia de la solicitud = %s, se esperaba la de la reserva %s", aprobada.EjemplarID, apartada.EjemplarID)
+		}
+		if r := obtenerReserva(t, reserva.ID); r.Estado != ReservaCumplida || r.EjemplarID != "" {
+			t.Errorf("la reserva debe quedar cumplida: %+v", r)
+		}
+		if l := obtenerLibro(t, libro.ID); l.Copias != 0 {
+			t.Errorf("copias = %d, se esperaba 0", l.Copias)
+		}
+		if retirada := prestarEl(t, libro, beto, time.Now()); retirada.ID != pedida.ID || retirada.Estado != EstadoActivo {
+			t.Errorf("retiro: %+v", retirada)
+		}
+	})
+}
+
+func TestMigrarEstadoPrestamosSQL(t *testing.T) {
+	ruta := filepath.Join(t.TempDir(), "anterior.db")
+	db, err := sql.Open("sqlite", ruta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Tabla de préstamos con las columnas activo y perdido de versiones anteriores
+	_, err = db.Exec(`
+CREATE TABLE prestamos (
+	id               TEXT PRIMARY KEY,
+	libro_id         TEXT NOT NULL,
+	persona_id       TEXT NOT NULL,
+	fecha_prestamo   DATETIME NOT NULL,
+	fecha_devolucion DATETIME,
+	activo           BOOLEAN NOT NULL,
+	perdido          BOOLEAN NOT NULL DEFAULT FALSE
+);
+CREATE INDEX idx_prestamos_persona ON prestamos (persona_id, activo);
+INSERT INTO prestamos (id, libro_id, persona_id, fecha_prestamo, activo, perdido) VALUES
+	('activo', 'l', 'p', '2024-05-01 10:00:00', TRUE, FALSE),
+	('devuelto', 'l', 'p', '2024-04-01 10:00:00', FALSE, FALSE),
+	('perdido', 'l', 'p', '2024-03-01 10:00:00', FALSE, TRUE);`)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NuevoSQLStore(context.Background(), dialectoSQLite, ruta)
+	if err != nil {
+		t.Fatalf("abriendo la base anterior: %v", err)
+	}
+	defer store.Close()
+	for id, estado := range map[string]string{"activo": EstadoActivo, "devuelto": EstadoDevuelto, "perdido": EstadoPerdido} {
+		p, err := store.Prestamos().Obtener(context.Background(), id)
+		if err != nil {
+			t.Fatalf("leyendo el préstamo %s: %v", id, err)
+		}
+		if p.Estado != estado {
+			t.Errorf("préstamo %s: estado %q, se esperaba %q", id, p.Estado, estado)
+		}
+	}
+	activos, err := store.Prestamos().ActivosPorPersona(context.Background(), "p")
+	if err != nil || len(activos) != 1 {
+		t.Errorf("ActivosPorPersona tras migrar = %d, %v", len(activos), err)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Errores comunes que devuelven todas las implementaciones de Store.
+var (
+	ErrNoEncontrado = errors.New("registro no encontrado")
+	ErrSinCopias    = errors.New("no quedan copias disponibles")
+)
+
+// ConsultaPagina pide una página de un listado ordenado por el campo Orden
+// (su nombre en JSON, ver ordenesLibro y ordenesPersona) y, a igual valor,
+// por ID. Despues es la posición del último elemento de la página anterior;
+// nil en la primera.
+type ConsultaPagina struct {
+	Orden   string
+	Desc    bool
+	Despues *PosicionPagina
+	Limite  int
+}
+
+// PosicionPagina ubica un elemento en un listado ordenado: el valor de su
+// campo de orden (string o int) y su ID.
+type PosicionPagina struct {
+	Valor any
+	ID    string
+}
+
+// LibroStore agrupa las operaciones sobre la colección de libros.
+type LibroStore interface {
+	Listar(ctx context.Context) ([]Libro, error)
+	// Pagina devuelve hasta consulta.Limite libros en el orden pedido.
+	Pagina(ctx context.Context, consulta ConsultaPagina) ([]Libro, error)
+	Obtener(ctx context.Context, id string) (*Libro, error)
+	Crear(ctx context.Context, libro *Libro) error // Asigna libro.ID
+	Guardar(ctx context.Context, libro *Libro) error
+	Eliminar(ctx context.Context, id string) error
+}
+
+// EjemplarStore agrupa las operaciones sobre las copias físicas de los libros.
+type EjemplarStore interface {
+	Obtener(ctx context.Context, id string) (*Ejemplar, error)
+	// PorLibro devuelve los ejemplares del libro ordenados por código.
+	PorLibro(ctx context.Context, libroID string) ([]Ejemplar, error)
+	BuscarPorCodigo(ctx context.Context, codigo string) (*Ejemplar, error)
+	Crear(ctx context.Context, ejemplar *Ejemplar) error // Asigna ejemplar.ID
+	Guardar(ctx context.Context, ejemplar *Ejemplar) error
+	Eliminar(ctx context.Context, id string) error
+}
+
+// PersonaStore agrupa las operaciones sobre la colección de personas.
+type PersonaStore interface {
+	Listar(ctx context.Context) ([]Persona, error)
+	// Pagina devuelve hasta consulta.Limite personas en el orden pedido.
+	Pagina(ctx context.Context, consulta ConsultaPagina) ([]Persona, error)
+	Obtener(ctx context.Context, id string) (*Persona, error)
+	// PorNombre devuelve las personas con ese nombre, ordenadas por ID; el
+	// nombre no es único.
+	PorNombre(ctx context.Context, nombre string) ([]Persona, error)
+	BuscarPorCedula(ctx context.Context, cedula string) (*Persona, error)
+	Crear(ctx context.Context, persona *Persona) error // Asigna persona.ID
+	Guardar(ctx context.Context, persona *Persona) error
+	Eliminar(ctx context.Context, id string) error
+}
+
+// FiltroPrestamos restringe una consulta de historial. Los campos vacíos no
+// filtran; Desde es inclusivo y Hasta exclusivo, ambos sobre FechaPrestamo.
+// Estados deja sólo los préstamos en alguno de los estados dados.
+type FiltroPrestamos struct {
+	LibroID   string
+	PersonaID string
+	Estados   []string
+	Desde     time.Time
+	Hasta     time.Time
+}
+
+// PrestamoStore agrupa las operaciones sobre la colección de préstamos.
+type PrestamoStore interface {
+	Obtener(ctx context.Context, id string) (*Prestamo, error)
+	Activos(ctx context.Context) ([]Prestamo, error)
+	ActivosPorPersona(ctx context.Context, personaID string) ([]Prestamo, error)
+	ActivosPorLibro(ctx context.Context, libroID string) ([]Prestamo, error)
+	// Historial devuelve préstamos en cualquier estado, del más reciente al
+	// más antiguo.
+	Historial(ctx context.Context, filtro FiltroPrestamos) ([]Prestamo, error)
+	// Vencidos devuelve los préstamos activos cuya fecha de vencimiento es
+	// anterior a ahora, del más atrasado al menos atrasado.
+	Vencidos(ctx context.Context, ahora time.Time) ([]Prestamo, error)
+	// AprobadosVencidos devuelve las solicitudes aprobadas cuyo plazo de
+	// retiro terminó antes de ahora.
+	AprobadosVencidos(ctx context.Context, ahora time.Time) ([]Prestamo, error)
+	Crear(ctx context.Context, prestamo *Prestamo) error // Asigna prestamo.ID
+	Guardar(ctx context.Context, prestamo *Prestamo) error
+	Eliminar(ctx context.Context, id string) error
+}
+
+// ReservaStore agrupa las operaciones sobre las colas de espera de libros.
+type ReservaStore interface {
+	Obtener(ctx context.Context, id string) (*Reserva, error)
+	// ActivasPorLibro devuelve la cola de espera del libro, de la reserva
+	// más antigua a la más reciente.
+	ActivasPorLibro(ctx context.Context, libroID string) ([]Reserva, error)
+	// ActivasPorPersona devuelve las reservas activas de la persona, de la
+	// más antigua a la más reciente.
+	ActivasPorPersona(ctx context.Context, personaID string) ([]Reserva, error)
+	// ListasVencidas devuelve las reservas en estado ReservaLista cuyo plazo
+	// de retiro terminó antes de ahora.
+	ListasVencidas(ctx context.Context, ahora time.Time) ([]Reserva, error)
+	Crear(ctx context.Context, reserva *Reserva) error // Asigna reserva.ID
+	Guardar(ctx context.Context, reserva *Reserva) error
+}
+
+// MultaStore es el libro de multas. Sólo se agregan asientos.
+type MultaStore interface {
+	// PorPersona devuelve los movimientos de la persona, del más antiguo al
+	// más reciente.
+	PorPersona(ctx context.Context, personaID string) ([]MovimientoMulta, error)
+	Crear(ctx context.Context, movimiento *MovimientoMulta) error // Asigna movimiento.ID
+}
+
+// SesionStore guarda las sesiones iniciadas.
+type SesionStore interface {
+	Obtener(ctx context.Context, id string) (*Sesion, error)
+	Guardar(ctx context.Context, sesion *Sesion) error // El ID lo asigna quien crea la sesión
+	Eliminar(ctx context.Context, id string) error
+	EliminarExpiradas(ctx context.Context, ahora time.Time) (int, error)
+}
+
+// TokenStore guarda los tokens de la API.
+type TokenStore interface {
+	Obtener(ctx context.Context, id string) (*TokenAPI, error)
+	// PorPersona devuelve los tokens de la persona, del más antiguo al más
+	// reciente.
+	PorPersona(ctx context.Context, personaID string) ([]TokenAPI, error)
+	Guardar(ctx context.Context, token *TokenAPI) error // El ID lo asigna quien crea el token
+	Eliminar(ctx context.Context, id string) error
+}
+
+// Store es la capa de persistencia de la aplicación. Los handlers sólo
+// dependen de esta interfaz, nunca de un backend concreto.
+//
+// RunTransaction ejecuta f de forma atómica: el Store que recibe f está
+// ligado a la transacción y todas sus lecturas y escrituras se confirman o
+// descartan juntas. Igual que en Firestore, f debe hacer todas sus lecturas
+// antes de la primera escritura y puede ejecutarse más de una vez.
+type Store interface {
+	Libros() LibroStore
+	Ejemplares() EjemplarStore
+	Personas() PersonaStore
+	Prestamos() PrestamoStore
+	Reservas() ReservaStore
+	Multas() MultaStore
+	Sesiones() SesionStore
+	Tokens() TokenStore
+	RunTransaction(ctx context.Context, f func(ctx context.Context, tx Store) error) error
+	Close() error
+}
+
+// DB es el Store global que usan los handlers; se inicializa en main.
+var DB Store
+
+// NuevoStore crea el backend indicado en cfg.Store: "firestore", "memoria",
+// "sqlite" o "postgres".
+func NuevoStore(ctx context.Context, cfg Config) (Store, error) {
+	switch cfg.Store {
+	case "", "firestore":
+		if err := InitFirebase(ctx, cfg); err != nil {
+			return nil, err
+		}
+		if n, err := migrarEstadoPrestamosFirestore(ctx, FirestoreClient); err != nil {
+			return nil, fmt.Errorf("migrando estados de préstamos: %w", err)
+		} else if n > 0 {
+			log.Printf("📚 Se pasaron %d préstamos al campo estado", n)
+		}
+		return NuevoFirestoreStore(FirestoreClient), nil
+	case "memoria":
+		return NuevoMemoriaStore(), nil
+	case dialectoSQLite, dialectoPostgres:
+		return NuevoSQLStore(ctx, cfg.Store, cfg.DatabaseURL)
+	default:
+		return nil, fmt.Errorf("backend de almacenamiento desconocido: %q", cfg.Store)
+	}
+}
+
+const alfabetoID = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+// nuevoID genera un identificador aleatorio de 20 caracteres, con el mismo
+// formato que los IDs automáticos de Firestore.
+func nuevoID() string {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	for i := range b {
+		b[i] = alfabetoID[int(b[i])%len(alfabetoID)]
+	}
+	return string(b)
+}
@@ -0,0 +1,818 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Nombres de las colecciones en Firestore.
+const (
+	coleccionLibros     = "libro"
+	coleccionEjemplares = "ejemplares"
+	coleccionPersonas   = "persona"
+	coleccionPrestamos  = "prestamos"
+	coleccionReservas   = "reservas"
+	coleccionMultas     = "multas"
+	coleccionSesiones   = "sesiones"
+	coleccionTokens     = "tokens"
+)
+
+// firestoreStore implementa Store sobre Cloud Firestore. Cuando tx no es nil
+// todas las operaciones se hacen dentro de esa transacción.
+type firestoreStore struct {
+	client *firestore.Client
+	tx     *firestore.Transaction
+}
+
+// NuevoFirestoreStore crea un Store que usa el cliente de Firestore dado.
+func NuevoFirestoreStore(client *firestore.Client) Store {
+	return &firestoreStore{client: client}
+}
+
+func (s *firestoreStore) Libros() LibroStore        { return firestoreLibros{s} }
+func (s *firestoreStore) Ejemplares() EjemplarStore { return firestoreEjemplares{s} }
+func (s *firestoreStore) Personas() PersonaStore    { return firestorePersonas{s} }
+func (s *firestoreStore) Prestamos() PrestamoStore  { return firestorePrestamos{s} }
+func (s *firestoreStore) Reservas() ReservaStore    { return firestoreReservas{s} }
+func (s *firestoreStore) Multas() MultaStore        { return firestoreMultas{s} }
+func (s *firestoreStore) Sesiones() SesionStore     { return firestoreSesiones{s} }
+func (s *firestoreStore) Tokens() TokenStore        { return firestoreTokens{s} }
+
+func (s *firestoreStore) RunTransaction(ctx context.Context, f func(ctx context.Context, tx Store) error) error {
+	if s.tx != nil {
+		return f(ctx, s)
+	}
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		return f(ctx, &firestoreStore{client: s.client, tx: tx})
+	})
+}
+
+func (s *firestoreStore) Close() error {
+	if s.tx != nil {
+		return nil
+	}
+	return s.client.Close()
+}
+
+func (s *firestoreStore) get(ctx context.Context, ref *firestore.DocumentRef) (*firestore.DocumentSnapshot, error) {
+	var doc *firestore.DocumentSnapshot
+	var err error
+	if s.tx != nil {
+		doc, err = s.tx.Get(ref)
+	} else {
+		doc, err = ref.Get(ctx)
+	}
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrNoEncontrado
+	}
+	return doc, err
+}
+
+func (s *firestoreStore) documentos(ctx context.Context, q firestore.Query) *firestore.DocumentIterator {
+	if s.tx != nil {
+		return s.tx.Documents(q)
+	}
+	return q.Documents(ctx)
+}
+
+// consultaPagina ordena la colección por consulta.Orden y por ID, y empieza
+// después del último documento de la página anterior con StartAfter. Si ese
+// documento existe se usa su snapshot, que tiene los valores tal como están
+// guardados (algunas personas antiguas tienen la cédula o el año con otro
+// tipo); si se borró, se usan los valores del cursor.
+func (s *firestoreStore) consultaPagina(ctx context.Context, coleccion string, consulta ConsultaPagina) (firestore.Query, error) {
+	direccion := firestore.Asc
+	if consulta.Desc {
+		direccion = firestore.Desc
+	}
+	q := s.client.Collection(coleccion).OrderBy(consulta.Orden, direccion).OrderBy(firestore.DocumentID, direccion).Limit(consulta.Limite)
+	if d := consulta.Despues; d != nil {
+		doc, err := s.get(ctx, s.client.Collection(coleccion).Doc(d.ID))
+		switch {
+		case err == nil:
+			q = q.StartAfter(doc)
+		case errors.Is(err, ErrNoEncontrado):
+			q = q.StartAfter(d.Valor, d.ID)
+		default:
+			return q, err
+		}
+	}
+	return q, nil
+}
+
+// primero devuelve el primer documento de la consulta o ErrNoEncontrado.
+func (s *firestoreStore) primero(ctx context.Context, q firestore.Query) (*firestore.DocumentSnapshot, error) {
+	iter := s.documentos(ctx, q.Limit(1))
+	defer iter.Stop()
+	doc, err := iter.Next()
+	if err == iterator.Done {
+		return nil, ErrNoEncontrado
+	}
+	return doc, err
+}
+
+func (s *firestoreStore) crear(ctx context.Context, col string, datos interface{}) (string, error) {
+	ref := s.client.Collection(col).NewDoc()
+	if s.tx != nil {
+		return ref.ID, s.tx.Create(ref, datos)
+	}
+	_, err := ref.Create(ctx, datos)
+	return ref.ID, err
+}
+
+func (s *firestoreStore) set(ctx context.Context, col, id string, datos interface{}) error {
+	ref := s.client.Collection(col).Doc(id)
+	if s.tx != nil {
+		return s.tx.Set(ref, datos)
+	}
+	_, err := ref.Set(ctx, datos)
+	return err
+}
+
+func (s *firestoreStore) eliminar(ctx context.Context, col, id string) error {
+	ref := s.client.Collection(col).Doc(id)
+	if s.tx != nil {
+		return s.tx.Delete(ref)
+	}
+	_, err := ref.Delete(ctx)
+	return err
+}
+
+// --- Libros ---
+
+type firestoreLibros struct{ s *firestoreStore }
+
+func libroDesdeDoc(doc *firestore.DocumentSnapshot) (*Libro, error) {
+	var libro Libro
+	if err := doc.DataTo(&libro); err != nil {
+		return nil, err
+	}
+	libro.ID = doc.Ref.ID
+	return &libro, nil
+}
+
+func (f firestoreLibros) Listar(ctx context.Context) ([]Libro, error) {
+	return f.listar(ctx, f.s.client.Collection(coleccionLibros).Query)
+}
+
+func (f firestoreLibros) Pagina(ctx context.Context, consulta ConsultaPagina) ([]Libro, error) {
+	if _, ok := ordenesLibro[consulta.Orden]; !ok {
+		return nil, ErrOrdenInvalido
+	}
+	q, err := f.s.consultaPagina(ctx, coleccionLibros, consulta)
+	if err != nil {
+		return nil, err
+	}
+	return f.listar(ctx, q)
+}
+
+func (f firestoreLibros) listar(ctx context.Context, q firestore.Query) ([]Libro, error) {
+	iter := f.s.documentos(ctx, q)
+	defer iter.Stop()
+	var libros []Libro
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		libro, err := libroDesdeDoc(doc)
+		if err != nil {
+			log.Printf("Error al mapear datos de libro %s: %v", doc.Ref.ID, err)
+			continue // Saltar este documento y continuar con el siguiente
+		}
+		libros = append(libros, *libro)
+	}
+	return libros, nil
+}
+
+func (f firestoreLibros) Obtener(ctx context.Context, id string) (*Libro, error) {
+	doc, err := f.s.get(ctx, f.s.client.Collection(coleccionLibros).Doc(id))
+	if err != nil {
+		return nil, err
+	}
+	return libroDesdeDoc(doc)
+}
+
+func (f firestoreLibros) Crear(ctx context.Context, libro *Libro) error {
+	datos := *libro
+	datos.ID = ""
+	id, err := f.s.crear(ctx, coleccionLibros, datos)
+	if err != nil {
+		return err
+	}
+	libro.ID = id
+	return nil
+}
+
+func (f firestoreLibros) Guardar(ctx context.Context, libro *Libro) error {
+	datos := *libro
+	datos.ID = ""
+	return f.s.set(ctx, coleccionLibros, libro.ID, datos)
+}
+
+func (f firestoreLibros) Eliminar(ctx context.Context, id string) error {
+	return f.s.eliminar(ctx, coleccionLibros, id)
+}
+
+// --- Ejemplares ---
+
+type firestoreEjemplares struct{ s *firestoreStore }
+
+func ejemplarDesdeDoc(doc *firestore.DocumentSnapshot) (*Ejemplar, error) {
+	var e Ejemplar
+	if err := doc.DataTo(&e); err != nil {
+		return nil, err
+	}
+	e.ID = doc.Ref.ID
+	return &e, nil
+}
+
+func (f firestoreEjemplares) Obtener(ctx context.Context, id string) (*Ejemplar, error) {
+	doc, err := f.s.get(ctx, f.s.client.Collection(coleccionEjemplares).Doc(id))
+	if err != nil {
+		return nil, err
+	}
+	return ejemplarDesdeDoc(doc)
+}
+
+// PorLibro necesita un índice compuesto libroID + codigo.
+func (f firestoreEjemplares) PorLibro(ctx context.Context, libroID string) ([]Ejemplar, error) {
+	q := f.s.client.Collection(coleccionEjemplares).
+		Where("libroID", "==", libroID).
+		OrderBy("codigo", firestore.Asc)
+	iter := f.s.documentos(ctx, q)
+	defer iter.Stop()
+	var ejemplares []Ejemplar
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		e, err := ejemplarDesdeDoc(doc)
+		if err != nil {
+			log.Printf("Error al mapear ejemplar %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		ejemplares = append(ejemplares, *e)
+	}
+	return ejemplares, nil
+}
+
+func (f firestoreEjemplares) BuscarPorCodigo(ctx context.Context, codigo string) (*Ejemplar, error) {
+	doc, err := f.s.primero(ctx, f.s.client.Collection(coleccionEjemplares).Where("codigo", "==", codigo))
+	if err != nil {
+		return nil, err
+	}
+	return ejemplarDesdeDoc(doc)
+}
+
+func (f firestoreEjemplares) Crear(ctx context.Context, ejemplar *Ejemplar) error {
+	datos := *ejemplar
+	datos.ID = ""
+	id, err := f.s.crear(ctx, coleccionEjemplares, datos)
+	if err != nil {
+		return err
+	}
+	ejemplar.ID = id
+	return nil
+}
+
+func (f firestoreEjemplares) Guardar(ctx context.Context, ejemplar *Ejemplar) error {
+	datos := *ejemplar
+	datos.ID = ""
+	return f.s.set(ctx, coleccionEjemplares, ejemplar.ID, datos)
+}
+
+func (f firestoreEjemplares) Eliminar(ctx context.Context, id string) error {
+	return f.s.eliminar(ctx, coleccionEjemplares, id)
+}
+
+// --- Personas ---
+
+type firestorePersonas struct{ s *firestoreStore }
+
+// personaDesdeDoc mapea a mano un documento de persona para tolerar las
+// inconsistencias de tipo de los datos existentes (cédula numérica, año
+// guardado como texto, etc.).
+func personaDesdeDoc(doc *firestore.DocumentSnapshot) *Persona {
+	persona := &Persona{ID: doc.Ref.ID}
+	data := doc.Data()
+
+	if nombre, ok := data["nombre"].(string); ok {
+		persona.Nombre = nombre
+	} else {
+		log.Printf("Advertencia: Tipo inesperado para 'nombre' en persona %s: %T", persona.ID, data["nombre"])
+	}
+
+	switch cedula := data["cedula"].(type) {
+	case string:
+		persona.Cedula = cedula
+	case float64:
+		// Si es un número, convertir a string (ej. 1234567890.0 -> "1234567890")
+		persona.Cedula = strconv.FormatFloat(cedula, 'f', 0, 64)
+	case int64:
+		persona.Cedula = strconv.FormatInt(cedula, 10)
+	default:
+		log.Printf("Advertencia: Tipo inesperado para 'cedula' en persona %s: %T", persona.ID, data["cedula"])
+	}
+
+	switch ano := data["ano"].(type) {
+	case int64:
+		persona.Ano = int(ano)
+	case float64:
+		persona.Ano = int(ano)
+	case string:
+		if parsed, err := strconv.Atoi(ano); err == nil {
+			persona.Ano = parsed
+		} else {
+			log.Printf("Advertencia: No se pudo convertir 'ano' '%s' a int para persona %s: %v", ano, persona.ID, err)
+		}
+	default:
+		log.Printf("Advertencia: Tipo inesperado para 'ano' en persona %s: %T", persona.ID, data["ano"])
+	}
+
+	if contrasena, ok := data["contrasena"].(string); ok {
+		persona.Contrasena = contrasena
+	}
+	if rol, ok := data["rol"].(string); ok {
+		persona.Rol = rol
+	}
+	return persona
+}
+
+func (f firestorePersonas) Listar(ctx context.Context) ([]Persona, error) {
+	return f.listar(ctx, f.s.client.Collection(coleccionPersonas).Query)
+}
+
+func (f firestorePersonas) Pagina(ctx context.Context, consulta ConsultaPagina) ([]Persona, error) {
+	if _, ok := ordenesPersona[consulta.Orden]; !ok {
+		return nil, ErrOrdenInvalido
+	}
+	q, err := f.s.consultaPagina(ctx, coleccionPersonas, consulta)
+	if err != nil {
+		return nil, err
+	}
+	return f.listar(ctx, q)
+}
+
+func (f firestorePersonas) listar(ctx context.Context, q firestore.Query) ([]Persona, error) {
+	iter := f.s.documentos(ctx, q)
+	defer iter.Stop()
+	var personas []Persona
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		personas = append(personas, *personaDesdeDoc(doc))
+	}
+	return personas, nil
+}
+
+func (f firestorePersonas) Obtener(ctx context.Context, id string) (*Persona, error) {
+	doc, err := f.s.get(ctx, f.s.client.Collection(coleccionPersonas).Doc(id))
+	if err != nil {
+		return nil, err
+	}
+	return personaDesdeDoc(doc), nil
+}
+
+func (f firestorePersonas) PorNombre(ctx context.Context, nombre string) ([]Persona, error) {
+	return f.listar(ctx, f.s.client.Collection(coleccionPersonas).Where("nombre", "==", nombre).OrderBy(firestore.DocumentID, firestore.Asc))
+}
+
+func (f firestorePersonas) BuscarPorCedula(ctx context.Context, cedula string) (*Persona, error) {
+	doc, err := f.s.primero(ctx, f.s.client.Collection(coleccionPersonas).Where("cedula", "==", cedula))
+	if err != nil {
+		return nil, err
+	}
+	return personaDesdeDoc(doc), nil
+}
+
+func (f firestorePersonas) Crear(ctx context.Context, persona *Persona) error {
+	datos := *persona
+	datos.ID = ""
+	id, err := f.s.crear(ctx, coleccionPersonas, datos)
+	if err != nil {
+		return err
+	}
+	persona.ID = id
+	return nil
+}
+
+func (f firestorePersonas) Guardar(ctx context.Context, persona *Persona) error {
+	datos := *persona
+	datos.ID = ""
+	return f.s.set(ctx, coleccionPersonas, persona.ID, datos)
+}
+
+func (f firestorePersonas) Eliminar(ctx context.Context, id string) error {
+	return f.s.eliminar(ctx, coleccionPersonas, id)
+}
+
+// --- Préstamos ---
+
+type firestorePrestamos struct{ s *firestoreStore }
+
+func prestamoDesdeDoc(doc *firestore.DocumentSnapshot) (*Prestamo, error) {
+	var p Prestamo
+	if err := doc.DataTo(&p); err != nil {
+		return nil, err
+	}
+	p.ID = doc.Ref.ID
+	return &p, nil
+}
+
+func (f firestorePrestamos) Obtener(ctx context.Context, id string) (*Prestamo, error) {
+	doc, err := f.s.get(ctx, f.s.client.Collection(coleccionPrestamos).Doc(id))
+	if err != nil {
+		return nil, err
+	}
+	return prestamoDesdeDoc(doc)
+}
+
+func (f firestorePrestamos) Activos(ctx context.Context) ([]Prestamo, error) {
+	return f.listar(ctx, f.s.client.Collection(coleccionPrestamos).Where("estado", "==", EstadoActivo))
+}
+
+func (f firestorePrestamos) ActivosPorPersona(ctx context.Context, personaID string) ([]Prestamo, error) {
+	q := f.s.client.Collection(coleccionPrestamos).
+		Where("estado", "==", EstadoActivo).
+		Where("personaID", "==", personaID)
+	return f.listar(ctx, q)
+}
+
+func (f firestorePrestamos) ActivosPorLibro(ctx context.Context, libroID string) ([]Prestamo, error) {
+	q := f.s.client.Collection(coleccionPrestamos).
+		Where("estado", "==", EstadoActivo).
+		Where("libroID", "==", libroID)
+	return f.listar(ctx, q)
+}
+
+// Historial combina igualdades con un rango sobre fechaPrestamo; Firestore
+// pide un índice compuesto para cada combinación de filtros que se use.
+func (f firestorePrestamos) Historial(ctx context.Context, filtro FiltroPrestamos) ([]Prestamo, error) {
+	q := f.s.client.Collection(coleccionPrestamos).Query
+	if filtro.LibroID != "" {
+		q = q.Where("libroID", "==", filtro.LibroID)
+	}
+	if filtro.PersonaID != "" {
+		q = q.Where("personaID", "==", filtro.PersonaID)
+	}
+	if len(filtro.Estados) > 0 {
+		q = q.Where("estado", "in", filtro.Estados)
+	}
+	if !filtro.Desde.IsZero() {
+		q = q.Where("fechaPrestamo", ">=", filtro.Desde)
+	}
+	if !filtro.Hasta.IsZero() {
+		q = q.Where("fechaPrestamo", "<", filtro.Hasta)
+	}
+	return f.listar(ctx, q.OrderBy("fechaPrestamo", firestore.Desc))
+}
+
+// Vencidos necesita un índice compuesto sobre estado + fechaVencimiento. Los
+// préstamos sin fechaVencimiento no aparecen.
+func (f firestorePrestamos) Vencidos(ctx context.Context, ahora time.Time) ([]Prestamo, error) {
+	q := f.s.client.Collection(coleccionPrestamos).
+		Where("estado", "==", EstadoActivo).
+		Where("fechaVencimiento", "<", ahora).
+		OrderBy("fechaVencimiento", firestore.Asc)
+	return f.listar(ctx, q)
+}
+
+// AprobadosVencidos necesita un índice compuesto estado + retirarHasta.
+func (f firestorePrestamos) AprobadosVencidos(ctx context.Context, ahora time.Time) ([]Prestamo, error) {
+	q := f.s.client.Collection(coleccionPrestamos).
+		Where("estado", "==", EstadoAprobado).
+		Where("retirarHasta", "<", ahora).
+		OrderBy("retirarHasta", firestore.Asc)
+	return f.listar(ctx, q)
+}
+
+// migrarEstadoPrestamosFirestore pasa los préstamos guardados con los campos
+// activo y perdido de versiones anteriores al campo estado, y borra los
+// campos viejos. Devuelve cuántos préstamos migró.
+func migrarEstadoPrestamosFirestore(ctx context.Context, client *firestore.Client) (int, error) {
+	iter := client.Collection(coleccionPrestamos).Where("activo", "in", []bool{true, false}).Documents(ctx)
+	defer iter.Stop()
+	n := 0
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		estado := EstadoDevuelto
+		if activo, _ := doc.Data()["activo"].(bool); activo {
+			estado = EstadoActivo
+		} else if perdido, _ := doc.Data()["perdido"].(bool); perdido {
+			estado = EstadoPerdido
+		}
+		_, err = doc.Ref.Update(ctx, []firestore.Update{
+			{Path: "estado", Value: estado},
+			{Path: "activo", Value: firestore.Delete},
+			{Path: "perdido", Value: firestore.Delete},
+		})
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+func (f firestorePrestamos) listar(ctx context.Context, q firestore.Query) ([]Prestamo, error) {
+	iter := f.s.documentos(ctx, q)
+	defer iter.Stop()
+	var prestamos []Prestamo
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		p, err := prestamoDesdeDoc(doc)
+		if err != nil {
+			log.Printf("Error al mapear préstamo %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		prestamos = append(prestamos, *p)
+	}
+	return prestamos, nil
+}
+
+func (f firestorePrestamos) Crear(ctx context.Context, prestamo *Prestamo) error {
+	datos := *prestamo
+	datos.ID = ""
+	id, err := f.s.crear(ctx, coleccionPrestamos, datos)
+	if err != nil {
+		return err
+	}
+	prestamo.ID = id
+	return nil
+}
+
+func (f firestorePrestamos) Guardar(ctx context.Context, prestamo *Prestamo) error {
+	datos := *prestamo
+	datos.ID = ""
+	return f.s.set(ctx, coleccionPrestamos, prestamo.ID, datos)
+}
+
+func (f firestorePrestamos) Eliminar(ctx context.Context, id string) error {
+	return f.s.eliminar(ctx, coleccionPrestamos, id)
+}
+
+// --- Reservas ---
+
+type firestoreReservas struct{ s *firestoreStore }
+
+func reservaDesdeDoc(doc *firestore.DocumentSnapshot) (*Reserva, error) {
+	var r Reserva
+	if err := doc.DataTo(&r); err != nil {
+		return nil, err
+	}
+	r.ID = doc.Ref.ID
+	return &r, nil
+}
+
+func (f firestoreReservas) Obtener(ctx context.Context, id string) (*Reserva, error) {
+	doc, err := f.s.get(ctx, f.s.client.Collection(coleccionReservas).Doc(id))
+	if err != nil {
+		return nil, err
+	}
+	return reservaDesdeDoc(doc)
+}
+
+// ActivasPorLibro necesita un índice compuesto libroID + estado + creada.
+func (f firestoreReservas) ActivasPorLibro(ctx context.Context, libroID string) ([]Reserva, error) {
+	q := f.s.client.Collection(coleccionReservas).
+		Where("libroID", "==", libroID).
+		Where("estado", "in", estadosReservaActivos).
+		OrderBy("creada", firestore.Asc)
+	return f.listar(ctx, q)
+}
+
+// ActivasPorPersona necesita un índice compuesto personaID + estado + creada.
+func (f firestoreReservas) ActivasPorPersona(ctx context.Context, personaID string) ([]Reserva, error) {
+	q := f.s.client.Collection(coleccionReservas).
+		Where("personaID", "==", personaID).
+		Where("estado", "in", estadosReservaActivos).
+		OrderBy("creada", firestore.Asc)
+	return f.listar(ctx, q)
+}
+
+// ListasVencidas necesita un índice compuesto estado + disponibleHasta.
+func (f firestoreReservas) ListasVencidas(ctx context.Context, ahora time.Time) ([]Reserva, error) {
+	q := f.s.client.Collection(coleccionReservas).
+		Where("estado", "==", ReservaLista).
+		Where("disponibleHasta", "<", ahora).
+		OrderBy("disponibleHasta", firestore.Asc)
+	return f.listar(ctx, q)
+}
+
+func (f firestoreReservas) listar(ctx context.Context, q firestore.Query) ([]Reserva, error) {
+	iter := f.s.documentos(ctx, q)
+	defer iter.Stop()
+	var reservas []Reserva
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		r, err := reservaDesdeDoc(doc)
+		if err != nil {
+			log.Printf("Error al mapear reserva %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		reservas = append(reservas, *r)
+	}
+	return reservas, nil
+}
+
+func (f firestoreReservas) Crear(ctx context.Context, reserva *Reserva) error {
+	datos := *reserva
+	datos.ID = ""
+	id, err := f.s.crear(ctx, coleccionReservas, datos)
+	if err != nil {
+		return err
+	}
+	reserva.ID = id
+	return nil
+}
+
+func (f firestoreReservas) Guardar(ctx context.Context, reserva *Reserva) error {
+	datos := *reserva
+	datos.ID = ""
+	return f.s.set(ctx, coleccionReservas, reserva.ID, datos)
+}
+
+// --- Multas ---
+
+type firestoreMultas struct{ s *firestoreStore }
+
+// PorPersona necesita un índice compuesto personaID + fecha.
+func (f firestoreMultas) PorPersona(ctx context.Context, personaID string) ([]MovimientoMulta, error) {
+	q := f.s.client.Collection(coleccionMultas).
+		Where("personaID", "==", personaID).
+		OrderBy("fecha", firestore.Asc)
+	iter := f.s.documentos(ctx, q)
+	defer iter.Stop()
+	var movimientos []MovimientoMulta
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var m MovimientoMulta
+		if err := doc.DataTo(&m); err != nil {
+			log.Printf("Error al mapear movimiento de multa %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		m.ID = doc.Ref.ID
+		movimientos = append(movimientos, m)
+	}
+	return movimientos, nil
+}
+
+func (f firestoreMultas) Crear(ctx context.Context, movimiento *MovimientoMulta) error {
+	datos := *movimiento
+	datos.ID = ""
+	id, err := f.s.crear(ctx, coleccionMultas, datos)
+	if err != nil {
+		return err
+	}
+	movimiento.ID = id
+	return nil
+}
+
+// --- Sesiones ---
+
+// firestoreSesiones guarda cada sesión en un documento cuyo ID es el de la
+// sesión. Se puede configurar una política TTL de Firestore sobre el campo
+// "expira" para que el propio Firestore borre las sesiones vencidas.
+type firestoreSesiones struct{ s *firestoreStore }
+
+func (f firestoreSesiones) Obtener(ctx context.Context, id string) (*Sesion, error) {
+	doc, err := f.s.get(ctx, f.s.client.Collection(coleccionSesiones).Doc(id))
+	if err != nil {
+		return nil, err
+	}
+	var sesion Sesion
+	if err := doc.DataTo(&sesion); err != nil {
+		return nil, err
+	}
+	sesion.ID = doc.Ref.ID
+	return &sesion, nil
+}
+
+func (f firestoreSesiones) Guardar(ctx context.Context, sesion *Sesion) error {
+	return f.s.set(ctx, coleccionSesiones, sesion.ID, sesion)
+}
+
+func (f firestoreSesiones) Eliminar(ctx context.Context, id string) error {
+	return f.s.eliminar(ctx, coleccionSesiones, id)
+}
+
+func (f firestoreSesiones) EliminarExpiradas(ctx context.Context, ahora time.Time) (int, error) {
+	iter := f.s.documentos(ctx, f.s.client.Collection(coleccionSesiones).Where("expira", "<", ahora))
+	defer iter.Stop()
+	n := 0
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return n, err
+		}
+		if err := f.s.eliminar(ctx, coleccionSesiones, doc.Ref.ID); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// --- Tokens ---
+
+// firestoreTokens guarda cada token en un documento cuyo ID es el hash del
+// token.
+type firestoreTokens struct{ s *firestoreStore }
+
+func tokenDesdeDoc(doc *firestore.DocumentSnapshot) (*TokenAPI, error) {
+	var token TokenAPI
+	if err := doc.DataTo(&token); err != nil {
+		return nil, err
+	}
+	token.ID = doc.Ref.ID
+	return &token, nil
+}
+
+func (f firestoreTokens) Obtener(ctx context.Context, id string) (*TokenAPI, error) {
+	doc, err := f.s.get(ctx, f.s.client.Collection(coleccionTokens).Doc(id))
+	if err != nil {
+		return nil, err
+	}
+	return tokenDesdeDoc(doc)
+}
+
+// PorPersona necesita un índice compuesto personaID + creado.
+func (f firestoreTokens) PorPersona(ctx context.Context, personaID string) ([]TokenAPI, error) {
+	q := f.s.client.Collection(coleccionTokens).
+		Where("personaID", "==", personaID).
+		OrderBy("creado", firestore.Asc)
+	iter := f.s.documentos(ctx, q)
+	defer iter.Stop()
+	var tokens []TokenAPI
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		token, err := tokenDesdeDoc(doc)
+		if err != nil {
+			log.Printf("Error al mapear token %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		tokens = append(tokens, *token)
+	}
+	return tokens, nil
+}
+
+func (f firestoreTokens) Guardar(ctx context.Context, token *TokenAPI) error {
+	return f.s.set(ctx, coleccionTokens, token.ID, token)
+}
+
+func (f firestoreTokens) Eliminar(ctx context.Context, id string) error {
+	return f.s.eliminar(ctx, coleccionTokens, id)
+}
@@ -0,0 +1,629 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoriaDatos guarda todas las colecciones del store en memoria.
+type memoriaDatos struct {
+	libros     map[string]Libro
+	ejemplares map[string]Ejemplar
+	personas   map[string]Persona
+	prestamos  map[string]Prestamo
+	reservas   map[string]Reserva
+	multas     map[string]MovimientoMulta
+	sesiones   map[string]Sesion
+	tokens     map[string]TokenAPI
+}
+
+func (d *memoriaDatos) clonar() *memoriaDatos {
+	c := &memoriaDatos{
+		libros:     make(map[string]Libro, len(d.libros)),
+		ejemplares: make(map[string]Ejemplar, len(d.ejemplares)),
+		personas:   make(map[string]Persona, len(d.personas)),
+		prestamos:  make(map[string]Prestamo, len(d.prestamos)),
+		reservas:   make(map[string]Reserva, len(d.reservas)),
+		multas:     make(map[string]MovimientoMulta, len(d.multas)),
+		sesiones:   make(map[string]Sesion, len(d.sesiones)),
+		tokens:     make(map[string]TokenAPI, len(d.tokens)),
+	}
+	for k, v := range d.libros {
+		c.libros[k] = v
+	}
+	for k, v := range d.ejemplares {
+		c.ejemplares[k] = v
+	}
+	for k, v := range d.personas {
+		c.personas[k] = v
+	}
+	for k, v := range d.prestamos {
+		c.prestamos[k] = v
+	}
+	for k, v := range d.reservas {
+		c.reservas[k] = v
+	}
+	for k, v := range d.multas {
+		c.multas[k] = v
+	}
+	for k, v := range d.sesiones {
+		c.sesiones[k] = v
+	}
+	for k, v := range d.tokens {
+		c.tokens[k] = v
+	}
+	return c
+}
+
+// memoriaStore implementa Store en memoria. Sirve para desarrollo local y
+// pruebas sin credenciales de Google; los datos se pierden al reiniciar.
+type memoriaStore struct {
+	mu    *sync.Mutex
+	datos **memoriaDatos
+	enTx  bool // true si el mutex ya está tomado por RunTransaction
+}
+
+// NuevoMemoriaStore crea un Store vacío en memoria.
+func NuevoMemoriaStore() Store {
+	datos := &memoriaDatos{
+		libros:     map[string]Libro{},
+		ejemplares: map[string]Ejemplar{},
+		personas:   map[string]Persona{},
+		prestamos:  map[string]Prestamo{},
+		reservas:   map[string]Reserva{},
+		multas:     map[string]MovimientoMulta{},
+		sesiones:   map[string]Sesion{},
+		tokens:     map[string]TokenAPI{},
+	}
+	return &memoriaStore{mu: &sync.Mutex{}, datos: &datos}
+}
+
+func (s *memoriaStore) Libros() LibroStore        { return memoriaLibros{s} }
+func (s *memoriaStore) Ejemplares() EjemplarStore { return memoriaEjemplares{s} }
+func (s *memoriaStore) Personas() PersonaStore    { return memoriaPersonas{s} }
+func (s *memoriaStore) Prestamos() PrestamoStore  { return memoriaPrestamos{s} }
+func (s *memoriaStore) Reservas() ReservaStore    { return memoriaReservas{s} }
+func (s *memoriaStore) Multas() MultaStore        { return memoriaMultas{s} }
+func (s *memoriaStore) Sesiones() SesionStore     { return memoriaSesiones{s} }
+func (s *memoriaStore) Tokens() TokenStore        { return memoriaTokens{s} }
+
+// RunTransaction toma el mutex durante toda la función y, si f devuelve
+// error, restaura la copia de los datos tomada al inicio.
+func (s *memoriaStore) RunTransaction(ctx context.Context, f func(ctx context.Context, tx Store) error) error {
+	if s.enTx {
+		return f(ctx, s)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	copia := (*s.datos).clonar()
+	if err := f(ctx, &memoriaStore{mu: s.mu, datos: s.datos, enTx: true}); err != nil {
+		*s.datos = copia
+		return err
+	}
+	return nil
+}
+
+func (s *memoriaStore) Close() error { return nil }
+
+// con ejecuta f con acceso exclusivo a los datos.
+func (s *memoriaStore) con(f func(d *memoriaDatos) error) error {
+	if !s.enTx {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+	}
+	return f(*s.datos)
+}
+
+// paginaOrdenada ordena los valores como lo harían Firestore y SQL para la
+// consulta y devuelve los que siguen a consulta.Despues, hasta
+// consulta.Limite.
+func paginaOrdenada[T any](valores []T, consulta ConsultaPagina, posicion func(T) PosicionPagina) []T {
+	comparar := func(a, b PosicionPagina) int {
+		if consulta.Desc {
+			return compararPosiciones(b, a)
+		}
+		return compararPosiciones(a, b)
+	}
+	slices.SortFunc(valores, func(a, b T) int { return comparar(posicion(a), posicion(b)) })
+	var pagina []T
+	for _, v := range valores {
+		if consulta.Despues != nil && comparar(posicion(v), *consulta.Despues) <= 0 {
+			continue
+		}
+		if len(pagina) == consulta.Limite {
+			break
+		}
+		pagina = append(pagina, v)
+	}
+	return pagina
+}
+
+// valoresOrdenados devuelve los valores del mapa ordenados por clave, igual
+// que Firestore devuelve los documentos ordenados por ID.
+func valoresOrdenados[T any](m map[string]T, incluir func(T) bool) []T {
+	claves := make([]string, 0, len(m))
+	for k := range m {
+		claves = append(claves, k)
+	}
+	sort.Strings(claves)
+	var res []T
+	for _, k := range claves {
+		if incluir == nil || incluir(m[k]) {
+			res = append(res, m[k])
+		}
+	}
+	return res
+}
+
+// --- Libros ---
+
+type memoriaLibros struct{ s *memoriaStore }
+
+func (m memoriaLibros) Listar(ctx context.Context) ([]Libro, error) {
+	var libros []Libro
+	err := m.s.con(func(d *memoriaDatos) error {
+		libros = valoresOrdenados(d.libros, nil)
+		return nil
+	})
+	return libros, err
+}
+
+func (m memoriaLibros) Pagina(ctx context.Context, consulta ConsultaPagina) ([]Libro, error) {
+	valor, ok := ordenesLibro[consulta.Orden]
+	if !ok {
+		return nil, ErrOrdenInvalido
+	}
+	libros, err := m.Listar(ctx)
+	return paginaOrdenada(libros, consulta, func(l Libro) PosicionPagina { return PosicionPagina{valor(l), l.ID} }), err
+}
+
+func (m memoriaLibros) Obtener(ctx context.Context, id string) (*Libro, error) {
+	var libro Libro
+	err := m.s.con(func(d *memoriaDatos) error {
+		l, ok := d.libros[id]
+		if !ok {
+			return ErrNoEncontrado
+		}
+		libro = l
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &libro, nil
+}
+
+func (m memoriaLibros) Crear(ctx context.Context, libro *Libro) error {
+	return m.s.con(func(d *memoriaDatos) error {
+		libro.ID = nuevoID()
+		d.libros[libro.ID] = *libro
+		return nil
+	})
+}
+
+func (m memoriaLibros) Guardar(ctx context.Context, libro *Libro) error {
+	return m.s.con(func(d *memoriaDatos) error {
+		d.libros[libro.ID] = *libro
+		return nil
+	})
+}
+
+func (m memoriaLibros) Eliminar(ctx context.Context, id string) error {
+	return m.s.con(func(d *memoriaDatos) error {
+		delete(d.libros, id)
+		return nil
+	})
+}
+
+// --- Ejemplares ---
+
+type memoriaEjemplares struct{ s *memoriaStore }
+
+func (m memoriaEjemplares) Obtener(ctx context.Context, id string) (*Ejemplar, error) {
+	return m.buscar(func(e Ejemplar) bool { return e.ID == id })
+}
+
+func (m memoriaEjemplares) PorLibro(ctx context.Context, libroID string) ([]Ejemplar, error) {
+	var ejemplares []Ejemplar
+	err := m.s.con(func(d *memoriaDatos) error {
+		ejemplares = valoresOrdenados(d.ejemplares, func(e Ejemplar) bool { return e.LibroID == libroID })
+		return nil
+	})
+	sort.SliceStable(ejemplares, func(i, j int) bool { return ejemplares[i].Codigo < ejemplares[j].Codigo })
+	return ejemplares, err
+}
+
+func (m memoriaEjemplares) BuscarPorCodigo(ctx context.Context, codigo string) (*Ejemplar, error) {
+	return m.buscar(func(e Ejemplar) bool { return e.Codigo == codigo })
+}
+
+func (m memoriaEjemplares) buscar(coincide func(Ejemplar) bool) (*Ejemplar, error) {
+	var ejemplar *Ejemplar
+	err := m.s.con(func(d *memoriaDatos) error {
+		encontrados := valoresOrdenados(d.ejemplares, coincide)
+		if len(encontrados) == 0 {
+			return ErrNoEncontrado
+		}
+		ejemplar = &encontrados[0]
+		return nil
+	})
+	return ejemplar, err
+}
+
+func (m memoriaEjemplares) Crear(ctx context.Context, ejemplar *Ejemplar) error {
+	return m.s.con(func(d *memoriaDatos) error {
+		ejemplar.ID = nuevoID()
+		d.ejemplares[ejemplar.ID] = *ejemplar
+		return nil
+	})
+}
+
+func (m memoriaEjemplares) Guardar(ctx context.Context, ejemplar *Ejemplar) error {
+	return m.s.con(func(d *memoriaDatos) error {
+		d.ejemplares[ejemplar.ID] = *ejemplar
+		return nil
+	})
+}
+
+func (m memoriaEjemplares) Eliminar(ctx context.Context, id string) error {
+	return m.s.con(func(d *memoriaDatos) error {
+		delete(d.ejemplares, id)
+		return nil
+	})
+}
+
+// --- Personas ---
+
+type memoriaPersonas struct{ s *memoriaStore }
+
+func (m memoriaPersonas) Listar(ctx context.Context) ([]Persona, error) {
+	var personas []Persona
+	err := m.s.con(func(d *memoriaDatos) error {
+		personas = valoresOrdenados(d.personas, nil)
+		return nil
+	})
+	return personas, err
+}
+
+func (m memoriaPersonas) Pagina(ctx context.Context, consulta ConsultaPagina) ([]Persona, error) {
+	valor, ok := ordenesPersona[consulta.Orden]
+	if !ok {
+		return nil, ErrOrdenInvalido
+	}
+	personas, err := m.Listar(ctx)
+	return paginaOrdenada(personas, consulta, func(p Persona) PosicionPagina { return PosicionPagina{valor(p), p.ID} }), err
+}
+
+func (m memoriaPersonas) Obtener(ctx context.Context, id string) (*Persona, error) {
+	return m.buscar(func(p Persona) bool { return p.ID == id })
+}
+
+func (m memoriaPersonas) PorNombre(ctx context.Context, nombre string) ([]Persona, error) {
+	var personas []Persona
+	err := m.s.con(func(d *memoriaDatos) error {
+		personas = valoresOrdenados(d.personas, func(p Persona) bool { return p.Nombre == nombre })
+		return nil
+	})
+	return personas, err
+}
+
+func (m memoriaPersonas) BuscarPorCedula(ctx context.Context, cedula string) (*Persona, error) {
+	return m.buscar(func(p Persona) bool { return p.Cedula == cedula })
+}
+
+func (m memoriaPersonas) buscar(coincide func(Persona) bool) (*Persona, error) {
+	var persona *Persona
+	err := m.s.con(func(d *memoriaDatos) error {
+		encontradas := valoresOrdenados(d.personas, coincide)
+		if len(encontradas) == 0 {
+			return ErrNoEncontrado
+		}
+		persona = &encontradas[0]
+		return nil
+	})
+	return persona, err
+}
+
+func (m memoriaPersonas) Crear(ctx context.Context, persona *Persona) error {
+	return m.s.con(func(d *memoriaDatos) error {
+		persona.ID = nuevoID()
+		d.personas[persona.ID] = *persona
+		return nil
+	})
+}
+
+func (m memoriaPersonas) Guardar(ctx context.Context, persona *Persona) error {
+	return m.s.con(func(d *memoriaDatos) error {
+		d.personas[persona.ID] = *persona
+		return nil
+	})
+}
+
+func (m memoriaPersonas) Eliminar(ctx context.Context, id string) error {
+	return m.s.con(func(d *memoriaDatos) error {
+		delete(d.personas, id)
+		return nil
+	})
+}
+
+// --- Préstamos ---
+
+type memoriaPrestamos struct{ s *memoriaStore }
+
+func (m memoriaPrestamos) Obtener(ctx context.Context, id string) (*Prestamo, error) {
+	var prestamo Prestamo
+	err := m.s.con(func(d *memoriaDatos) error {
+		p, ok := d.prestamos[id]
+		if !ok {
+			return ErrNoEncontrado
+		}
+		prestamo = p
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &prestamo, nil
+}
+
+func (m memoriaPrestamos) Activos(ctx context.Context) ([]Prestamo, error) {
+	return m.listar(func(p Prestamo) bool { return p.Estado == EstadoActivo })
+}
+
+func (m memoriaPrestamos) ActivosPorPersona(ctx context.Context, personaID string) ([]Prestamo, error) {
+	return m.listar(func(p Prestamo) bool { return p.Estado == EstadoActivo && p.PersonaID == personaID })
+}
+
+func (m memoriaPrestamos) ActivosPorLibro(ctx context.Context, libroID string) ([]Prestamo, error) {
+	return m.listar(func(p Prestamo) bool { return p.Estado == EstadoActivo && p.LibroID == libroID })
+}
+
+func (m memoriaPrestamos) Historial(ctx context.Context, filtro FiltroPrestamos) ([]Prestamo, error) {
+	prestamos, err := m.listar(func(p Prestamo) bool {
+		return (filtro.LibroID == "" || p.LibroID == filtro.LibroID) &&
+			(filtro.PersonaID == "" || p.PersonaID == filtro.PersonaID) &&
+			(len(filtro.Estados) == 0 || slices.Contains(filtro.Estados, p.Estado)) &&
+			(filtro.Desde.IsZero() || !p.FechaPrestamo.Before(filtro.Desde)) &&
+			(filtro.Hasta.IsZero() || p.FechaPrestamo.Before(filtro.Hasta))
+	})
+	sort.SliceStable(prestamos, func(i, j int) bool {
+		return prestamos[i].FechaPrestamo.After(prestamos[j].FechaPrestamo)
+	})
+	return prestamos, err
+}
+
+func (m memoriaPrestamos) Vencidos(ctx context.Context, ahora time.Time) ([]Prestamo, error) {
+	prestamos, err := m.listar(func(p Prestamo) bool {
+		return p.Estado == EstadoActivo && !p.FechaVencimiento.IsZero() && p.FechaVencimiento.Before(ahora)
+	})
+	sort.SliceStable(prestamos, func(i, j int) bool {
+		return prestamos[i].FechaVencimiento.Before(prestamos[j].FechaVencimiento)
+	})
+	return prestamos, err
+}
+
+func (m memoriaPrestamos) AprobadosVencidos(ctx context.Context, ahora time.Time) ([]Prestamo, error) {
+	return m.listar(func(p Prestamo) bool {
+		return p.Estado == EstadoAprobado && p.RetirarHasta.Before(ahora)
+	})
+}
+
+func (m memoriaPrestamos) listar(incluir func(Prestamo) bool) ([]Prestamo, error) {
+	var prestamos []Prestamo
+	err := m.s.con(func(d *memoriaDatos) error {
+		prestamos = valoresOrdenados(d.prestamos, incluir)
+		return nil
+	})
+	return prestamos, err
+}
+
+func (m memoriaPrestamos) Crear(ctx context.Context, prestamo *Prestamo) error {
+	return m.s.con(func(d *memoriaDatos) error {
+		prestamo.ID = nuevoID()
+		d.prestamos[prestamo.ID] = *prestamo
+		return nil
+	})
+}
+
+func (m memoriaPrestamos) Guardar(ctx context.Context, prestamo *Prestamo) error {
+	return m.s.con(func(d *memoriaDatos) error {
+		d.prestamos[prestamo.ID] = *prestamo
+		return nil
+	})
+}
+
+func (m memoriaPrestamos) Eliminar(ctx context.Context, id string) error {
+	return m.s.con(func(d *memoriaDatos) error {
+		delete(d.prestamos, id)
+		return nil
+	})
+}
+
+// --- Reservas ---
+
+type memoriaReservas struct{ s *memoriaStore }
+
+func (m memoriaReservas) Obtener(ctx context.Context, id string) (*Reserva, error) {
+	var reserva Reserva
+	err := m.s.con(func(d *memoriaDatos) error {
+		r, ok := d.reservas[id]
+		if !ok {
+			return ErrNoEncontrado
+		}
+		reserva = r
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &reserva, nil
+}
+
+func (m memoriaReservas) ActivasPorLibro(ctx context.Context, libroID string) ([]Reserva, error) {
+	return m.listar(func(r Reserva) bool {
+		return r.LibroID == libroID && slices.Contains(estadosReservaActivos, r.Estado)
+	})
+}
+
+func (m memoriaReservas) ActivasPorPersona(ctx context.Context, personaID string) ([]Reserva, error) {
+	return m.listar(func(r Reserva) bool {
+		return r.PersonaID == personaID && slices.Contains(estadosReservaActivos, r.Estado)
+	})
+}
+
+func (m memoriaReservas) ListasVencidas(ctx context.Context, ahora time.Time) ([]Reserva, error) {
+	return m.listar(func(r Reserva) bool {
+		return r.Estado == ReservaLista && r.DisponibleHasta.Before(ahora)
+	})
+}
+
+// listar devuelve las reservas que cumplen incluir, por orden de creación.
+func (m memoriaReservas) listar(incluir func(Reserva) bool) ([]Reserva, error) {
+	var reservas []Reserva
+	err := m.s.con(func(d *memoriaDatos) error {
+		reservas = valoresOrdenados(d.reservas, incluir)
+		return nil
+	})
+	sort.SliceStable(reservas, func(i, j int) bool {
+		return reservas[i].Creada.Before(reservas[j].Creada)
+	})
+	return reservas, err
+}
+
+func (m memoriaReservas) Crear(ctx context.Context, reserva *Reserva) error {
+	return m.s.con(func(d *memoriaDatos) error {
+		reserva.ID = nuevoID()
+		d.reservas[reserva.ID] = *reserva
+		return nil
+	})
+}
+
+func (m memoriaReservas) Guardar(ctx context.Context, reserva *Reserva) error {
+	return m.s.con(func(d *memoriaDatos) error {
+		d.reservas[reserva.ID] = *reserva
+		return nil
+	})
+}
+
+// --- Multas ---
+
+type memoriaMultas struct{ s *memoriaStore }
+
+func (m memoriaMultas) PorPersona(ctx context.Context, personaID string) ([]MovimientoMulta, error) {
+	var movimientos []MovimientoMulta
+	err := m.s.con(func(d *memoriaDatos) error {
+		movimientos = valoresOrdenados(d.multas, func(mov MovimientoMulta) bool {
+			return mov.PersonaID == personaID
+		})
+		return nil
+	})
+	sort.SliceStable(movimientos, func(i, j int) bool {
+		return movimientos[i].Fecha.Before(movimientos[j].Fecha)
+	})
+	return movimientos, err
+}
+
+func (m memoriaMultas) Crear(ctx context.Context, movimiento *MovimientoMulta) error {
+	return m.s.con(func(d *memoriaDatos) error {
+		movimiento.ID = nuevoID()
+		d.multas[movimiento.ID] = *movimiento
+		return nil
+	})
+}
+
+// --- Sesiones ---
+
+type memoriaSesiones struct{ s *memoriaStore }
+
+func (m memoriaSesiones) Obtener(ctx context.Context, id string) (*Sesion, error) {
+	var sesion Sesion
+	err := m.s.con(func(d *memoriaDatos) error {
+		s, ok := d.sesiones[id]
+		if !ok {
+			return ErrNoEncontrado
+		}
+		sesion = s
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &sesion, nil
+}
+
+func (m memoriaSesiones) Guardar(ctx context.Context, sesion *Sesion) error {
+	return m.s.con(func(d *memoriaDatos) error {
+		d.sesiones[sesion.ID] = *sesion
+		return nil
+	})
+}
+
+func (m memoriaSesiones) Eliminar(ctx context.Context, id string) error {
+	return m.s.con(func(d *memoriaDatos) error {
+		delete(d.sesiones, id)
+		return nil
+	})
+}
+
+func (m memoriaSesiones) EliminarExpiradas(ctx context.Context, ahora time.Time) (int, error) {
+	n := 0
+	err := m.s.con(func(d *memoriaDatos) error {
+		for id, s := range d.sesiones {
+			if s.Expira.Before(ahora) {
+				delete(d.sesiones, id)
+				n++
+			}
+		}
+		return nil
+	})
+	return n, err
+}
+
+// --- Tokens ---
+
+type memoriaTokens struct{ s *memoriaStore }
+
+func (m memoriaTokens) Obtener(ctx context.Context, id string) (*TokenAPI, error) {
+	var token TokenAPI
+	err := m.s.con(func(d *memoriaDatos) error {
+		t, ok := d.tokens[id]
+		if !ok {
+			return ErrNoEncontrado
+		}
+		token = t
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (m memoriaTokens) PorPersona(ctx context.Context, personaID string) ([]TokenAPI, error) {
+	var tokens []TokenAPI
+	err := m.s.con(func(d *memoriaDatos) error {
+		tokens = valoresOrdenados(d.tokens, func(t TokenAPI) bool { return t.PersonaID == personaID })
+		return nil
+	})
+	sort.SliceStable(tokens, func(i, j int) bool {
+		return tokens[i].Creado.Before(tokens[j].Creado)
+	})
+	return tokens, err
+}
+
+func (m memoriaTokens) Guardar(ctx context.Context, token *TokenAPI) error {
+	return m.s.con(func(d *memoriaDatos) error {
+		d.tokens[token.ID] = *token
+		return nil
+	})
+}
+
+func (m memoriaTokens) Eliminar(ctx context.Context, id string) error {
+	return m.s.con(func(d *memoriaDatos) error {
+		delete(d.tokens, id)
+		return nil
+	})
+}
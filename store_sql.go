@@ -0,0 +1,878 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	_ "github.com/jackc/pgx/v5/stdlib" // Driver "pgx" para PostgreSQL
+	"modernc.org/sqlite"               // Driver "sqlite" (Go puro, sin cgo)
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// Dialectos SQL soportados.
+const (
+	dialectoSQLite   = "sqlite"
+	dialectoPostgres = "postgres"
+)
+
+// esquemaSQL crea las tablas si no existen. Los nombres de tabla coinciden
+// con las colecciones de Firestore; %[1]s es el tipo de columna de fechas.
+const esquemaSQL = `
+CREATE TABLE IF NOT EXISTS libro (
+	id              TEXT PRIMARY KEY,
+	nombre          TEXT NOT NULL,
+	autor           TEXT NOT NULL,
+	ano             INTEGER NOT NULL,
+	descripcion     TEXT NOT NULL,
+	imagen          TEXT NOT NULL,
+	total           INTEGER NOT NULL DEFAULT 0,
+	copias          INTEGER NOT NULL,
+	disponible      BOOLEAN NOT NULL,
+	restringido     BOOLEAN NOT NULL DEFAULT FALSE
+);
+CREATE INDEX IF NOT EXISTS idx_libro_nombre ON libro (nombre, id);
+CREATE INDEX IF NOT EXISTS idx_libro_autor ON libro (autor, id);
+CREATE INDEX IF NOT EXISTS idx_libro_ano ON libro (ano, id);
+CREATE INDEX IF NOT EXISTS idx_libro_copias ON libro (copias, id);
+
+CREATE TABLE IF NOT EXISTS ejemplares (
+	id        TEXT PRIMARY KEY,
+	libro_id  TEXT NOT NULL REFERENCES libro (id) ON DELETE CASCADE,
+	codigo    TEXT NOT NULL UNIQUE,
+	condicion TEXT NOT NULL,
+	ubicacion TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS idx_ejemplares_libro ON ejemplares (libro_id, codigo);
+
+CREATE TABLE IF NOT EXISTS persona (
+	id         TEXT PRIMARY KEY,
+	nombre     TEXT NOT NULL,
+	cedula     TEXT NOT NULL UNIQUE,
+	ano        INTEGER NOT NULL,
+	contrasena TEXT NOT NULL,
+	rol        TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_persona_nombre ON persona (nombre);
+
+CREATE TABLE IF NOT EXISTS prestamos (
+	id               TEXT PRIMARY KEY,
+	libro_id         TEXT NOT NULL REFERENCES libro (id),
+	ejemplar_id      TEXT NOT NULL DEFAULT '',
+	persona_id       TEXT NOT NULL REFERENCES persona (id),
+	fecha_prestamo   %[1]s NOT NULL,
+	fecha_devolucion %[1]s,
+	fecha_vencimiento %[1]s,
+	renovaciones     INTEGER NOT NULL DEFAULT 0,
+	ultima_renovacion %[1]s,
+	estado           TEXT NOT NULL,
+	retirar_hasta    %[1]s,
+	resuelto_por     TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS idx_prestamos_libro ON prestamos (libro_id);
+CREATE INDEX IF NOT EXISTS idx_prestamos_fecha ON prestamos (fecha_prestamo);
+
+CREATE TABLE IF NOT EXISTS reservas (
+	id         TEXT PRIMARY KEY,
+	libro_id   TEXT NOT NULL REFERENCES libro (id) ON DELETE CASCADE,
+	persona_id TEXT NOT NULL REFERENCES persona (id) ON DELETE CASCADE,
+	creada     %[1]s NOT NULL,
+	estado     TEXT NOT NULL,
+	disponible_hasta %[1]s,
+	ejemplar_id TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS idx_reservas_libro ON reservas (libro_id, estado, creada);
+CREATE INDEX IF NOT EXISTS idx_reservas_persona ON reservas (persona_id, estado);
+
+CREATE TABLE IF NOT EXISTS multas (
+	id             TEXT PRIMARY KEY,
+	persona_id     TEXT NOT NULL REFERENCES persona (id),
+	prestamo_id    TEXT NOT NULL DEFAULT '',
+	tipo           TEXT NOT NULL,
+	monto          INTEGER NOT NULL,
+	motivo         TEXT NOT NULL DEFAULT '',
+	registrado_por TEXT NOT NULL DEFAULT '',
+	fecha          %[1]s NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_multas_persona ON multas (persona_id, fecha);
+
+CREATE TABLE IF NOT EXISTS sesiones (
+	id         TEXT PRIMARY KEY,
+	persona_id TEXT NOT NULL REFERENCES persona (id) ON DELETE CASCADE,
+	creada     %[1]s NOT NULL,
+	expira     %[1]s NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_sesiones_expira ON sesiones (expira);
+
+CREATE TABLE IF NOT EXISTS tokens (
+	id          TEXT PRIMARY KEY,
+	persona_id  TEXT NOT NULL REFERENCES persona (id) ON DELETE CASCADE,
+	descripcion TEXT NOT NULL DEFAULT '',
+	creado      %[1]s NOT NULL,
+	ultimo_uso  %[1]s
+);
+CREATE INDEX IF NOT EXISTS idx_tokens_persona ON tokens (persona_id, creado);
+`
+
+// columnasAgregadas son columnas que se añadieron al esquema después de su
+// primera versión. CREATE TABLE IF NOT EXISTS no las crea en bases de datos
+// existentes, así que agregarColumnas las añade si faltan. %[1]s en el tipo
+// es el tipo de columna de fechas.
+var columnasAgregadas = []struct{ tabla, columna, tipo string }{
+	{"libro", "total", "INTEGER NOT NULL DEFAULT 0"},
+	{"prestamos", "fecha_vencimiento", "%[1]s"},
+	{"prestamos", "renovaciones", "INTEGER NOT NULL DEFAULT 0"},
+	{"prestamos", "ultima_renovacion", "%[1]s"},
+	{"reservas", "disponible_hasta", "%[1]s"},
+	{"prestamos", "ejemplar_id", "TEXT NOT NULL DEFAULT ''"},
+	{"reservas", "ejemplar_id", "TEXT NOT NULL DEFAULT ''"},
+	{"prestamos", "estado", "TEXT NOT NULL DEFAULT ''"},
+	{"prestamos", "retirar_hasta", "%[1]s"},
+	{"prestamos", "resuelto_por", "TEXT NOT NULL DEFAULT ''"},
+	{"libro", "restringido", "BOOLEAN NOT NULL DEFAULT FALSE"},
+}
+
+// indicesPrestamos se crean después de migrarEstadoPrestamos, porque en las
+// bases de datos anteriores la columna estado todavía no existe cuando corre
+// esquemaSQL.
+const indicesPrestamos = `
+CREATE INDEX IF NOT EXISTS idx_prestamos_estado ON prestamos (persona_id, estado);
+CREATE INDEX IF NOT EXISTS idx_prestamos_retiro ON prestamos (estado, retirar_hasta);
+`
+
+// existeColumna comprueba con una consulta vacía sobre la columna, válida en
+// ambos dialectos, si la tabla la tiene.
+func existeColumna(ctx context.Context, db *sql.DB, tabla, columna string) bool {
+	rows, err := db.QueryContext(ctx, "SELECT "+columna+" FROM "+tabla+" WHERE 1 = 0")
+	if err != nil {
+		return false
+	}
+	rows.Close()
+	return true
+}
+
+// agregarColumnas añade las columnas de columnasAgregadas que no existan.
+func agregarColumnas(ctx context.Context, db *sql.DB, tipoFecha string) error {
+	for _, c := range columnasAgregadas {
+		if existeColumna(ctx, db, c.tabla, c.columna) {
+			continue
+		}
+		alter := "ALTER TABLE " + c.tabla + " ADD COLUMN " + c.columna + " " + strings.ReplaceAll(c.tipo, "%[1]s", tipoFecha)
+		if _, err := db.ExecContext(ctx, alter); err != nil {
+			return fmt.Errorf("%s.%s: %w", c.tabla, c.columna, err)
+		}
+	}
+	return nil
+}
+
+// migrarEstadoPrestamos pasa los préstamos de las columnas activo y perdido
+// de versiones anteriores a la columna estado y borra las columnas viejas
+// (activo no tiene valor por omisión, así que impediría insertar). Después
+// crea los índices sobre estado.
+func migrarEstadoPrestamos(ctx context.Context, db *sql.DB) error {
+	if existeColumna(ctx, db, "prestamos", "activo") {
+		cerrado := "'" + EstadoDevuelto + "'"
+		if existeColumna(ctx, db, "prestamos", "perdido") {
+			cerrado = "CASE WHEN perdido THEN '" + EstadoPerdido + "' ELSE '" + EstadoDevuelto + "' END"
+		}
+		sentencias := []string{
+			"UPDATE prestamos SET estado = CASE WHEN activo THEN '" + EstadoActivo + "' ELSE " + cerrado + " END WHERE estado = ''",
+			"DROP INDEX IF EXISTS idx_prestamos_persona",
+			"ALTER TABLE prestamos DROP COLUMN activo",
+		}
+		if existeColumna(ctx, db, "prestamos", "perdido") {
+			sentencias = append(sentencias, "ALTER TABLE prestamos DROP COLUMN perdido")
+		}
+		for _, sentencia := range sentencias {
+			if _, err := db.ExecContext(ctx, sentencia); err != nil {
+				return fmt.Errorf("%s: %w", sentencia, err)
+			}
+		}
+	}
+	_, err := db.ExecContext(ctx, indicesPrestamos)
+	return err
+}
+
+// sqlEjecutor es lo que tienen en común *sql.DB y *sql.Tx.
+type sqlEjecutor interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// sqlStore implementa Store sobre SQLite o PostgreSQL con database/sql.
+type sqlStore struct {
+	db       *sql.DB
+	q        sqlEjecutor
+	dialecto string
+	enTx     bool
+}
+
+// NuevoSQLStore abre la base de datos indicada y crea el esquema si hace
+// falta. dialecto es "sqlite" o "postgres".
+func NuevoSQLStore(ctx context.Context, dialecto, dsn string) (Store, error) {
+	var driver, tipoFecha string
+	switch dialecto {
+	case dialectoSQLite:
+		driver, tipoFecha = "sqlite", "DATETIME"
+		if dsn == "" {
+			dsn = "biblioteca.db"
+		}
+		// Las claves foráneas en SQLite se activan por conexión
+		sep := "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+		dsn += sep + "_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_time_format=sqlite"
+	case dialectoPostgres:
+		driver, tipoFecha = "pgx", "TIMESTAMPTZ"
+	default:
+		return nil, fmt.Errorf("dialecto SQL desconocido: %q", dialecto)
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if dialecto == dialectoSQLite {
+		// SQLite admite un solo escritor: una única conexión serializa las
+		// transacciones y evita errores SQLITE_BUSY.
+		db.SetMaxOpenConns(1)
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if _, err := db.ExecContext(ctx, fmt.Sprintf(esquemaSQL, tipoFecha)); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creando esquema: %w", err)
+	}
+	if err := agregarColumnas(ctx, db, tipoFecha); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("actualizando esquema: %w", err)
+	}
+	if err := migrarEstadoPrestamos(ctx, db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrando estados de préstamos: %w", err)
+	}
+	return &sqlStore{db: db, q: db, dialecto: dialecto}, nil
+}
+
+func (s *sqlStore) Libros() LibroStore        { return sqlLibros{s} }
+func (s *sqlStore) Ejemplares() EjemplarStore { return sqlEjemplares{s} }
+func (s *sqlStore) Personas() PersonaStore    { return sqlPersonas{s} }
+func (s *sqlStore) Prestamos() PrestamoStore  { return sqlPrestamos{s} }
+func (s *sqlStore) Reservas() ReservaStore    { return sqlReservas{s} }
+func (s *sqlStore) Multas() MultaStore        { return sqlMultas{s} }
+func (s *sqlStore) Sesiones() SesionStore     { return sqlSesiones{s} }
+func (s *sqlStore) Tokens() TokenStore        { return sqlTokens{s} }
+
+func (s *sqlStore) RunTransaction(ctx context.Context, f func(ctx context.Context, tx Store) error) error {
+	if s.enTx {
+		return f(ctx, s)
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := f(ctx, &sqlStore{db: s.db, q: tx, dialecto: s.dialecto, enTx: true}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) Close() error {
+	if s.enTx {
+		return nil
+	}
+	return s.db.Close()
+}
+
+// sql adapta la consulta al dialecto: cambia los "?" por "$n" en PostgreSQL.
+func (s *sqlStore) sql(query string) string {
+	if s.dialecto != dialectoPostgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// paraActualizar añade FOR UPDATE a las lecturas hechas dentro de una
+// transacción en PostgreSQL, de modo que dos préstamos simultáneos del mismo
+// libro se serialicen igual que con RunTransaction en Firestore. En SQLite
+// no hace falta porque hay una sola conexión.
+func (s *sqlStore) paraActualizar(query string) string {
+	if s.enTx && s.dialecto == dialectoPostgres {
+		return query + " FOR UPDATE"
+	}
+	return query
+}
+
+func (s *sqlStore) exec(ctx context.Context, query string, args ...any) error {
+	_, err := s.q.ExecContext(ctx, s.sql(query), args...)
+	return err
+}
+
+func (s *sqlStore) queryRow(ctx context.Context, query string, args ...any) *sql.Row {
+	return s.q.QueryRowContext(ctx, s.sql(s.paraActualizar(query)), args...)
+}
+
+func (s *sqlStore) query(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return s.q.QueryContext(ctx, s.sql(s.paraActualizar(query)), args...)
+}
+
+// esValorRepetido indica si err es la violación de una restricción UNIQUE.
+func esValorRepetido(err error) bool {
+	var errPostgres *pgconn.PgError
+	if errors.As(err, &errPostgres) {
+		return errPostgres.Code == "23505" // unique_violation
+	}
+	var errSQLite *sqlite.Error
+	if errors.As(err, &errSQLite) {
+		return errSQLite.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+	}
+	return false
+}
+
+// errSQL traduce sql.ErrNoRows a ErrNoEncontrado.
+func errSQL(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNoEncontrado
+	}
+	return err
+}
+
+// clausulasPagina arma el WHERE de la posición, el ORDER BY y el LIMIT de
+// una consulta de página. consulta.Orden se interpola como columna, así que
+// el llamador debe haberlo validado contra ordenesLibro u ordenesPersona,
+// cuyos campos tienen el mismo nombre que las columnas.
+func clausulasPagina(consulta ConsultaPagina) (string, []any) {
+	comparacion, direccion := ">", "ASC"
+	if consulta.Desc {
+		comparacion, direccion = "<", "DESC"
+	}
+	col := consulta.Orden
+	var where string
+	var args []any
+	if d := consulta.Despues; d != nil {
+		where = " WHERE (" + col + " " + comparacion + " ? OR (" + col + " = ? AND id " + comparacion + " ?))"
+		args = []any{d.Valor, d.Valor, d.ID}
+	}
+	return where + " ORDER BY " + col + " " + direccion + ", id " + direccion + " LIMIT ?", append(args, consulta.Limite)
+}
+
+// fechaNula convierte la fecha cero en NULL.
+func fechaNula(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
+
+// --- Libros ---
+
+type sqlLibros struct{ s *sqlStore }
+
+const columnasLibro = "id, nombre, autor, ano, descripcion, imagen, total, copias, disponible, restringido"
+
+type escaner interface{ Scan(dest ...any) error }
+
+func escanearLibro(row escaner) (*Libro, error) {
+	var l Libro
+	err := row.Scan(&l.ID, &l.Nombre, &l.Autor, &l.Ano, &l.Descripcion, &l.ImagenURL, &l.Total, &l.Copias, &l.Disponible, &l.Restringido)
+	if err != nil {
+		return nil, errSQL(err)
+	}
+	return &l, nil
+}
+
+func (t sqlLibros) Listar(ctx context.Context) ([]Libro, error) {
+	return t.listar(ctx, "SELECT "+columnasLibro+" FROM libro ORDER BY id")
+}
+
+func (t sqlLibros) Pagina(ctx context.Context, consulta ConsultaPagina) ([]Libro, error) {
+	if _, ok := ordenesLibro[consulta.Orden]; !ok {
+		return nil, ErrOrdenInvalido
+	}
+	clausulas, args := clausulasPagina(consulta)
+	return t.listar(ctx, "SELECT "+columnasLibro+" FROM libro"+clausulas, args...)
+}
+
+func (t sqlLibros) listar(ctx context.Context, query string, args ...any) ([]Libro, error) {
+	rows, err := t.s.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var libros []Libro
+	for rows.Next() {
+		l, err := escanearLibro(rows)
+		if err != nil {
+			return nil, err
+		}
+		libros = append(libros, *l)
+	}
+	return libros, rows.Err()
+}
+
+func (t sqlLibros) Obtener(ctx context.Context, id string) (*Libro, error) {
+	return escanearLibro(t.s.queryRow(ctx, "SELECT "+columnasLibro+" FROM libro WHERE id = ?", id))
+}
+
+func (t sqlLibros) Crear(ctx context.Context, libro *Libro) error {
+	libro.ID = nuevoID()
+	return t.Guardar(ctx, libro)
+}
+
+func (t sqlLibros) Guardar(ctx context.Context, l *Libro) error {
+	return t.s.exec(ctx, `INSERT INTO libro (`+columnasLibro+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET nombre = excluded.nombre, autor = excluded.autor, ano = excluded.ano,
+			descripcion = excluded.descripcion, imagen = excluded.imagen, total = excluded.total,
+			copias = excluded.copias, disponible = excluded.disponible, restringido = excluded.restringido`,
+		l.ID, l.Nombre, l.Autor, l.Ano, l.Descripcion, l.ImagenURL, l.Total, l.Copias, l.Disponible, l.Restringido)
+}
+
+func (t sqlLibros) Eliminar(ctx context.Context, id string) error {
+	return t.s.exec(ctx, "DELETE FROM libro WHERE id = ?", id)
+}
+
+// --- Ejemplares ---
+
+type sqlEjemplares struct{ s *sqlStore }
+
+const columnasEjemplar = "id, libro_id, codigo, condicion, ubicacion"
+
+func escanearEjemplar(row escaner) (*Ejemplar, error) {
+	var e Ejemplar
+	if err := row.Scan(&e.ID, &e.LibroID, &e.Codigo, &e.Condicion, &e.Ubicacion); err != nil {
+		return nil, errSQL(err)
+	}
+	return &e, nil
+}
+
+func (t sqlEjemplares) Obtener(ctx context.Context, id string) (*Ejemplar, error) {
+	return escanearEjemplar(t.s.queryRow(ctx, "SELECT "+columnasEjemplar+" FROM ejemplares WHERE id = ?", id))
+}
+
+func (t sqlEjemplares) PorLibro(ctx context.Context, libroID string) ([]Ejemplar, error) {
+	rows, err := t.s.query(ctx, "SELECT "+columnasEjemplar+" FROM ejemplares WHERE libro_id = ? ORDER BY codigo", libroID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ejemplares []Ejemplar
+	for rows.Next() {
+		e, err := escanearEjemplar(rows)
+		if err != nil {
+			return nil, err
+		}
+		ejemplares = append(ejemplares, *e)
+	}
+	return ejemplares, rows.Err()
+}
+
+func (t sqlEjemplares) BuscarPorCodigo(ctx context.Context, codigo string) (*Ejemplar, error) {
+	return escanearEjemplar(t.s.queryRow(ctx, "SELECT "+columnasEjemplar+" FROM ejemplares WHERE codigo = ?", codigo))
+}
+
+func (t sqlEjemplares) Crear(ctx context.Context, ejemplar *Ejemplar) error {
+	ejemplar.ID = nuevoID()
+	return t.Guardar(ctx, ejemplar)
+}
+
+func (t sqlEjemplares) Guardar(ctx context.Context, e *Ejemplar) error {
+	return t.s.exec(ctx, `INSERT INTO ejemplares (`+columnasEjemplar+`) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET libro_id = excluded.libro_id, codigo = excluded.codigo,
+			condicion = excluded.condicion, ubicacion = excluded.ubicacion`,
+		e.ID, e.LibroID, e.Codigo, e.Condicion, e.Ubicacion)
+}
+
+func (t sqlEjemplares) Eliminar(ctx context.Context, id string) error {
+	return t.s.exec(ctx, "DELETE FROM ejemplares WHERE id = ?", id)
+}
+
+// --- Personas ---
+
+type sqlPersonas struct{ s *sqlStore }
+
+const columnasPersona = "id, nombre, cedula, ano, contrasena, rol"
+
+func escanearPersona(row escaner) (*Persona, error) {
+	var p Persona
+	if err := row.Scan(&p.ID, &p.Nombre, &p.Cedula, &p.Ano, &p.Contrasena, &p.Rol); err != nil {
+		return nil, errSQL(err)
+	}
+	return &p, nil
+}
+
+func (t sqlPersonas) Listar(ctx context.Context) ([]Persona, error) {
+	return t.listar(ctx, "SELECT "+columnasPersona+" FROM persona ORDER BY id")
+}
+
+func (t sqlPersonas) Pagina(ctx context.Context, consulta ConsultaPagina) ([]Persona, error) {
+	if _, ok := ordenesPersona[consulta.Orden]; !ok {
+		return nil, ErrOrdenInvalido
+	}
+	clausulas, args := clausulasPagina(consulta)
+	return t.listar(ctx, "SELECT "+columnasPersona+" FROM persona"+clausulas, args...)
+}
+
+func (t sqlPersonas) listar(ctx context.Context, query string, args ...any) ([]Persona, error) {
+	rows, err := t.s.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var personas []Persona
+	for rows.Next() {
+		p, err := escanearPersona(rows)
+		if err != nil {
+			return nil, err
+		}
+		personas = append(personas, *p)
+	}
+	return personas, rows.Err()
+}
+
+func (t sqlPersonas) Obtener(ctx context.Context, id string) (*Persona, error) {
+	return escanearPersona(t.s.queryRow(ctx, "SELECT "+columnasPersona+" FROM persona WHERE id = ?", id))
+}
+
+func (t sqlPersonas) PorNombre(ctx context.Context, nombre string) ([]Persona, error) {
+	return t.listar(ctx, "SELECT "+columnasPersona+" FROM persona WHERE nombre = ? ORDER BY id", nombre)
+}
+
+func (t sqlPersonas) BuscarPorCedula(ctx context.Context, cedula string) (*Persona, error) {
+	return escanearPersona(t.s.queryRow(ctx, "SELECT "+columnasPersona+" FROM persona WHERE cedula = ?", cedula))
+}
+
+func (t sqlPersonas) Crear(ctx context.Context, persona *Persona) error {
+	persona.ID = nuevoID()
+	return t.Guardar(ctx, persona)
+}
+
+// Guardar devuelve ErrCedulaRegistrada si otra persona ya tiene la cédula:
+// la restricción UNIQUE la ataja aunque dos registros se crucen.
+func (t sqlPersonas) Guardar(ctx context.Context, p *Persona) error {
+	err := t.s.exec(ctx, `INSERT INTO persona (`+columnasPersona+`) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET nombre = excluded.nombre, cedula = excluded.cedula, ano = excluded.ano,
+			contrasena = excluded.contrasena, rol = excluded.rol`,
+		p.ID, p.Nombre, p.Cedula, p.Ano, p.Contrasena, p.Rol)
+	if esValorRepetido(err) {
+		return ErrCedulaRegistrada
+	}
+	return err
+}
+
+func (t sqlPersonas) Eliminar(ctx context.Context, id string) error {
+	return t.s.exec(ctx, "DELETE FROM persona WHERE id = ?", id)
+}
+
+// --- Préstamos ---
+
+type sqlPrestamos struct{ s *sqlStore }
+
+const columnasPrestamo = "id, libro_id, ejemplar_id, persona_id, estado, fecha_prestamo, fecha_devolucion, fecha_vencimiento, renovaciones, ultima_renovacion, retirar_hasta, resuelto_por"
+
+func escanearPrestamo(row escaner) (*Prestamo, error) {
+	var p Prestamo
+	var devolucion, vencimiento, renovacion, retiro sql.NullTime
+	if err := row.Scan(&p.ID, &p.LibroID, &p.EjemplarID, &p.PersonaID, &p.Estado, &p.FechaPrestamo, &devolucion, &vencimiento,
+		&p.Renovaciones, &renovacion, &retiro, &p.ResueltoPor); err != nil {
+		return nil, errSQL(err)
+	}
+	p.FechaDevolucion = devolucion.Time
+	p.FechaVencimiento = vencimiento.Time
+	p.UltimaRenovacion = renovacion.Time
+	p.RetirarHasta = retiro.Time
+	return &p, nil
+}
+
+func (t sqlPrestamos) Obtener(ctx context.Context, id string) (*Prestamo, error) {
+	return escanearPrestamo(t.s.queryRow(ctx, "SELECT "+columnasPrestamo+" FROM prestamos WHERE id = ?", id))
+}
+
+func (t sqlPrestamos) Activos(ctx context.Context) ([]Prestamo, error) {
+	return t.listar(ctx, "estado = ?", "id", EstadoActivo)
+}
+
+func (t sqlPrestamos) ActivosPorPersona(ctx context.Context, personaID string) ([]Prestamo, error) {
+	return t.listar(ctx, "persona_id = ? AND estado = ?", "id", personaID, EstadoActivo)
+}
+
+func (t sqlPrestamos) ActivosPorLibro(ctx context.Context, libroID string) ([]Prestamo, error) {
+	return t.listar(ctx, "libro_id = ? AND estado = ?", "id", libroID, EstadoActivo)
+}
+
+func (t sqlPrestamos) Historial(ctx context.Context, filtro FiltroPrestamos) ([]Prestamo, error) {
+	condiciones := []string{"1 = 1"}
+	var args []any
+	if filtro.LibroID != "" {
+		condiciones = append(condiciones, "libro_id = ?")
+		args = append(args, filtro.LibroID)
+	}
+	if filtro.PersonaID != "" {
+		condiciones = append(condiciones, "persona_id = ?")
+		args = append(args, filtro.PersonaID)
+	}
+	if len(filtro.Estados) > 0 {
+		condiciones = append(condiciones, "estado IN (?"+strings.Repeat(", ?", len(filtro.Estados)-1)+")")
+		for _, estado := range filtro.Estados {
+			args = append(args, estado)
+		}
+	}
+	if !filtro.Desde.IsZero() {
+		condiciones = append(condiciones, "fecha_prestamo >= ?")
+		args = append(args, filtro.Desde)
+	}
+	if !filtro.Hasta.IsZero() {
+		condiciones = append(condiciones, "fecha_prestamo < ?")
+		args = append(args, filtro.Hasta)
+	}
+	return t.listar(ctx, strings.Join(condiciones, " AND "), "fecha_prestamo DESC, id", args...)
+}
+
+func (t sqlPrestamos) Vencidos(ctx context.Context, ahora time.Time) ([]Prestamo, error) {
+	return t.listar(ctx, "estado = ? AND fecha_vencimiento < ?", "fecha_vencimiento, id", EstadoActivo, ahora)
+}
+
+func (t sqlPrestamos) AprobadosVencidos(ctx context.Context, ahora time.Time) ([]Prestamo, error) {
+	return t.listar(ctx, "estado = ? AND retirar_hasta < ?", "retirar_hasta, id", EstadoAprobado, ahora)
+}
+
+// listar devuelve los préstamos que cumplen la condición WHERE dada, en el
+// orden indicado.
+func (t sqlPrestamos) listar(ctx context.Context, condicion, orden string, args ...any) ([]Prestamo, error) {
+	rows, err := t.s.query(ctx, "SELECT "+columnasPrestamo+" FROM prestamos WHERE "+condicion+" ORDER BY "+orden, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var prestamos []Prestamo
+	for rows.Next() {
+		p, err := escanearPrestamo(rows)
+		if err != nil {
+			return nil, err
+		}
+		prestamos = append(prestamos, *p)
+	}
+	return prestamos, rows.Err()
+}
+
+func (t sqlPrestamos) Crear(ctx context.Context, prestamo *Prestamo) error {
+	prestamo.ID = nuevoID()
+	return t.Guardar(ctx, prestamo)
+}
+
+func (t sqlPrestamos) Guardar(ctx context.Context, p *Prestamo) error {
+	return t.s.exec(ctx, `INSERT INTO prestamos (`+columnasPrestamo+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET libro_id = excluded.libro_id, ejemplar_id = excluded.ejemplar_id, persona_id = excluded.persona_id,
+			estado = excluded.estado, fecha_prestamo = excluded.fecha_prestamo, fecha_devolucion = excluded.fecha_devolucion,
+			fecha_vencimiento = excluded.fecha_vencimiento, renovaciones = excluded.renovaciones,
+			ultima_renovacion = excluded.ultima_renovacion, retirar_hasta = excluded.retirar_hasta, resuelto_por = excluded.resuelto_por`,
+		p.ID, p.LibroID, p.EjemplarID, p.PersonaID, p.Estado, p.FechaPrestamo, fechaNula(p.FechaDevolucion), fechaNula(p.FechaVencimiento),
+		p.Renovaciones, fechaNula(p.UltimaRenovacion), fechaNula(p.RetirarHasta), p.ResueltoPor)
+}
+
+func (t sqlPrestamos) Eliminar(ctx context.Context, id string) error {
+	return t.s.exec(ctx, "DELETE FROM prestamos WHERE id = ?", id)
+}
+
+// --- Reservas ---
+
+type sqlReservas struct{ s *sqlStore }
+
+const columnasReserva = "id, libro_id, persona_id, creada, estado, disponible_hasta, ejemplar_id"
+
+func escanearReserva(row escaner) (*Reserva, error) {
+	var r Reserva
+	var hasta sql.NullTime
+	if err := row.Scan(&r.ID, &r.LibroID, &r.PersonaID, &r.Creada, &r.Estado, &hasta, &r.EjemplarID); err != nil {
+		return nil, errSQL(err)
+	}
+	r.DisponibleHasta = hasta.Time
+	return &r, nil
+}
+
+func (t sqlReservas) Obtener(ctx context.Context, id string) (*Reserva, error) {
+	return escanearReserva(t.s.queryRow(ctx, "SELECT "+columnasReserva+" FROM reservas WHERE id = ?", id))
+}
+
+func (t sqlReservas) ActivasPorLibro(ctx context.Context, libroID string) ([]Reserva, error) {
+	return t.activas(ctx, "libro_id", libroID)
+}
+
+func (t sqlReservas) ActivasPorPersona(ctx context.Context, personaID string) ([]Reserva, error) {
+	return t.activas(ctx, "persona_id", personaID)
+}
+
+// activas devuelve las reservas activas cuya columna vale valor.
+func (t sqlReservas) activas(ctx context.Context, columna, valor string) ([]Reserva, error) {
+	args := []any{valor}
+	for _, e := range estadosReservaActivos {
+		args = append(args, e)
+	}
+	marcas := strings.TrimSuffix(strings.Repeat("?, ", len(estadosReservaActivos)), ", ")
+	return t.listar(ctx, columna+" = ? AND estado IN ("+marcas+")", "creada, id", args...)
+}
+
+func (t sqlReservas) ListasVencidas(ctx context.Context, ahora time.Time) ([]Reserva, error) {
+	return t.listar(ctx, "estado = ? AND disponible_hasta < ?", "disponible_hasta, id", ReservaLista, ahora)
+}
+
+// listar devuelve las reservas que cumplen la condición WHERE dada, en el
+// orden indicado.
+func (t sqlReservas) listar(ctx context.Context, condicion, orden string, args ...any) ([]Reserva, error) {
+	rows, err := t.s.query(ctx, "SELECT "+columnasReserva+" FROM reservas WHERE "+condicion+" ORDER BY "+orden, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var reservas []Reserva
+	for rows.Next() {
+		r, err := escanearReserva(rows)
+		if err != nil {
+			return nil, err
+		}
+		reservas = append(reservas, *r)
+	}
+	return reservas, rows.Err()
+}
+
+func (t sqlReservas) Crear(ctx context.Context, reserva *Reserva) error {
+	reserva.ID = nuevoID()
+	return t.Guardar(ctx, reserva)
+}
+
+func (t sqlReservas) Guardar(ctx context.Context, r *Reserva) error {
+	return t.s.exec(ctx, `INSERT INTO reservas (`+columnasReserva+`) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET libro_id = excluded.libro_id, persona_id = excluded.persona_id,
+			creada = excluded.creada, estado = excluded.estado, disponible_hasta = excluded.disponible_hasta,
+			ejemplar_id = excluded.ejemplar_id`,
+		r.ID, r.LibroID, r.PersonaID, r.Creada, r.Estado, fechaNula(r.DisponibleHasta), r.EjemplarID)
+}
+
+// --- Multas ---
+
+type sqlMultas struct{ s *sqlStore }
+
+const columnasMulta = "id, persona_id, prestamo_id, tipo, monto, motivo, registrado_por, fecha"
+
+func (t sqlMultas) PorPersona(ctx context.Context, personaID string) ([]MovimientoMulta, error) {
+	rows, err := t.s.query(ctx, "SELECT "+columnasMulta+" FROM multas WHERE persona_id = ? ORDER BY fecha, id", personaID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var movimientos []MovimientoMulta
+	for rows.Next() {
+		var m MovimientoMulta
+		if err := rows.Scan(&m.ID, &m.PersonaID, &m.PrestamoID, &m.Tipo, &m.Monto, &m.Motivo, &m.RegistradoPor, &m.Fecha); err != nil {
+			return nil, err
+		}
+		movimientos = append(movimientos, m)
+	}
+	return movimientos, rows.Err()
+}
+
+func (t sqlMultas) Crear(ctx context.Context, m *MovimientoMulta) error {
+	m.ID = nuevoID()
+	return t.s.exec(ctx, `INSERT INTO multas (`+columnasMulta+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		m.ID, m.PersonaID, m.PrestamoID, m.Tipo, m.Monto, m.Motivo, m.RegistradoPor, m.Fecha)
+}
+
+// --- Sesiones ---
+
+type sqlSesiones struct{ s *sqlStore }
+
+func (t sqlSesiones) Obtener(ctx context.Context, id string) (*Sesion, error) {
+	var sesion Sesion
+	err := t.s.queryRow(ctx, "SELECT id, persona_id, creada, expira FROM sesiones WHERE id = ?", id).
+		Scan(&sesion.ID, &sesion.PersonaID, &sesion.Creada, &sesion.Expira)
+	if err != nil {
+		return nil, errSQL(err)
+	}
+	return &sesion, nil
+}
+
+func (t sqlSesiones) Guardar(ctx context.Context, s *Sesion) error {
+	return t.s.exec(ctx, `INSERT INTO sesiones (id, persona_id, creada, expira) VALUES (?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET persona_id = excluded.persona_id, creada = excluded.creada, expira = excluded.expira`,
+		s.ID, s.PersonaID, s.Creada, s.Expira)
+}
+
+func (t sqlSesiones) Eliminar(ctx context.Context, id string) error {
+	return t.s.exec(ctx, "DELETE FROM sesiones WHERE id = ?", id)
+}
+
+func (t sqlSesiones) EliminarExpiradas(ctx context.Context, ahora time.Time) (int, error) {
+	res, err := t.s.q.ExecContext(ctx, t.s.sql("DELETE FROM sesiones WHERE expira < ?"), ahora)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// --- Tokens ---
+
+type sqlTokens struct{ s *sqlStore }
+
+const columnasToken = "id, persona_id, descripcion, creado, ultimo_uso"
+
+func escanearToken(row escaner) (*TokenAPI, error) {
+	var t TokenAPI
+	var uso sql.NullTime
+	if err := row.Scan(&t.ID, &t.PersonaID, &t.Descripcion, &t.Creado, &uso); err != nil {
+		return nil, errSQL(err)
+	}
+	t.UltimoUso = uso.Time
+	return &t, nil
+}
+
+func (t sqlTokens) Obtener(ctx context.Context, id string) (*TokenAPI, error) {
+	return escanearToken(t.s.queryRow(ctx, "SELECT "+columnasToken+" FROM tokens WHERE id = ?", id))
+}
+
+func (t sqlTokens) PorPersona(ctx context.Context, personaID string) ([]TokenAPI, error) {
+	rows, err := t.s.query(ctx, "SELECT "+columnasToken+" FROM tokens WHERE persona_id = ? ORDER BY creado, id", personaID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tokens []TokenAPI
+	for rows.Next() {
+		token, err := escanearToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *token)
+	}
+	return tokens, rows.Err()
+}
+
+func (t sqlTokens) Guardar(ctx context.Context, token *TokenAPI) error {
+	return t.s.exec(ctx, `INSERT INTO tokens (`+columnasToken+`) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET persona_id = excluded.persona_id, descripcion = excluded.descripcion,
+			creado = excluded.creado, ultimo_uso = excluded.ultimo_uso`,
+		token.ID, token.PersonaID, token.Descripcion, token.Creado, fechaNula(token.UltimoUso))
+}
+
+func (t sqlTokens) Eliminar(ctx context.Context, id string) error {
+	return t.s.exec(ctx, "DELETE FROM tokens WHERE id = ?", id)
+}
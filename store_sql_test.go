@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// nuevoSQLitePrueba abre una base SQLite en un archivo temporal.
+func nuevoSQLitePrueba(t *testing.T, archivo string) Store {
+	t.Helper()
+	store, err := NuevoSQLStore(context.Background(), dialectoSQLite, archivo)
+	if err != nil {
+		t.Fatalf("abriendo sqlite: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// igual falla la prueba si obtenido no es igual a esperado. Compara el
+// texto de los valores para que dos fechas iguales leídas con otro
+// *time.Location cuenten como iguales.
+func igual[T any](t *testing.T, que string, obtenido *T, err error, esperado T) {
+	t.Helper()
+	if err != nil {
+		t.Errorf("%s: %v", que, err)
+		return
+	}
+	if fmt.Sprintf("%+v", *obtenido) != fmt.Sprintf("%+v", esperado) {
+		t.Errorf("%s:\n%+v\nse esperaba\n%+v", que, *obtenido, esperado)
+	}
+}
+
+func TestSQLCadaTabla(t *testing.T) {
+	ctx := context.Background()
+	archivo := filepath.Join(t.TempDir(), "biblioteca.db")
+	store := nuevoSQLitePrueba(t, archivo)
+	fecha := time.Date(2026, 3, 2, 10, 30, 0, 0, time.UTC)
+
+	libro := Libro{Nombre: "Rayuela", Autor: "Cortázar", Ano: 1963, Descripcion: "Novela", ImagenURL: "r.png", Total: 2, Copias: 1, Disponible: true, Restringido: true}
+	if err := store.Libros().Crear(ctx, &libro); err != nil || libro.ID == "" {
+		t.Fatalf("Libros().Crear: %v, id %q", err, libro.ID)
+	}
+	l, err := store.Libros().Obtener(ctx, libro.ID)
+	igual(t, "libro creado", l, err, libro)
+	libro.Copias, libro.Disponible = 0, false
+	if err := store.Libros().Guardar(ctx, &libro); err != nil {
+		t.Fatal(err)
+	}
+	l, err = store.Libros().Obtener(ctx, libro.ID)
+	igual(t, "libro guardado", l, err, libro)
+
+	ejemplar := Ejemplar{LibroID: libro.ID, Codigo: "R-1", Condicion: CondicionBueno, Ubicacion: "A1"}
+	if err := store.Ejemplares().Crear(ctx, &ejemplar); err != nil {
+		t.Fatal(err)
+	}
+	e, err := store.Ejemplares().BuscarPorCodigo(ctx, "R-1")
+	igual(t, "ejemplar por código", e, err, ejemplar)
+	ejemplar.Condicion = CondicionDanado
+	if err := store.Ejemplares().Guardar(ctx, &ejemplar); err != nil {
+		t.Fatal(err)
+	}
+	if ejemplares, err := store.Ejemplares().PorLibro(ctx, libro.ID); err != nil || len(ejemplares) != 1 || ejemplares[0] != ejemplar {
+		t.Errorf("ejemplares del libro: %+v, %v", ejemplares, err)
+	}
+
+	persona := Persona{Nombre: "ana", Cedula: "0101", Ano: 1990, Contrasena: "hash", Rol: RolUsuario}
+	if err := store.Personas().Crear(ctx, &persona); err != nil {
+		t.Fatal(err)
+	}
+	p, err := store.Personas().BuscarPorCedula(ctx, "0101")
+	igual(t, "persona por cédula", p, err, persona)
+	persona.Rol = RolBibliotecario
+	if err := store.Personas().Guardar(ctx, &persona); err != nil {
+		t.Fatal(err)
+	}
+	p, err = store.Personas().Obtener(ctx, persona.ID)
+	igual(t, "persona guardada", p, err, persona)
+
+	prestamo := Prestamo{LibroID: libro.ID, EjemplarID: ejemplar.ID, PersonaID: persona.ID, Estado: EstadoActivo, FechaPrestamo: fecha, FechaVencimiento: fecha.AddDate(0, 0, 14)}
+	if err := store.Prestamos().Crear(ctx, &prestamo); err != nil {
+		t.Fatal(err)
+	}
+	pr, err := store.Prestamos().Obtener(ctx, prestamo.ID)
+	igual(t, "préstamo creado", pr, err, prestamo)
+	if activos, err := store.Prestamos().ActivosPorLibro(ctx, libro.ID); err != nil || len(activos) != 1 {
+		t.Errorf("activos del libro: %+v, %v", activos, err)
+	}
+	prestamo.Estado, prestamo.FechaDevolucion, prestamo.Renovaciones = EstadoDevuelto, fecha.AddDate(0, 0, 3), 1
+	if err := store.Prestamos().Guardar(ctx, &prestamo); err != nil {
+		t.Fatal(err)
+	}
+	pr, err = store.Prestamos().Obtener(ctx, prestamo.ID)
+	igual(t, "préstamo devuelto", pr, err, prestamo)
+	if historial, err := store.Prestamos().Historial(ctx, FiltroPrestamos{PersonaID: persona.ID, Estados: []string{EstadoDevuelto}}); err != nil || len(historial) != 1 {
+		t.Errorf("historial: %+v, %v", historial, err)
+	}
+
+	reserva := Reserva{LibroID: libro.ID, PersonaID: persona.ID, Creada: fecha, Estado: ReservaLista, DisponibleHasta: fecha.Add(48 * time.Hour), EjemplarID: ejemplar.ID}
+	if err := store.Reservas().Crear(ctx, &reserva); err != nil {
+		t.Fatal(err)
+	}
+	r, err := store.Reservas().Obtener(ctx, reserva.ID)
+	igual(t, "reserva creada", r, err, reserva)
+	reserva.Estado, reserva.DisponibleHasta, reserva.EjemplarID = ReservaCumplida, time.Time{}, ""
+	if err := store.Reservas().Guardar(ctx, &reserva); err != nil {
+		t.Fatal(err)
+	}
+	r, err = store.Reservas().Obtener(ctx, reserva.ID)
+	igual(t, "reserva cumplida", r, err, reserva)
+
+	multa := MovimientoMulta{PersonaID: persona.ID, PrestamoID: prestamo.ID, Tipo: MultaAtraso, Monto: 150, Fecha: fecha}
+	if err := store.Multas().Crear(ctx, &multa); err != nil {
+		t.Fatal(err)
+	}
+	if multas, err := store.Multas().PorPersona(ctx, persona.ID); err != nil || len(multas) != 1 {
+		t.Errorf("multas: %+v, %v", multas, err)
+	} else {
+		igual(t, "multa", &multas[0], nil, multa)
+	}
+
+	sesion := Sesion{ID: "sesion-1", PersonaID: persona.ID, Creada: fecha, Expira: fecha.Add(time.Hour)}
+	if err := store.Sesiones().Guardar(ctx, &sesion); err != nil {
+		t.Fatal(err)
+	}
+	s, err := store.Sesiones().Obtener(ctx, sesion.ID)
+	igual(t, "sesión", s, err, sesion)
+	if n, err := store.Sesiones().EliminarExpiradas(ctx, fecha.Add(2*time.Hour)); err != nil || n != 1 {
+		t.Errorf("sesiones expiradas: %d, %v", n, err)
+	}
+
+	token := TokenAPI{ID: "hash-1", PersonaID: persona.ID, Descripcion: "script", Creado: fecha}
+	if err := store.Tokens().Guardar(ctx, &token); err != nil {
+		t.Fatal(err)
+	}
+	token.UltimoUso = fecha.Add(time.Minute)
+	if err := store.Tokens().Guardar(ctx, &token); err != nil {
+		t.Fatal(err)
+	}
+	tk, err := store.Tokens().Obtener(ctx, token.ID)
+	igual(t, "token", tk, err, token)
+
+	for _, eliminar := range []struct {
+		que      string
+		eliminar func() error
+		obtener  func() error
+	}{
+		{"token", func() error { return store.Tokens().Eliminar(ctx, token.ID) }, func() error { _, err := store.Tokens().Obtener(ctx, token.ID); return err }},
+		{"préstamo", func() error { return store.Prestamos().Eliminar(ctx, prestamo.ID) }, func() error { _, err := store.Prestamos().Obtener(ctx, prestamo.ID); return err }},
+		{"ejemplar", func() error { return store.Ejemplares().Eliminar(ctx, ejemplar.ID) }, func() error { _, err := store.Ejemplares().Obtener(ctx, ejemplar.ID); return err }},
+	} {
+		if err := eliminar.eliminar(); err != nil {
+			t.Errorf("eliminando %s: %v", eliminar.que, err)
+		}
+		if err := eliminar.obtener(); !errors.Is(err, ErrNoEncontrado) {
+			t.Errorf("%s eliminado: err = %v, se esperaba ErrNoEncontrado", eliminar.que, err)
+		}
+	}
+
+	// Reabrir la base vuelve a correr el esquema y las migraciones sin perder
+	// datos
+	store.Close()
+	store = nuevoSQLitePrueba(t, archivo)
+	l, err = store.Libros().Obtener(ctx, libro.ID)
+	igual(t, "libro al reabrir", l, err, libro)
+}
+
+func TestSQLTransaccion(t *testing.T) {
+	ctx := context.Background()
+	store := nuevoSQLitePrueba(t, ":memory:")
+	libro := Libro{Nombre: "Aura", Total: 1, Copias: 1, Disponible: true}
+	if err := store.Libros().Crear(ctx, &libro); err != nil {
+		t.Fatal(err)
+	}
+
+	fallo := errors.New("fallo a propósito")
+	var creada string
+	err := store.RunTransaction(ctx, func(ctx context.Context, tx Store) error {
+		persona := &Persona{Nombre: "beto", Cedula: "0202", Rol: RolUsuario}
+		if err := tx.Personas().Crear(ctx, persona); err != nil {
+			return err
+		}
+		creada = persona.ID
+		editado := libro
+		editado.Copias = 0
+		if err := tx.Libros().Guardar(ctx, &editado); err != nil {
+			return err
+		}
+		return tx.RunTransaction(ctx, func(ctx context.Context, tx Store) error {
+			if err := tx.Prestamos().Crear(ctx, &Prestamo{LibroID: libro.ID, PersonaID: persona.ID, Estado: EstadoActivo, FechaPrestamo: time.Now()}); err != nil {
+				return err
+			}
+			return fallo
+		})
+	})
+	if !errors.Is(err, fallo) {
+		t.Fatalf("err = %v, se esperaba el error de f", err)
+	}
+	if _, err := store.Personas().Obtener(ctx, creada); !errors.Is(err, ErrNoEncontrado) {
+		t.Errorf("la persona de la transacción quedó guardada: %v", err)
+	}
+	if l, _ := store.Libros().Obtener(ctx, libro.ID); l == nil || l.Copias != 1 {
+		t.Errorf("la edición del libro quedó guardada: %+v", l)
+	}
+	if activos, _ := store.Prestamos().Activos(ctx); len(activos) != 0 {
+		t.Errorf("el préstamo de la transacción anidada quedó guardado: %+v", activos)
+	}
+
+	// La cédula es única: el segundo alta falla y revierte la transacción
+	if err := store.Personas().Crear(ctx, &Persona{Nombre: "ana", Cedula: "0101", Rol: RolUsuario}); err != nil {
+		t.Fatal(err)
+	}
+	err = store.RunTransaction(ctx, func(ctx context.Context, tx Store) error {
+		if err := tx.Libros().Crear(ctx, &Libro{Nombre: "Ficciones"}); err != nil {
+			return err
+		}
+		return tx.Personas().Crear(ctx, &Persona{Nombre: "otra ana", Cedula: "0101", Rol: RolUsuario})
+	})
+	if !errors.Is(err, ErrCedulaRegistrada) {
+		t.Fatalf("cédula repetida: err = %v, se esperaba ErrCedulaRegistrada por la restricción UNIQUE", err)
+	}
+	if libros, _ := store.Libros().Listar(ctx); len(libros) != 1 {
+		t.Errorf("el libro de la transacción fallida quedó guardado: %+v", libros)
+	}
+}
+
+func TestSQLDialectoPostgres(t *testing.T) {
+	consulta := "SELECT id FROM libro WHERE nombre = ? AND ano > ?"
+	afuera := &sqlStore{dialecto: dialectoPostgres}
+	if got := afuera.sql(afuera.paraActualizar(consulta)); got != "SELECT id FROM libro WHERE nombre = $1 AND ano > $2" {
+		t.Errorf("fuera de una transacción: %q", got)
+	}
+	adentro := &sqlStore{dialecto: dialectoPostgres, enTx: true}
+	if got := adentro.sql(adentro.paraActualizar(consulta)); got != "SELECT id FROM libro WHERE nombre = $1 AND ano > $2 FOR UPDATE" {
+		t.Errorf("dentro de una transacción: %q", got)
+	}
+	sqlite := &sqlStore{dialecto: dialectoSQLite, enTx: true}
+	if got := sqlite.sql(sqlite.paraActualizar(consulta)); got != consulta {
+		t.Errorf("sqlite: %q", got)
+	}
+}
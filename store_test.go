@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestTransaccionMemoria(t *testing.T) {
+	ctx := context.Background()
+	store := NuevoMemoriaStore()
+	libro := &Libro{Nombre: "Rayuela", Total: 1, Copias: 1}
+	persona := &Persona{Nombre: "ana", Cedula: "1"}
+	if err := store.Libros().Crear(ctx, libro); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Personas().Crear(ctx, persona); err != nil {
+		t.Fatal(err)
+	}
+
+	// Si f devuelve error, se descartan todas sus escrituras
+	fallo := errors.New("fallo a propósito")
+	var creado string
+	err := store.RunTransaction(ctx, func(ctx context.Context, tx Store) error {
+		nuevo := &Libro{Nombre: "Aura"}
+		if err := tx.Libros().Crear(ctx, nuevo); err != nil {
+			return err
+		}
+		creado = nuevo.ID
+		editado := *libro
+		editado.Copias = 0
+		if err := tx.Libros().Guardar(ctx, &editado); err != nil {
+			return err
+		}
+		if err := tx.Personas().Eliminar(ctx, persona.ID); err != nil {
+			return err
+		}
+		// Una transacción anidada es parte de la de afuera
+		return tx.RunTransaction(ctx, func(ctx context.Context, tx Store) error {
+			if err := tx.Prestamos().Crear(ctx, &Prestamo{LibroID: libro.ID, PersonaID: persona.ID, Estado: EstadoActivo}); err != nil {
+				return err
+			}
+			return fallo
+		})
+	})
+	if !errors.Is(err, fallo) {
+		t.Fatalf("err = %v, se esperaba el error de f", err)
+	}
+	if _, err := store.Libros().Obtener(ctx, creado); !errors.Is(err, ErrNoEncontrado) {
+		t.Errorf("el libro creado en la transacción quedó guardado: %v", err)
+	}
+	if l, _ := store.Libros().Obtener(ctx, libro.ID); l == nil || l.Copias != 1 {
+		t.Errorf("la edición del libro quedó guardada: %+v", l)
+	}
+	if _, err := store.Personas().Obtener(ctx, persona.ID); err != nil {
+		t.Errorf("la persona eliminada en la transacción no volvió: %v", err)
+	}
+	if activos, _ := store.Prestamos().Activos(ctx); len(activos) != 0 {
+		t.Errorf("el préstamo de la transacción anidada quedó guardado: %+v", activos)
+	}
+
+	// Si f termina bien, sus escrituras se confirman
+	err = store.RunTransaction(ctx, func(ctx context.Context, tx Store) error {
+		editado := *libro
+		editado.Copias = 0
+		return tx.Libros().Guardar(ctx, &editado)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l, _ := store.Libros().Obtener(ctx, libro.ID); l == nil || l.Copias != 0 {
+		t.Errorf("la transacción confirmada no guardó el libro: %+v", l)
+	}
+}
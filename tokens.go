@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// prefijoToken distingue a simple vista los tokens de la API de otros
+// secretos.
+const prefijoToken = "bib_"
+
+// ErrTokenInvalido se devuelve ante un token que no existe, fue revocado o
+// pertenece a una persona eliminada.
+var ErrTokenInvalido = errors.New("token inválido o revocado")
+
+// hashToken es el ID con el que se guarda un token: su SHA-256 en hexadecimal.
+// Los tokens son aleatorios de 256 bits, así que no hace falta un hash lento.
+func hashToken(token string) string {
+	suma := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(suma[:])
+}
+
+// crearToken genera un token nuevo para la persona y devuelve el token en
+// claro, que no se guarda en ningún lado.
+func crearToken(ctx context.Context, store Store, persona *Persona, descripcion string, fecha time.Time) (string, *TokenAPI, error) {
+	aleatorio, err := nuevoIDSesion()
+	if err != nil {
+		return "", nil, err
+	}
+	token := prefijoToken + aleatorio
+	guardado := &TokenAPI{
+		ID:          hashToken(token),
+		PersonaID:   persona.ID,
+		Descripcion: strings.TrimSpace(descripcion),
+		Creado:      fecha,
+	}
+	if err := store.Tokens().Guardar(ctx, guardado); err != nil {
+		return "", nil, err
+	}
+	return token, guardado, nil
+}
+
+// revocarToken elimina un token. Sólo su dueño o un administrador puede
+// revocarlo.
+func revocarToken(ctx context.Context, store Store, id string, quien *Persona) error {
+	token, err := store.Tokens().Obtener(ctx, id)
+	if err != nil {
+		return err
+	}
+	if token.PersonaID != quien.ID && quien.Rol != RolAdmin {
+		return ErrNoAutorizado
+	}
+	return store.Tokens().Eliminar(ctx, id)
+}
+
+// personaDelToken devuelve la persona dueña del token y anota su último uso
+// (como mucho una vez por minuto, para no escribir en cada petición).
+func personaDelToken(ctx context.Context, token string, ahora time.Time) (*Persona, error) {
+	guardado, err := DB.Tokens().Obtener(ctx, hashToken(token))
+	if errors.Is(err, ErrNoEncontrado) {
+		return nil, ErrTokenInvalido
+	}
+	if err != nil {
+		return nil, err
+	}
+	persona, err := DB.Personas().Obtener(ctx, guardado.PersonaID)
+	if errors.Is(err, ErrNoEncontrado) {
+		return nil, ErrTokenInvalido
+	}
+	if err != nil {
+		return nil, err
+	}
+	if persona.Rol == "" {
+		persona.Rol = RolUsuario
+	}
+	if ahora.Sub(guardado.UltimoUso) > time.Minute {
+		guardado.UltimoUso = ahora
+		if err := DB.Tokens().Guardar(ctx, guardado); err != nil {
+			log.Printf("Error al anotar el uso del token de %s: %v", persona.ID, err)
+		}
+	}
+	return persona, nil
+}
+
+// conToken autentica las peticiones a la API con la cabecera
+// "Authorization: Bearer <token>" y deja la persona en el contexto. La API no
+// acepta la cookie de sesión: así no necesita CSRF y una página ajena no
+// puede usar la sesión del navegador. Un token inválido recibe 401 aunque la
+// ruta sea pública.
+func conToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cabecera := r.Header.Get("Authorization")
+		if cabecera == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		token, ok := strings.CutPrefix(cabecera, "Bearer ")
+		if !ok || strings.TrimSpace(token) == "" {
+			responderErrorAPI(w, http.StatusUnauthorized, "token_invalido", "La cabecera Authorization debe ser «Bearer <token>»")
+			return
+		}
+		persona, err := personaDelToken(r.Context(), strings.TrimSpace(token), time.Now())
+		if errors.Is(err, ErrTokenInvalido) {
+			responderErrorAPI(w, http.StatusUnauthorized, "token_invalido", "El token no es válido o fue revocado")
+			return
+		}
+		if err != nil {
+			log.Printf("Error al verificar un token: %v", err)
+			responderErrorAPI(w, http.StatusInternalServerError, "error_interno", "Error al verificar el token")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clavePersona, persona)))
+	})
+}
+
+// CrearTokenHandler crea un token para la persona logueada desde Mi cuenta y
+// muestra el token en claro esa única vez, sin redirigir.
+func CrearTokenHandler(w http.ResponseWriter, r *http.Request) {
+	persona := personaActual(r)
+	token, _, err := crearToken(r.Context(), DB, persona, r.FormValue("descripcion"), time.Now())
+	if err != nil {
+		log.Printf("Error al crear un token para %s: %v", persona.ID, err)
+		http.Redirect(w, r, "/perfil?msg="+url.QueryEscape("Error al crear el token")+"&msg_type=danger", http.StatusSeeOther)
+		return
+	}
+	log.Printf("🔑 %s creó un token de la API", persona.Nombre)
+	mostrarPerfil(w, r, DatosPagina{
+		NuevoToken:  token,
+		Mensaje:     "Token creado. Cópialo ahora: no se volverá a mostrar.",
+		TipoMensaje: "success",
+	})
+}
+
+// RevocarTokenHandler revoca uno de los tokens de la persona logueada.
+func RevocarTokenHandler(w http.ResponseWriter, r *http.Request) {
+	persona := personaActual(r)
+	mensaje, tipo := "Token revocado", "success"
+	if err := revocarToken(r.Context(), DB, r.FormValue("id"), persona); err != nil {
+		tipo = "danger"
+		switch {
+		case errors.Is(err, ErrNoEncontrado):
+			mensaje = "El token no existe"
+		case errors.Is(err, ErrNoAutorizado):
+			mensaje = "No puedes revocar el token de otra persona"
+		default:
+			log.Printf("Error al revocar un token de %s: %v", persona.ID, err)
+			mensaje = "Error al revocar el token"
+		}
+	}
+	http.Redirect(w, r, "/perfil?msg="+url.QueryEscape(mensaje)+"&msg_type="+tipo, http.StatusSeeOther)
+}
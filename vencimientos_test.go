@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrestamoTieneVencimiento(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		ana := crearPersona(t, "ana", "clave", "usuario")
+		libro := crearLibro(t, "Rayuela", 1)
+
+		fecha := time.Date(2024, 5, 1, 9, 0, 0, 0, time.Local)
+		prestamo := prestarEl(t, libro, ana, fecha)
+		guardado, err := DB.Prestamos().Obtener(context.Background(), prestamo.ID)
+		if err != nil {
+			t.Fatalf("obteniendo préstamo: %v", err)
+		}
+		if want := fecha.AddDate(0, 0, Configuracion.DiasPrestamo); !guardado.FechaVencimiento.Equal(want) {
+			t.Errorf("vencimiento = %v, se esperaba %v", guardado.FechaVencimiento, want)
+		}
+	})
+}
+
+func TestDiasDeAtraso(t *testing.T) {
+	vence := time.Date(2024, 5, 15, 9, 0, 0, 0, time.UTC)
+	casos := []struct {
+		ahora  time.Time
+		estado string
+		dias   int
+	}{
+		{vence.Add(-time.Hour), EstadoActivo, 0},
+		{vence, EstadoActivo, 0},
+		{vence.Add(time.Minute), EstadoActivo, 1},
+		{vence.Add(24 * time.Hour), EstadoActivo, 1},
+		{vence.Add(72*time.Hour + time.Minute), EstadoActivo, 3},
+		{vence.Add(72 * time.Hour), EstadoDevuelto, 0},
+		{vence.Add(72 * time.Hour), EstadoAprobado, 0},
+	}
+	for _, caso := range casos {
+		p := Prestamo{FechaVencimiento: vence, Estado: caso.estado}
+		if got := p.DiasDeAtraso(caso.ahora); got != caso.dias {
+			t.Errorf("DiasDeAtraso(%v, estado=%s) = %d, se esperaba %d", caso.ahora, caso.estado, got, caso.dias)
+		}
+	}
+	if got := (&Prestamo{Estado: EstadoActivo}).DiasDeAtraso(vence); got != 0 {
+		t.Errorf("un préstamo sin vencimiento no debería estar atrasado: %d", got)
+	}
+}
+
+func TestDevolucionesMarcaVencidos(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		ana := crearPersona(t, "ana", "clave", "usuario")
+		// Primero el préstamo en plazo: con uno vencido ya no podría pedirlo
+		prestar(t, crearLibro(t, "Ficciones", 1), ana)
+		prestarEl(t, crearLibro(t, "Rayuela", 1), ana, time.Now().AddDate(0, 0, -Configuracion.DiasPrestamo-3))
+
+		c.login("ana", "clave")
+		resp := c.get("/devoluciones")
+		esperarEstado(t, resp, http.StatusOK)
+		if n := strings.Count(resp.Cuerpo, "Vencido ("); n != 1 {
+			t.Errorf("/devoluciones marca %d préstamos vencidos, se esperaba 1", n)
+		}
+		if !strings.Contains(resp.Cuerpo, "Vencido (3 días)") {
+			t.Errorf("/devoluciones no muestra los días de atraso")
+		}
+	})
+}
+
+func TestReporteDeVencidos(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		crearPersona(t, "admin", "clave", "admin")
+		ana := crearPersona(t, "ana", "clave", "usuario")
+		luis := crearPersona(t, "luis", "clave", "usuario")
+		rayuela := crearLibro(t, "Rayuela", 2)
+		ficciones := crearLibro(t, "Ficciones", 2)
+		aleph := crearLibro(t, "El Aleph", 2)
+
+		hace := func(dias int) time.Time { return time.Now().AddDate(0, 0, -Configuracion.DiasPrestamo-dias) }
+		prestar(t, aleph, ana) // Todavía en plazo; antes del vencido, que la bloquearía
+		prestarEl(t, rayuela, ana, hace(2))
+		prestarEl(t, ficciones, luis, hace(10))
+		devuelto := prestarEl(t, aleph, luis, hace(5))
+		if err := devolverLibro(context.Background(), DB, devuelto.ID, luis, time.Now()); err != nil {
+			t.Fatalf("devolviendo: %v", err)
+		}
+
+		vencidos, err := DB.Prestamos().Vencidos(context.Background(), time.Now())
+		if err != nil {
+			t.Fatalf("Vencidos: %v", err)
+		}
+		if len(vencidos) != 2 || vencidos[0].LibroID != ficciones.ID || vencidos[1].LibroID != rayuela.ID {
+			t.Fatalf("Vencidos = %+v; se esperaban Ficciones y Rayuela, en ese orden", vencidos)
+		}
+
+		c.login("admin", "clave")
+		resp := c.get("/vencidos")
+		esperarEstado(t, resp, http.StatusOK)
+		for _, texto := range []string{"luis", "ced-luis", "Ficciones", ">10<", "ana", "ced-ana", "Rayuela", ">2<"} {
+			if !strings.Contains(resp.Cuerpo, texto) {
+				t.Errorf("/vencidos no muestra %q", texto)
+			}
+		}
+		if strings.Contains(resp.Cuerpo, "El Aleph") {
+			t.Errorf("/vencidos muestra préstamos devueltos o en plazo")
+		}
+		if strings.Index(resp.Cuerpo, "Ficciones") > strings.Index(resp.Cuerpo, "Rayuela") {
+			t.Errorf("/vencidos no ordena del más atrasado al menos atrasado")
+		}
+	})
+}
+
+func TestReporteDeVencidosSoloPersonal(t *testing.T) {
+	paraCadaBackend(t, func(t *testing.T, c *clientePrueba) {
+		crearPersona(t, "ana", "clave", "usuario")
+		c.login("ana", "clave")
+		esperarEstado(t, c.get("/vencidos"), http.StatusForbidden)
+	})
+}
+
+// TestSQLAgregaColumnasNuevas abre una base de datos SQLite creada con el
+// esquema anterior a los vencimientos y comprueba que se actualiza.
+func TestSQLAgregaColumnasNuevas(t *testing.T) {
+	archivo := filepath.Join(t.TempDir(), "vieja.db")
+	db, err := sql.Open("sqlite", archivo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`CREATE TABLE prestamos (
+		id TEXT PRIMARY KEY, libro_id TEXT NOT NULL, persona_id TEXT NOT NULL,
+		fecha_prestamo DATETIME NOT NULL, fecha_devolucion DATETIME, activo BOOLEAN NOT NULL)`)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NuevoSQLStore(context.Background(), dialectoSQLite, archivo)
+	if err != nil {
+		t.Fatalf("abriendo base de datos vieja: %v", err)
+	}
+	defer store.Close()
+	if _, err := store.Prestamos().Vencidos(context.Background(), time.Now()); err != nil {
+		t.Errorf("la columna fecha_vencimiento no se agregó: %v", err)
+	}
+}